@@ -4,8 +4,96 @@
 
 package mpd
 
-import "testing"
+import (
+	"os"
+	"fmt"
+	"strconv"
+	"strings"
+	"testing"
+	"github.com/jteeuwen/go-pkg-mpd/mpdtest"
+)
 
 func Test(t *testing.T) {
 
 }
+
+var testSongs = []mpdtest.Song{
+	mpdtest.Song{
+		"file": "rock/acdc/hells_bells.mp3", "Artist": "AC/DC", "Album": "Back in Black",
+		"Title": "Hells Bells", "Track": "1", "Genre": "Rock", "Time": "312", "size": "7488000",
+	},
+	mpdtest.Song{
+		"file": "rock/acdc/back_in_black.mp3", "Artist": "AC/DC", "Album": "Back in Black",
+		"Title": "Back in Black", "Track": "6", "Genre": "Rock", "Time": "255", "size": "6120000",
+	},
+	mpdtest.Song{
+		"file": "jazz/coltrane/giant_steps.flac", "Artist": "John Coltrane", "Album": "Giant Steps",
+		"Title": "Giant Steps", "Track": "1", "Genre": "Jazz", "Time": "286",
+	},
+	mpdtest.Song{
+		"file": "jazz/davis/so_what.flac", "Artist": "Miles Davis", "Album": "Kind of Blue",
+		"Title": "So What", "Track": "1", "Genre": "Jazz", "Time": "562",
+	},
+}
+
+// Starts a fake server with the test songs in its database and returns a
+// client connected to it.
+func startServer(t *testing.T) (*mpdtest.Server, *Client) {
+	srv := mpdtest.NewServer()
+	srv.AddSongs(testSongs...)
+
+	if err := srv.Listen("tcp", "127.0.0.1:0"); err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+
+	c := newClient()
+	if err := c.Open("tcp", srv.Addr()); err != nil {
+		srv.Close()
+		t.Fatalf("Open: %v", err)
+	}
+	return srv, c
+}
+
+// Returns a configuration which points to the given server.
+func testConfig(srv *mpdtest.Server) *Config {
+	addr := srv.Addr()
+	pos := strings.LastIndex(addr, ":")
+
+	cfg := NewConfig()
+	cfg.Address = addr[:pos]
+	cfg.Port, _ = strconv.Atoi(addr[pos+1:])
+	return cfg
+}
+
+// Runs a command over an existing connection, like Command.Run does over a
+// new one.
+func runCommand(c *Client, name string, args ...string) (err os.Error) {
+	cmd := CreateCommand(name)
+	if cmd == nil {
+		return os.NewError(fmt.Sprintf("Unknown command '%s'.", name))
+	}
+
+	if err = cmd.parse(args); err != nil {
+		return
+	}
+	return cmd.Execute(c)
+}
+
+// A command line and the protocol command it is expected to send last.
+type commandTest struct {
+	args []string
+	want string
+}
+
+func testCommands(t *testing.T, srv *mpdtest.Server, c *Client, tests []commandTest) {
+	for _, test := range tests {
+		if err := runCommand(c, test.args[0], test.args[1:]...); err != nil {
+			t.Errorf("%v: %v", test.args, err)
+			continue
+		}
+
+		if last := srv.Last(); last != test.want {
+			t.Errorf("%v: Expected '%s', got '%s'.", test.args, test.want, last)
+		}
+	}
+}
@@ -4,24 +4,181 @@
 
 package mpd
 
-import "os"
+import (
+	"os"
+	"fmt"
+	"time"
+	"strconv"
+)
+
+func init() {
+	mustRegister(GroupAdmin,
+		&Command{
+			Name: "disableoutput",
+			Desc: "Turns an audio-output source off.",
+			Params: []*Param{
+				newParam("id", "Id of the output device. Use the 'outputs' command to find all valid Ids.", IndexKind, false),
+			},
+			Exec: disableoutput,
+		},
+		&Command{
+			Name: "enableoutput",
+			Desc: "Turns an audio-output source on.",
+			Params: []*Param{
+				newParam("id", "Id of the output device. Use the 'outputs' command to find all valid Ids.", IndexKind, false),
+			},
+			Exec: enableoutput,
+		},
+		&Command{
+			Name: "kill",
+			Desc: "Stops MPD from running, in a safe way. Writes a state file if defined.",
+			Exec: kill,
+		},
+		&Command{
+			Name: "update",
+			Desc: "Scans the music directory as defined in the MPD configuration file's music_directory  setting. Adds new files and their metadata (if any) to the MPD database and removes files and metadata from the database that are no longer in the directory.",
+			Params: []*Param{
+				newParam("path", "path is an optional argument that picks an exact directory or file to update, otherwise the root of the music_directory in your MPD configuration file is assumed.", TextKind, true),
+				newFlag("wait", "Block until the update job has finished, reporting progress along the way."),
+			},
+			Exec: update,
+		},
+		&Command{
+			Name: "rescan",
+			Desc: "Same as update, but also rescans unmodified files.",
+			Params: []*Param{
+				newParam("path", "path is an optional argument that picks an exact directory or file to rescan, otherwise the root of the music_directory in your MPD configuration file is assumed.", TextKind, true),
+				newFlag("wait", "Block until the rescan job has finished, reporting progress along the way."),
+			},
+			Exec: rescan,
+		},
+	)
+}
+
+// Starts a database update for @path, or the whole music directory if @path
+// is empty. Returns the id of the update job.
+func (this *Client) Update(path string) (job int, err os.Error) {
+	return this.updateDb("update", path)
+}
+
+// Same as Update, but also rescans unmodified files.
+func (this *Client) Rescan(path string) (job int, err os.Error) {
+	return this.updateDb("rescan", path)
+}
+
+func (this *Client) updateDb(cmd, path string) (job int, err os.Error) {
+	var a Args
+
+	if path == "" {
+		a, err = this.requestArgs(cmd)
+	} else {
+		a, err = this.requestArgs("%s %s", cmd, quote(path))
+	}
+
+	if err != nil {
+		return
+	}
+
+	if job = a.Int("updating_db", 0); job == 0 {
+		err = os.NewError(fmt.Sprintf("No update job id returned by '%s'.", cmd))
+	}
+	return
+}
+
+// Blocks until the update job with the given id has finished. This relies on
+// idle events, so the client can not be used for anything else meanwhile.
+func (this *Client) WaitForUpdate(job int) os.Error {
+	return this.waitForUpdate(job, nil)
+}
+
+// Same as WaitForUpdate, but calls @progress with the current server status
+// each time the update state changes while the job is still pending.
+func (this *Client) waitForUpdate(job int, progress func(s *Status)) (err os.Error) {
+	var s *Status
+
+	for {
+		if s, err = this.Status(); err != nil {
+			return
+		}
+
+		// Jobs are numbered in the order they are queued. The server only
+		// reports the one it is currently working on.
+		if s.UpdatingDb == 0 || s.UpdatingDb > job {
+			return
+		}
+
+		if progress != nil {
+			progress(s)
+		}
+
+		if _, err = this.Idle("update", "database"); err != nil {
+			return
+		}
+	}
+	return
+}
 
 func disableoutput(cmd *Command, c *Client) (err os.Error) {
-	return c.request("disableoutput %d", cmd.I("id", 0))
+	return cmd.request(c, "disableoutput %d", cmd.I("id", 0))
 }
 
 func enableoutput(cmd *Command, c *Client) (err os.Error) {
-	return c.request("enableoutput %d", cmd.I("id", 0))
+	return cmd.request(c, "enableoutput %d", cmd.I("id", 0))
 }
 
 func kill(cmd *Command, c *Client) (err os.Error) {
-	return c.request("kill")
+	return cmd.request(c, "kill")
 }
 
 func update(cmd *Command, c *Client) (err os.Error) {
-	var path string
-	if path = cmd.S("path", ""); path == "" {
-		return c.request("update")
+	var job int
+	if job, err = c.Update(cmd.S("path", "")); err != nil {
+		return
+	}
+	return reportUpdate(cmd, c, job)
+}
+
+func rescan(cmd *Command, c *Client) (err os.Error) {
+	var job int
+	if job, err = c.Rescan(cmd.S("path", "")); err != nil {
+		return
+	}
+	return reportUpdate(cmd, c, job)
+}
+
+func reportUpdate(cmd *Command, c *Client, job int) (err os.Error) {
+	if cmd.S("wait", "") != "on" {
+		return cmd.Record([]Pair{Pair{"updating_db", strconv.Itoa(job)}}, "")
 	}
-	return c.request("update \"%s\"", path)
+
+	start := time.Seconds()
+	cmd.Printf("Waiting for update job %d...\n", job)
+
+	err = c.waitForUpdate(job, func(s *Status) {
+		if s.UpdatingDb == job {
+			cmd.Printf("[%s] job %d running\n", parseTime(int(time.Seconds()-start)), job)
+		} else {
+			cmd.Printf("[%s] job %d queued behind job %d\n", parseTime(int(time.Seconds()-start)), job, s.UpdatingDb)
+		}
+	})
+
+	if err != nil {
+		return
+	}
+
+	var stats Args
+	if stats, err = c.requestArgs("stats"); err != nil {
+		return
+	}
+
+	elapsed := time.Seconds() - start
+	return cmd.Record(
+		[]Pair{
+			Pair{"updating_db", strconv.Itoa(job)},
+			Pair{"seconds", strconv.Itoa64(elapsed)},
+			Pair{"songs", stats["songs"]},
+		},
+		fmt.Sprintf("Update job %d finished after %s. The database now holds %s songs.",
+			job, parseTime(int(elapsed)), stats["songs"]),
+	)
 }
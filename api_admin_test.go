@@ -0,0 +1,90 @@
+// Copyright (c) 2010, Jim Teeuwen. All rights reserved.
+// This code is subject to a 1-clause BSD license.
+// See the LICENSE file for its contents.
+
+package mpd
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAdminCommands(t *testing.T) {
+	srv, c := startServer(t)
+	defer srv.Close()
+	defer c.Close()
+
+	srv.AddOutput("Default", true)
+
+	testCommands(t, srv, c, []commandTest{
+		commandTest{[]string{"disableoutput", "0"}, "disableoutput 0"},
+		commandTest{[]string{"enableoutput", "0"}, "enableoutput 0"},
+		commandTest{[]string{"update"}, "update"},
+		commandTest{[]string{"update", "rock"}, `update "rock"`},
+		commandTest{[]string{"update", "--wait"}, "stats"},
+		commandTest{[]string{"rescan", "jazz"}, `rescan "jazz"`},
+		commandTest{[]string{"kill"}, "kill"},
+	})
+
+	if err := runCommand(c, "enableoutput", "3"); err == nil {
+		t.Errorf("Expected error for unknown output.")
+	}
+
+	if err := runCommand(c, "update", "--bogus"); err == nil {
+		t.Errorf("Expected error for unknown flag.")
+	}
+}
+
+func TestUpdate(t *testing.T) {
+	srv, c := startServer(t)
+	defer srv.Close()
+	defer c.Close()
+
+	job, err := c.Update("")
+	if err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+
+	next, err := c.Rescan("rock/acdc")
+	if err != nil {
+		t.Fatalf("Rescan: %v", err)
+	}
+
+	if next != job+1 {
+		t.Errorf("Expected job %d, got %d.", job+1, next)
+	}
+
+	if last := srv.Last(); last != `rescan "rock/acdc"` {
+		t.Errorf("Unexpected command '%s'.", last)
+	}
+}
+
+func TestWaitForUpdate(t *testing.T) {
+	srv, c := startServer(t)
+	defer srv.Close()
+	defer c.Close()
+
+	srv.ManualUpdates = true
+
+	job, err := c.Update("")
+	if err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+
+	if s := srv.Status(); s["updating_db"] != "1" {
+		t.Fatalf("Expected running update job, got '%s'.", s["updating_db"])
+	}
+
+	go func() {
+		time.Sleep(1e8)
+		srv.FinishUpdate()
+	}()
+
+	if err = c.WaitForUpdate(job); err != nil {
+		t.Fatalf("WaitForUpdate: %v", err)
+	}
+
+	if s := srv.Status(); s["updating_db"] != "" {
+		t.Errorf("Update job still running after WaitForUpdate returned.")
+	}
+}
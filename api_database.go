@@ -6,112 +6,445 @@ package mpd
 
 import "os"
 import "fmt"
+import "time"
+import "strings"
+
+func init() {
+	mustRegister(GroupDatabase,
+		&Command{
+			Name: "find",
+			Desc: "Finds songs in the database with a case sensitive, exact match to @term.",
+			Params: []*Param{
+				newParam("tag", "This is the type of metadata you wish to use to refine the search. Examples would be album, artist , title or any.", TagKind, false),
+				newParam("term", "This is the value that is being searched for in @tag.", TextKind, false),
+			},
+			Exec: find,
+		},
+		&Command{
+			Name: "list",
+			Desc: "Reports all metadata of @type1.",
+			Params: []*Param{
+				newParam("tag1", "This lists all metadata of @tag1", TagKind, false),
+				newParam("tag2", "Only required if @term is present. This specifies to look for @tag2 in the list of @tag1", TagKind, true),
+				newParam("term", "Only required if @tag2 is present. This specifies to look for matches of @term of @tag2 in the list of @tag1", TextKind, true),
+			},
+			Exec: list,
+		},
+		&Command{
+			Name: "listall",
+			Desc: "Reports all directories and filenames in @path recursively.",
+			Params: []*Param{
+				newParam("path", "An optional path or directory to act as the root of the list.", TextKind, true),
+			},
+			Exec: listall,
+		},
+		&Command{
+			Name: "listallinfo",
+			Desc: "Reports all information in database about all music files in <string path> recursively.",
+			Params: []*Param{
+				newParam("path", "An optional path or directory to act as the root of the list.", TextKind, true),
+			},
+			Exec: listallinfo,
+		},
+		&Command{
+			Name: "lsinfo",
+			Desc: "Reports contents of @path, from the database.",
+			Params: []*Param{
+				newParam("path", "An optional path or directory to act as the root of the list.", TextKind, true),
+			},
+			Exec: lsinfo,
+		},
+		&Command{
+			Name: "listfiles",
+			Desc: "Reports contents of @path, straight from the music directory. Unlike lsinfo, this includes files which are not songs, along with their size and modification time.",
+			Params: []*Param{
+				newParam("path", "An optional path or directory to act as the root of the list.", TextKind, true),
+			},
+			Exec: listfiles,
+		},
+		&Command{
+			Name: "readcomments",
+			Desc: "Reports the raw comments (tags) stored in the file at @path.",
+			Params: []*Param{
+				newParam("path", "Path of the file, relative to the music directory.", TextKind, false),
+			},
+			Exec: readcomments,
+		},
+		&Command{
+			Name: "fingerprint",
+			Desc: "Reports the chromaprint fingerprint of the song at @path.",
+			Params: []*Param{
+				newParam("path", "Path of the song, relative to the music directory.", TextKind, false),
+			},
+			Exec: fingerprint,
+		},
+		&Command{
+			Name: "search",
+			Desc: "Finds songs in the database with a case insensitive match to @what.",
+			Params: []*Param{
+				newParam("tag", "This is the type of metadata you wish to use to refine the search. Examples would be album, artist , title or any.", TagKind, false),
+				newParam("term", "This is the value that is being searched for in @type.", TextKind, false),
+			},
+			Exec: search,
+		},
+		&Command{
+			Name: "count",
+			Desc: "Reports the number of songs and their total playtime in the database matching @what.",
+			Params: []*Param{
+				newParam("tag", "This is the type of metadata you wish to use to refine the search. Examples would be album, artist , title or any.", TagKind, false),
+				newParam("term", "This is the value that is being searched for in @type.", TextKind, false),
+			},
+			Exec: count,
+		},
+	)
+
+	mustAlias("ls", "lsinfo")
+}
+
+// A file or directory as reported by listfiles. Unlike the database views,
+// this includes files that are not songs.
+type FileEntry struct {
+	Name         string
+	Directory    bool
+	Size         int64      // Size in bytes. Zero for directories.
+	LastModified *time.Time // nil if the server did not report it.
+}
+
+// Lists the contents of @path straight from the music directory, or the root
+// of the music directory if @path is empty.
+func (this *Client) ListFiles(path string) (list []*FileEntry, err os.Error) {
+	var data []Args
+
+	if path == "" {
+		err = this.send("listfiles")
+	} else {
+		err = this.send("listfiles %s", quote(path))
+	}
+
+	if err != nil {
+		return
+	}
+
+	if data, err = this.receiveEntries("file", "directory"); err != nil {
+		return
+	}
+
+	list = make([]*FileEntry, 0, len(data))
+	for _, a := range data {
+		f := new(FileEntry)
+
+		if v, ok := a["directory"]; ok {
+			f.Name = v
+			f.Directory = true
+		} else {
+			f.Name = a["file"]
+		}
+
+		f.Size = a.Int64("size", 0)
+
+		if v, ok := a["Last-Modified"]; ok {
+			if f.LastModified, err = time.Parse(time.RFC3339, v); err != nil {
+				return nil, os.NewError(fmt.Sprintf("Invalid Last-Modified value '%s' for '%s'.", v, f.Name))
+			}
+		}
+
+		list = append(list, f)
+	}
+	return
+}
+
+// Returns the songs whose tags match all given tag/term pairs exactly, like
+// Find("genre", "Jazz", "artist", "Miles Davis").
+func (this *Client) Find(pairs ...string) (songs []Args, err os.Error) {
+	var list [][]Pair
+	if list, err = this.findPairs(pairs...); err != nil {
+		return
+	}
+	return songArgs(list), nil
+}
+
+// Like Find, but keeps every value of tags which occur more than once.
+func (this *Client) findPairs(pairs ...string) (songs [][]Pair, err os.Error) {
+	if len(pairs) == 0 || len(pairs)%2 != 0 {
+		return nil, &UsageError{"Find expects pairs of a tag and a term."}
+	}
+
+	args := make([]string, len(pairs))
+	for i, v := range pairs {
+		args[i] = quote(v)
+	}
+
+	if err = this.send("find %s", strings.Join(args, " ")); err != nil {
+		return
+	}
+
+	var data []Pair
+	if data, err = this.receivePairs(); err != nil {
+		return
+	}
+	return splitEntries(data, "file"), nil
+}
+
+// Returns all songs at or below @path, or in the whole database if @path
+// is empty.
+func (this *Client) ListAllInfo(path string) (songs []Args, err os.Error) {
+	var list [][]Pair
+	if list, err = this.listAllInfoPairs(path); err != nil {
+		return
+	}
+	return songArgs(list), nil
+}
+
+// Like ListAllInfo, but keeps every value of tags which occur more than
+// once.
+func (this *Client) listAllInfoPairs(path string) (songs [][]Pair, err os.Error) {
+	if path == "" {
+		err = this.send("listallinfo")
+	} else {
+		err = this.send("listallinfo %s", quote(path))
+	}
+
+	if err != nil {
+		return
+	}
+
+	var data []Pair
+	if data, err = this.receivePairs(); err != nil {
+		return
+	}
+
+	songs = make([][]Pair, 0)
+	for _, e := range splitEntries(data, "file", "directory", "playlist") {
+		if e[0].Key == "file" {
+			songs = append(songs, e)
+		}
+	}
+	return
+}
+
+// Turns songs read as pairs into Args. Of tags with several values, the
+// last one is kept.
+func songArgs(songs [][]Pair) []Args {
+	list := make([]Args, len(songs))
+	for i, s := range songs {
+		list[i] = pairArgs(s)
+	}
+	return list
+}
+
+// Reads the raw comments (tags) of the file at @path. Keys are reported
+// exactly as they appear in the file and may occur more than once.
+func (this *Client) ReadComments(path string) (comments []Pair, err os.Error) {
+	if err = this.send("readcomments %s", quote(path)); err != nil {
+		return
+	}
+	return this.receivePairs()
+}
 
 func find(cmd *Command, c *Client) (err os.Error) {
-	return c.requestList(
-		"find \"%s\" \"%s\"",
-		cmd.S("tag", "any"),
-		cmd.S("term", ""),
+	return cmd.requestList(c,
+		"find %s %s",
+		quote(cmd.S("tag", "any")),
+		quote(cmd.S("term", "")),
 	)
 }
 
 func list(cmd *Command, c *Client) (err os.Error) {
-	var arg []Args
-	var v string
-
 	str := ""
 	tag1 := cmd.S("tag1", "any")
 	tag2 := cmd.S("tag2", "")
 
 	if tag2 == "" {
-		str = fmt.Sprintf("list \"%s\"", tag1)
+		str = fmt.Sprintf("list %s", quote(tag1))
 	} else {
 		var term string
 		if term = cmd.S("term", ""); term == "" {
-			return os.NewError("Missing parameter @term if parameter @tag2 has been supplied.")
+			return &UsageError{"Missing parameter @term if parameter @tag2 has been supplied."}
 		}
-		str = fmt.Sprintf("list \"%s\" \"%s\" \"%s\"", tag1, tag2, term)
+		str = fmt.Sprintf("list %s %s %s", quote(tag1), quote(tag2), quote(term))
 	}
 
-	if arg, err = c.requestListArgs(str); err != nil {
+	return reportValues(cmd, c, str)
+}
+
+func listall(cmd *Command, c *Client) (err os.Error) {
+	str := "listall"
+	path := cmd.S("path", "")
+	if path != "" {
+		str = fmt.Sprintf("listall %s", quote(path))
+	}
+
+	return reportValues(cmd, c, str)
+}
+
+// Sends a command and reports each line of the response as a record of its
+// own, which is shown as just the value.
+func reportValues(cmd *Command, c *Client, str string) (err os.Error) {
+	if err = c.send(str); err != nil {
 		return
 	}
 
-	for _, a := range arg {
-		for _, v = range a {
-			fmt.Printf("%s\n", v)
+	var pairs []Pair
+	if pairs, err = c.receivePairs(); err != nil {
+		return
+	}
+
+	for _, p := range pairs {
+		if err = cmd.Record([]Pair{p}, p.Value); err != nil {
+			return
 		}
 	}
 	return
 }
 
-func listall(cmd *Command, c *Client) (err os.Error) {
-	var arg []Args
-	var v string
+func listallinfo(cmd *Command, c *Client) (err os.Error) {
+	path := cmd.S("path", "")
+	if path == "" {
+		return cmd.requestList(c, "listallinfo")
+	}
+	return cmd.requestList(c, "listallinfo %s", quote(path))
+}
 
-	str := "listall"
+func lsinfo(cmd *Command, c *Client) (err os.Error) {
 	path := cmd.S("path", "")
-	if path != "" {
-		str = fmt.Sprintf("listall \"%s\"", path)
+	if path == "" {
+		err = c.send("lsinfo")
+	} else {
+		err = c.send("lsinfo %s", quote(path))
+	}
+
+	if err != nil {
+		return
 	}
 
-	if arg, err = c.requestListArgs(str); err != nil {
+	var pairs []Pair
+	if pairs, err = c.receivePairs(); err != nil {
 		return
 	}
 
-	for _, a := range arg {
-		for _, v = range a {
-			fmt.Printf("%s\n", v)
+	// Stored playlists are listed in the root directory as well, but they
+	// are not part of the library.
+	for _, e := range splitEntries(pairs, "directory", "file", "playlist") {
+		if e[0].Key == "playlist" {
+			continue
+		}
+
+		if err = cmd.Record(e, e[0].Value); err != nil {
+			return
 		}
 	}
 	return
 }
 
-func listallinfo(cmd *Command, c *Client) (err os.Error) {
-	path := cmd.S("path", "")
+// Returns the paths of all songs below @path, or the whole database if @path
+// is empty. Directories and playlists are left out.
+func (this *Client) ListAllFiles(path string) (files []string, err os.Error) {
+	var pairs []Pair
+
 	if path == "" {
-		return c.requestList("listallinfo")
+		err = this.send("listall")
+	} else {
+		err = this.send("listall %s", quote(path))
+	}
+
+	if err != nil {
+		return
 	}
-	return c.requestList("listallinfo \"%s\"", path)
+
+	if pairs, err = this.receivePairs(); err != nil {
+		return
+	}
+
+	files = make([]string, 0, len(pairs))
+	for _, p := range pairs {
+		if p.Key == "file" {
+			files = append(files, p.Value)
+		}
+	}
+	return
 }
 
-func lsinfo(cmd *Command, c *Client) (err os.Error) {
-	var arg []Args
-	var k, v string
+// Returns the chromaprint fingerprint of the song at @path. The server
+// decodes part of the song to compute it, so this can take a while.
+func (this *Client) Fingerprint(path string) (fp string, err os.Error) {
+	var a Args
+	if a, err = this.requestArgs("getfingerprint %s", quote(path)); err != nil {
+		return
+	}
 
-	str := "lsinfo"
-	path := cmd.S("path", "")
-	if path != "" {
-		str = fmt.Sprintf("lsinfo \"%s\"", path)
+	if fp = a["chromaprint"]; fp == "" {
+		err = os.NewError(fmt.Sprintf("No fingerprint returned for '%s'.", path))
 	}
+	return
+}
 
-	if arg, err = c.requestListArgs(str); err != nil {
+func listfiles(cmd *Command, c *Client) (err os.Error) {
+	var list []*FileEntry
+	if list, err = c.ListFiles(cmd.S("path", "")); err != nil {
 		return
 	}
 
-	for _, a := range arg {
-		for k, v = range a {
-			if k != "directory" && k != "file" {
-				continue
-			}
-			fmt.Printf("%s\n", v)
+	for _, f := range list {
+		kind, size, modified := "-", fmt.Sprint(f.Size), "-"
+		fields := []Pair{Pair{"name", f.Name}, Pair{"type", "file"}}
+
+		if f.Directory {
+			kind, size = "d", "-"
+			fields[1].Value = "directory"
+		} else {
+			fields = append(fields, Pair{"size", size})
+		}
+
+		if f.LastModified != nil {
+			modified = f.LastModified.Format("2006-01-02 15:04")
+			fields = append(fields, Pair{"last_modified", f.LastModified.Format(time.RFC3339)})
+		}
+
+		if err = cmd.Record(fields, fmt.Sprintf("%s %12s %16s %s", kind, size, modified, f.Name)); err != nil {
+			return
 		}
 	}
 	return
 }
 
+func readcomments(cmd *Command, c *Client) (err os.Error) {
+	var list []Pair
+	if list, err = c.ReadComments(cmd.S("path", "")); err != nil {
+		return
+	}
+
+	lines := make([]string, len(list))
+	for i, p := range list {
+		lines[i] = fmt.Sprintf("%s: %s", p.Key, p.Value)
+	}
+
+	if len(list) == 0 {
+		return
+	}
+	return cmd.Record(list, strings.Join(lines, "\n"))
+}
+
+func fingerprint(cmd *Command, c *Client) (err os.Error) {
+	var fp string
+	if fp, err = c.Fingerprint(cmd.S("path", "")); err != nil {
+		return
+	}
+
+	return cmd.Record([]Pair{Pair{"chromaprint", fp}}, fp)
+}
+
 func search(cmd *Command, c *Client) (err os.Error) {
-	return c.requestList(
-		"search \"%s\" \"%s\"",
-		cmd.S("tag", "any"),
-		cmd.S("term", ""),
+	return cmd.requestList(c,
+		"search %s %s",
+		quote(cmd.S("tag", "any")),
+		quote(cmd.S("term", "")),
 	)
 }
 
 func count(cmd *Command, c *Client) (err os.Error) {
-	return c.requestList(
-		"count \"%s\" \"%s\"",
-		cmd.S("tag", "any"),
-		cmd.S("term", ""),
+	return cmd.requestList(c,
+		"count %s %s",
+		quote(cmd.S("tag", "any")),
+		quote(cmd.S("term", "")),
 	)
 }
@@ -0,0 +1,225 @@
+// Copyright (c) 2010, Jim Teeuwen. All rights reserved.
+// This code is subject to a 1-clause BSD license.
+// See the LICENSE file for its contents.
+
+package mpd
+
+import (
+	"testing"
+	"github.com/jteeuwen/go-pkg-mpd/mpdtest"
+)
+
+func TestDatabaseCommands(t *testing.T) {
+	srv, c := startServer(t)
+	defer srv.Close()
+	defer c.Close()
+
+	testCommands(t, srv, c, []commandTest{
+		commandTest{[]string{"find", "artist", "AC/DC"}, `find "artist" "AC/DC"`},
+		commandTest{[]string{"list", "artist"}, `list "artist"`},
+		commandTest{[]string{"list", "album", "artist", "AC/DC"}, `list "album" "artist" "AC/DC"`},
+		commandTest{[]string{"listall"}, "listall"},
+		commandTest{[]string{"listall", "rock"}, `listall "rock"`},
+		commandTest{[]string{"listallinfo"}, "listallinfo"},
+		commandTest{[]string{"listallinfo", "jazz"}, `listallinfo "jazz"`},
+		commandTest{[]string{"lsinfo"}, "lsinfo"},
+		commandTest{[]string{"lsinfo", "jazz"}, `lsinfo "jazz"`},
+		commandTest{[]string{"listfiles"}, "listfiles"},
+		commandTest{[]string{"listfiles", "rock/acdc"}, `listfiles "rock/acdc"`},
+		commandTest{[]string{"readcomments", "jazz/davis/so_what.flac"}, `readcomments "jazz/davis/so_what.flac"`},
+		commandTest{[]string{"fingerprint", "jazz/davis/so_what.flac"}, `getfingerprint "jazz/davis/so_what.flac"`},
+		commandTest{[]string{"search", "title", "steps"}, `search "title" "steps"`},
+		commandTest{[]string{"count", "genre", "Jazz"}, `count "genre" "Jazz"`},
+	})
+
+	if err := runCommand(c, "list", "album", "artist"); err == nil {
+		t.Errorf("Expected error for list with @tag2 but without @term.")
+	}
+
+	if err := runCommand(c, "lsinfo", "classical"); err == nil {
+		t.Errorf("Expected error for unknown directory.")
+	}
+}
+
+func TestListFiles(t *testing.T) {
+	srv, c := startServer(t)
+	defer srv.Close()
+	defer c.Close()
+
+	list, err := c.ListFiles("")
+	if err != nil {
+		t.Fatalf("ListFiles: %v", err)
+	}
+
+	if len(list) != 2 || !list[0].Directory || !list[1].Directory {
+		t.Fatalf("Expected two directories, got %d entries.", len(list))
+	}
+
+	if list, err = c.ListFiles("rock/acdc"); err != nil {
+		t.Fatalf("ListFiles: %v", err)
+	}
+
+	if len(list) != 2 {
+		t.Fatalf("Expected 2 files, got %d.", len(list))
+	}
+
+	f := list[0]
+	if f.Directory || f.Name != "hells_bells.mp3" || f.Size != 7488000 {
+		t.Errorf("Unexpected entry %+v.", f)
+	}
+
+	if f.LastModified == nil || f.LastModified.Year != 2011 {
+		t.Errorf("Expected modification time to be parsed.")
+	}
+}
+
+func TestReadComments(t *testing.T) {
+	srv, c := startServer(t)
+	defer srv.Close()
+	defer c.Close()
+
+	comments, err := c.ReadComments("rock/acdc/hells_bells.mp3")
+	if err != nil {
+		t.Fatalf("ReadComments: %v", err)
+	}
+
+	found := false
+	for _, p := range comments {
+		if p.Key == "ARTIST" && p.Value == "AC/DC" {
+			found = true
+		}
+	}
+
+	if !found {
+		t.Errorf("ARTIST comment missing from %v.", comments)
+	}
+
+	if _, err = c.ReadComments("missing.mp3"); err == nil {
+		t.Errorf("Expected error for missing file.")
+	}
+}
+
+func TestListAllFiles(t *testing.T) {
+	srv, c := startServer(t)
+	defer srv.Close()
+	defer c.Close()
+
+	files, err := c.ListAllFiles("")
+	if err != nil {
+		t.Fatalf("ListAllFiles: %v", err)
+	}
+
+	if len(files) != len(testSongs) {
+		t.Errorf("Expected %d files, got %d.", len(testSongs), len(files))
+	}
+
+	if files, err = c.ListAllFiles("jazz"); err != nil {
+		t.Fatalf("ListAllFiles: %v", err)
+	}
+
+	if len(files) != 2 || files[0] != "jazz/coltrane/giant_steps.flac" {
+		t.Errorf("Unexpected files %v.", files)
+	}
+}
+
+func TestFingerprints(t *testing.T) {
+	srv, c := startServer(t)
+	defer srv.Close()
+	defer c.Close()
+
+	fp, err := c.Fingerprint("jazz/davis/so_what.flac")
+	if err != nil {
+		t.Fatalf("Fingerprint: %v", err)
+	}
+
+	pool := NewPool(testConfig(srv), 3)
+	defer pool.Close()
+
+	results, err := Fingerprints(pool, "")
+	if err != nil {
+		t.Fatalf("Fingerprints: %v", err)
+	}
+
+	if len(results) != len(testSongs) {
+		t.Fatalf("Expected %d results, got %d.", len(testSongs), len(results))
+	}
+
+	for _, r := range results {
+		if r.Error != nil {
+			t.Errorf("%s: %v", r.File, r.Error)
+		}
+
+		if r.File == "jazz/davis/so_what.flac" && r.Fingerprint != fp {
+			t.Errorf("Expected fingerprint '%s', got '%s'.", fp, r.Fingerprint)
+		}
+	}
+}
+
+func TestFingerprintsBrokenConnection(t *testing.T) {
+	srv, c := startServer(t)
+	defer srv.Close()
+	defer c.Close()
+
+	broken := "jazz/davis/so_what.flac"
+	srv.Handle("getfingerprint", func(c *mpdtest.Conn, args []string, w *mpdtest.Response) *mpdtest.Ack {
+		if args[0] == broken {
+			c.Drop()
+			return nil
+		}
+		w.Add("chromaprint", "fp-"+args[0])
+		return nil
+	})
+
+	pool := NewPool(testConfig(srv), 1)
+	defer pool.Close()
+
+	results, err := Fingerprints(pool, "")
+	if err != nil {
+		t.Fatalf("Fingerprints: %v", err)
+	}
+
+	if len(results) != len(testSongs) {
+		t.Fatalf("Expected %d results, got %d.", len(testSongs), len(results))
+	}
+
+	// Only the song which broke the connection fails. The others are
+	// fetched over a new one.
+	for _, r := range results {
+		if failed := r.Error != nil; failed != (r.File == broken) {
+			t.Errorf("%s: unexpected error %v.", r.File, r.Error)
+		}
+	}
+}
+
+func TestLineBreaks(t *testing.T) {
+	srv, c := startServer(t)
+	defer srv.Close()
+	defer c.Close()
+
+	if _, err := c.Find("artist", "x\nkill"); err == nil {
+		t.Errorf("Find: Expected an error for a value with a line break.")
+	}
+
+	if err := c.ExecuteList(`add "x"`, "add \"y\"\nkill"); err == nil {
+		t.Errorf("ExecuteList: Expected an error for a command with a line break.")
+	}
+
+	// Nothing was sent, so the connection is still in a sane state.
+	if err := c.Ping(); err != nil {
+		t.Errorf("Ping: %v", err)
+	}
+
+	if cmds := srv.Commands(); len(cmds) != 1 || cmds[0] != "ping" {
+		t.Errorf("Unexpected commands %v.", cmds)
+	}
+
+	if c.Failed() {
+		t.Errorf("Expected the connection to remain usable.")
+	}
+
+	// Losing the connection is a failure.
+	srv.Close()
+	if err := c.Ping(); err == nil || !c.Failed() {
+		t.Errorf("Expected the connection to fail, got %v.", err)
+	}
+}
@@ -7,46 +7,533 @@ package mpd
 import (
 	"os"
 	"fmt"
+	"strings"
 )
 
+func init() {
+	mustRegister(GroupInfo,
+		&Command{
+			Name: "status",
+			Desc: "Reports the current status of MPD, as well as the current settings of some playback options.",
+			Exec: status,
+		},
+		&Command{
+			Name: "simplestatus",
+			Desc: "Same as status, but only basic info in 'prettier' output..",
+			Exec: simplestatus,
+		},
+		&Command{
+			Name: "stats",
+			Desc: "Reports database and playlist statistics.",
+			Exec: stats,
+		},
+		&Command{
+			Name: "outputs",
+			Desc: "Reports information about all known audio output devices.",
+			Exec: outputs,
+		},
+		&Command{
+			Name: "commands",
+			Desc: "Reports which commands the current user has access to.",
+			Exec: commands,
+		},
+		&Command{
+			Name: "notcommands",
+			Desc: "Reports which commands the current user has *no* access to.",
+			Exec: notcommands,
+		},
+		&Command{
+			Name: "tagtypes",
+			Desc: "Reports a list of available song metadata fields. Optionally enables or disables tag types first. Changes only apply to the current connection.",
+			Params: []*Param{
+				newParam("action", "One of list, enable, disable, clear or all. Defaults to list.", EnumKind("list", "enable", "disable", "clear", "all"), true).withDefault("list"),
+				newParam("tags", "Comma separated list of tag types to enable or disable.", TextKind, true),
+			},
+			Exec: tagtypes,
+		},
+		&Command{
+			Name: "decoders",
+			Desc: "Reports the available decoder plugins, with the file suffixes and MIME types they support.",
+			Exec: decoders,
+		},
+		&Command{
+			Name: "config",
+			Desc: "Reports server configuration values, like the music directory. Only available over a local unix socket connection.",
+			Exec: config,
+		},
+		&Command{
+			Name: "urlhandlers",
+			Desc: "Reports a list of available URL handlers.",
+			Exec: urlhandlers,
+		},
+	)
+}
+
+// Server status as reported by the status command.
+type Status struct {
+	PlaybackOptions
+	State          string // play, pause or stop.
+	Volume         int    // 0-100, or -1 if no mixer is available.
+	Playlist       int    // Playlist version.
+	PlaylistLength int
+	Song           int   // Position of the current song, or -1.
+	SongId         int   // Id of the current song, or -1.
+	NextSong       int   // Position of the next song, or -1.
+	NextSongId     int   // Id of the next song, or -1.
+	Elapsed        int64 // Elapsed time of the current song in nanoseconds.
+	Duration       int64 // Duration of the current song in nanoseconds.
+	Bitrate        int   // Instantaneous bitrate in kbps.
+	Audio          string
+	UpdatingDb     int // Id of the running update job, or 0.
+	Error          string
+}
+
+func newStatus(a Args) *Status {
+	s := new(Status)
+	s.PlaybackOptions = *newPlaybackOptions(a)
+	s.State = a.String("state", "stop")
+	s.Volume = a.Int("volume", -1)
+	s.Playlist = a.Int("playlist", 0)
+	s.PlaylistLength = a.Int("playlistlength", 0)
+	s.Song = a.Int("song", -1)
+	s.SongId = a.Int("songid", -1)
+	s.NextSong = a.Int("nextsong", -1)
+	s.NextSongId = a.Int("nextsongid", -1)
+	s.Bitrate = a.Int("bitrate", 0)
+	s.Audio = a["audio"]
+	s.UpdatingDb = a.Int("updating_db", 0)
+	s.Error = a["error"]
+
+	// Older servers only report 'time' as integer seconds in the form
+	// elapsed:total. Prefer the fractional 'elapsed' and 'duration' fields.
+	var elapsed, duration string
+	if t := a["time"]; t != "" {
+		if pos := strings.Index(t, ":"); pos != -1 {
+			elapsed, duration = t[:pos], t[pos+1:]
+		}
+	}
+
+	if v, ok := a["elapsed"]; ok {
+		elapsed = v
+	}
+
+	if v, ok := a["duration"]; ok {
+		duration = v
+	}
+
+	s.Elapsed, _ = parseSeconds(elapsed)
+	s.Duration, _ = parseSeconds(duration)
+	return s
+}
+
+// Reads the current server status.
+func (this *Client) Status() (s *Status, err os.Error) {
+	var a Args
+	if a, err = this.requestArgs("status"); err != nil {
+		return
+	}
+	return newStatus(a), nil
+}
+
+// Statistics of the server and its database, as reported by the stats
+// command. Times are in seconds.
+type Stats struct {
+	Artists    int
+	Albums     int
+	Songs      int
+	Uptime     int64 // Time the server has been running.
+	Playtime   int64 // Time the server has been playing.
+	DbPlaytime int64 // Total length of all songs in the database.
+	DbUpdate   int64 // Unix time of the last database update.
+}
+
+func (this *Client) Stats() (s *Stats, err os.Error) {
+	var a Args
+	if a, err = this.requestArgs("stats"); err != nil {
+		return
+	}
+
+	s = new(Stats)
+	s.Artists = a.Int("artists", 0)
+	s.Albums = a.Int("albums", 0)
+	s.Songs = a.Int("songs", 0)
+	s.Uptime = a.Int64("uptime", 0)
+	s.Playtime = a.Int64("playtime", 0)
+	s.DbPlaytime = a.Int64("db_playtime", 0)
+	s.DbUpdate = a.Int64("db_update", 0)
+	return
+}
+
+// An audio output, as reported by the outputs command.
+type Output struct {
+	Id      int
+	Name    string
+	Plugin  string
+	Enabled bool
+}
+
+func (this *Client) Outputs() (list []*Output, err os.Error) {
+	if err = this.send("outputs"); err != nil {
+		return
+	}
+
+	var entries []Args
+	if entries, err = this.receiveEntries("outputid"); err != nil {
+		return
+	}
+
+	list = make([]*Output, len(entries))
+	for i, a := range entries {
+		list[i] = &Output{a.Int("outputid", 0), a["outputname"], a["plugin"], a.Bool("outputenabled", false)}
+	}
+	return
+}
+
+// Blocks until one of the given subsystems changes, or any subsystem if none
+// are given. Returns the names of the changed subsystems. Call NoIdle from
+// another goroutine to cancel the wait.
+//
+// Valid subsystems are: database, update, stored_playlist, playlist, player,
+// mixer, output, options, sticker, subscription and message.
+func (this *Client) Idle(subsystems ...string) (changed []string, err os.Error) {
+	if err = this.sendIdle(subsystems); err != nil {
+		return
+	}
+	return this.receiveIdle()
+}
+
+// Like Idle, but also returns when something is received from @stop. The
+// changes collected up to that point, if any, are returned as well. Unlike
+// calling NoIdle from another goroutine, this can not cancel the wait
+// before it has started.
+func (this *Client) IdleUntil(stop <-chan bool, subsystems ...string) (changed []string, err os.Error) {
+	if err = this.sendIdle(subsystems); err != nil {
+		return
+	}
+
+	done := make(chan os.Error, 1)
+	go func() {
+		var e os.Error
+		changed, e = this.receiveIdle()
+		done <- e
+	}()
+
+	select {
+	case err = <-done:
+	case <-stop:
+		if err = this.NoIdle(); err == nil {
+			err = <-done
+		}
+	}
+	return
+}
+
+// Reports the current song and the status of the player to @observe once
+// at the start, and whenever the player changes, until @stop is closed.
+// Then the last song is reported once more with the state "stop", so that
+// the time it played can be accounted for.
+func (this *Client) followPlayer(stop <-chan bool, observe func(song []Pair, status *Status)) (err os.Error) {
+	for {
+		var song []Pair
+		var status *Status
+
+		if status, err = this.Status(); err != nil {
+			return
+		}
+
+		if err = this.send("currentsong"); err != nil {
+			return
+		}
+
+		if song, err = this.receivePairs(); err != nil {
+			return
+		}
+
+		observe(song, status)
+
+		if _, err = this.IdleUntil(stop, "player"); err != nil {
+			return
+		}
+
+		select {
+		case <-stop:
+			observe(song, &Status{State: "stop"})
+			return
+		default:
+		}
+	}
+	return
+}
+
+func (this *Client) sendIdle(subsystems []string) os.Error {
+	cmd := "idle"
+	if len(subsystems) > 0 {
+		cmd += " " + strings.Join(subsystems, " ")
+	}
+	return this.send(cmd)
+}
+
+func (this *Client) receiveIdle() (changed []string, err os.Error) {
+	var list []Args
+	if list, err = this.receiveList(); err != nil {
+		return
+	}
+
+	changed = make([]string, 0, len(list))
+	for _, a := range list {
+		changed = append(changed, a["changed"])
+	}
+	return
+}
+
+// Cancels a pending Idle call. Idle then returns with the changes that were
+// collected up to that point, if any.
+func (this *Client) NoIdle() os.Error {
+	return this.send("noidle")
+}
+
+// A decoder plugin and the file types it supports.
+type Decoder struct {
+	Plugin    string
+	Suffixes  []string
+	MimeTypes []string
+}
+
+type Decoders []*Decoder
+
+// Reports whether any of the decoders handles files with the suffix of @file.
+func (this Decoders) CanDecode(file string) bool {
+	pos := strings.LastIndex(file, ".")
+	if pos == -1 {
+		return false
+	}
+
+	suffix := strings.ToLower(file[pos+1:])
+	for _, d := range this {
+		if contains(d.Suffixes, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the decoder plugins known to the server.
+func (this *Client) Decoders() (list Decoders, err os.Error) {
+	var pairs []Pair
+	var d *Decoder
+
+	if err = this.send("decoders"); err != nil {
+		return
+	}
+
+	if pairs, err = this.receivePairs(); err != nil {
+		return
+	}
+
+	list = make(Decoders, 0)
+	for _, p := range pairs {
+		switch p.Key {
+		case "plugin":
+			d = &Decoder{p.Value, make([]string, 0), make([]string, 0)}
+			list = append(list, d)
+		case "suffix":
+			if d != nil {
+				d.Suffixes = append(d.Suffixes, p.Value)
+			}
+		case "mime_type":
+			if d != nil {
+				d.MimeTypes = append(d.MimeTypes, p.Value)
+			}
+		}
+	}
+	return
+}
+
+// Returns the server configuration values exposed to clients. The server only
+// answers this over a local (unix socket) connection.
+func (this *Client) ServerConfig() (Args, os.Error) {
+	return this.requestArgs("config")
+}
+
+// Returns the absolute path of the music directory. Only available over a
+// local (unix socket) connection.
+func (this *Client) MusicDirectory() (dir string, err os.Error) {
+	var a Args
+	if a, err = this.ServerConfig(); err != nil {
+		return
+	}
+
+	if dir = a["music_directory"]; dir == "" {
+		err = os.NewError("Server did not report a music directory.")
+	}
+	return
+}
+
+// Returns the tag types enabled for this connection.
+func (this *Client) TagTypes() (tags []string, err os.Error) {
+	var pairs []Pair
+
+	if err = this.send("tagtypes"); err != nil {
+		return
+	}
+
+	if pairs, err = this.receivePairs(); err != nil {
+		return
+	}
+
+	tags = make([]string, 0, len(pairs))
+	for _, p := range pairs {
+		tags = append(tags, p.Value)
+	}
+	return
+}
+
+// Enables the given tag types for this connection. Songs reported afterwards
+// include these tags.
+func (this *Client) EnableTagTypes(tags ...string) os.Error {
+	return this.tagtypes("enable", tags)
+}
+
+// Disables the given tag types for this connection. Songs reported afterwards
+// no longer include these tags, which reduces the size of responses.
+func (this *Client) DisableTagTypes(tags ...string) os.Error {
+	return this.tagtypes("disable", tags)
+}
+
+// Disables all tag types for this connection.
+func (this *Client) ClearTagTypes() os.Error {
+	return this.execute("tagtypes clear")
+}
+
+// Enables all tag types for this connection.
+func (this *Client) AllTagTypes() os.Error {
+	return this.execute("tagtypes all")
+}
+
+func (this *Client) tagtypes(action string, tags []string) os.Error {
+	if len(tags) == 0 {
+		return &UsageError{fmt.Sprintf("tagtypes %s requires at least one tag.", action)}
+	}
+
+	args := make([]string, len(tags))
+	for i, t := range tags {
+		args[i] = quote(t)
+	}
+	return this.execute("tagtypes %s %s", action, strings.Join(args, " "))
+}
+
 func status(cmd *Command, c *Client) (err os.Error) {
-	return c.request("status")
+	return cmd.request(c, "status")
 }
 
 func simplestatus(cmd *Command, c *Client) (err os.Error) {
-	var a Args
-	if a, err = c.requestArgs("status"); err != nil {
+	if err = c.send("status"); err != nil {
+		return
+	}
+
+	var pairs []Pair
+	if pairs, err = c.receivePairs(); err != nil {
 		return
 	}
 
-	fmt.Printf(
-		"[%s] vol: %s%%, repeat %s, single %s, random %s, consume %s\n",
+	a := pairArgs(pairs)
+	fields := make([]Pair, 0, 6)
+	for _, k := range []string{"state", "volume", "repeat", "single", "random", "consume"} {
+		fields = append(fields, Pair{k, a[k]})
+	}
+
+	return cmd.Record(fields, fmt.Sprintf(
+		"[%s] vol: %s%%, repeat %s, single %s, random %s, consume %s",
 		a["state"], a["volume"], onoff(a["repeat"]), onoff(a["single"]),
 		onoff(a["random"]), onoff(a["consume"]),
-	)
-	return
+	))
 }
 
 func stats(cmd *Command, c *Client) (err os.Error) {
-	return c.request("stats")
+	return cmd.request(c, "stats")
 }
 
 func outputs(cmd *Command, c *Client) (err os.Error) {
-	return c.requestList("outputs")
+	return cmd.requestList(c, "outputs")
 }
 
 func commands(cmd *Command, c *Client) (err os.Error) {
-	return c.requestList("commands")
+	return cmd.requestList(c, "commands")
 }
 
 func notcommands(cmd *Command, c *Client) (err os.Error) {
-	return c.requestList("notcommands")
+	return cmd.requestList(c, "notcommands")
 }
 
 func tagtypes(cmd *Command, c *Client) (err os.Error) {
-	return c.requestList("tagtypes")
+	tags := strings.Fields(strings.Replace(cmd.S("tags", ""), ",", " ", -1))
+
+	switch cmd.S("action", "list") {
+	case "enable":
+		err = c.EnableTagTypes(tags...)
+	case "disable":
+		err = c.DisableTagTypes(tags...)
+	case "clear":
+		err = c.ClearTagTypes()
+	case "all":
+		err = c.AllTagTypes()
+	}
+
+	if err != nil {
+		return
+	}
+
+	// Tag type changes only apply to the current connection, so report the
+	// resulting set to show what they would look like.
+	if tags, err = c.TagTypes(); err != nil {
+		return
+	}
+
+	for _, t := range tags {
+		if err = cmd.Record([]Pair{Pair{"tagtype", t}}, t); err != nil {
+			return
+		}
+	}
+	return
+}
+
+func decoders(cmd *Command, c *Client) (err os.Error) {
+	var list Decoders
+	if list, err = c.Decoders(); err != nil {
+		return
+	}
+
+	for _, d := range list {
+		fields := []Pair{Pair{"plugin", d.Plugin}}
+		text := d.Plugin
+
+		for _, v := range d.Suffixes {
+			fields = append(fields, Pair{"suffix", v})
+		}
+
+		for _, v := range d.MimeTypes {
+			fields = append(fields, Pair{"mime_type", v})
+		}
+
+		if len(d.Suffixes) > 0 {
+			text += "\n  suffixes: " + strings.Join(d.Suffixes, " ")
+		}
+
+		if len(d.MimeTypes) > 0 {
+			text += "\n  mime types: " + strings.Join(d.MimeTypes, " ")
+		}
+
+		if err = cmd.Record(fields, text); err != nil {
+			return
+		}
+	}
+	return
+}
+
+func config(cmd *Command, c *Client) (err os.Error) {
+	return cmd.request(c, "config")
 }
 
 func urlhandlers(cmd *Command, c *Client) (err os.Error) {
-	return c.requestList("urlhandlers")
+	return cmd.requestList(c, "urlhandlers")
 }
@@ -0,0 +1,238 @@
+// Copyright (c) 2010, Jim Teeuwen. All rights reserved.
+// This code is subject to a 1-clause BSD license.
+// See the LICENSE file for its contents.
+
+package mpd
+
+import (
+	"os"
+	"fmt"
+	"time"
+	"testing"
+	"github.com/jteeuwen/go-pkg-mpd/mpdtest"
+)
+
+func TestInfoCommands(t *testing.T) {
+	srv, c := startServer(t)
+	defer srv.Close()
+	defer c.Close()
+
+	testCommands(t, srv, c, []commandTest{
+		commandTest{[]string{"status"}, "status"},
+		commandTest{[]string{"simplestatus"}, "status"},
+		commandTest{[]string{"stats"}, "stats"},
+		commandTest{[]string{"outputs"}, "outputs"},
+		commandTest{[]string{"commands"}, "commands"},
+		commandTest{[]string{"notcommands"}, "notcommands"},
+		commandTest{[]string{"tagtypes"}, "tagtypes"},
+		commandTest{[]string{"tagtypes", "disable", "artist,album"}, "tagtypes"},
+		commandTest{[]string{"tagtypes", "all"}, "tagtypes"},
+		commandTest{[]string{"urlhandlers"}, "urlhandlers"},
+		commandTest{[]string{"decoders"}, "decoders"},
+	})
+
+	if err := runCommand(c, "config"); err == nil {
+		t.Errorf("Expected config to be refused over tcp.")
+	}
+}
+
+func TestStatus(t *testing.T) {
+	srv, c := startServer(t)
+	defer srv.Close()
+	defer c.Close()
+
+	srv.Enqueue("rock/acdc/hells_bells.mp3", "rock/acdc/back_in_black.mp3")
+
+	s, err := c.Status()
+	if err != nil {
+		t.Fatalf("Status: %v", err)
+	}
+
+	if s.State != "stop" || s.Song != -1 || s.PlaylistLength != 2 || s.Volume != 100 {
+		t.Errorf("Unexpected status %+v.", s)
+	}
+
+	if err = c.Seek(1, 90.5e9); err != nil {
+		t.Fatalf("Seek: %v", err)
+	}
+
+	if s, err = c.Status(); err != nil {
+		t.Fatalf("Status: %v", err)
+	}
+
+	if s.State != "play" || s.Song != 1 || s.NextSong != -1 {
+		t.Errorf("Unexpected status %+v.", s)
+	}
+
+	if s.Elapsed != 90.5e9 || s.Duration != 255e9 {
+		t.Errorf("Expected 90.5s of 255s, got %d/%d.", s.Elapsed, s.Duration)
+	}
+}
+
+func TestIdle(t *testing.T) {
+	srv, c := startServer(t)
+	defer srv.Close()
+	defer c.Close()
+
+	go func() {
+		time.Sleep(1e8)
+		srv.Notify("mixer", "player")
+	}()
+
+	changed, err := c.Idle("player")
+	if err != nil {
+		t.Fatalf("Idle: %v", err)
+	}
+
+	if len(changed) != 1 || changed[0] != "player" {
+		t.Errorf("Expected player change, got %v.", changed)
+	}
+
+	go func() {
+		time.Sleep(1e8)
+		c.NoIdle()
+	}()
+
+	if changed, err = c.Idle("database"); err != nil {
+		t.Fatalf("Idle: %v", err)
+	}
+
+	if len(changed) != 0 {
+		t.Errorf("Expected no changes after noidle, got %v.", changed)
+	}
+}
+
+func TestDecoders(t *testing.T) {
+	srv, c := startServer(t)
+	defer srv.Close()
+	defer c.Close()
+
+	list, err := c.Decoders()
+	if err != nil {
+		t.Fatalf("Decoders: %v", err)
+	}
+
+	if len(list) != 3 || list[0].Plugin != "mad" || len(list[1].MimeTypes) != 2 {
+		t.Errorf("Unexpected decoders %v.", list)
+	}
+
+	if !list.CanDecode("incoming/song.MP3") || !list.CanDecode("a.b/c.flac") {
+		t.Errorf("Expected mp3 and flac files to be supported.")
+	}
+
+	if list.CanDecode("song.wav") || list.CanDecode("README") {
+		t.Errorf("Unexpected support for wav or extensionless files.")
+	}
+}
+
+func TestServerConfig(t *testing.T) {
+	srv := mpdtest.NewServer()
+	path := fmt.Sprintf("/tmp/mpdtest-%d.sock", os.Getpid())
+	os.Remove(path)
+
+	if err := srv.Listen("unix", path); err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	defer srv.Close()
+
+	cfg := NewConfig()
+	cfg.Address = path
+
+	c, err := Dial(cfg)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer c.Close()
+
+	dir, err := c.MusicDirectory()
+	if err != nil {
+		t.Fatalf("MusicDirectory: %v", err)
+	}
+
+	if dir != srv.MusicDirectory {
+		t.Errorf("Expected '%s', got '%s'.", srv.MusicDirectory, dir)
+	}
+
+	if err = runCommand(c, "config"); err != nil {
+		t.Errorf("config: %v", err)
+	}
+}
+
+func TestDialUnsupportedVersion(t *testing.T) {
+	srv := mpdtest.NewServer()
+	srv.Version = "0.12.0"
+
+	if err := srv.Listen("tcp", "127.0.0.1:0"); err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	defer srv.Close()
+
+	cfg := testConfig(srv)
+	cfg.Transcript = fmt.Sprintf("/tmp/mpdtest-%d.transcript", os.Getpid())
+	defer os.Remove(cfg.Transcript)
+
+	if _, err := Dial(cfg); err == nil {
+		t.Fatalf("Expected an error for protocol version 0.12.0.")
+	}
+
+	// The server sees the connection closed.
+	for i := 0; i < 100 && srv.Connections() > 0; i++ {
+		time.Sleep(10e6)
+	}
+
+	if n := srv.Connections(); n != 0 {
+		t.Errorf("Expected no open connections, got %d.", n)
+	}
+}
+
+func TestTagTypes(t *testing.T) {
+	srv, c := startServer(t)
+	defer srv.Close()
+	defer c.Close()
+
+	all, err := c.TagTypes()
+	if err != nil {
+		t.Fatalf("TagTypes: %v", err)
+	}
+
+	if err = c.DisableTagTypes("Artist", "Album"); err != nil {
+		t.Fatalf("DisableTagTypes: %v", err)
+	}
+
+	if last := srv.Last(); last != `tagtypes disable "Artist" "Album"` {
+		t.Errorf("Unexpected command '%s'.", last)
+	}
+
+	tags, _ := c.TagTypes()
+	if len(tags) != len(all)-2 || contains(tags, "Artist") {
+		t.Errorf("Expected Artist and Album to be disabled, got %v.", tags)
+	}
+
+	if err = c.EnableTagTypes("Artist"); err != nil {
+		t.Fatalf("EnableTagTypes: %v", err)
+	}
+
+	if err = c.ClearTagTypes(); err != nil {
+		t.Fatalf("ClearTagTypes: %v", err)
+	}
+
+	if tags, _ = c.TagTypes(); len(tags) != 0 {
+		t.Errorf("Expected no tag types after clear, got %v.", tags)
+	}
+
+	if err = c.AllTagTypes(); err != nil {
+		t.Fatalf("AllTagTypes: %v", err)
+	}
+
+	if tags, _ = c.TagTypes(); len(tags) != len(all) {
+		t.Errorf("Expected all tag types, got %v.", tags)
+	}
+
+	if err = c.EnableTagTypes("Bogus"); err == nil {
+		t.Errorf("Expected error for unknown tag type.")
+	}
+
+	if err = c.EnableTagTypes(); err == nil {
+		t.Errorf("Expected error for empty tag list.")
+	}
+}
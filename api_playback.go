@@ -6,9 +6,307 @@ package mpd
 
 import (
 	"os"
+	"fmt"
+	"math"
 	"strconv"
 )
 
+func init() {
+	mustRegister(GroupPlayback,
+		&Command{
+			Name: "crossfade",
+			Desc: "Sets crossfading (mixing) between songs.",
+			Params: []*Param{
+				newParam("time", "Crossfade time in seconds.", IndexKind, false),
+			},
+			Exec: crossfade,
+		},
+		&Command{
+			Name: "next",
+			Desc: "Skip to next song.",
+			Exec: next,
+		},
+		&Command{
+			Name: "pause",
+			Desc: "Toggle pause on/off",
+			Params: []*Param{
+				newParam("toggle", "Whether to pause or resume.", BoolKind, false),
+			},
+			Exec: pause,
+		},
+		&Command{
+			Name: "play",
+			Desc: "Play the song at the specified position.",
+			Params: []*Param{
+				newParam("pos", "Position of song to play.", IndexKind, false),
+			},
+			Exec: play,
+		},
+		&Command{
+			Name: "playid",
+			Desc: "Play the song with the specified id.",
+			Params: []*Param{
+				newParam("id", "Id of the song to play.", IndexKind, false),
+			},
+			Exec: playid,
+		},
+		&Command{
+			Name: "previous",
+			Desc: "Go back to previous song.",
+			Exec: previous,
+		},
+		&Command{
+			Name: "random",
+			Desc: "Toggle random mode on/off",
+			Params: []*Param{
+				newParam("toggle", "Whether to play randomized or not.", BoolKind, false),
+			},
+			Exec: random,
+		},
+		&Command{
+			Name: "repeat",
+			Desc: "Toggle repeat mode on/off",
+			Params: []*Param{
+				newParam("toggle", "Whether to repeat or not.", BoolKind, false),
+			},
+			Exec: repeat,
+		},
+		&Command{
+			Name: "seek",
+			Desc: "Skip to specific point in time in song at position @pos.",
+			Params: []*Param{
+				newParam("pos", "Position of song to skip to.", IndexKind, false),
+				newParam("time", "Time to jump to. Either seconds (90.5), mm:ss (1:30) or units (1m30s).", DurationKind, false),
+			},
+			Exec: seek,
+		},
+		&Command{
+			Name: "seekid",
+			Desc: "Skip to specific point in time in song with @id.",
+			Params: []*Param{
+				newParam("id", "Id of song to skip to.", IndexKind, false),
+				newParam("time", "Time to jump to. Either seconds (90.5), mm:ss (1:30) or units (1m30s).", DurationKind, false),
+			},
+			Exec: seekid,
+		},
+		&Command{
+			Name: "seekcur",
+			Desc: "Skip to specific point in time in the current song. Prefix @time with + or - to seek relative to the current position.",
+			Params: []*Param{
+				newParam("time", "Time to jump to. Either seconds (90.5), mm:ss (1:30) or units (1m30s), optionally prefixed with + or -.", OffsetKind, false),
+			},
+			Exec: seekcur,
+		},
+		&Command{
+			Name: "volume",
+			Desc: "Volume adjustment. Allows setting of explicit volume value as well as a relative increase and decrease of current volume.",
+			Params: []*Param{
+				newParam("value", "New volume value. Can be 0-100. Prefixed with + or -, or in conjunction with the optional @sign parameter, the current volume is increased/decreased by @value instead.", RelativeKind(0, 100), false),
+				newParam("sign", "Optional + or - to indicate current volume should be adjusted by @value.", EnumKind("+", "-"), true),
+			},
+			Exec: volume,
+		},
+		&Command{
+			Name: "stop",
+			Desc: "Stop the playback.",
+			Exec: stop,
+		},
+		&Command{
+			Name: "toggle",
+			Desc: "Toggles between play/pause",
+			Exec: toggle,
+		},
+		&Command{
+			Name: "consume",
+			Desc: "Toggle consume mode on/off. When consume is on, each song played is removed from the playlist. In oneshot mode, consume is turned off again after the current song.",
+			Params: []*Param{
+				newParam("toggle", "on, off or oneshot.", EnumKind("on", "off", "oneshot"), false),
+			},
+			Exec: consume,
+		},
+		&Command{
+			Name: "single",
+			Desc: "Toggle single mode on/off. When single is on, playback stops after the current song, or the song is repeated if repeat is on. In oneshot mode, single is turned off again after the current song.",
+			Params: []*Param{
+				newParam("toggle", "on, off or oneshot.", EnumKind("on", "off", "oneshot"), false),
+			},
+			Exec: single,
+		},
+		&Command{
+			Name: "replaygain",
+			Desc: "Sets the replay gain mode, or reports the current mode if @mode is omitted.",
+			Params: []*Param{
+				newParam("mode", "One of off, track, album or auto.", EnumKind("off", "track", "album", "auto"), true),
+			},
+			Exec: replaygain,
+		},
+		&Command{
+			Name: "mixrampdb",
+			Desc: "Sets the threshold at which songs will be overlapped. Like crossfading but doesn't fade the track volume, just overlaps.",
+			Params: []*Param{
+				newParam("db", "Threshold in decibels. Usually a negative value.", FloatKind(math.Inf(-1), math.Inf(1)), false),
+			},
+			Exec: mixrampdb,
+		},
+		&Command{
+			Name: "mixrampdelay",
+			Desc: "Additional time subtracted from the overlap calculated by mixrampdb.",
+			Params: []*Param{
+				newParam("time", "Delay in seconds. A value of 'nan' disables MixRamp overlapping and falls back to crossfading.", AnyKind(FloatKind(0, math.Inf(1)), EnumKind("nan")), false),
+			},
+			Exec: mixrampdelay,
+		},
+		&Command{
+			Name: "options",
+			Desc: "Reports the current settings of all playback options.",
+			Exec: options,
+		},
+	)
+
+	mustAlias("prev", "previous")
+	mustAlias("vol", "volume")
+}
+
+// Tri-state value used by the 'single' and 'consume' playback options.
+type OptionMode byte
+
+const (
+	ModeOff OptionMode = iota
+	ModeOn
+	ModeOneshot // Applies once and then reverts to ModeOff.
+)
+
+// Parses an option mode as reported by the status command (0, 1, oneshot)
+// or as entered on the command line (off, on, oneshot).
+func ParseOptionMode(v string) (OptionMode, os.Error) {
+	switch v {
+	case "0", "off":
+		return ModeOff, nil
+	case "1", "on":
+		return ModeOn, nil
+	case "oneshot":
+		return ModeOneshot, nil
+	}
+	return ModeOff, os.NewError(fmt.Sprintf("Invalid option mode '%s'. Expected on, off or oneshot.", v))
+}
+
+func (this OptionMode) String() string {
+	switch this {
+	case ModeOn:
+		return "on"
+	case ModeOneshot:
+		return "oneshot"
+	}
+	return "off"
+}
+
+// Returns the value as it is expected by the MPD protocol.
+func (this OptionMode) arg() string {
+	switch this {
+	case ModeOn:
+		return "1"
+	case ModeOneshot:
+		return "oneshot"
+	}
+	return "0"
+}
+
+// Valid values for Client.SetReplayGainMode.
+const (
+	ReplayGainOff   = "off"
+	ReplayGainTrack = "track"
+	ReplayGainAlbum = "album"
+	ReplayGainAuto  = "auto"
+)
+
+// Current settings of the playback options, as reported by the status command.
+type PlaybackOptions struct {
+	Random       bool
+	Repeat       bool
+	Single       OptionMode
+	Consume      OptionMode
+	Crossfade    int     // Crossfade time in seconds.
+	MixRampDb    float64 // MixRamp threshold in decibels.
+	MixRampDelay float64 // MixRamp delay in seconds. Negative when disabled.
+}
+
+func newPlaybackOptions(a Args) *PlaybackOptions {
+	opt := new(PlaybackOptions)
+	opt.Random = a.Bool("random", false)
+	opt.Repeat = a.Bool("repeat", false)
+	opt.Single, _ = ParseOptionMode(a["single"])
+	opt.Consume, _ = ParseOptionMode(a["consume"])
+	opt.Crossfade = a.Int("xfade", 0)
+	opt.MixRampDb = a.Float64("mixrampdb", 0)
+	opt.MixRampDelay = -1
+
+	if v := a["mixrampdelay"]; v != "" && v != "nan" {
+		opt.MixRampDelay = a.Float64("mixrampdelay", -1)
+	}
+	return opt
+}
+
+// Reads the current playback options from the server status.
+func (this *Client) PlaybackOptions() (opt *PlaybackOptions, err os.Error) {
+	var a Args
+	if a, err = this.requestArgs("status"); err != nil {
+		return
+	}
+	return newPlaybackOptions(a), nil
+}
+
+func (this *Client) SetRandom(v bool) os.Error {
+	return this.execute("random %d", btoi(v))
+}
+
+func (this *Client) SetRepeat(v bool) os.Error {
+	return this.execute("repeat %d", btoi(v))
+}
+
+func (this *Client) SetSingle(mode OptionMode) os.Error {
+	return this.execute("single %s", mode.arg())
+}
+
+func (this *Client) SetConsume(mode OptionMode) os.Error {
+	return this.execute("consume %s", mode.arg())
+}
+
+// Sets the crossfade time in seconds.
+func (this *Client) SetCrossfade(seconds int) os.Error {
+	return this.execute("crossfade %d", seconds)
+}
+
+// Sets the replay gain mode. One of the ReplayGainXXX constants.
+func (this *Client) SetReplayGainMode(mode string) os.Error {
+	if !PatReplayGain.MatchString(mode) {
+		return &UsageError{fmt.Sprintf("Invalid replay gain mode '%s'.", mode)}
+	}
+	return this.execute("replay_gain_mode %s", mode)
+}
+
+// Returns the current replay gain mode.
+func (this *Client) ReplayGainStatus() (mode string, err os.Error) {
+	var a Args
+	if a, err = this.requestArgs("replay_gain_status"); err != nil {
+		return
+	}
+	return a.String("replay_gain_mode", ReplayGainOff), nil
+}
+
+// Sets the MixRamp threshold in decibels.
+func (this *Client) SetMixRampDb(db float64) os.Error {
+	return this.execute("mixrampdb %s", strconv.Ftoa64(db, 'f', -1))
+}
+
+// Sets the MixRamp overlap in seconds. A negative value disables MixRamp and
+// falls back to crossfading.
+func (this *Client) SetMixRampDelay(seconds float64) os.Error {
+	if seconds < 0 {
+		return this.execute("mixrampdelay nan")
+	}
+	return this.execute("mixrampdelay %s", strconv.Ftoa64(seconds, 'f', -1))
+}
+
 func toggle(cmd *Command, c *Client) (err os.Error) {
 	var arg Args
 	if arg, err = c.requestArgs("status"); err != nil {
@@ -16,17 +314,120 @@ func toggle(cmd *Command, c *Client) (err os.Error) {
 	}
 
 	if arg["state"] == "play" {
-		return c.request("pause 1")
+		return cmd.request(c, "pause 1")
 	}
-	return c.request("play")
+	return cmd.request(c, "play")
+}
+
+// Seeks to position @t (in nanoseconds) in the song at playlist position @pos.
+func (this *Client) Seek(pos int, t int64) os.Error {
+	return this.execute("seek %d %s", pos, formatSeconds(t))
+}
+
+// Seeks to position @t (in nanoseconds) in the song with the given id.
+func (this *Client) SeekId(id int, t int64) os.Error {
+	return this.execute("seekid %d %s", id, formatSeconds(t))
+}
+
+// Seeks within the current song. @t is in nanoseconds. If @relative is true,
+// @t is an offset from the current position and may be negative. Otherwise it
+// is an absolute position.
+func (this *Client) SeekCurrent(t int64, relative bool) os.Error {
+	if !relative {
+		if t < 0 {
+			return &UsageError{"Absolute seek position can not be negative."}
+		}
+		return this.execute("seekcur %s", formatSeconds(t))
+	}
+
+	if t < 0 {
+		return this.execute("seekcur -%s", formatSeconds(-t))
+	}
+	return this.execute("seekcur +%s", formatSeconds(t))
 }
 
 func crossfade(cmd *Command, c *Client) (err os.Error) {
-	return c.request("crossfade %d", cmd.I("time", 0))
+	return c.SetCrossfade(cmd.I("time", 0))
+}
+
+func consume(cmd *Command, c *Client) (err os.Error) {
+	var mode OptionMode
+	if mode, err = ParseOptionMode(cmd.S("toggle", "")); err != nil {
+		return
+	}
+	return c.SetConsume(mode)
+}
+
+func single(cmd *Command, c *Client) (err os.Error) {
+	var mode OptionMode
+	if mode, err = ParseOptionMode(cmd.S("toggle", "")); err != nil {
+		return
+	}
+	return c.SetSingle(mode)
+}
+
+func replaygain(cmd *Command, c *Client) (err os.Error) {
+	if mode := cmd.S("mode", ""); mode != "" {
+		return c.SetReplayGainMode(mode)
+	}
+
+	var mode string
+	if mode, err = c.ReplayGainStatus(); err != nil {
+		return
+	}
+
+	return cmd.Record([]Pair{Pair{"replay_gain_mode", mode}}, "replay gain: "+mode)
+}
+
+func mixrampdb(cmd *Command, c *Client) (err os.Error) {
+	return c.SetMixRampDb(cmd.F("db", 0))
+}
+
+func mixrampdelay(cmd *Command, c *Client) (err os.Error) {
+	if cmd.S("time", "") == "nan" {
+		return c.SetMixRampDelay(-1)
+	}
+	return c.SetMixRampDelay(cmd.F("time", 0))
+}
+
+func options(cmd *Command, c *Client) (err os.Error) {
+	var opt *PlaybackOptions
+	var mode string
+
+	if opt, err = c.PlaybackOptions(); err != nil {
+		return
+	}
+
+	if mode, err = c.ReplayGainStatus(); err != nil {
+		return
+	}
+
+	delay, rampdelay := "off", "nan"
+	if opt.MixRampDelay >= 0 {
+		delay = fmt.Sprintf("%gs", opt.MixRampDelay)
+		rampdelay = strconv.Ftoa64(opt.MixRampDelay, 'f', -1)
+	}
+
+	fields := []Pair{
+		Pair{"random", strconv.Itoa(btoi(opt.Random))},
+		Pair{"repeat", strconv.Itoa(btoi(opt.Repeat))},
+		Pair{"single", opt.Single.arg()},
+		Pair{"consume", opt.Consume.arg()},
+		Pair{"xfade", strconv.Itoa(opt.Crossfade)},
+		Pair{"mixrampdb", strconv.Ftoa64(opt.MixRampDb, 'f', -1)},
+		Pair{"mixrampdelay", rampdelay},
+		Pair{"replay_gain_mode", mode},
+	}
+
+	return cmd.Record(fields, fmt.Sprintf(
+		"random %s, repeat %s, single %s, consume %s, crossfade %ds, mixrampdb %gdB, mixrampdelay %s, replay gain %s",
+		onoff(strconv.Itoa(btoi(opt.Random))), onoff(strconv.Itoa(btoi(opt.Repeat))),
+		opt.Single, opt.Consume, opt.Crossfade, opt.MixRampDb, delay, mode,
+	))
 }
 
 func next(cmd *Command, c *Client) (err os.Error) {
-	return c.request("next")
+	return cmd.request(c, "next")
 }
 
 func pause(cmd *Command, c *Client) (err os.Error) {
@@ -35,72 +436,82 @@ func pause(cmd *Command, c *Client) (err os.Error) {
 	if t == "on" {
 		v = 1
 	}
-	return c.request("pause %d", v)
+	return cmd.request(c, "pause %d", v)
 }
 
 func play(cmd *Command, c *Client) (err os.Error) {
-	return c.request("play %d", cmd.I("pos", 0))
+	return cmd.request(c, "play %d", cmd.I("pos", 0))
 }
 
 func playid(cmd *Command, c *Client) (err os.Error) {
-	return c.request("playid %d", cmd.I("id", 0))
+	return cmd.request(c, "playid %d", cmd.I("id", 0))
 }
 
 func previous(cmd *Command, c *Client) (err os.Error) {
-	return c.request("previous")
+	return cmd.request(c, "previous")
 }
 
 func random(cmd *Command, c *Client) (err os.Error) {
-	t := cmd.S("toggle", "")
-	v := 0
-	if t == "on" {
-		v = 1
-	}
-	return c.request("random %d", v)
+	return c.SetRandom(cmd.S("toggle", "") == "on")
 }
 
 func repeat(cmd *Command, c *Client) (err os.Error) {
-	t := cmd.S("toggle", "")
-	v := 0
-	if t == "on" {
-		v = 1
-	}
-	return c.request("repeat %d", v)
+	return c.SetRepeat(cmd.S("toggle", "") == "on")
 }
 
 func seek(cmd *Command, c *Client) (err os.Error) {
-	return c.request(
-		"seek %d %d",
-		cmd.I("pos", 0),
-		cmd.I("time", 0),
-	)
+	var t int64
+	if t, err = ParseDuration(cmd.S("time", "")); err != nil {
+		return
+	}
+	return c.Seek(cmd.I("pos", 0), t)
 }
 
 func seekid(cmd *Command, c *Client) (err os.Error) {
-	return c.request(
-		"seekid %d %d",
-		cmd.I("id", 0),
-		cmd.I("time", 0),
-	)
+	var t int64
+	if t, err = ParseDuration(cmd.S("time", "")); err != nil {
+		return
+	}
+	return c.SeekId(cmd.I("id", 0), t)
+}
+
+func seekcur(cmd *Command, c *Client) (err os.Error) {
+	var t int64
+
+	v := cmd.S("time", "")
+	relative := len(v) > 0 && (v[0] == '+' || v[0] == '-')
+
+	if relative {
+		if t, err = ParseDuration(v[1:]); err != nil {
+			return
+		}
+		if v[0] == '-' {
+			t = -t
+		}
+	} else if t, err = ParseDuration(v); err != nil {
+		return
+	}
+
+	return c.SeekCurrent(t, relative)
 }
 
 func volume(cmd *Command, c *Client) (err os.Error) {
-	v := cmd.I("value", -1)
-	if v < 0 || v > 100 {
-		return os.NewError("Volume must be in the range 0-100.")
+	value := cmd.S("value", "")
+	s := cmd.S("sign", "")
+
+	if value[0] == '+' || value[0] == '-' {
+		if s != "" {
+			return &UsageError{"Use either a signed @value or the @sign parameter, not both."}
+		}
+		s, value = value[:1], value[1:]
 	}
 
-	if s := cmd.S("sign", ""); s != "" {
+	v, _ := strconv.Atoi(value)
+
+	if s != "" {
 		var arg Args
 		var cv int
 
-		if s != "+" && s != "-" {
-			// this should be caught by the patSign regex pattern, but the current
-			// regexp implementation has some issues with escaping +/- signs. eg:
-			// it isn't supported at all.
-			return os.NewError("Invalid value for parameter @sign. Expected + or -")
-		}
-
 		if arg, err = c.requestArgs("status"); err != nil {
 			return
 		}
@@ -120,9 +531,9 @@ func volume(cmd *Command, c *Client) (err os.Error) {
 		}
 	}
 
-	return c.request("setvol %d", v)
+	return cmd.request(c, "setvol %d", v)
 }
 
 func stop(cmd *Command, c *Client) (err os.Error) {
-	return c.request("stop")
+	return cmd.request(c, "stop")
 }
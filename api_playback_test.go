@@ -0,0 +1,188 @@
+// Copyright (c) 2010, Jim Teeuwen. All rights reserved.
+// This code is subject to a 1-clause BSD license.
+// See the LICENSE file for its contents.
+
+package mpd
+
+import (
+	"os"
+	"testing"
+)
+
+func TestPlaybackCommands(t *testing.T) {
+	srv, c := startServer(t)
+	defer srv.Close()
+	defer c.Close()
+
+	srv.Enqueue("rock/acdc/hells_bells.mp3", "rock/acdc/back_in_black.mp3", "jazz/davis/so_what.flac")
+
+	testCommands(t, srv, c, []commandTest{
+		commandTest{[]string{"crossfade", "5"}, "crossfade 5"},
+		commandTest{[]string{"consume", "oneshot"}, "consume oneshot"},
+		commandTest{[]string{"single", "on"}, "single 1"},
+		commandTest{[]string{"replaygain", "album"}, "replay_gain_mode album"},
+		commandTest{[]string{"replaygain"}, "replay_gain_status"},
+		commandTest{[]string{"mixrampdb", "-17.5"}, "mixrampdb -17.5"},
+		commandTest{[]string{"mixrampdelay", "2"}, "mixrampdelay 2"},
+		commandTest{[]string{"mixrampdelay", "nan"}, "mixrampdelay nan"},
+		commandTest{[]string{"options"}, "replay_gain_status"},
+		commandTest{[]string{"play", "0"}, "play 0"},
+		commandTest{[]string{"playid", "2"}, "playid 2"},
+		commandTest{[]string{"pause", "on"}, "pause 1"},
+		commandTest{[]string{"pause", "off"}, "pause 0"},
+		commandTest{[]string{"toggle"}, "pause 1"},
+		commandTest{[]string{"toggle"}, "play"},
+		commandTest{[]string{"next"}, "next"},
+		commandTest{[]string{"previous"}, "previous"},
+		commandTest{[]string{"random", "on"}, "random 1"},
+		commandTest{[]string{"repeat", "off"}, "repeat 0"},
+		commandTest{[]string{"seek", "0", "1:30"}, "seek 0 90.000"},
+		commandTest{[]string{"seekid", "1", "1m"}, "seekid 1 60.000"},
+		commandTest{[]string{"seekcur", "+10"}, "seekcur +10.000"},
+		commandTest{[]string{"seekcur", "-5.5"}, "seekcur -5.500"},
+		commandTest{[]string{"seekcur", "30"}, "seekcur 30.000"},
+		commandTest{[]string{"volume", "50"}, "setvol 50"},
+		commandTest{[]string{"volume", "10", "-"}, "setvol 40"},
+		commandTest{[]string{"volume", "+5"}, "setvol 45"},
+		commandTest{[]string{"vol", "--value=-50"}, "setvol 0"},
+		commandTest{[]string{"stop"}, "stop"},
+	})
+
+	if err := runCommand(c, "consume", "twice"); err == nil {
+		t.Errorf("Expected error for invalid consume mode.")
+	}
+
+	if err := runCommand(c, "volume", "101"); err == nil {
+		t.Errorf("Expected error for volume out of range.")
+	}
+
+	if err := runCommand(c, "volume", "+5", "+"); err == nil {
+		t.Errorf("Expected error for volume with two signs.")
+	}
+
+	if err := runCommand(c, "seek", "0", "1h2x"); err == nil {
+		t.Errorf("Expected error for invalid seek time.")
+	}
+}
+
+func TestPlaybackOptions(t *testing.T) {
+	srv, c := startServer(t)
+	defer srv.Close()
+	defer c.Close()
+
+	steps := []func() os.Error{
+		func() os.Error { return c.SetRandom(true) },
+		func() os.Error { return c.SetRepeat(true) },
+		func() os.Error { return c.SetSingle(ModeOneshot) },
+		func() os.Error { return c.SetConsume(ModeOn) },
+		func() os.Error { return c.SetCrossfade(3) },
+		func() os.Error { return c.SetMixRampDb(-10) },
+		func() os.Error { return c.SetMixRampDelay(1.5) },
+		func() os.Error { return c.SetReplayGainMode(ReplayGainTrack) },
+	}
+
+	for i, fn := range steps {
+		if err := fn(); err != nil {
+			t.Fatalf("Step %d: %v", i, err)
+		}
+	}
+
+	opt, err := c.PlaybackOptions()
+	if err != nil {
+		t.Fatalf("PlaybackOptions: %v", err)
+	}
+
+	if !opt.Random || !opt.Repeat || opt.Single != ModeOneshot || opt.Consume != ModeOn {
+		t.Errorf("Unexpected options %+v.", opt)
+	}
+
+	if opt.Crossfade != 3 || opt.MixRampDb != -10 || opt.MixRampDelay != 1.5 {
+		t.Errorf("Unexpected options %+v.", opt)
+	}
+
+	mode, err := c.ReplayGainStatus()
+	if err != nil || mode != ReplayGainTrack {
+		t.Errorf("Expected replay gain mode 'track', got '%s' (%v).", mode, err)
+	}
+
+	if err = c.SetMixRampDelay(-1); err != nil {
+		t.Fatalf("SetMixRampDelay: %v", err)
+	}
+
+	if opt, _ = c.PlaybackOptions(); opt.MixRampDelay >= 0 {
+		t.Errorf("Expected MixRamp to be disabled, got delay %g.", opt.MixRampDelay)
+	}
+
+	if err = c.SetReplayGainMode("loud"); err == nil {
+		t.Errorf("Expected error for invalid replay gain mode.")
+	}
+}
+
+func TestParseOptionMode(t *testing.T) {
+	tests := map[string]OptionMode{
+		"0": ModeOff, "off": ModeOff, "1": ModeOn, "on": ModeOn, "oneshot": ModeOneshot,
+	}
+
+	for v, want := range tests {
+		if m, err := ParseOptionMode(v); err != nil || m != want {
+			t.Errorf("%s: Expected %s, got %s (%v).", v, want, m, err)
+		}
+	}
+
+	if _, err := ParseOptionMode("2"); err == nil {
+		t.Errorf("Expected error for invalid mode.")
+	}
+
+	if ModeOneshot.arg() != "oneshot" || ModeOn.arg() != "1" || ModeOff.arg() != "0" {
+		t.Errorf("Unexpected protocol values.")
+	}
+}
+
+func TestSeekCurrent(t *testing.T) {
+	srv, c := startServer(t)
+	defer srv.Close()
+	defer c.Close()
+
+	srv.Enqueue("jazz/davis/so_what.flac")
+
+	if err := c.SeekId(1, 60e9); err != nil {
+		t.Fatalf("SeekId: %v", err)
+	}
+
+	if err := c.SeekCurrent(-15e9, true); err != nil {
+		t.Fatalf("SeekCurrent: %v", err)
+	}
+
+	if s, _ := c.Status(); s.Elapsed != 45e9 {
+		t.Errorf("Expected 45s elapsed, got %d.", s.Elapsed)
+	}
+
+	if err := c.SeekCurrent(-1, false); err == nil {
+		t.Errorf("Expected error for negative absolute position.")
+	}
+}
+
+func TestParseDuration(t *testing.T) {
+	tests := map[string]int64{
+		"90":      90e9,
+		"90.5":    90.5e9,
+		"1:30":    90e9,
+		"1:02:03": 3723e9,
+		"0:05.25": 5.25e9,
+		"1h2m":    3720e9,
+		"2m30s":   150e9,
+		"1.5s":    1.5e9,
+	}
+
+	for v, want := range tests {
+		if ns, err := ParseDuration(v); err != nil || ns != want {
+			t.Errorf("%s: Expected %d, got %d (%v).", v, want, ns, err)
+		}
+	}
+
+	for _, v := range []string{"", "1:60", "a:30", "1:2:3:4", "h", "1x", "-5"} {
+		if _, err := ParseDuration(v); err == nil {
+			t.Errorf("%s: Expected error.", v)
+		}
+	}
+}
@@ -9,179 +9,427 @@ import (
 	"fmt"
 )
 
+func init() {
+	mustRegister(GroupPlaylist,
+		&Command{
+			Name: "add",
+			Desc: "Add a single file from the database to the playlist. This command increments the playlist version by 1 for each song added to the playlist.",
+			Params: []*Param{
+				newParam("path", "A single directory or file. If this is a directory or path all files in directory or path are added recursively. Adding all files in the database is as simple as add /.", TextKind, false),
+			},
+			Exec: add,
+		},
+		&Command{
+			Name: "addid",
+			Desc: "Same as 'add', but this returns a playlistid and allows specifying a position at which to insert the file(s).",
+			Params: []*Param{
+				newParam("path", "A single directory or file. If this is a directory or path all files in directory or path are added recursively. Adding all files in the database is as simple as add /.", TextKind, false),
+				newParam("pos", "Optional integer value specifying the location at which to insert the file(s) into the playlist.", IndexKind, true),
+			},
+			Exec: addid,
+		},
+		&Command{
+			Name: "clear",
+			Desc: "Clears the current playlist. Increments the playlist version by 1.",
+			Exec: clear,
+		},
+		&Command{
+			Name: "current",
+			Desc: "Reports the metadata of the currently playing song, along with the playback progress.",
+			Exec: current,
+		},
+		&Command{
+			Name: "delete",
+			Desc: "Deletes the specified songs from the playlist. increments the playlist version by 1.",
+			Params: []*Param{
+				newParam("pos", "Position of the song in the playlist, or a range of positions as START:END.", RangeKind, false),
+			},
+			Exec: delete,
+		},
+		&Command{
+			Name: "deleteid",
+			Desc: "Deletes the specified song from the playlist. Increments the playlist version by 1.",
+			Params: []*Param{
+				newParam("id", "Id of the song to delete.", IndexKind, false),
+			},
+			Exec: deleteid,
+		},
+		&Command{
+			Name: "load",
+			Desc: "Load the playlist @name from the playlist directory, Increments the playlist version by the number of songs added.",
+			Params: []*Param{
+				newParam("name", "Name of the playlist file *without* the file extension.", TextKind, false),
+			},
+			Exec: load,
+		},
+		&Command{
+			Name: "rename",
+			Desc: "Renames a playlist from @oldname to @newname.",
+			Params: []*Param{
+				newParam("oldname", "Current name of the playlist.", TextKind, false),
+				newParam("newname", "New name of the playlist.", TextKind, false),
+			},
+			Exec: rename,
+		},
+		&Command{
+			Name: "move",
+			Desc: "Moves a song from position @src to position @dest.",
+			Params: []*Param{
+				newParam("src", "Source position.", IndexKind, false),
+				newParam("dest", "Target position.", IndexKind, false),
+			},
+			Exec: move,
+		},
+		&Command{
+			Name: "moveid",
+			Desc: "Moves a song with id @src to position @dest.",
+			Params: []*Param{
+				newParam("src", "Song Id of the track you want to move.", IndexKind, false),
+				newParam("dest", "Target position.", IndexKind, false),
+			},
+			Exec: moveid,
+		},
+		&Command{
+			Name: "plinfo",
+			Desc: "Reports metadata for songs in the playlist.",
+			Params: []*Param{
+				newParam("pos", "An optional position or range START:END that specifies the songs to display information for.", RangeKind, true),
+			},
+			Exec: plinfo,
+		},
+		&Command{
+			Name: "plchanges",
+			Desc: "Reports changed songs currently in the playlist since @version.",
+			Params: []*Param{
+				newParam("version", "The number for the version to display changed songs of the playlist.", IndexKind, false),
+			},
+			Exec: plchanges,
+		},
+		&Command{
+			Name: "plchangesid",
+			Desc: "Same as plchanges, but returns only the songids.",
+			Params: []*Param{
+				newParam("version", "The number for the version to display changed songs of the playlist.", IndexKind, false),
+			},
+			Exec: plchangesid,
+		},
+		&Command{
+			Name: "rm",
+			Desc: "Removes the playlist called @name from the playlist directory.",
+			Params: []*Param{
+				newParam("name", "The name of the saved playlist to be removed from the playlist directory.", TextKind, false),
+			},
+			Exec: rm,
+		},
+		&Command{
+			Name: "save",
+			Desc: "Saves the current playlist to @name in the playlist directory.",
+			Params: []*Param{
+				newParam("name", "The name for the saved playlist.", TextKind, false),
+			},
+			Exec: save,
+		},
+		&Command{
+			Name: "shuffle",
+			Desc: "Shuffles the current playlist, increments playlist version by 1.",
+			Exec: shuffle,
+		},
+		&Command{
+			Name: "swap",
+			Desc: "Swap positions of songs at positions @pos1 and @pos2. Increments playlist version by 1.",
+			Params: []*Param{
+				newParam("pos1", "First song position", IndexKind, false),
+				newParam("pos2", "Second song position", IndexKind, false),
+			},
+			Exec: swap,
+		},
+		&Command{
+			Name: "swapid",
+			Desc: "Swap positions of songs with id @pos1 and @pos2. Increments playlist version by 1.",
+			Params: []*Param{
+				newParam("id1", "First song id", IndexKind, false),
+				newParam("id2", "Second song id", IndexKind, false),
+			},
+			Exec: swapid,
+		},
+		&Command{
+			Name: "playlists",
+			Desc: "Reports the names of all stored playlists, along with the time they were last modified.",
+			Exec: playlists,
+		},
+		&Command{
+			Name: "listpl",
+			Desc: "Reports files in playlist named @name.",
+			Params: []*Param{
+				newParam("name", "Name of the playlist", TextKind, false),
+			},
+			Exec: listpl,
+		},
+		&Command{
+			Name: "listplinfo",
+			Desc: "Reports songs in playlist named @name.",
+			Params: []*Param{
+				newParam("name", "Name of the playlist", TextKind, false),
+			},
+			Exec: listplinfo,
+		},
+		&Command{
+			Name: "pladd",
+			Desc: "Adds @path to playlist @name.",
+			Params: []*Param{
+				newParam("name", "Name of playlist.", TextKind, false),
+				newParam("path", "Path of file to add to playlist.", TextKind, false),
+			},
+			Exec: pladd,
+		},
+		&Command{
+			Name: "plclear",
+			Desc: "Clears playlist @name.",
+			Params: []*Param{
+				newParam("name", "Name of playlist.", TextKind, false),
+			},
+			Exec: plclear,
+		},
+		&Command{
+			Name: "pldelete",
+			Desc: "Deletes song with given @id from playlist @name.",
+			Params: []*Param{
+				newParam("name", "Name of playlist.", TextKind, false),
+				newParam("id", "Id of song to delete.", IndexKind, false),
+			},
+			Exec: pldelete,
+		},
+		&Command{
+			Name: "plmove",
+			Desc: "Moves song with given @id in playlist @name to position @pos.",
+			Params: []*Param{
+				newParam("name", "Name of playlist.", TextKind, false),
+				newParam("id", "Id of song to move.", IndexKind, false),
+				newParam("pos", "New song position.", IndexKind, false),
+			},
+			Exec: plmove,
+		},
+		&Command{
+			Name: "plsearch",
+			Desc: "Case-insensitive playlist search with 'pretty' output. Easier to use when looking for specific songs to play. Outputs a list of entries like: [#pos:#id] Artist - Album - Title (mm:ss), or as set by PlsearchFormat or --format. Listed #pos and #id can be used directly with the 'play' and 'playid' commands.",
+			Params: []*Param{
+				newParam("tag", "Metadata field to search in.", TagKind, false),
+				newParam("term", "Term to search for.", TextKind, false),
+			},
+			Exec: plsearch,
+		},
+	)
+
+	mustAlias("playlist", "plinfo")
+}
+
+// Returns the songs in the queue, in order.
+func (this *Client) PlaylistInfo() (songs []Args, err os.Error) {
+	if err = this.send("playlistinfo"); err != nil {
+		return
+	}
+	return this.receiveEntries("file")
+}
+
+// Returns the songs in the stored playlist @name, in order.
+func (this *Client) ListPlaylistInfo(name string) (songs []Args, err os.Error) {
+	if err = this.send("listplaylistinfo %s", quote(name)); err != nil {
+		return
+	}
+	return this.receiveEntries("file")
+}
+
+// Adds a song, all songs in a directory, or a URL to the end of the queue.
+func (this *Client) Add(uri string) os.Error {
+	return this.execute("add %s", quote(uri))
+}
+
+// Adds a song, all songs in a directory, or a URL to the end of the stored
+// playlist @name. The playlist is created if it does not exist.
+func (this *Client) PlaylistAdd(name, uri string) os.Error {
+	return this.execute("playlistadd %s %s", quote(name), quote(uri))
+}
+
 func add(cmd *Command, c *Client) (err os.Error) {
-	return c.request("add \"%s\"", cmd.S("path", ""))
+	return cmd.request(c, "add %s", quote(cmd.S("path", "")))
 }
 
 func addid(cmd *Command, c *Client) (err os.Error) {
 	pos := cmd.I("pos", -1)
 	if pos > -1 {
-		return c.request("addid \"%s\" %d", cmd.S("path", ""), pos)
+		return cmd.request(c, "addid %s %d", quote(cmd.S("path", "")), pos)
 	}
-	return c.request("addid \"%s\"")
+	return cmd.request(c, "addid %s", quote(cmd.S("path", "")))
 }
 
 func clear(cmd *Command, c *Client) (err os.Error) {
-	return c.request("clear")
+	return cmd.request(c, "clear")
 }
 
 func current(cmd *Command, c *Client) (err os.Error) {
-	var a, stats Args
-	if a, err = c.requestArgs("currentsong"); err != nil {
+	var song []Pair
+	var status *Status
+
+	if err = c.send("currentsong"); err != nil {
 		return
 	}
 
-	if stats, err = c.requestArgs("stats"); err != nil {
+	if song, err = c.receivePairs(); err != nil || len(song) == 0 {
 		return
 	}
 
-	fmt.Printf("[%s/%s] %s - %s - %s (%s)\n",
-		a["Pos"], stats["songs"], a["Artist"], a["Album"], a["Title"],
-		parseTime(a.Int("Time", 0)),
-	)
-	return
+	if status, err = c.Status(); err != nil {
+		return
+	}
+
+	// Include the player fields, so that formats can show the progress.
+	song = append(song, statusPairs(status)...)
+	return cmd.Record(song, CurrentFormat.Format(song))
 }
 
 func delete(cmd *Command, c *Client) (err os.Error) {
-	return c.request("delete %d", cmd.I("pos", 0))
+	return cmd.request(c, "delete %s", cmd.S("pos", ""))
 }
 
 func deleteid(cmd *Command, c *Client) (err os.Error) {
-	return c.request("deleteid %d", cmd.I("id", 0))
+	return cmd.request(c, "deleteid %d", cmd.I("id", 0))
 }
 
 func load(cmd *Command, c *Client) (err os.Error) {
-	return c.request("load \"%s\"", cmd.S("name", ""))
+	return cmd.request(c, "load %s", quote(cmd.S("name", "")))
 }
 
 func rename(cmd *Command, c *Client) (err os.Error) {
-	return c.request("rename \"%s\" \"%s\"",
-		cmd.S("oldname", ""),
-		cmd.S("newname", ""),
+	return cmd.request(c, "rename %s %s",
+		quote(cmd.S("oldname", "")),
+		quote(cmd.S("newname", "")),
 	)
 }
 
 func move(cmd *Command, c *Client) (err os.Error) {
-	return c.request("move %d %d",
+	return cmd.request(c, "move %d %d",
 		cmd.I("src", 0),
 		cmd.I("dest", 0),
 	)
 }
 
 func moveid(cmd *Command, c *Client) (err os.Error) {
-	return c.request("moveid %d %d",
+	return cmd.request(c, "moveid %d %d",
 		cmd.I("src", 0),
 		cmd.I("dest", 0),
 	)
 }
 
 func plinfo(cmd *Command, c *Client) (err os.Error) {
-	pos := cmd.I("pos", -1)
-	if pos == -1 {
-		return c.requestList("playlistinfo")
+	pos := cmd.S("pos", "")
+	if pos == "" {
+		return cmd.requestList(c, "playlistinfo")
 	}
-	return c.requestList("playlistinfo %d", pos)
+	return cmd.requestList(c, "playlistinfo %s", pos)
 }
 
 func plchanges(cmd *Command, c *Client) (err os.Error) {
-	return c.requestList("plchanges %d", cmd.I("version", 0))
+	return cmd.requestList(c, "plchanges %d", cmd.I("version", 0))
 }
 
 func plchangesid(cmd *Command, c *Client) (err os.Error) {
-	var arg []Args
-	if arg, err = c.requestListArgs("plchangesposid %d", cmd.I("version", 0)); err != nil {
+	if err = c.send("plchangesposid %d", cmd.I("version", 0)); err != nil {
+		return
+	}
+
+	var pairs []Pair
+	if pairs, err = c.receivePairs(); err != nil {
 		return
 	}
 
-	for _, a := range arg {
-		fmt.Printf("id: %s, cpos: %s\n", a["Id"], a["cpos"])
+	for _, e := range splitEntries(pairs, "cpos") {
+		a := pairArgs(e)
+		if err = cmd.Record(e, fmt.Sprintf("id: %s, cpos: %s", a["Id"], a["cpos"])); err != nil {
+			return
+		}
 	}
 	return
 }
 
 func rm(cmd *Command, c *Client) (err os.Error) {
-	return c.request("rm \"%s\"", cmd.S("name", ""))
+	return cmd.request(c, "rm %s", quote(cmd.S("name", "")))
 }
 
 func save(cmd *Command, c *Client) (err os.Error) {
-	return c.request("save \"%s\"", cmd.S("name", ""))
+	return cmd.request(c, "save %s", quote(cmd.S("name", "")))
 }
 
 func shuffle(cmd *Command, c *Client) (err os.Error) {
-	return c.request("shuffle")
+	return cmd.request(c, "shuffle")
 }
 
 func swap(cmd *Command, c *Client) (err os.Error) {
-	return c.request("swap %d %d",
+	return cmd.request(c, "swap %d %d",
 		cmd.I("pos1", 0),
 		cmd.I("pos2", 0),
 	)
 }
 
 func swapid(cmd *Command, c *Client) (err os.Error) {
-	return c.request("swapid %d %d",
+	return cmd.request(c, "swapid %d %d",
 		cmd.I("id1", 0),
 		cmd.I("id2", 0),
 	)
 }
 
-func listpl(cmd *Command, c *Client) (err os.Error) {
-	var arg []Args
-	if arg, err = c.requestListArgs("listplaylist \"%s\"", cmd.S("name", "")); err != nil {
-		return
-	}
+func playlists(cmd *Command, c *Client) (err os.Error) {
+	return cmd.requestList(c, "listplaylists")
+}
 
-	var v string
-	for _, a := range arg {
-		for _, v = range a {
-			fmt.Printf("%s\n", v)
-		}
-	}
-	return
+func listpl(cmd *Command, c *Client) (err os.Error) {
+	return reportValues(cmd, c, fmt.Sprintf("listplaylist %s", quote(cmd.S("name", ""))))
 }
 
 func listplinfo(cmd *Command, c *Client) (err os.Error) {
-	return c.requestList("listplaylistinfo \"%s\"", cmd.S("name", ""))
+	return cmd.requestList(c, "listplaylistinfo %s", quote(cmd.S("name", "")))
 }
 
 func pladd(cmd *Command, c *Client) (err os.Error) {
-	return c.request("playlistadd \"%s\" \"%s\"",
-		cmd.S("name", ""),
-		cmd.S("path", ""),
+	return cmd.request(c, "playlistadd %s %s",
+		quote(cmd.S("name", "")),
+		quote(cmd.S("path", "")),
 	)
 }
 
 func plclear(cmd *Command, c *Client) (err os.Error) {
-	return c.request("playlistclear \"%s\"", cmd.S("name", ""))
+	return cmd.request(c, "playlistclear %s", quote(cmd.S("name", "")))
 }
 
 func pldelete(cmd *Command, c *Client) (err os.Error) {
-	return c.request("playlistdelete \"%s\" %d",
-		cmd.S("name", ""),
+	return cmd.request(c, "playlistdelete %s %d",
+		quote(cmd.S("name", "")),
 		cmd.I("id", 0),
 	)
 }
 
 func plmove(cmd *Command, c *Client) (err os.Error) {
-	return c.request("playlistmove \"%s\" %d %d",
-		cmd.S("name", ""),
+	return cmd.request(c, "playlistmove %s %d %d",
+		quote(cmd.S("name", "")),
 		cmd.I("id", 0),
 		cmd.I("pos", 0),
 	)
 }
 
 func plsearch(cmd *Command, c *Client) (err os.Error) {
-	var arg []Args
-	if arg, err = c.requestListArgs("playlistsearch \"%s\" \"%s\"",
-		cmd.S("tag", ""),
-		cmd.S("term", "")); err != nil {
+	err = c.send("playlistsearch %s %s", quote(cmd.S("tag", "")), quote(cmd.S("term", "")))
+	if err != nil {
 		return
 	}
 
-	for _, a := range arg {
-		fmt.Printf("[%6s:%6s] %s - %s - %s (%s)\n",
-			a["Pos"], a["Id"], a["Artist"], a["Album"], a["Title"], parseTime(a.Int("Time", 0)),
-		)
+	var pairs []Pair
+	if pairs, err = c.receivePairs(); err != nil {
+		return
+	}
+
+	for _, e := range splitEntries(pairs, "file") {
+		if err = cmd.Record(e, PlsearchFormat.Format(e)); err != nil {
+			return
+		}
 	}
 	return
 }
@@ -0,0 +1,67 @@
+// Copyright (c) 2010, Jim Teeuwen. All rights reserved.
+// This code is subject to a 1-clause BSD license.
+// See the LICENSE file for its contents.
+
+package mpd
+
+import "testing"
+
+func TestPlaylistCommands(t *testing.T) {
+	srv, c := startServer(t)
+	defer srv.Close()
+	defer c.Close()
+
+	testCommands(t, srv, c, []commandTest{
+		commandTest{[]string{"add", "rock"}, `add "rock"`},
+		commandTest{[]string{"addid", "jazz/davis/so_what.flac"}, `addid "jazz/davis/so_what.flac"`},
+		commandTest{[]string{"addid", "jazz/coltrane/giant_steps.flac", "0"}, `addid "jazz/coltrane/giant_steps.flac" 0`},
+		commandTest{[]string{"plinfo"}, "playlistinfo"},
+		commandTest{[]string{"plinfo", "1"}, "playlistinfo 1"},
+		commandTest{[]string{"plinfo", "1:"}, "playlistinfo 1:"},
+		commandTest{[]string{"plchanges", "0"}, "plchanges 0"},
+		commandTest{[]string{"plchangesid", "0"}, "plchangesposid 0"},
+		commandTest{[]string{"move", "0", "3"}, "move 0 3"},
+		commandTest{[]string{"moveid", "4", "0"}, "moveid 4 0"},
+		commandTest{[]string{"swap", "0", "1"}, "swap 0 1"},
+		commandTest{[]string{"swapid", "1", "2"}, "swapid 1 2"},
+		commandTest{[]string{"deleteid", "3"}, "deleteid 3"},
+		commandTest{[]string{"delete", "0"}, "delete 0"},
+	})
+
+	queue := srv.Queue()
+	if len(queue) != 2 || queue[0]["file"] != "jazz/coltrane/giant_steps.flac" {
+		t.Fatalf("Unexpected queue %v.", queue)
+	}
+
+	testCommands(t, srv, c, []commandTest{
+		commandTest{[]string{"shuffle"}, "shuffle"},
+		commandTest{[]string{"plsearch", "artist", "coltrane"}, `playlistsearch "artist" "coltrane"`},
+		commandTest{[]string{"current"}, "status"},
+		commandTest{[]string{"save", "mix"}, `save "mix"`},
+		commandTest{[]string{"playlists"}, "listplaylists"},
+		commandTest{[]string{"listpl", "mix"}, `listplaylist "mix"`},
+		commandTest{[]string{"listplinfo", "mix"}, `listplaylistinfo "mix"`},
+		commandTest{[]string{"pladd", "mix", "jazz/davis/so_what.flac"}, `playlistadd "mix" "jazz/davis/so_what.flac"`},
+		commandTest{[]string{"pldelete", "mix", "0"}, `playlistdelete "mix" 0`},
+		commandTest{[]string{"plmove", "mix", "0", "1"}, `playlistmove "mix" 0 1`},
+		commandTest{[]string{"rename", "mix", "party"}, `rename "mix" "party"`},
+		commandTest{[]string{"load", "party"}, `load "party"`},
+		commandTest{[]string{"plclear", "party"}, `playlistclear "party"`},
+		commandTest{[]string{"rm", "party"}, `rm "party"`},
+		commandTest{[]string{"delete", "1:"}, "delete 1:"},
+		commandTest{[]string{"clear"}, "clear"},
+	})
+
+	if queue = srv.Queue(); len(queue) != 0 {
+		t.Errorf("Expected empty queue, got %v.", queue)
+	}
+
+	err := runCommand(c, "load", "party")
+	if ack, ok := err.(*AckError); !ok || ack.Code != ErrNoExist || ack.Command != "load" {
+		t.Errorf("Expected ACK error for removed playlist, got %#v.", err)
+	}
+
+	if err := runCommand(c, "addid", "missing.mp3"); err == nil {
+		t.Errorf("Expected error for unknown song.")
+	}
+}
@@ -0,0 +1,75 @@
+// Copyright (c) 2010, Jim Teeuwen. All rights reserved.
+// This code is subject to a 1-clause BSD license.
+// See the LICENSE file for its contents.
+
+package mpd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Stickers are values which the server stores along with songs, like a
+// rating or a play count. They are kept in the sticker database, which must
+// be enabled in the server's configuration.
+
+// Returns the value of the sticker @name of the song @file.
+func (this *Client) Sticker(file, name string) (value string, err os.Error) {
+	var pairs []Pair
+	if err = this.send("sticker get song %s %s", quote(file), quote(name)); err != nil {
+		return
+	}
+
+	if pairs, err = this.receivePairs(); err != nil {
+		return
+	}
+
+	for _, p := range pairs {
+		if p.Key == "sticker" {
+			return stickerValue(p.Value, name)
+		}
+	}
+	return "", os.NewError(fmt.Sprintf("No sticker '%s' for '%s'.", name, file))
+}
+
+// Sets the sticker @name of the song @file.
+func (this *Client) SetSticker(file, name, value string) os.Error {
+	return this.execute("sticker set song %s %s %s", quote(file), quote(name), quote(value))
+}
+
+// Returns the values of the sticker @name, by file, for the songs at or
+// below @dir which have it. An empty @dir searches the whole database.
+func (this *Client) FindStickers(dir, name string) (values map[string]string, err os.Error) {
+	var pairs []Pair
+	if err = this.send("sticker find song %s %s", quote(dir), quote(name)); err != nil {
+		return
+	}
+
+	if pairs, err = this.receivePairs(); err != nil {
+		return
+	}
+
+	values = make(map[string]string)
+	file := ""
+
+	for _, p := range pairs {
+		switch p.Key {
+		case "file":
+			file = p.Value
+		case "sticker":
+			if values[file], err = stickerValue(p.Value, name); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return
+}
+
+// Returns the value of a 'name=value' pair as the server reports stickers.
+func stickerValue(v, name string) (string, os.Error) {
+	if !strings.HasPrefix(v, name+"=") {
+		return "", os.NewError(fmt.Sprintf("Malformed sticker '%s'.", v))
+	}
+	return v[len(name)+1:], nil
+}
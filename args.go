@@ -42,6 +42,13 @@ func (this Args) Int64(k string, d int64) int64 {
 	return d
 }
 
+func (this Args) Float64(k string, d float64) float64 {
+	if v, e := strconv.Atof64(this.read(k)); e == nil {
+		return v
+	}
+	return d
+}
+
 func (this Args) String(k, d string) string {
 	if v := this.read(k); v != "" {
 		return v
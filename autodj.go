@@ -0,0 +1,415 @@
+// Copyright (c) 2010, Jim Teeuwen. All rights reserved.
+// This code is subject to a 1-clause BSD license.
+// See the LICENSE file for its contents.
+
+package mpd
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"rand"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func init() {
+	mustRegister(GroupSession,
+		&Command{
+			Name: "autodj",
+			Desc: "Keeps at least @count songs queued after the current one, until the program is stopped. Songs are chosen by @strategy, leaving out the ones in the queue and those played within @avoid, according to the play history in @file. With @keep, played songs are removed from the queue as well.",
+			Params: []*Param{
+				newParam("strategy", "How songs are chosen: random songs, a random album, songs of the same genre or artist as the current one, or the songs of the smart playlist in @rules.", EnumKind("random", "album", "genre", "artist", "smart"), true).withDefault("random"),
+				newParam("count", "The number of songs to keep queued.", IntKind(1, 1000), true).withDefault("5"),
+				newParam("rules", "The rule file of the smart strategy. See smartpl.", TextKind, true),
+				newParam("avoid", "Songs played this long ago, or less, are not chosen again.", DurationKind, true).withDefault("4h"),
+				newParam("keep", "The number of played songs to keep before the current one. Others are removed.", IntKind(0, math.MaxInt32), true),
+				newParam("file", "The history file. Defaults to ~/.mpd_play_history.", TextKind, true),
+			},
+			Exec: autodj,
+		},
+	)
+}
+
+// AutoDJ keeps the queue topped up. Whenever the queue or the player
+// changes, and fewer than Count songs are left after the current one, the
+// Strategy is asked for more.
+//
+// Songs which are in the queue, which were played within Avoid according
+// to the History, or which the DJ added itself within Avoid, are not chosen
+// again.
+type AutoDJ struct {
+	Strategy DJStrategy
+	Count    int       // Songs to keep queued after the current one.
+	Keep     int       // Played songs to keep before the current one, or -1 to keep all.
+	Avoid    int64     // In nanoseconds.
+	History  *History  // May be nil.
+	Log      io.Writer // Receives what the DJ does. Defaults to standard error.
+
+	library []Args           // All songs, until the database changes.
+	added   map[string]int64 // Songs added by the DJ, and when.
+	avoid   map[string]bool  // Songs which can not be chosen right now.
+	now     func() int64
+	perm    func(n int) []int
+}
+
+// A DJStrategy chooses the songs which an AutoDJ adds to the queue.
+type DJStrategy interface {
+	// Returns up to @n songs to append to the queue. @current is the
+	// current song, or else the last one in the queue, or nil if the queue
+	// is empty. Songs for which dj.Avoided reports true are left out.
+	Choose(dj *AutoDJ, c *Client, current Args, n int) ([]Args, os.Error)
+}
+
+func NewAutoDJ(s DJStrategy) *AutoDJ {
+	dj := new(AutoDJ)
+	dj.Strategy = s
+	dj.Count = 5
+	dj.Keep = -1
+	dj.Avoid = 4 * 3600e9
+	dj.added = make(map[string]int64)
+	dj.avoid = make(map[string]bool)
+	dj.now = time.Nanoseconds
+
+	r := rand.New(rand.NewSource(time.Nanoseconds()))
+	dj.perm = func(n int) []int { return r.Perm(n) }
+	return dj
+}
+
+// Keeps the queue of @c topped up until @stop is closed. Errors are logged,
+// and only end the DJ if the connection failed.
+func (this *AutoDJ) Run(c *Client, stop <-chan bool) (err os.Error) {
+	if this.Log == nil {
+		this.Log = os.Stderr
+	}
+
+	for {
+		if err = this.topUp(c); err != nil {
+			if c.Failed() {
+				return
+			}
+
+			// Perhaps a song was removed since the library was read, so
+			// read it again next time.
+			fmt.Fprintf(this.Log, "Can not top up the queue: %v\n", err)
+			this.library = nil
+		}
+
+		var changed []string
+		if changed, err = c.IdleUntil(stop, "playlist", "player", "database"); err != nil {
+			return
+		}
+
+		if contains(changed, "database") {
+			this.library = nil
+		}
+
+		select {
+		case <-stop:
+			return
+		default:
+		}
+	}
+	return
+}
+
+// Removes played songs, if asked to, and adds new ones if the queue runs
+// short.
+func (this *AutoDJ) topUp(c *Client) (err os.Error) {
+	var status *Status
+	var queue []Args
+
+	if status, err = c.Status(); err != nil {
+		return
+	}
+
+	if queue, err = c.PlaylistInfo(); err != nil {
+		return
+	}
+
+	pos := status.Song
+	if this.Keep >= 0 && pos > this.Keep {
+		n := pos - this.Keep
+		if err = c.execute("delete 0:%d", n); err != nil {
+			return
+		}
+
+		queue, pos = queue[n:], pos-n
+		fmt.Fprintf(this.Log, "Removed %d played songs.\n", n)
+	}
+
+	left := len(queue) - pos - 1
+	if pos < 0 {
+		left = len(queue)
+	}
+
+	if left >= this.Count {
+		return
+	}
+
+	var current Args
+	switch {
+	case pos >= 0 && pos < len(queue):
+		current = queue[pos]
+	case len(queue) > 0:
+		current = queue[len(queue)-1]
+	}
+
+	if err = this.update(queue); err != nil {
+		return
+	}
+
+	var songs []Args
+	if songs, err = this.Strategy.Choose(this, c, current, this.Count-left); err != nil {
+		return
+	}
+
+	if len(songs) == 0 {
+		fmt.Fprintf(this.Log, "No songs left to add.\n")
+		return
+	}
+
+	commands := make([]string, len(songs))
+	for i, s := range songs {
+		commands[i] = "add " + quote(s["file"])
+	}
+
+	if err = c.ExecuteList(commands...); err != nil {
+		return
+	}
+
+	for _, s := range songs {
+		this.added[s["file"]] = this.now()
+		fmt.Fprintf(this.Log, "Added %s\n", s["file"])
+	}
+	return
+}
+
+// Collects the songs which can not be chosen: those in @queue, those
+// played recently and those added recently.
+func (this *AutoDJ) update(queue []Args) (err os.Error) {
+	now := this.now()
+	this.avoid = make(map[string]bool)
+
+	for _, s := range queue {
+		this.avoid[s["file"]] = true
+	}
+
+	for file, t := range this.added {
+		if t < now-this.Avoid {
+			this.added[file] = 0, false
+		} else {
+			this.avoid[file] = true
+		}
+	}
+
+	if this.History == nil {
+		return
+	}
+
+	return this.History.Each((now-this.Avoid)/1e9, func(e *HistoryEntry) {
+		this.avoid[e.File] = true
+	})
+}
+
+// Reports whether the song @file should not be chosen.
+func (this *AutoDJ) Avoided(file string) bool {
+	return this.avoid[file]
+}
+
+// Returns all songs in the library. They are read once, and again after
+// the database changes.
+func (this *AutoDJ) Library(c *Client) (songs []Args, err os.Error) {
+	if this.library == nil {
+		if this.library, err = c.ListAllInfo(""); err != nil {
+			return
+		}
+	}
+	return this.library, nil
+}
+
+// Returns up to @n songs from @songs in random order, leaving out the
+// avoided ones.
+func (this *AutoDJ) Pick(songs []Args, n int) []Args {
+	list := make([]Args, 0, n)
+	for _, i := range this.perm(len(songs)) {
+		if len(list) >= n {
+			break
+		}
+
+		if !this.Avoided(songs[i]["file"]) {
+			list = append(list, songs[i])
+		}
+	}
+	return list
+}
+
+// Chooses random songs from the library.
+type RandomSongs struct{}
+
+func (this *RandomSongs) Choose(dj *AutoDJ, c *Client, current Args, n int) (songs []Args, err os.Error) {
+	if songs, err = dj.Library(c); err != nil {
+		return
+	}
+	return dj.Pick(songs, n), nil
+}
+
+// Chooses a random album, none of whose songs are avoided, and adds all of
+// it, in order.
+type RandomAlbum struct{}
+
+func (this *RandomAlbum) Choose(dj *AutoDJ, c *Client, current Args, n int) (songs []Args, err os.Error) {
+	if songs, err = dj.Library(c); err != nil {
+		return
+	}
+
+	albums := make(map[string][]Args)
+	names := make([]string, 0)
+
+	for _, s := range songs {
+		if len(s["Album"]) == 0 {
+			continue
+		}
+
+		artist := s["AlbumArtist"]
+		if len(artist) == 0 {
+			artist = s["Artist"]
+		}
+
+		key := artist + "\x00" + s["Album"]
+		if _, ok := albums[key]; !ok {
+			names = append(names, key)
+		}
+		albums[key] = append(albums[key], s)
+	}
+
+	// Sorted, so that the same random order gives the same album.
+	sort.SortStrings(names)
+
+next:
+	for _, i := range dj.perm(len(names)) {
+		list := albums[names[i]]
+		for _, s := range list {
+			if dj.Avoided(s["file"]) {
+				continue next
+			}
+		}
+
+		sort.Sort(albumTracks(list))
+		return list, nil
+	}
+	return nil, nil
+}
+
+// Sorts the songs of an album by disc and track number.
+type albumTracks []Args
+
+func (this albumTracks) Len() int      { return len(this) }
+func (this albumTracks) Swap(i, j int) { this[i], this[j] = this[j], this[i] }
+
+func (this albumTracks) Less(i, j int) bool {
+	if a, b := trackNumber(this[i]["Disc"]), trackNumber(this[j]["Disc"]); a != b {
+		return a < b
+	}
+
+	if a, b := trackNumber(this[i]["Track"]), trackNumber(this[j]["Track"]); a != b {
+		return a < b
+	}
+	return this[i]["file"] < this[j]["file"]
+}
+
+// Returns the number of a Track or Disc tag, like 3 or 3/12, or 0.
+func trackNumber(v string) int {
+	if pos := strings.Index(v, "/"); pos != -1 {
+		v = v[:pos]
+	}
+
+	n, _ := strconv.Atoi(strings.TrimSpace(v))
+	return n
+}
+
+// Chooses songs with the same value of Tag, like Genre or Artist, as the
+// current song. Without a current song, or when all of those songs are
+// avoided, random songs are chosen instead.
+type SameTag struct {
+	Tag string
+}
+
+func (this *SameTag) Choose(dj *AutoDJ, c *Client, current Args, n int) (songs []Args, err os.Error) {
+	if v, ok := smartField(current, strings.ToLower(this.Tag)); ok && len(v) > 0 {
+		if songs, err = c.Find(this.Tag, v); err != nil {
+			return
+		}
+
+		if songs = dj.Pick(songs, n); len(songs) > 0 {
+			return
+		}
+	}
+	return new(RandomSongs).Choose(dj, c, current, n)
+}
+
+// Chooses the first songs of a smart playlist which are not avoided. Use
+// 'order random' in its rules to get them in random order.
+type SmartSource struct {
+	Playlist *SmartPlaylist
+}
+
+func (this *SmartSource) Choose(dj *AutoDJ, c *Client, current Args, n int) (songs []Args, err os.Error) {
+	var all []Args
+	if all, err = this.Playlist.Songs(c); err != nil {
+		return
+	}
+
+	songs = make([]Args, 0, n)
+	for _, s := range all {
+		if len(songs) >= n {
+			break
+		}
+
+		if !dj.Avoided(s["file"]) {
+			songs = append(songs, s)
+		}
+	}
+	return
+}
+
+func autodj(cmd *Command, c *Client) (err os.Error) {
+	dj := NewAutoDJ(nil)
+
+	switch cmd.S("strategy", "random") {
+	case "album":
+		dj.Strategy = new(RandomAlbum)
+	case "genre":
+		dj.Strategy = &SameTag{"Genre"}
+	case "artist":
+		dj.Strategy = &SameTag{"Artist"}
+	case "smart":
+		rules := cmd.S("rules", "")
+		if len(rules) == 0 {
+			return &UsageError{"The smart strategy needs a rule file. Use --rules."}
+		}
+
+		var sp *SmartPlaylist
+		if sp, err = LoadSmartPlaylist(rules); err != nil {
+			return
+		}
+		dj.Strategy = &SmartSource{sp}
+	default:
+		dj.Strategy = new(RandomSongs)
+	}
+
+	if dj.Avoid, err = ParseDuration(cmd.S("avoid", "4h")); err != nil {
+		return
+	}
+
+	dj.Count = cmd.I("count", 5)
+	dj.Keep = cmd.I("keep", -1)
+	dj.History = NewHistory(historyFile(cmd))
+
+	stop, release := untilInterrupted()
+	defer release()
+	return dj.Run(c, stop)
+}
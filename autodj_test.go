@@ -0,0 +1,213 @@
+// Copyright (c) 2010, Jim Teeuwen. All rights reserved.
+// This code is subject to a 1-clause BSD license.
+// See the LICENSE file for its contents.
+
+package mpd
+
+import (
+	"bytes"
+	"os"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+	"github.com/jteeuwen/go-pkg-mpd/mpdtest"
+)
+
+// Returns an AutoDJ which picks songs in the order they are given.
+func testDJ(s DJStrategy) *AutoDJ {
+	dj := NewAutoDJ(s)
+	dj.Log = new(bytes.Buffer)
+	dj.perm = func(n int) []int {
+		list := make([]int, n)
+		for i := range list {
+			list[i] = i
+		}
+		return list
+	}
+	return dj
+}
+
+// Returns the files in the queue after @start, sorted.
+func queueFiles(c *Client, start int) []string {
+	queue, _ := c.PlaylistInfo()
+
+	files := make([]string, 0)
+	for i := start; i < len(queue); i++ {
+		files = append(files, queue[i]["file"])
+	}
+
+	sort.SortStrings(files)
+	return files
+}
+
+func TestAutoDJ(t *testing.T) {
+	srv, c := startServer(t)
+	defer srv.Close()
+	defer c.Close()
+
+	h := tempHistory(t)
+	defer os.Remove(h.File)
+
+	var clock int64 = 1290000000e9
+	h.Append(&HistoryEntry{clock/1e9 - 600, "rock/acdc/hells_bells.mp3", nil, 312, 312, false})
+
+	dj := testDJ(new(RandomSongs))
+	dj.Count = 2
+	dj.History = h
+	dj.now = func() int64 { return clock }
+
+	srv.Enqueue("rock/acdc/back_in_black.mp3")
+	if err := c.execute("play 0"); err != nil {
+		t.Fatalf("play: %v", err)
+	}
+
+	// Neither the song in the queue nor the one played recently.
+	if err := dj.topUp(c); err != nil {
+		t.Fatalf("topUp: %v", err)
+	}
+
+	if got := strings.Join(queueFiles(c, 1), " "); got != "jazz/coltrane/giant_steps.flac jazz/davis/so_what.flac" {
+		t.Fatalf("Unexpected songs added: %s", got)
+	}
+
+	if err := dj.topUp(c); err != nil || len(queueFiles(c, 0)) != 3 {
+		t.Errorf("Expected no more songs, got %v (%v).", queueFiles(c, 0), err)
+	}
+
+	// Keeping no played songs removes them. The songs added before are
+	// still avoided, but not once Avoid has passed.
+	dj.Keep = 0
+	if err := c.execute("play 2"); err != nil {
+		t.Fatalf("play: %v", err)
+	}
+
+	if err := dj.topUp(c); err != nil {
+		t.Fatalf("topUp: %v", err)
+	}
+
+	queue, _ := c.PlaylistInfo()
+	if len(queue) != 2 || queue[1]["file"] != "rock/acdc/back_in_black.mp3" {
+		t.Fatalf("Unexpected queue %v.", queue)
+	}
+
+	if log := dj.Log.(*bytes.Buffer).String(); strings.Index(log, "Removed 2 played songs.") == -1 {
+		t.Errorf("Unexpected log:\n%s", log)
+	}
+
+	clock += dj.Avoid + 1e9
+	if err := dj.topUp(c); err != nil || len(queueFiles(c, 0)) != 3 {
+		t.Errorf("Expected a third song, got %v (%v).", queueFiles(c, 0), err)
+	}
+}
+
+// A strategy, the current song and the songs to avoid, and the songs it
+// should choose.
+type strategyTest struct {
+	strategy DJStrategy
+	current  Args
+	avoid    []string
+	want     string
+}
+
+func TestDJStrategies(t *testing.T) {
+	srv, c := startServer(t)
+	defer srv.Close()
+	defer c.Close()
+
+	jazz := Args{"file": "jazz/coltrane/giant_steps.flac", "Genre": "Jazz"}
+	sp, _ := ParseSmartPlaylist(strings.NewReader("where genre == Rock\norder track"))
+
+	tests := []strategyTest{
+		strategyTest{new(RandomAlbum), nil, nil, "hells_bells back_in_black"},
+		strategyTest{new(RandomAlbum), nil, []string{"rock/acdc/back_in_black.mp3"}, "giant_steps"},
+		strategyTest{&SameTag{"Genre"}, jazz, []string{"jazz/coltrane/giant_steps.flac"}, "so_what"},
+		strategyTest{&SameTag{"Genre"}, jazz, []string{"jazz/coltrane/giant_steps.flac", "jazz/davis/so_what.flac"}, "back_in_black"},
+		strategyTest{&SmartSource{sp}, nil, []string{"rock/acdc/hells_bells.mp3"}, "back_in_black"},
+	}
+
+	for i, test := range tests {
+		dj := testDJ(test.strategy)
+		for _, file := range test.avoid {
+			dj.avoid[file] = true
+		}
+
+		// Sorted by file, so that the order of the library is known.
+		library, _ := c.listAllInfoPairs("")
+		sort.Sort(&smartSongs{library, nil, nil})
+		dj.library = songArgs(library)
+
+		songs, err := test.strategy.Choose(dj, c, test.current, 1)
+		if err != nil {
+			t.Errorf("%d: %v", i, err)
+			continue
+		}
+
+		names := make([]string, len(songs))
+		for j, s := range songs {
+			file := s["file"]
+			names[j] = file[strings.LastIndex(file, "/")+1 : strings.LastIndex(file, ".")]
+		}
+
+		if got := strings.Join(names, " "); got != test.want {
+			t.Errorf("%d: Expected %s, got %s.", i, test.want, got)
+		}
+	}
+}
+
+func TestAutoDJRun(t *testing.T) {
+	srv, c := startServer(t)
+	defer srv.Close()
+	defer c.Close()
+
+	dj := testDJ(new(RandomSongs))
+	dj.Count = 1
+
+	stop := make(chan bool)
+	done := make(chan os.Error, 1)
+	go func() { done <- dj.Run(c, stop) }()
+
+	for i := 0; i < 100 && len(srv.Queue()) == 0; i++ {
+		time.Sleep(10e6)
+	}
+
+	close(stop)
+	if err := <-done; err != nil {
+		t.Errorf("Run: %v", err)
+	}
+
+	if queue := srv.Queue(); len(queue) != 1 {
+		t.Errorf("Expected a song in the queue, got %v.", queue)
+	}
+}
+
+func TestAutoDJRunErrors(t *testing.T) {
+	srv, c := startServer(t)
+	defer srv.Close()
+	defer c.Close()
+
+	srv.Handle("add", func(c *mpdtest.Conn, args []string, w *mpdtest.Response) *mpdtest.Ack {
+		return mpdtest.NewAck(mpdtest.ErrNoExist, "No such song")
+	})
+
+	dj := testDJ(new(RandomSongs))
+	dj.Count = 1
+
+	stop := make(chan bool)
+	done := make(chan os.Error, 1)
+	go func() { done <- dj.Run(c, stop) }()
+
+	for i := 0; i < 100 && strings.Index(strings.Join(srv.Commands(), "\n"), "add ") == -1; i++ {
+		time.Sleep(10e6)
+	}
+
+	// The refused song does not end the DJ.
+	close(stop)
+	if err := <-done; err != nil {
+		t.Errorf("Run: %v", err)
+	}
+
+	if log := dj.Log.(*bytes.Buffer).String(); strings.Index(log, "Can not top up the queue") == -1 {
+		t.Errorf("Unexpected log:\n%s", log)
+	}
+}
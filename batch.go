@@ -0,0 +1,432 @@
+// Copyright (c) 2010, Jim Teeuwen. All rights reserved.
+// This code is subject to a 1-clause BSD license.
+// See the LICENSE file for its contents.
+
+package mpd
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strings"
+)
+
+func init() {
+	mustRegister(GroupSession,
+		&Command{
+			Name: "batch",
+			Desc: "Runs a script of commands over a single connection. Commands are separated by line breaks or semicolons, and lines starting with # are comments. 'set name value' defines a variable, which the commands after it use as $name or ${name}. The whole script is checked before the first command is sent.",
+			Params: []*Param{
+				newParam("file", "Script to run. Use - to read it from standard input.", TextKind, true).withDefault("-"),
+				newParam("vars", "Variables to start with, as a comma separated list of name=value pairs.", TextKind, true),
+				newFlag("atomic", "Send all commands as a single command list, which the server runs without interruption. Commands which depend on the response of an earlier one can not be used, and no output is reported."),
+				newFlag("continue", "Keep going when a command fails, and report the number of failures at the end. Has no effect on atomic scripts, which the server stops at the first failure."),
+			},
+			Exec: batch,
+		},
+	)
+}
+
+// Batch runs scripts of commands. A script holds the same command lines as
+// the shell accepts, separated by line breaks or semicolons:
+//
+//	# Start the party.
+//	set list party
+//	clear; load $list; random on; play 0
+//
+// Lines starting with # are comments. 'set name value' defines a variable
+// and $name or ${name} is replaced by its value, except inside single
+// quotes. Quote variables whose values contain spaces: add "$dir".
+//
+// The script is parsed and every command is checked before the first one
+// is sent, so a mistake on the last line does not leave the first ones
+// applied.
+type Batch struct {
+	Vars     map[string]string
+	Atomic   bool      // Send the script as a single command list.
+	Continue bool      // Run the remaining commands after one fails.
+	Format   Formatter // Output format. Defaults to text.
+	Output   io.Writer // Defaults to standard output.
+	Log      io.Writer // Receives the failures in Continue mode. Defaults to standard error.
+}
+
+// A command of a script, along with the line it came from.
+type batchStep struct {
+	line int
+	cmd  *Command
+}
+
+var reVarName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// Commands which can not be part of a script.
+var batchRefused = []string{"batch", "shell", "watch"}
+
+func NewBatch() *Batch {
+	b := new(Batch)
+	b.Vars = make(map[string]string)
+	return b
+}
+
+// Reads a script from @r and runs it over @c. Returns the first error, or
+// in Continue mode, an error which counts the failed commands.
+func (this *Batch) Run(c *Client, r io.Reader) (err os.Error) {
+	if this.Format == nil {
+		this.Format = new(TextFormatter)
+	}
+
+	if this.Output == nil {
+		this.Output = os.Stdout
+	}
+
+	if err = this.Format.Begin(this.Output); err != nil {
+		return
+	}
+
+	err = this.run(c, r)
+	if e := this.Format.End(this.Output); err == nil {
+		err = e
+	}
+	return
+}
+
+// Runs a script into the output, without beginning or ending it.
+func (this *Batch) run(c *Client, r io.Reader) (err os.Error) {
+	var data []byte
+	if data, err = ioutil.ReadAll(r); err != nil {
+		return
+	}
+
+	var steps []*batchStep
+	if steps, err = this.parse(string(data)); err != nil {
+		return
+	}
+
+	// Check the values which only the server can validate before anything
+	// is sent.
+	for _, s := range steps {
+		if err = s.cmd.checkServer(c); err != nil {
+			return lineError(s.line, err)
+		}
+	}
+
+	if this.Atomic {
+		return this.runList(c, steps)
+	}
+
+	if this.Log == nil {
+		this.Log = os.Stderr
+	}
+
+	failed := 0
+	for _, s := range steps {
+		s.cmd.Format = this.Format
+		s.cmd.Output = this.Output
+
+		if err = s.cmd.Exec(s.cmd, c); err == nil {
+			continue
+		}
+
+		if !this.Continue {
+			return lineError(s.line, err)
+		}
+
+		failed++
+		fmt.Fprintf(this.Log, "%v\n", lineError(s.line, err))
+	}
+
+	if failed > 0 {
+		return os.NewError(fmt.Sprintf("%d of %d commands failed.", failed, len(steps)))
+	}
+	return nil
+}
+
+// Parses a script into its commands. Variables are expanded as the script
+// is read, so a variable can be redefined between commands.
+func (this *Batch) parse(script string) (steps []*batchStep, err os.Error) {
+	steps = make([]*batchStep, 0)
+
+	for n, line := range strings.Split(script, "\n", -1) {
+		var list []string
+		if list, err = splitStatements(line); err != nil {
+			return nil, lineError(n+1, err)
+		}
+
+		for _, stmt := range list {
+			var step *batchStep
+			if step, err = this.parseStatement(stmt); err != nil {
+				return nil, lineError(n+1, err)
+			}
+
+			if step != nil {
+				step.line = n + 1
+				steps = append(steps, step)
+			}
+		}
+	}
+	return
+}
+
+// Returns the command of a single statement, or nil for a variable
+// definition.
+func (this *Batch) parseStatement(stmt string) (step *batchStep, err os.Error) {
+	if stmt, err = expandVars(stmt, this.Vars); err != nil {
+		return
+	}
+
+	var args []string
+	if args, err = splitLine(stmt); err != nil || len(args) == 0 {
+		return
+	}
+
+	if args[0] == "set" {
+		if len(args) < 3 || !reVarName.MatchString(args[1]) {
+			return nil, os.NewError("Usage: set <name> <value>")
+		}
+		this.Vars[args[1]] = strings.Join(args[2:], " ")
+		return
+	}
+
+	for _, name := range batchRefused {
+		if args[0] == name {
+			return nil, os.NewError(fmt.Sprintf("Command '%s' can not be used in a script.", name))
+		}
+	}
+
+	cmd := CreateCommand(args[0])
+	if cmd == nil {
+		return nil, os.NewError(fmt.Sprintf("Unknown command '%s'.", args[0]))
+	}
+
+	if err = cmd.parse(args[1:]); err != nil {
+		return
+	}
+	return &batchStep{0, cmd}, nil
+}
+
+// Checks a script without running it. Returns the commands it holds, as
+// they would be run.
+func (this *Batch) Parse(script string) (list []*Command, err os.Error) {
+	var steps []*batchStep
+	if steps, err = this.parse(script); err != nil {
+		return
+	}
+
+	list = make([]*Command, len(steps))
+	for i, s := range steps {
+		list[i] = s.cmd
+	}
+	return
+}
+
+// Sends the script as a single command list. The protocol commands are
+// collected first, by running each command against a connection which
+// answers everything with OK.
+func (this *Batch) runList(c *Client, steps []*batchStep) (err os.Error) {
+	lines := make([]string, 0, len(steps))
+
+	for _, s := range steps {
+		conn := new(captureConn)
+		capture := newClient()
+		capture.writer = bufio.NewWriter(conn)
+		capture.reader = bufio.NewReader(conn)
+
+		s.cmd.Format = new(TextFormatter)
+		s.cmd.Output = new(bytes.Buffer)
+
+		if err = s.cmd.Exec(s.cmd, capture); err != nil {
+			return lineError(s.line, err)
+		}
+
+		if conn.dependent {
+			return lineError(s.line, os.NewError(fmt.Sprintf(
+				"Command '%s' depends on the server's state and can not be part of an atomic script.", s.cmd.Name)))
+		}
+		lines = append(lines, conn.lines...)
+	}
+
+	if len(lines) == 0 {
+		return
+	}
+	return c.ExecuteList(lines...)
+}
+
+// Stands in for the server while the commands of an atomic script are
+// collected. Remembers whether a command sent anything after reading a
+// response, as such commands need the actual response.
+type captureConn struct {
+	lines     []string
+	read      bool
+	dependent bool
+}
+
+func (this *captureConn) Write(p []byte) (int, os.Error) {
+	if this.read {
+		this.dependent = true
+	}
+
+	for _, line := range strings.Split(strings.TrimRight(string(p), "\n"), "\n", -1) {
+		this.lines = append(this.lines, line)
+	}
+	return len(p), nil
+}
+
+func (this *captureConn) Read(p []byte) (int, os.Error) {
+	this.read = true
+	return copy(p, []byte("OK\n")), nil
+}
+
+// Splits a line of a script into its statements, at semicolons outside
+// quotes. A statement starting with # makes the rest of the line a
+// comment.
+func splitStatements(line string) (list []string, err os.Error) {
+	var quote byte
+	list = make([]string, 0, 1)
+	start := 0
+
+	add := func(stmt string) {
+		if stmt = strings.TrimSpace(stmt); len(stmt) > 0 {
+			list = append(list, stmt)
+		}
+	}
+
+	for i := 0; i < len(line); i++ {
+		ch := line[i]
+
+		switch {
+		case quote == '\'' && ch != '\'':
+
+		case ch == '\\' && i+1 < len(line):
+			i++
+
+		case quote == 0 && (ch == '"' || ch == '\''):
+			quote = ch
+
+		case quote != 0 && ch == quote:
+			quote = 0
+
+		case quote == 0 && ch == '#' && len(strings.TrimSpace(line[start:i])) == 0:
+			return
+
+		case quote == 0 && ch == ';':
+			add(line[start:i])
+			start = i + 1
+		}
+	}
+
+	if quote != 0 {
+		return nil, os.NewError(fmt.Sprintf("Missing closing %c.", quote))
+	}
+
+	add(line[start:])
+	return
+}
+
+// Replaces $name and ${name} in @stmt by the values in @vars, except inside
+// single quotes. Use \$ for a literal dollar sign.
+func expandVars(stmt string, vars map[string]string) (string, os.Error) {
+	var buf bytes.Buffer
+	var quote byte
+
+	for i := 0; i < len(stmt); i++ {
+		ch := stmt[i]
+
+		switch {
+		case quote == '\'' && ch != '\'':
+
+		case ch == '\\' && i+1 < len(stmt):
+			// Keep the escape for splitLine.
+			buf.WriteByte(ch)
+			i++
+			ch = stmt[i]
+
+		case quote == 0 && (ch == '"' || ch == '\''):
+			quote = ch
+
+		case quote != 0 && ch == quote:
+			quote = 0
+
+		case ch == '$':
+			name, n := varName(stmt[i+1:])
+			if n == 0 {
+				return "", os.NewError("Missing variable name after '$'.")
+			}
+
+			value, ok := vars[name]
+			if !ok {
+				return "", os.NewError(fmt.Sprintf("Undefined variable '%s'.", name))
+			}
+
+			buf.WriteString(value)
+			i += n
+			continue
+		}
+
+		buf.WriteByte(ch)
+	}
+	return buf.String(), nil
+}
+
+// Returns the variable name at the start of @s, in the form name or {name},
+// and the number of bytes it takes up.
+func varName(s string) (string, int) {
+	if strings.HasPrefix(s, "{") {
+		end := strings.Index(s, "}")
+		if end == -1 || !reVarName.MatchString(s[1:end]) {
+			return "", 0
+		}
+		return s[1:end], end + 1
+	}
+
+	n := 0
+	for n < len(s) && (s[n] == '_' || s[n] >= 'a' && s[n] <= 'z' || s[n] >= 'A' && s[n] <= 'Z' || n > 0 && s[n] >= '0' && s[n] <= '9') {
+		n++
+	}
+	return s[:n], n
+}
+
+func lineError(line int, err os.Error) os.Error {
+	return os.NewError(fmt.Sprintf("Line %d: %s", line, err.String()))
+}
+
+// Parses a comma separated list of name=value pairs into @vars.
+func parseVars(list string, vars map[string]string) os.Error {
+	for _, v := range strings.Split(list, ",", -1) {
+		if v = strings.TrimSpace(v); len(v) == 0 {
+			continue
+		}
+
+		pos := strings.Index(v, "=")
+		if pos == -1 || !reVarName.MatchString(v[:pos]) {
+			return os.NewError(fmt.Sprintf("Invalid variable '%s'. Use name=value.", v))
+		}
+		vars[v[:pos]] = v[pos+1:]
+	}
+	return nil
+}
+
+func batch(cmd *Command, c *Client) (err os.Error) {
+	b := NewBatch()
+	b.Atomic = cmd.S("atomic", "off") == "on"
+	b.Continue = cmd.S("continue", "off") == "on"
+	b.Format = cmd.Format
+	b.Output = cmd.Output
+
+	if err = parseVars(cmd.S("vars", ""), b.Vars); err != nil {
+		return
+	}
+
+	var r io.Reader = os.Stdin
+	if file := cmd.S("file", "-"); file != "-" {
+		var f *os.File
+		if f, err = os.Open(file, os.O_RDONLY, 0); err != nil {
+			return
+		}
+		defer f.Close()
+		r = f
+	}
+	return b.run(c, r)
+}
@@ -0,0 +1,190 @@
+// Copyright (c) 2010, Jim Teeuwen. All rights reserved.
+// This code is subject to a 1-clause BSD license.
+// See the LICENSE file for its contents.
+
+package mpd
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+const testScript = `# Start the party.
+set list party
+clear; load $list ; random on
+  # Indented comment.
+play 0; status
+`
+
+func TestSplitStatements(t *testing.T) {
+	tests := map[string]string{
+		"clear; play 0":             "clear|play 0",
+		`add "a;b"; add 'c;d'`:      `add "a;b"|add 'c;d'`,
+		`add a\;b`:                  `add a\;b`,
+		"# clear; play":             "",
+		"clear; # play":             "clear",
+		"add a#b":                   "add a#b",
+		" ; ;clear;":                "clear",
+		`find title "it's"; play`:   `find title "it's"|play`,
+		`find title 'say "hi";'; x`: `find title 'say "hi";'|x`,
+	}
+
+	for line, want := range tests {
+		list, err := splitStatements(line)
+		if err != nil {
+			t.Errorf("%s: %v", line, err)
+			continue
+		}
+
+		if got := strings.Join(list, "|"); got != want {
+			t.Errorf("%s: Expected '%s', got '%s'.", line, want, got)
+		}
+	}
+}
+
+func TestExpandVars(t *testing.T) {
+	vars := map[string]string{"dir": "rock/AC DC", "n": "3"}
+
+	tests := map[string]string{
+		`add "$dir"`:        `add "rock/AC DC"`,
+		`play ${n}0`:        `play 30`,
+		`save '$dir'`:       `save '$dir'`,
+		`save \$dir`:        `save \$dir`,
+		`add "$dir/$n.mp3"`: `add "rock/AC DC/3.mp3"`,
+	}
+
+	for stmt, want := range tests {
+		got, err := expandVars(stmt, vars)
+		if err != nil {
+			t.Errorf("%s: %v", stmt, err)
+			continue
+		}
+
+		if got != want {
+			t.Errorf("%s: Expected '%s', got '%s'.", stmt, want, got)
+		}
+	}
+
+	for _, stmt := range []string{"play $x", "play $", "play ${n"} {
+		if _, err := expandVars(stmt, vars); err == nil {
+			t.Errorf("%s: Expected error.", stmt)
+		}
+	}
+}
+
+func TestBatch(t *testing.T) {
+	srv, c := startServer(t)
+	defer srv.Close()
+	defer c.Close()
+
+	srv.AddPlaylist("party", "rock/acdc/hells_bells.mp3", "rock/acdc/back_in_black.mp3")
+
+	var buf bytes.Buffer
+	b := NewBatch()
+	b.Output = &buf
+	srv.ResetCommands()
+
+	if err := b.Run(c, strings.NewReader(testScript)); err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+
+	want := `clear|load "party"|random 1|play 0|status`
+	if got := strings.Join(srv.Commands(), "|"); got != want {
+		t.Errorf("Expected '%s', got '%s'.", want, got)
+	}
+	expectContains(t, "status", buf.String(), "state : play")
+
+	// Atomic scripts are sent as a single command list.
+	b = NewBatch()
+	b.Atomic = true
+	b.Vars["list"] = "party"
+	srv.ResetCommands()
+
+	if err := b.Run(c, strings.NewReader("clear; load $list; play 1")); err != nil {
+		t.Fatalf("Atomic run: %v", err)
+	}
+
+	want = `command_list_begin|clear|load "party"|play 1|command_list_end`
+	if got := strings.Join(srv.Commands(), "|"); got != want {
+		t.Errorf("Expected '%s', got '%s'.", want, got)
+	}
+
+	if srv.Status()["song"] != "1" {
+		t.Errorf("Expected song 1 to play, got %v.", srv.Status())
+	}
+
+	if err := NewBatch().Run(c, strings.NewReader("volume +5")); err != nil {
+		t.Errorf("volume +5: %v", err)
+	}
+
+	b = NewBatch()
+	b.Atomic = true
+	if err := b.Run(c, strings.NewReader("clear\nvolume +5")); err == nil {
+		t.Errorf("Expected error for a command which reads the server's state.")
+	}
+}
+
+func TestBatchErrors(t *testing.T) {
+	srv, c := startServer(t)
+	defer srv.Close()
+	defer c.Close()
+
+	// Mistakes are found before anything is sent.
+	tests := map[string]string{
+		"clear\nplay 0\nbogus":    "Line 3: Unknown command 'bogus'.",
+		"clear; play $pos":        "Line 1: Undefined variable 'pos'.",
+		"clear\n\nvolume 200":     "Line 3: Invalid value",
+		"set 1x 2":                "Line 1: Usage: set <name> <value>",
+		"clear; shell":            "Line 1: Command 'shell' can not be used in a script.",
+		"clear\nfind nosuchtag x": "Line 2: Invalid value 'nosuchtag'",
+		"add \"rock\nplay":        "Line 1: Missing closing \".",
+	}
+
+	for script, want := range tests {
+		srv.ResetCommands()
+
+		err := NewBatch().Run(c, strings.NewReader(script))
+		if err == nil {
+			t.Errorf("%q: Expected error.", script)
+			continue
+		}
+
+		if !strings.HasPrefix(err.String(), want) {
+			t.Errorf("%q: Expected '%s', got '%s'.", script, want, err)
+		}
+
+		for _, cmd := range srv.Commands() {
+			if cmd == "clear" {
+				t.Errorf("%q: Commands were sent before the error was found.", script)
+			}
+		}
+	}
+
+	// Failures on the server stop the script, unless Continue is set.
+	script := "load nosuch\nclear\nplay 99\nrandom on"
+
+	srv.ResetCommands()
+	if err := NewBatch().Run(c, strings.NewReader(script)); err == nil || !strings.HasPrefix(err.String(), "Line 1: ") {
+		t.Errorf("Expected error on line 1, got %v.", err)
+	}
+
+	if len(srv.Commands()) != 1 {
+		t.Errorf("Expected the script to stop, got %v.", srv.Commands())
+	}
+
+	var log bytes.Buffer
+	b := NewBatch()
+	b.Continue = true
+	b.Log = &log
+	srv.ResetCommands()
+
+	if err := b.Run(c, strings.NewReader(script)); err == nil || err.String() != "2 of 4 commands failed." {
+		t.Errorf("Expected a count of failures, got %v.", err)
+	}
+
+	if srv.Last() != "random 1" {
+		t.Errorf("Expected the script to continue, got %v.", srv.Commands())
+	}
+	expectContains(t, "log", log.String(), "Line 1: ", "Line 3: ")
+}
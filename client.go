@@ -13,6 +13,8 @@ import (
 	"strconv"
 	"bufio"
 	"net"
+	"io"
+	"sync"
 )
 
 // Used to test whether we are compatible with the MPD server.
@@ -25,16 +27,111 @@ type Client struct {
 	Protocol        string
 	Address         string
 	ProtocolVersion string
+
+	// Receives the outcome of each request. Dial sets it from the
+	// configuration.
+	Observer RequestObserver
+
+	transcript io.Writer // Set by Record.
+	file       *os.File  // Transcript file opened by Dial.
+	cfg        *Config   // Set by Dial, used by Reconnect.
+	pending    string    // Command whose response is awaited, for the Observer.
+	started    int64     // Time at which the pending command was sent.
+	listing    bool      // Inside a command list.
+	failed     bool      // Reading or writing the connection failed.
+	returned   int64     // Time at which a Pool got the connection back.
+}
+
+// A RequestObserver is told about every request a Client makes, for example
+// to keep metrics. @duration is the time in nanoseconds from sending the
+// command until its response was read. @err is an *AckError if the server
+// rejected the command, or the error of the connection. Command lists are
+// reported as a single request named "command_list". Idle is not reported,
+// since it takes as long as nothing changes.
+type RequestObserver interface {
+	ObserveRequest(command string, duration int64, err os.Error)
 }
 
 func newClient() *Client {
 	return &Client{}
 }
 
+// Opens a connection to the server described by @cfg and authenticates with
+// its password, if one is set.
+func Dial(cfg *Config) (c *Client, err os.Error) {
+	c = newClient()
+	c.cfg = cfg
+	c.Observer = cfg.Observer
+
+	if len(cfg.Transcript) > 0 {
+		name := transcriptFile(cfg.Transcript)
+		if c.file, err = os.Open(name, os.O_WRONLY|os.O_CREAT|os.O_TRUNC, 0644); err != nil {
+			return nil, err
+		}
+		c.Record(c.file)
+	}
+
+	// Addresses starting with a slash refer to a local unix socket. Some
+	// commands, like config, are only available over such a connection.
+	if strings.HasPrefix(cfg.Address, "/") {
+		err = c.Open("unix", cfg.Address)
+	} else {
+		err = c.Open("tcp", fmt.Sprintf("%s:%d", cfg.Address, cfg.Port))
+	}
+
+	if err != nil {
+		// Open leaves the socket open if the handshake or the version is
+		// not accepted.
+		if c.tcp != nil {
+			c.tcp.Close()
+		}
+		c.closeTranscript()
+		return nil, err
+	}
+
+	if len(cfg.Password) > 0 {
+		if err = c.Password(cfg.Password); err != nil {
+			c.Close()
+			return nil, err
+		}
+	}
+	return
+}
+
+// Number of connections recorded to each transcript path so far.
+var (
+	transcriptLock  sync.Mutex
+	transcriptCount = make(map[string]int)
+)
+
+// Returns the file to record the next connection to @path in. Each
+// connection, including those of a pool and reconnects, gets its own file:
+// the first one @path itself, later ones @path.1, @path.2 and so on.
+func transcriptFile(path string) string {
+	transcriptLock.Lock()
+	defer transcriptLock.Unlock()
+
+	n := transcriptCount[path]
+	transcriptCount[path] = n + 1
+
+	if n == 0 {
+		return path
+	}
+	return fmt.Sprintf("%s.%d", path, n)
+}
+
 func (this *Client) IsConnected() bool {
 	return this.tcp != nil
 }
 
+// Reports whether reading or writing the connection has failed, or a
+// response could not be understood. Such a connection is out of step with
+// the server and should be closed. Errors reported by the server, or found
+// before anything was sent, leave it usable.
+func (this *Client) Failed() bool {
+	return this.failed
+}
+
 func (this *Client) Open(protocol, address string) (err os.Error) {
 	this.Protocol = protocol
 	this.Address = address
@@ -43,6 +140,10 @@ func (this *Client) Open(protocol, address string) (err os.Error) {
 		return
 	}
 
+	if this.transcript != nil {
+		this.tcp = NewRecorder(this.tcp, this.transcript)
+	}
+
 	this.reader = bufio.NewReader(this.tcp)
 	this.writer = bufio.NewWriter(this.tcp)
 
@@ -74,11 +175,48 @@ func (this *Client) Open(protocol, address string) (err os.Error) {
 	return
 }
 
-func (this *Client) Close() (err os.Error) {
-	if err = this.send("close"); err != nil {
+// Records all traffic on the connection to @w, in the format described by
+// Recorder. This must be called before Open.
+func (this *Client) Record(w io.Writer) {
+	this.transcript = w
+}
+
+// Re-opens a connection which was created with Dial, for example after the
+// server closed it for being idle too long.
+func (this *Client) Reconnect() (err os.Error) {
+	if this.cfg == nil {
+		return os.NewError("Only connections created with Dial can be reopened.")
+	}
+
+	this.Close()
+
+	var c *Client
+	if c, err = Dial(this.cfg); err != nil {
 		return
 	}
 
+	*this = *c
+	return
+}
+
+// Checks whether the connection is still alive.
+func (this *Client) Ping() os.Error {
+	return this.execute("ping")
+}
+
+// Authenticates with the given password. This unlocks the commands the
+// password has been granted permissions for.
+func (this *Client) Password(pw string) os.Error {
+	return this.execute("password %s", quote(pw))
+}
+
+// Closes the connection. The socket and transcript are closed even if the
+// server can no longer be told, since that is when Close is most needed.
+func (this *Client) Close() (err os.Error) {
+	if this.writer != nil {
+		this.send("close")
+	}
+
 	this.reader = nil
 	this.writer = nil
 
@@ -87,15 +225,67 @@ func (this *Client) Close() (err os.Error) {
 		this.tcp = nil
 	}
 
+	this.closeTranscript()
 	return
 }
 
+func (this *Client) closeTranscript() {
+	if this.file != nil {
+		this.file.Close()
+		this.file = nil
+	}
+}
+
+// ACK error codes, as defined by the MPD protocol.
+const (
+	ErrNotList       = 1
+	ErrArg           = 2
+	ErrPassword      = 3
+	ErrPermission    = 4
+	ErrUnknown       = 5
+	ErrNoExist       = 50
+	ErrPlaylistMax   = 51
+	ErrSystem        = 52
+	ErrPlaylistLoad  = 53
+	ErrUpdateAlready = 54
+	ErrPlayerSync    = 55
+	ErrExist         = 56
+)
+
+// An error reported by the server in an ACK line. Errors which come from
+// the connection itself are plain os.Errors.
+type AckError struct {
+	Code    int    // One of the Err constants.
+	Index   int    // Position of the failed command in a command list.
+	Command string // Name of the failed command.
+	Message string
+}
+
+func (this *AckError) String() string {
+	return this.Message
+}
+
 func (this *Client) parseError(line string) os.Error {
 	if strings.HasPrefix(line, "ACK ") {
 		// sig: [errcode@token] {command} message
 		//  ex: [2@0] {enableoutput} wrong number of arguments for "enableoutput"
+		ack := new(AckError)
 		pos := strings.Index(line, "}")
-		return os.NewError(strings.TrimSpace(line[pos+1:]))
+		ack.Message = strings.TrimSpace(line[pos+1:])
+
+		start, end := strings.Index(line, "["), strings.Index(line, "]")
+		if start != -1 && end > start {
+			code := strings.Split(line[start+1:end], "@", 2)
+			ack.Code, _ = strconv.Atoi(code[0])
+			if len(code) == 2 {
+				ack.Index, _ = strconv.Atoi(code[1])
+			}
+		}
+
+		if open := strings.Index(line, "{"); open != -1 && pos > open {
+			ack.Command = line[open+1 : pos]
+		}
+		return ack
 	}
 	return os.NewError(line)
 }
@@ -107,38 +297,53 @@ func (this *Client) requestArgs(cmd string, arg ...interface{}) (args Args, err
 	return this.receive()
 }
 
-func (this *Client) request(cmd string, arg ...interface{}) (err os.Error) {
-	var args Args
-	if args, err = this.requestArgs(fmt.Sprintf(cmd, arg...)); err != nil {
+// Sends a command and discards the response. Used by commands which only
+// report success or failure.
+func (this *Client) execute(cmd string, arg ...interface{}) (err os.Error) {
+	if err = this.send(cmd, arg...); err != nil {
 		return
 	}
-	args.Print()
+	_, err = this.receive()
 	return
 }
 
-func (this *Client) requestListArgs(cmd string, arg ...interface{}) (args []Args, err os.Error) {
-	if err = this.send(fmt.Sprintf(cmd, arg...)); err != nil {
-		return
+// Sends the given protocol commands as a single command list. The server
+// runs them without interruption by other clients, and stops at the first
+// one which fails. Its error is returned, but the commands before it have
+// been applied.
+func (this *Client) ExecuteList(commands ...string) (err os.Error) {
+	// Check the commands before the list is started, so that it is never
+	// left open.
+	for _, cmd := range commands {
+		if breaksLine(cmd) {
+			return &UsageError{fmt.Sprintf("Command %q spans more than one line.", cmd)}
+		}
 	}
-	return this.receiveList()
-}
 
-func (this *Client) requestList(cmd string, arg ...interface{}) (err os.Error) {
-	if err = this.send(fmt.Sprintf(cmd, arg...)); err != nil {
+	if err = this.send("command_list_begin"); err != nil {
 		return
 	}
 
-	var arglist []Args
-	if arglist, err = this.receiveList(); err != nil || arglist == nil {
-		return
+	for _, cmd := range commands {
+		if err = this.send("%s", cmd); err != nil {
+			return
+		}
 	}
 
-	for _, v := range arglist {
-		v.Print()
+	if err = this.send("command_list_end"); err != nil {
+		return
 	}
+	_, err = this.receive()
 	return
 }
 
+func (this *Client) requestListArgs(cmd string, arg ...interface{}) (args []Args, err os.Error) {
+	if err = this.send(fmt.Sprintf(cmd, arg...)); err != nil {
+		return
+	}
+	return this.receiveList()
+}
+
 func (this *Client) receive() (data Args, err os.Error) {
 	if this.reader == nil {
 		return nil, os.NewError("Stream reader is closed.")
@@ -149,7 +354,7 @@ func (this *Client) receive() (data Args, err os.Error) {
 	var pos int
 
 	for {
-		if line, err = this.reader.ReadString('\n'); err != nil {
+		if line, err = this.readLine(); err != nil {
 			return nil, err
 		}
 
@@ -184,7 +389,7 @@ func (this *Client) receiveList() (data []Args, err os.Error) {
 	a := make(Args)
 
 	for {
-		if line, err = this.reader.ReadString('\n'); err != nil {
+		if line, err = this.readLine(); err != nil {
 			return nil, err
 		}
 
@@ -220,6 +425,104 @@ func (this *Client) receiveList() (data []Args, err os.Error) {
 	return
 }
 
+// A single key/value pair from a server response.
+type Pair struct {
+	Key   string
+	Value string
+}
+
+// Collects @pairs in a map. Of keys which occur more than once, the last
+// value is kept.
+func pairArgs(pairs []Pair) Args {
+	a := make(Args)
+	for _, p := range pairs {
+		a[p.Key] = p.Value
+	}
+	return a
+}
+
+// Reads a response as an ordered list of key/value pairs. Unlike receive and
+// receiveList, this preserves the order of the pairs and keeps repeated keys.
+func (this *Client) receivePairs() (pairs []Pair, err os.Error) {
+	var line string
+	var pos int
+
+	if this.reader == nil {
+		return nil, os.NewError("Stream reader is closed.")
+	}
+
+	pairs = make([]Pair, 0)
+
+	for {
+		if line, err = this.readLine(); err != nil {
+			return nil, err
+		}
+
+		if line = strings.TrimSpace(line); len(line) > 0 {
+			if line == "OK" {
+				break
+			}
+
+			if strings.HasPrefix(line, "ACK ") {
+				return nil, this.parseError(line)
+			}
+
+			if pos = strings.Index(line, ":"); pos == -1 {
+				this.failed = true
+				return nil, os.NewError(fmt.Sprintf("Malformed response line '%s'.", line))
+			}
+
+			if line[0:pos] == "error" {
+				return nil, this.parseError(line)
+			}
+
+			pairs = append(pairs, Pair{line[0:pos], strings.TrimSpace(line[pos+1:])})
+		}
+	}
+	return
+}
+
+// Reads a response as a list of entries. A new entry is started at each
+// occurrence of one of the keys in @start. This is more reliable than
+// receiveList for responses which mix different kinds of entries, like
+// files and directories.
+func (this *Client) receiveEntries(start ...string) (data []Args, err os.Error) {
+	var pairs []Pair
+	if pairs, err = this.receivePairs(); err != nil {
+		return
+	}
+
+	entries := splitEntries(pairs, start...)
+	data = make([]Args, len(entries))
+
+	for i, e := range entries {
+		data[i] = make(Args)
+		for _, p := range e {
+			data[i][p.Key] = p.Value
+		}
+	}
+	return
+}
+
+// Splits @pairs into entries, starting a new one at each occurrence of one
+// of the keys in @start.
+func splitEntries(pairs []Pair, start ...string) [][]Pair {
+	list := make([][]Pair, 0)
+	pos := 0
+
+	for i, p := range pairs {
+		if i > pos && contains(start, p.Key) {
+			list = append(list, pairs[pos:i])
+			pos = i
+		}
+	}
+
+	if pos < len(pairs) {
+		list = append(list, pairs[pos:])
+	}
+	return list
+}
+
 func (this *Client) send(msg string, args ...interface{}) (err os.Error) {
 	const max_retries = 3
 	var tries, num int
@@ -228,18 +531,90 @@ func (this *Client) send(msg string, args ...interface{}) (err os.Error) {
 		return os.NewError("Stream writer is closed.")
 	}
 
-	msg = fmt.Sprintf(msg, args...)
+	if len(args) > 0 {
+		msg = fmt.Sprintf(msg, args...)
+	}
+
+	if breaksLine(msg) {
+		return &UsageError{fmt.Sprintf("Command %q spans more than one line.", msg)}
+	}
 	msg += "\n"
 
+	if this.Observer != nil {
+		this.track(msg)
+	}
+
 	for tries = 0; tries < max_retries; tries++ {
 		if num, err = this.writer.WriteString(msg); num < len(msg) {
 			time.Sleep(300000000) // 0.3 seconds between retries
 			continue
 		}
-		this.writer.Flush()
+		err = this.writer.Flush()
 		break
 	}
 
+	if err != nil {
+		this.failed = true
+	}
+	return
+}
+
+// Notes the command which is sent in @msg, so that its response can be
+// reported to the Observer.
+func (this *Client) track(msg string) {
+	name := msg
+	if pos := strings.IndexAny(msg, " \n"); pos != -1 {
+		name = msg[:pos]
+	}
+
+	switch name {
+	case "command_list_begin", "command_list_ok_begin":
+		this.listing = true
+		name = "command_list"
+	case "command_list_end":
+		this.listing = false
+		return
+	case "idle", "close":
+		this.pending = ""
+		return
+	case "noidle":
+		// Sent while another goroutine reads the response to idle, which
+		// already cleared pending, so nothing may be touched here.
+		return
+	}
+
+	if !this.listing || name == "command_list" {
+		this.pending = name
+		this.started = time.Nanoseconds()
+	}
+}
+
+// Reads a line of a response. The end of the response is reported to the
+// Observer.
+func (this *Client) readLine() (line string, err os.Error) {
+	line, err = this.reader.ReadString('\n')
+	if err != nil {
+		this.failed = true
+	}
+
+	if this.Observer == nil || len(this.pending) == 0 {
+		return
+	}
+
+	var result os.Error
+	switch {
+	case err != nil:
+		result = err
+	case line == "OK\n":
+	case strings.HasPrefix(line, "ACK "):
+		result = this.parseError(strings.TrimSpace(line))
+	default:
+		return
+	}
+
+	name := this.pending
+	this.pending = ""
+	this.Observer.ObserveRequest(name, time.Nanoseconds()-this.started, result)
 	return
 }
 
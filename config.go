@@ -10,9 +10,17 @@ import (
 )
 
 type Config struct {
-	Address  string
+	Address  string // Host name, IP address or the path of a unix socket.
 	Port     int
 	Password string
+
+	// Path of a file to record the protocol session to. See Recorder.
+	// Every connection opened by Dial is recorded to a file of its own:
+	// the first to Transcript, later ones to Transcript.1, Transcript.2...
+	Transcript string
+
+	// Receives the outcome of each request on connections opened by Dial.
+	Observer RequestObserver
 }
 
 func NewConfig() *Config {
@@ -35,5 +43,9 @@ func NewConfig() *Config {
 		c.Password = v
 	}
 
+	if v := os.Getenv("MPD_TRANSCRIPT"); len(v) > 0 {
+		c.Transcript = v
+	}
+
 	return c
 }
@@ -0,0 +1,155 @@
+// Copyright (c) 2010, Jim Teeuwen. All rights reserved.
+// This code is subject to a 1-clause BSD license.
+// See the LICENSE file for its contents.
+
+package mpd
+
+import (
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// MPD lists the tracks of a CUE sheet as virtual songs below the sheet,
+// like 'live/concert.cue/track0003'. Sheets embedded in an audio file give
+// paths like 'live/concert.flac/track0003'. Both files are called the image
+// of the track here.
+
+// Returns the image and the index of a CUE track, counting from 1, if
+// @file is the virtual path of one.
+func ParseCuePath(file string) (image string, index int, ok bool) {
+	pos := strings.LastIndex(file, "/")
+	if pos < 1 || !strings.HasPrefix(file[pos+1:], "track") {
+		return "", 0, false
+	}
+
+	digits := file[pos+len("/track"):]
+	for i := 0; i < len(digits); i++ {
+		if digits[i] < '0' || digits[i] > '9' {
+			return "", 0, false
+		}
+	}
+
+	// Directories have no extension, which tells them apart from images.
+	if image = file[:pos]; len(path.Ext(image)) < 2 {
+		return "", 0, false
+	}
+
+	index, err := strconv.Atoi(digits)
+	if err != nil || index < 1 {
+		return "", 0, false
+	}
+	return image, index, true
+}
+
+func IsCueTrack(file string) bool {
+	_, _, ok := ParseCuePath(file)
+	return ok
+}
+
+// A track of a CUE image, with its place in the image. Times are in
+// nanoseconds and are -1 if they are not known.
+type CueTrack struct {
+	Song  Args
+	Index int
+	Start int64
+	End   int64
+}
+
+// Returns the length of the track, or -1 if it is not known.
+func (this *CueTrack) Duration() int64 {
+	if this.Start < 0 || this.End < 0 {
+		return -1
+	}
+	return this.End - this.Start
+}
+
+// The tracks of one image, ordered by their index.
+type CueImage struct {
+	Image  string
+	Tracks []*CueTrack
+}
+
+// Groups the CUE tracks among @songs by their image, in the order in which
+// the images first occur. Other songs are left out.
+//
+// A track starts where the one before it ends, so boundaries are only known
+// for the tracks which are preceded by all others, or whose song has the
+// Range field of newer servers.
+func GroupCueTracks(songs []Args) []*CueImage {
+	images := make([]*CueImage, 0)
+	byImage := make(map[string]*CueImage)
+
+	for _, song := range songs {
+		image, index, ok := ParseCuePath(song["file"])
+		if !ok {
+			continue
+		}
+
+		img, ok := byImage[image]
+		if !ok {
+			img = &CueImage{image, make([]*CueTrack, 0)}
+			byImage[image] = img
+			images = append(images, img)
+		}
+
+		img.Tracks = append(img.Tracks, &CueTrack{song, index, -1, -1})
+	}
+
+	for _, img := range images {
+		sort.Sort(cueTracks(img.Tracks))
+		img.bounds()
+	}
+	return images
+}
+
+// Sets the start and end of the tracks.
+func (this *CueImage) bounds() {
+	var prev *CueTrack
+
+	for _, t := range this.Tracks {
+		if start, end, ok := parseRange(t.Song["Range"]); ok {
+			t.Start, t.End = start, end
+		} else if t.Index == 1 {
+			t.Start = 0
+		} else if prev != nil && prev.Index == t.Index-1 {
+			t.Start = prev.End
+		}
+
+		if d := t.Song.Int64("Time", -1); t.End < 0 && t.Start >= 0 && d >= 0 {
+			t.End = t.Start + d*1e9
+		}
+		prev = t
+	}
+}
+
+// Parses a Range field, like '252.000-563.500'. The end is left out for
+// the last track.
+func parseRange(v string) (start, end int64, ok bool) {
+	pos := strings.Index(v, "-")
+	if pos == -1 {
+		return
+	}
+
+	s, err := strconv.Atof64(v[:pos])
+	if err != nil {
+		return
+	}
+
+	end = -1
+	if len(v) > pos+1 {
+		e, err := strconv.Atof64(v[pos+1:])
+		if err != nil {
+			return
+		}
+		end = int64(e * 1e9)
+	}
+	return int64(s * 1e9), end, true
+}
+
+type cueTracks []*CueTrack
+
+func (this cueTracks) Len() int           { return len(this) }
+func (this cueTracks) Less(i, j int) bool { return this[i].Index < this[j].Index }
+func (this cueTracks) Swap(i, j int)      { this[i], this[j] = this[j], this[i] }
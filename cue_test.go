@@ -0,0 +1,125 @@
+// Copyright (c) 2010, Jim Teeuwen. All rights reserved.
+// This code is subject to a 1-clause BSD license.
+// See the LICENSE file for its contents.
+
+package mpd
+
+import (
+	"bytes"
+	"testing"
+	"github.com/jteeuwen/go-pkg-mpd/mpdtest"
+)
+
+func TestParseCuePath(t *testing.T) {
+	tests := map[string]int{
+		"live/concert.cue/track0003":  3,
+		"live/concert.flac/track0012": 12,
+		"concert.cue/track1":          1,
+		"live/concert.cue/track0000":  0,
+		"live/concert.cue/track":      0,
+		"live/concert.cue/track03a":   0,
+		"live/concert.cue":            0,
+		"live/tracks/track0003":       0,
+		"/track0003":                  0,
+		"rock/acdc/hells_bells.mp3":   0,
+	}
+
+	for file, want := range tests {
+		_, index, ok := ParseCuePath(file)
+		if ok != (want > 0) || index != want {
+			t.Errorf("%s: Expected %d, got %d (%v).", file, want, index, ok)
+		}
+	}
+
+	if image, _, _ := ParseCuePath("live/concert.cue/track0003"); image != "live/concert.cue" {
+		t.Errorf("Unexpected image %s.", image)
+	}
+}
+
+func TestGroupCueTracks(t *testing.T) {
+	songs := []Args{
+		Args{"file": "a.cue/track0002", "Time": "200"},
+		Args{"file": "rock/acdc/hells_bells.mp3", "Time": "312"},
+		Args{"file": "b.flac/track0003", "Time": "60"},
+		Args{"file": "a.cue/track0001", "Time": "100"},
+		Args{"file": "b.flac/track0004", "Time": "60"},
+		Args{"file": "a.cue/track0003"},
+		Args{"file": "b.flac/track0002", "Range": "30.500-90.000"},
+	}
+
+	images := GroupCueTracks(songs)
+	if len(images) != 2 || images[0].Image != "a.cue" || images[1].Image != "b.flac" {
+		t.Fatalf("Unexpected images %v.", images)
+	}
+
+	type bounds struct {
+		index      int
+		start, end int64
+	}
+
+	want := [][]bounds{
+		[]bounds{bounds{1, 0, 100e9}, bounds{2, 100e9, 300e9}, bounds{3, 300e9, -1}},
+		[]bounds{bounds{2, 30.5e9, 90e9}, bounds{3, 90e9, 150e9}, bounds{4, 150e9, 210e9}},
+	}
+
+	for i, img := range images {
+		if len(img.Tracks) != len(want[i]) {
+			t.Errorf("%s: Expected %d tracks, got %d.", img.Image, len(want[i]), len(img.Tracks))
+			continue
+		}
+
+		for j, track := range img.Tracks {
+			w := want[i][j]
+			if track.Index != w.index || track.Start != w.start || track.End != w.end {
+				t.Errorf("%s: Expected %v, got %d %d-%d.", img.Image, w, track.Index, track.Start, track.End)
+			}
+		}
+	}
+
+	if d := images[0].Tracks[1].Duration(); d != 200e9 {
+		t.Errorf("Expected a duration of 200s, got %d.", d)
+	}
+
+	// Without the tracks before it, a track's place is not known.
+	images = GroupCueTracks([]Args{Args{"file": "a.cue/track0002", "Time": "200"}})
+	if track := images[0].Tracks[0]; track.Start != -1 || track.Duration() != -1 {
+		t.Errorf("Expected unknown bounds, got %d-%d.", track.Start, track.End)
+	}
+}
+
+func TestCueFormat(t *testing.T) {
+	srv, c := startServer(t)
+	defer srv.Close()
+	defer c.Close()
+
+	srv.AddSongs(
+		mpdtest.Song{"file": "live/concert.cue/track0002", "Artist": "Miles Davis", "Title": "Freddie Freeloader", "Time": "589"},
+		mpdtest.Song{"file": "live/concert.cue/track0003", "Time": "337"},
+	)
+	srv.Enqueue("live/concert.cue/track0002", "live/concert.cue/track0003")
+
+	tests := map[string][]string{
+		"[0:1] Miles Davis - 2. Freddie Freeloader (09:49)\n": []string{"plsearch", "title", "freddie"},
+		"[1:2] concert.cue track 3 (05:37)\n":                 []string{"plsearch", "file", "track0003"},
+	}
+
+	for want, args := range tests {
+		var buf bytes.Buffer
+		cmd := CreateCommand(args[0])
+		cmd.Output = &buf
+
+		if err := cmd.parse(args[1:]); err != nil {
+			t.Errorf("%v: parse: %v", args, err)
+			continue
+		}
+
+		if err := cmd.Execute(c); err != nil {
+			t.Errorf("%v: %v", args, err)
+			continue
+		}
+
+		if got := buf.String(); got != want {
+			t.Errorf("%v: Expected %q, got %q.", args, want, got)
+		}
+	}
+}
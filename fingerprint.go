@@ -0,0 +1,99 @@
+// Copyright (c) 2010, Jim Teeuwen. All rights reserved.
+// This code is subject to a 1-clause BSD license.
+// See the LICENSE file for its contents.
+
+package mpd
+
+import "os"
+
+// Outcome of a single fingerprint lookup performed by Fingerprints.
+type FingerprintResult struct {
+	File        string
+	Fingerprint string
+	Error       os.Error
+}
+
+// Fetches the fingerprints of all songs below @path, or the whole database if
+// @path is empty. Songs are spread over the connections in @pool, so at most
+// pool.Size() fingerprints are computed at the same time.
+//
+// A failed lookup does not stop the others. Its error is reported in the
+// result for that song. The returned error is only set if the song list
+// itself could not be retrieved.
+func Fingerprints(pool *Pool, path string) (results []*FingerprintResult, err os.Error) {
+	var c *Client
+	var files []string
+
+	if c, err = pool.Get(); err != nil {
+		return
+	}
+
+	files, err = c.ListAllFiles(path)
+	pool.Put(c)
+
+	if err != nil {
+		return
+	}
+
+	jobs := make(chan string, len(files))
+	for _, f := range files {
+		jobs <- f
+	}
+	close(jobs)
+
+	out := make(chan *FingerprintResult, len(files))
+	done := make(chan os.Error)
+
+	for i := 0; i < pool.Size(); i++ {
+		go fingerprintWorker(pool, jobs, out, done)
+	}
+
+	var werr os.Error
+	for i := 0; i < pool.Size(); i++ {
+		if e := <-done; e != nil {
+			werr = e
+		}
+	}
+
+	// Anything still queued could not be processed, because none of the
+	// workers managed to connect.
+	for f := range jobs {
+		out <- &FingerprintResult{f, "", werr}
+	}
+	close(out)
+
+	results = make([]*FingerprintResult, 0, len(files))
+	for r := range out {
+		results = append(results, r)
+	}
+	return
+}
+
+func fingerprintWorker(pool *Pool, jobs <-chan string, out chan<- *FingerprintResult, done chan<- os.Error) {
+	c, err := pool.Get()
+	if err != nil {
+		done <- err
+		return
+	}
+
+	for f := range jobs {
+		r := &FingerprintResult{File: f}
+		r.Fingerprint, r.Error = c.Fingerprint(f)
+		out <- r
+
+		// Every following song would fail the same way on a broken
+		// connection, so swap it for a new one.
+		if c.Failed() {
+			c.Close()
+			pool.Put(nil)
+
+			if c, err = pool.Get(); err != nil {
+				done <- err
+				return
+			}
+		}
+	}
+
+	pool.Put(c)
+	done <- nil
+}
@@ -0,0 +1,262 @@
+// Copyright (c) 2010, Jim Teeuwen. All rights reserved.
+// This code is subject to a 1-clause BSD license.
+// See the LICENSE file for its contents.
+
+package mpd
+
+import (
+	"fmt"
+	"io"
+	"json"
+	"os"
+	"strings"
+	"template"
+)
+
+// A Formatter renders the output of a command. Commands report their output
+// as a sequence of records: a song, an output device, the player status and
+// so on. Each record holds its fields in the order the server sent them, as
+// well as a human readable form for the text format.
+type Formatter interface {
+	Begin(w io.Writer) os.Error
+	Record(w io.Writer, fields []Pair, text string) os.Error
+	End(w io.Writer) os.Error
+}
+
+// Names of the built-in formats, as accepted by NewFormatter.
+var FormatList = []string{"text", "json", "jsonl", "tsv"}
+
+// Returns the formatter with the given name. Anything which is not the name
+// of a built-in format, but contains a '{', is used as a template. See
+// TemplateFormatter. Otherwise, anything which contains a '%' is used as a
+// song format. See SongFormat.
+func NewFormatter(name string) (Formatter, os.Error) {
+	switch name {
+	case "", "text":
+		return new(TextFormatter), nil
+	case "json":
+		return new(JSONFormatter), nil
+	case "jsonl":
+		return &JSONFormatter{Lines: true}, nil
+	case "tsv":
+		return new(TSVFormatter), nil
+	}
+
+	switch {
+	case strings.Index(name, "{") != -1:
+		return NewTemplateFormatter(name)
+	case strings.Index(name, "%") != -1:
+		return NewSongFormatter(name)
+	}
+
+	return nil, os.NewError(fmt.Sprintf("Unknown output format '%s'. Use one of %s, a template or a song format.",
+		name, strings.Join(FormatList, ", ")))
+}
+
+// Writes the human readable form of each record. Records without one are
+// written as 'key : value' lines.
+type TextFormatter struct{}
+
+func (this *TextFormatter) Begin(w io.Writer) os.Error { return nil }
+func (this *TextFormatter) End(w io.Writer) os.Error   { return nil }
+
+func (this *TextFormatter) Record(w io.Writer, fields []Pair, text string) (err os.Error) {
+	if len(text) > 0 {
+		_, err = fmt.Fprintln(w, text)
+		return
+	}
+
+	for _, f := range fields {
+		if _, err = fmt.Fprintf(w, "%s : %s\n", f.Key, f.Value); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// Writes records as JSON objects. Fields keep their order. Keys which occur
+// more than once in a record, like the suffixes of a decoder, become arrays.
+// By default, all records are written as a single array. With Lines set,
+// each record is written on a line of its own instead (JSON lines).
+type JSONFormatter struct {
+	Lines bool
+	count int
+}
+
+func (this *JSONFormatter) Begin(w io.Writer) (err os.Error) {
+	this.count = 0
+	if !this.Lines {
+		_, err = io.WriteString(w, "[")
+	}
+	return
+}
+
+func (this *JSONFormatter) Record(w io.Writer, fields []Pair, text string) (err os.Error) {
+	sep := ""
+	switch {
+	case this.Lines:
+	case this.count == 0:
+		sep = "\n"
+	default:
+		sep = ",\n"
+	}
+
+	this.count++
+
+	var data []byte
+	if data, err = jsonObject(fields); err != nil {
+		return
+	}
+
+	if this.Lines {
+		_, err = fmt.Fprintf(w, "%s\n", data)
+	} else {
+		_, err = fmt.Fprintf(w, "%s%s", sep, data)
+	}
+	return
+}
+
+func (this *JSONFormatter) End(w io.Writer) (err os.Error) {
+	if !this.Lines {
+		_, err = io.WriteString(w, "\n]\n")
+	}
+	return
+}
+
+func jsonObject(fields []Pair) ([]byte, os.Error) {
+	keys, values := groupFields(fields)
+	buf := []byte{'{'}
+
+	for i, k := range keys {
+		if i > 0 {
+			buf = append(buf, ',')
+		}
+
+		key, err := json.Marshal(k)
+		if err != nil {
+			return nil, err
+		}
+
+		var value []byte
+		if v := values[k]; len(v) == 1 {
+			value, err = json.Marshal(v[0])
+		} else {
+			value, err = json.Marshal(v)
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		buf = append(buf, key...)
+		buf = append(buf, ':')
+		buf = append(buf, value...)
+	}
+
+	return append(buf, '}'), nil
+}
+
+// Returns the distinct keys in @fields, in order of appearance, and all
+// values for each of them.
+func groupFields(fields []Pair) (keys []string, values map[string][]string) {
+	keys = make([]string, 0, len(fields))
+	values = make(map[string][]string)
+
+	for _, f := range fields {
+		if _, ok := values[f.Key]; !ok {
+			keys = append(keys, f.Key)
+		}
+		values[f.Key] = append(values[f.Key], f.Value)
+	}
+	return
+}
+
+// Writes records as tab separated values, with a header line. The columns
+// are taken from Columns or, if that is empty, from the first record. Fields
+// which are not a column are left out. Repeated keys are joined with commas.
+// Tabs and line breaks in values are replaced by spaces.
+type TSVFormatter struct {
+	Columns []string
+	header  bool
+}
+
+func (this *TSVFormatter) Begin(w io.Writer) os.Error {
+	this.header = false
+	return nil
+}
+
+func (this *TSVFormatter) Record(w io.Writer, fields []Pair, text string) (err os.Error) {
+	keys, values := groupFields(fields)
+
+	if !this.header {
+		if len(this.Columns) == 0 {
+			this.Columns = keys
+		}
+
+		if _, err = fmt.Fprintln(w, strings.Join(this.Columns, "\t")); err != nil {
+			return
+		}
+		this.header = true
+	}
+
+	row := make([]string, len(this.Columns))
+	for i, c := range this.Columns {
+		row[i] = tsvEscape(strings.Join(values[c], ","))
+	}
+
+	_, err = fmt.Fprintln(w, strings.Join(row, "\t"))
+	return
+}
+
+func (this *TSVFormatter) End(w io.Writer) os.Error { return nil }
+
+func tsvEscape(v string) string {
+	v = strings.Replace(v, "\t", " ", -1)
+	v = strings.Replace(v, "\r", " ", -1)
+	return strings.Replace(v, "\n", " ", -1)
+}
+
+// Writes each record through a template, followed by a line break. Fields
+// are referenced by key, as in '{Artist} - {Title}'. Only the first value of
+// a repeated key is available. Refer to the template package for the syntax.
+type TemplateFormatter struct {
+	tpl *template.Template
+}
+
+func NewTemplateFormatter(text string) (*TemplateFormatter, os.Error) {
+	tpl, err := template.Parse(text, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &TemplateFormatter{tpl}, nil
+}
+
+func (this *TemplateFormatter) Begin(w io.Writer) os.Error { return nil }
+func (this *TemplateFormatter) End(w io.Writer) os.Error   { return nil }
+
+func (this *TemplateFormatter) Record(w io.Writer, fields []Pair, text string) (err os.Error) {
+	data := make(map[string]string)
+	for _, f := range fields {
+		if _, ok := data[f.Key]; !ok {
+			data[f.Key] = f.Value
+		}
+	}
+
+	if err = this.tpl.Execute(w, data); err != nil {
+		return
+	}
+
+	_, err = io.WriteString(w, "\n")
+	return
+}
+
+// Keys which start the entries of list responses: songs, directories and
+// playlists, outputs, commands and URL handlers.
+var entryKeys = []string{"file", "directory", "playlist", "outputid", "command", "handler"}
+
+// Splits a list response into records, starting a new one at each of the
+// entryKeys. Other keys may repeat within a record, like a tag with several
+// values.
+func splitRecords(pairs []Pair) [][]Pair {
+	return splitEntries(pairs, entryKeys...)
+}
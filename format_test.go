@@ -0,0 +1,171 @@
+// Copyright (c) 2010, Jim Teeuwen. All rights reserved.
+// This code is subject to a 1-clause BSD license.
+// See the LICENSE file for its contents.
+
+package mpd
+
+import (
+	"bytes"
+	"json"
+	"strings"
+	"testing"
+)
+
+var testRecords = [][]Pair{
+	[]Pair{Pair{"file", "a.mp3"}, Pair{"Title", "Tab\there"}, Pair{"Time", "10"}},
+	[]Pair{Pair{"file", "b.mp3"}, Pair{"Time", "20"}, Pair{"Genre", "Rock"}, Pair{"Genre", "Pop"}},
+}
+
+func formatRecords(t *testing.T, f Formatter) string {
+	var buf bytes.Buffer
+
+	if err := f.Begin(&buf); err != nil {
+		t.Fatalf("Begin: %v", err)
+	}
+
+	for _, r := range testRecords {
+		if err := f.Record(&buf, r, "text of "+r[0].Value); err != nil {
+			t.Fatalf("Record: %v", err)
+		}
+	}
+
+	if err := f.End(&buf); err != nil {
+		t.Fatalf("End: %v", err)
+	}
+	return buf.String()
+}
+
+func TestFormatters(t *testing.T) {
+	tests := map[string]string{
+		"text": "text of a.mp3\ntext of b.mp3\n",
+		"json": "[\n" +
+			`{"file":"a.mp3","Title":"Tab\there","Time":"10"},` + "\n" +
+			`{"file":"b.mp3","Time":"20","Genre":["Rock","Pop"]}` + "\n]\n",
+		"jsonl": `{"file":"a.mp3","Title":"Tab\there","Time":"10"}` + "\n" +
+			`{"file":"b.mp3","Time":"20","Genre":["Rock","Pop"]}` + "\n",
+		"tsv":              "file\tTitle\tTime\na.mp3\tTab here\t10\nb.mp3\t\t20\n",
+		"{file} ({Time}s)": "a.mp3 (10s)\nb.mp3 (20s)\n",
+	}
+
+	for name, want := range tests {
+		f, err := NewFormatter(name)
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+
+		if got := formatRecords(t, f); got != want {
+			t.Errorf("%s: Expected %q, got %q.", name, want, got)
+		}
+	}
+
+	for _, name := range []string{"xml", "{unclosed"} {
+		if _, err := NewFormatter(name); err == nil {
+			t.Errorf("%s: Expected error.", name)
+		}
+	}
+}
+
+func TestTSVColumns(t *testing.T) {
+	f := &TSVFormatter{Columns: []string{"Genre", "file"}}
+	want := "Genre\tfile\n\ta.mp3\nRock,Pop\tb.mp3\n"
+
+	if got := formatRecords(t, f); got != want {
+		t.Errorf("Expected %q, got %q.", want, got)
+	}
+}
+
+func TestSplitRecords(t *testing.T) {
+	pairs := []Pair{
+		Pair{"outputid", "0"}, Pair{"outputname", "A"},
+		Pair{"outputid", "1"}, Pair{"outputname", "B"}, Pair{"outputenabled", "1"},
+	}
+
+	list := splitRecords(pairs)
+	if len(list) != 2 || len(list[0]) != 2 || len(list[1]) != 3 || list[1][1].Value != "B" {
+		t.Errorf("Unexpected records %v.", list)
+	}
+
+	// Tags with several values stay in one record, and directories are
+	// records of their own.
+	pairs = []Pair{
+		Pair{"directory", "jazz"}, Pair{"Last-Modified", "2010-11-01T10:00:00Z"},
+		Pair{"file", "jazz/a.flac"}, Pair{"Genre", "Jazz"}, Pair{"Genre", "Fusion"},
+		Pair{"file", "jazz/b.flac"},
+	}
+
+	list = splitRecords(pairs)
+	if len(list) != 3 || len(list[0]) != 2 || len(list[1]) != 3 || list[1][2].Value != "Fusion" {
+		t.Errorf("Unexpected records %v.", list)
+	}
+
+	if len(splitRecords(nil)) != 0 {
+		t.Errorf("Expected no records for an empty response.")
+	}
+}
+
+func TestCommandFormat(t *testing.T) {
+	srv, c := startServer(t)
+	defer srv.Close()
+	defer c.Close()
+
+	srv.Enqueue("rock/acdc/hells_bells.mp3", "jazz/davis/so_what.flac")
+
+	var buf bytes.Buffer
+	cmd := CreateCommand("plinfo")
+	cmd.Output = &buf
+
+	if err := cmd.parse([]string{"--json"}); err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+
+	if err := cmd.Execute(c); err != nil {
+		t.Fatalf("Execute: %v", err)
+	}
+
+	var songs []map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &songs); err != nil {
+		t.Fatalf("Invalid JSON %q: %v", buf.String(), err)
+	}
+
+	if len(songs) != 2 || songs[1]["Title"] != "So What" || songs[0]["Pos"] != "0" {
+		t.Errorf("Unexpected songs %v.", songs)
+	}
+
+	buf.Reset()
+	cmd = CreateCommand("plsearch")
+	cmd.Output = &buf
+
+	if err := cmd.parse([]string{"--format", "{Pos}:{Title}", "artist", "AC/DC"}); err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+
+	if err := cmd.Execute(c); err != nil {
+		t.Fatalf("Execute: %v", err)
+	}
+
+	if got := buf.String(); got != "0:Hells Bells\n" {
+		t.Errorf("Unexpected output %q.", got)
+	}
+
+	buf.Reset()
+	cmd = CreateCommand("simplestatus")
+	cmd.Output = &buf
+
+	if err := cmd.parse([]string{"--format=tsv"}); err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+
+	if err := cmd.Execute(c); err != nil {
+		t.Fatalf("Execute: %v", err)
+	}
+
+	lines := strings.Split(buf.String(), "\n", -1)
+	if len(lines) != 3 || lines[0] != "state\tvolume\trepeat\tsingle\trandom\tconsume" {
+		t.Errorf("Unexpected output %q.", buf.String())
+	}
+
+	if err := CreateCommand("status").parse([]string{"--format"}); err == nil {
+		t.Errorf("Expected error for --format without a value.")
+	}
+}
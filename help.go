@@ -0,0 +1,445 @@
+// Copyright (c) 2010, Jim Teeuwen. All rights reserved.
+// This code is subject to a 1-clause BSD license.
+// See the LICENSE file for its contents.
+
+package mpd
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+)
+
+func init() {
+	mustRegister(GroupSession,
+		&Command{
+			Name:    "help",
+			Desc:    "Describes @command, or lists all commands by group. With @doc, writes a man page, a Markdown reference or a completion script for bash, zsh or fish instead.",
+			Offline: true,
+			Params: []*Param{
+				newParam("command", "Name or alias of the command to describe.", TextKind, true),
+				newParam("doc", "Kind of document to write.", EnumKind("text", "man", "markdown", "bash", "zsh", "fish"), true).withDefault("text"),
+			},
+			Exec: help,
+		},
+	)
+}
+
+// Options which every command accepts. See Command.parse.
+var outputOptions = []string{"--json", "--jsonl", "--tsv", "--format="}
+
+// Name under which the program is invoked, for usage lines and completion
+// scripts.
+func programName() string {
+	return path.Base(os.Args[0])
+}
+
+// Writes the usage line, description and parameters of @cmd.
+func WriteUsage(w io.Writer, cmd *Command) (err os.Error) {
+	var buf bytes.Buffer
+
+	fmt.Fprintf(&buf, "Usage: %s\n", cmd)
+	if len(cmd.Aliases) > 0 {
+		fmt.Fprintf(&buf, "Aliases: %s\n", strings.Join(cmd.Aliases, ", "))
+	}
+
+	buf.WriteString("\n")
+	for _, line := range wrapText(cmd.Desc, 76) {
+		fmt.Fprintf(&buf, "  %s\n", line)
+	}
+
+	if len(cmd.Params) > 0 {
+		width := 0
+		for _, p := range cmd.Params {
+			if n := len(p.String()); n > width {
+				width = n
+			}
+		}
+
+		buf.WriteString("\nParameters:\n")
+		for _, p := range cmd.Params {
+			lines := wrapText(p.Desc, 72-width)
+			lines = append(lines, wrapText(paramDetails(p), 72-width)...)
+
+			for i, line := range lines {
+				name := ""
+				if i == 0 {
+					name = p.String()
+				}
+				fmt.Fprintf(&buf, "  %-*s  %s\n", width, name, line)
+			}
+		}
+	}
+
+	_, err = w.Write(buf.Bytes())
+	return
+}
+
+// Describes the values a parameter accepts and its default.
+func paramDetails(p *Param) string {
+	if p.Flag {
+		return "Flag: give --" + p.Name + " to turn it on."
+	}
+
+	s := "Accepts " + articled(p.Kind.String()) + "."
+	if len(p.Default) > 0 {
+		s += " Default: " + p.Default + "."
+	}
+	return s
+}
+
+func articled(s string) string {
+	switch {
+	case s == "text", strings.HasPrefix(s, "one of"), strings.HasPrefix(s, "on or"):
+		return s
+	case strings.Index("aeiou", s[:1]) != -1:
+		return "an " + s
+	}
+	return "a " + s
+}
+
+// Lists all registered commands by group, with the first sentence of their
+// description.
+func WriteOverview(w io.Writer) (err os.Error) {
+	var buf bytes.Buffer
+
+	width := 0
+	for _, name := range CommandList() {
+		if len(name) > width {
+			width = len(name)
+		}
+	}
+
+	for i, g := range CommandGroups() {
+		if i > 0 {
+			buf.WriteString("\n")
+		}
+
+		fmt.Fprintf(&buf, "%s:\n", g)
+		for _, name := range GroupCommands(g) {
+			fmt.Fprintf(&buf, "  %-*s  %s\n", width, name, summary(registry[name].Desc))
+		}
+	}
+
+	_, err = w.Write(buf.Bytes())
+	return
+}
+
+// Returns the first sentence of @desc.
+func summary(desc string) string {
+	if pos := strings.Index(desc, ". "); pos != -1 {
+		return desc[:pos+1]
+	}
+	return desc
+}
+
+// Splits @text into lines of at most @width characters, at word boundaries.
+// Longer words get a line of their own.
+func wrapText(text string, width int) []string {
+	lines := make([]string, 0)
+	line := ""
+
+	for _, word := range strings.Fields(text) {
+		switch {
+		case len(line) == 0:
+			line = word
+		case len(line)+1+len(word) > width:
+			lines = append(lines, line)
+			line = word
+		default:
+			line += " " + word
+		}
+	}
+
+	if len(line) > 0 {
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+/* Reference documents */
+
+// Writes a man page (roff) which describes all registered commands. @prog
+// is the name of the program which runs them.
+func WriteManPage(w io.Writer, prog string) (err os.Error) {
+	var buf bytes.Buffer
+
+	fmt.Fprintf(&buf, ".TH %s 1\n", strings.ToUpper(roffEscape(prog)))
+	fmt.Fprintf(&buf, ".SH NAME\n%s \\- control the Music Player Daemon\n", roffEscape(prog))
+	fmt.Fprintf(&buf, ".SH SYNOPSIS\n.B %s\n.I command\n[\\fIparameters\\fR] [\\fB\\-\\-json\\fR|\\fB\\-\\-jsonl\\fR|\\fB\\-\\-tsv\\fR|\\fB\\-\\-format\\fR=\\fIformat\\fR]\n", roffEscape(prog))
+	buf.WriteString(".SH DESCRIPTION\nParameters are given in order, or by name as \\fB\\-\\-name\\fR=\\fIvalue\\fR.\n")
+	buf.WriteString("Every value is checked before anything is sent to the server.\n")
+	buf.WriteString(".SH COMMANDS\n")
+
+	for _, g := range CommandGroups() {
+		fmt.Fprintf(&buf, ".SS %s\n", roffEscape(g))
+
+		for _, name := range GroupCommands(g) {
+			cmd := CreateCommand(name)
+			fmt.Fprintf(&buf, ".TP\n.B %s\n", roffEscape(cmd.String()))
+
+			buf.WriteString(roffText(cmd.Desc))
+			if len(cmd.Aliases) > 0 {
+				buf.WriteString(roffText("Aliases: " + strings.Join(cmd.Aliases, ", ") + "."))
+			}
+
+			if len(cmd.Params) == 0 {
+				continue
+			}
+
+			buf.WriteString(".RS\n")
+			for _, p := range cmd.Params {
+				fmt.Fprintf(&buf, ".TP\n.I %s\n", roffEscape(p.Name))
+				buf.WriteString(roffText(p.Desc + " " + paramDetails(p)))
+			}
+			buf.WriteString(".RE\n")
+		}
+	}
+
+	buf.WriteString(".SH OUTPUT FORMATS\n")
+	buf.WriteString(roffText("Every command accepts --json, --jsonl, --tsv or --format=<name or template> to select its output format. The default is human readable text."))
+
+	_, err = w.Write(buf.Bytes())
+	return
+}
+
+func roffEscape(s string) string {
+	s = strings.Replace(s, `\`, `\e`, -1)
+	return strings.Replace(s, "-", `\-`, -1)
+}
+
+// Escapes a paragraph of text. Lines must not start with a control
+// character.
+func roffText(s string) string {
+	s = roffEscape(s)
+	if strings.HasPrefix(s, ".") || strings.HasPrefix(s, "'") {
+		s = `\&` + s
+	}
+	return s + "\n"
+}
+
+// Writes a Markdown reference of all registered commands.
+func WriteMarkdown(w io.Writer, prog string) (err os.Error) {
+	var buf bytes.Buffer
+
+	fmt.Fprintf(&buf, "# %s command reference\n\n", prog)
+	fmt.Fprintf(&buf, "Usage: `%s <command> [parameters]`. Parameters are given in order, "+
+		"or by name as `--name=value`. Every command accepts `--json`, `--jsonl`, `--tsv` "+
+		"or `--format=<name or template>` to select its output format.\n", prog)
+
+	for _, g := range CommandGroups() {
+		fmt.Fprintf(&buf, "\n## %s\n", g)
+
+		for _, name := range GroupCommands(g) {
+			cmd := CreateCommand(name)
+			fmt.Fprintf(&buf, "\n### %s\n\n`%s`\n\n%s\n", cmd.Name, cmd, cmd.Desc)
+
+			if len(cmd.Aliases) > 0 {
+				fmt.Fprintf(&buf, "\nAliases: %s\n", strings.Join(cmd.Aliases, ", "))
+			}
+
+			if len(cmd.Params) == 0 {
+				continue
+			}
+
+			buf.WriteString("\n| Parameter | Accepts | Default | Description |\n")
+			buf.WriteString("|-----------|---------|---------|-------------|\n")
+
+			for _, p := range cmd.Params {
+				accepts := p.Kind.String()
+				if p.Flag {
+					accepts = "flag"
+				}
+
+				fmt.Fprintf(&buf, "| `%s` | %s | %s | %s |\n", p.Name,
+					markdownCell(accepts), markdownCell(p.Default), markdownCell(p.Desc))
+			}
+		}
+	}
+
+	_, err = w.Write(buf.Bytes())
+	return
+}
+
+func markdownCell(s string) string {
+	return strings.Replace(s, "|", `\|`, -1)
+}
+
+/* Completion scripts */
+
+// Writes a completion script for @shell, which is one of bash, zsh or
+// fish. The script completes command names, parameter names and the values
+// of parameters with a fixed set of them.
+func WriteCompletion(w io.Writer, shell, prog string) (err os.Error) {
+	var buf bytes.Buffer
+
+	switch shell {
+	case "bash":
+		bashCompletion(&buf, prog)
+	case "zsh":
+		zshCompletion(&buf, prog)
+	case "fish":
+		fishCompletion(&buf, prog)
+	default:
+		return os.NewError(fmt.Sprintf("Unsupported shell '%s'. Use bash, zsh or fish.", shell))
+	}
+
+	_, err = w.Write(buf.Bytes())
+	return
+}
+
+// Returns the words which may follow @cmd on the command line.
+func completionWords(cmd *Command) []string {
+	words := make([]string, 0)
+
+	for _, p := range cmd.Params {
+		if p.Flag {
+			words = append(words, "--"+p.Name)
+		} else {
+			words = append(words, "--"+p.Name+"=")
+		}
+
+		if c, ok := p.Kind.(Chooser); ok && !p.Flag {
+			words = append(words, c.Choices()...)
+		}
+	}
+	return append(words, outputOptions...)
+}
+
+// Returns all command names, including aliases.
+func completionNames() []string {
+	names := CommandList()
+	for alias := range registryAliases {
+		names = append(names, alias)
+	}
+	return names
+}
+
+// Quotes @s for use in a shell script, within single quotes.
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
+
+func bashCompletion(buf *bytes.Buffer, prog string) {
+	fn := "_" + strings.Replace(prog, "-", "_", -1)
+
+	fmt.Fprintf(buf, "# bash completion for %s\n", prog)
+	fmt.Fprintf(buf, "%s() {\n", fn)
+	buf.WriteString("\tlocal cur=${COMP_WORDS[COMP_CWORD]}\n\n")
+	buf.WriteString("\tif [ $COMP_CWORD -eq 1 ]; then\n")
+	fmt.Fprintf(buf, "\t\tCOMPREPLY=($(compgen -W %s -- \"$cur\"))\n", shellQuote(strings.Join(completionNames(), " ")))
+	buf.WriteString("\t\treturn\n\tfi\n\n")
+	buf.WriteString("\tcase ${COMP_WORDS[1]} in\n")
+
+	for _, name := range completionNames() {
+		words := completionWords(CreateCommand(name))
+		fmt.Fprintf(buf, "\t%s) COMPREPLY=($(compgen -W %s -- \"$cur\")) ;;\n", name, shellQuote(strings.Join(words, " ")))
+	}
+
+	buf.WriteString("\tesac\n}\n")
+	fmt.Fprintf(buf, "complete -o nospace -F %s %s\n", fn, prog)
+}
+
+func zshCompletion(buf *bytes.Buffer, prog string) {
+	fn := "_" + strings.Replace(prog, "-", "_", -1)
+
+	fmt.Fprintf(buf, "#compdef %s\n\n", prog)
+	fmt.Fprintf(buf, "%s() {\n", fn)
+	buf.WriteString("\tlocal -a cmds\n\tcmds=(\n")
+
+	for _, name := range completionNames() {
+		cmd := CreateCommand(name)
+		fmt.Fprintf(buf, "\t\t%s\n", shellQuote(name+":"+summary(cmd.Desc)))
+	}
+
+	buf.WriteString("\t)\n\n")
+	buf.WriteString("\tif (( CURRENT == 2 )); then\n\t\t_describe 'command' cmds\n\t\treturn\n\tfi\n\n")
+	buf.WriteString("\tcase $words[2] in\n")
+
+	for _, name := range completionNames() {
+		words := completionWords(CreateCommand(name))
+		for i, w := range words {
+			words[i] = shellQuote(w)
+		}
+		fmt.Fprintf(buf, "\t%s) compadd -S '' -- %s ;;\n", name, strings.Join(words, " "))
+	}
+
+	buf.WriteString("\tesac\n}\n\n")
+	fmt.Fprintf(buf, "%s \"$@\"\n", fn)
+}
+
+func fishCompletion(buf *bytes.Buffer, prog string) {
+	fmt.Fprintf(buf, "# fish completion for %s\n", prog)
+	fmt.Fprintf(buf, "complete -c %s -f\n", prog)
+
+	for _, name := range completionNames() {
+		cmd := CreateCommand(name)
+		fmt.Fprintf(buf, "complete -c %s -n __fish_use_subcommand -a %s -d %s\n",
+			prog, name, shellQuote(summary(cmd.Desc)))
+	}
+
+	for _, name := range completionNames() {
+		cmd := CreateCommand(name)
+		cond := shellQuote("__fish_seen_subcommand_from " + name)
+
+		for _, p := range cmd.Params {
+			opt := ""
+			if !p.Flag {
+				opt = " -r"
+			}
+			fmt.Fprintf(buf, "complete -c %s -n %s -l %s%s -d %s\n", prog, cond, p.Name, opt, shellQuote(summary(p.Desc)))
+
+			if c, ok := p.Kind.(Chooser); ok && !p.Flag {
+				fmt.Fprintf(buf, "complete -c %s -n %s -a %s\n", prog, cond, shellQuote(strings.Join(c.Choices(), " ")))
+			}
+		}
+
+		for _, opt := range []string{"json", "jsonl", "tsv"} {
+			fmt.Fprintf(buf, "complete -c %s -n %s -l %s\n", prog, cond, opt)
+		}
+		fmt.Fprintf(buf, "complete -c %s -n %s -l format -r\n", prog, cond)
+	}
+}
+
+func help(cmd *Command, c *Client) (err os.Error) {
+	prog := programName()
+	out := cmd.Output
+	doc := cmd.S("doc", "text")
+
+	// Help is prose, which has no place in the other output formats.
+	if _, ok := cmd.Format.(*TextFormatter); !ok {
+		return &UsageError{"The help command only writes text."}
+	}
+
+	if name := cmd.S("command", ""); len(name) > 0 {
+		if doc != "text" {
+			return &UsageError{"Parameter @doc can not be combined with @command."}
+		}
+
+		target := CreateCommand(name)
+		if target == nil {
+			return os.NewError(fmt.Sprintf("Unknown command '%s'.", name))
+		}
+		return WriteUsage(out, target)
+	}
+
+	switch doc {
+	case "man":
+		return WriteManPage(out, prog)
+	case "markdown":
+		return WriteMarkdown(out, prog)
+	case "bash", "zsh", "fish":
+		return WriteCompletion(out, doc, prog)
+	}
+
+	fmt.Fprintf(out, "Usage: %s <command> [parameters]\n\n", prog)
+	if err = WriteOverview(out); err != nil {
+		return
+	}
+
+	_, err = fmt.Fprintf(out, "\nRun '%s help <command>' for details on a command.\n", prog)
+	return
+}
@@ -0,0 +1,131 @@
+// Copyright (c) 2010, Jim Teeuwen. All rights reserved.
+// This code is subject to a 1-clause BSD license.
+// See the LICENSE file for its contents.
+
+package mpd
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func expectContains(t *testing.T, name, text string, want ...string) {
+	for _, w := range want {
+		if strings.Index(text, w) == -1 {
+			t.Errorf("%s: Expected output to contain %q:\n%s", name, w, text)
+		}
+	}
+}
+
+func TestWriteUsage(t *testing.T) {
+	var buf bytes.Buffer
+
+	if err := WriteUsage(&buf, CreateCommand("vol")); err != nil {
+		t.Fatalf("WriteUsage: %v", err)
+	}
+
+	expectContains(t, "volume", buf.String(),
+		"Usage: volume <value> [<sign>]\nAliases: vol\n",
+		"  <value>   New volume value.",
+		"Accepts an integer from 0 to 100",
+		"Accepts one of +, -.")
+
+	for _, line := range strings.Split(buf.String(), "\n", -1) {
+		if len(line) > 80 {
+			t.Errorf("Line exceeds 80 characters: %q", line)
+		}
+	}
+
+	buf.Reset()
+	WriteUsage(&buf, CreateCommand("update"))
+	expectContains(t, "update", buf.String(), "[--wait]", "Flag: give --wait to turn it on.")
+
+	buf.Reset()
+	WriteUsage(&buf, CreateCommand("tagtypes"))
+	expectContains(t, "tagtypes", buf.String(), "Default: list.")
+}
+
+func TestWriteOverview(t *testing.T) {
+	var buf bytes.Buffer
+
+	if err := WriteOverview(&buf); err != nil {
+		t.Fatalf("WriteOverview: %v", err)
+	}
+
+	expectContains(t, "overview", buf.String(),
+		"playback:\n", "database:\n", "  seekcur ", "Stop the playback.\n")
+}
+
+func TestReferenceDocs(t *testing.T) {
+	var buf bytes.Buffer
+
+	if err := WriteManPage(&buf, "mpd"); err != nil {
+		t.Fatalf("WriteManPage: %v", err)
+	}
+
+	if !strings.HasPrefix(buf.String(), ".TH MPD 1\n") {
+		t.Errorf("Unexpected man page header %q.", buf.String()[:20])
+	}
+	expectContains(t, "man", buf.String(), ".SS playback\n", ".B seekcur <time>\n", `\-\-json`)
+
+	buf.Reset()
+	if err := WriteMarkdown(&buf, "mpd"); err != nil {
+		t.Fatalf("WriteMarkdown: %v", err)
+	}
+	expectContains(t, "markdown", buf.String(), "## playlist\n", "### delete\n",
+		"| `pos` | position or range START:END |  | Position")
+}
+
+func TestCompletion(t *testing.T) {
+	tests := map[string][]string{
+		"bash": []string{"complete -o nospace -F _mpd mpd", "random) COMPREPLY=($(compgen -W '--toggle= on off"},
+		"zsh":  []string{"#compdef mpd", "'seekcur:Skip to specific point in time in the current song.'"},
+		"fish": []string{"-a seekcur", "__fish_seen_subcommand_from update' -l wait -d"},
+	}
+
+	for shell, want := range tests {
+		var buf bytes.Buffer
+		if err := WriteCompletion(&buf, shell, "mpd"); err != nil {
+			t.Errorf("%s: %v", shell, err)
+			continue
+		}
+		expectContains(t, shell, buf.String(), want...)
+	}
+
+	if err := WriteCompletion(new(bytes.Buffer), "csh", "mpd"); err == nil {
+		t.Errorf("Expected error for unsupported shell.")
+	}
+}
+
+func TestHelpCommand(t *testing.T) {
+	var buf bytes.Buffer
+
+	cmd := CreateCommand("help")
+	cmd.Output = &buf
+
+	if err := cmd.parse([]string{"seek"}); err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+
+	// Help does not need a connection.
+	if err := cmd.Execute(nil); err != nil {
+		t.Fatalf("Execute: %v", err)
+	}
+	expectContains(t, "help seek", buf.String(), "Usage: seek <pos> <time>")
+
+	cmd = CreateCommand("help")
+	if cmd.parse([]string{"bogus"}) != nil || cmd.Execute(nil) == nil {
+		t.Errorf("Expected error for unknown command.")
+	}
+
+	cmd = CreateCommand("help")
+	cmd.Output = new(bytes.Buffer)
+	if err := cmd.parse([]string{"--json"}); err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+
+	if _, ok := cmd.Execute(nil).(*UsageError); !ok {
+		t.Errorf("Expected a usage error for help --json.")
+	}
+}
@@ -0,0 +1,519 @@
+// Copyright (c) 2010, Jim Teeuwen. All rights reserved.
+// This code is subject to a 1-clause BSD license.
+// See the LICENSE file for its contents.
+
+package mpd
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"json"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+func init() {
+	mustRegister(GroupSession,
+		&Command{
+			Name: "logplays",
+			Desc: "Follows the player and appends every song which is played to the history @file, until the program is stopped. The song which is playing then is recorded as well. See the plays command.",
+			Params: []*Param{
+				newParam("file", "The history file. Defaults to ~/.mpd_play_history.", TextKind, true),
+			},
+			Exec: logplays,
+		},
+		&Command{
+			Name: "plays",
+			Desc: "Reports from the history written by logplays: the songs played last, the most played values of @tag, or the values of @tag which are skipped most. Counts are taken over @period.",
+			Params: []*Param{
+				newParam("view", "What to report.", EnumKind("last", "top", "skips"), true).withDefault("last"),
+				newParam("count", "The number of results.", IntKind(1, math.MaxInt32), true).withDefault("10"),
+				newParam("tag", "The tag to count by, or file.", TextKind, true).withDefault("Artist"),
+				newParam("period", "The period to count over. month and week start at the first day of the current month or week.", EnumKind("all", "month", "week", "today"), true).withDefault("month"),
+				newParam("file", "The history file. Defaults to ~/.mpd_play_history.", TextKind, true),
+			},
+			Exec:    plays,
+			Offline: true,
+		},
+	)
+}
+
+// A song which was played, as kept in the history.
+type HistoryEntry struct {
+	Time     int64             // Unix time at which the song started.
+	File     string            // Path of the song in the library.
+	Tags     map[string]string // Artist, Title, Album and so on.
+	Duration int               // Length of the song in seconds, or 0 if unknown.
+	Played   int               // Seconds the song played, not counting pauses.
+	Skipped  bool              // The song ended before it was played to the end.
+}
+
+// Returns the value of the tag @name, or the file for "file".
+func (this *HistoryEntry) Tag(name string) string {
+	if name == "file" {
+		return this.File
+	}
+	return this.Tags[name]
+}
+
+// The play history: a file with one JSON encoded HistoryEntry per line.
+// Entries are only ever appended, so the file can be read by other
+// programs, or rotated, while it is written.
+type History struct {
+	File string
+	lock sync.Mutex
+}
+
+func NewHistory(file string) *History {
+	h := new(History)
+	h.File = file
+	return h
+}
+
+// Appends an entry to the history file, creating it if necessary. If the
+// last line was cut off, the entry starts on a new line.
+func (this *History) Append(e *HistoryEntry) (err os.Error) {
+	var data []byte
+	if data, err = json.Marshal(e); err != nil {
+		return
+	}
+
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	var fd *os.File
+	if fd, err = os.Open(this.File, os.O_RDWR|os.O_CREAT|os.O_APPEND, 0600); err != nil {
+		return
+	}
+
+	defer fd.Close()
+
+	var fi *os.FileInfo
+	if fi, err = fd.Stat(); err != nil {
+		return
+	}
+
+	if fi.Size > 0 {
+		last := make([]byte, 1)
+		if _, err = fd.ReadAt(last, fi.Size-1); err != nil {
+			return
+		}
+
+		if last[0] != '\n' {
+			data = append([]byte{'\n'}, data...)
+		}
+	}
+
+	_, err = fd.Write(append(data, '\n'))
+	return
+}
+
+// Calls @f for each entry which started at or after @since, a unix time,
+// oldest first. Lines which can not be read, like one which was cut off
+// while it was written, are skipped. A history file which does not exist
+// yet is empty.
+func (this *History) Each(since int64, f func(e *HistoryEntry)) (err os.Error) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	var fd *os.File
+	if fd, err = os.Open(this.File, os.O_RDONLY, 0); err != nil {
+		if _, e := os.Stat(this.File); e != nil {
+			return nil
+		}
+		return
+	}
+
+	defer fd.Close()
+	reader := bufio.NewReader(fd)
+
+	for {
+		var line string
+		if line, err = reader.ReadString('\n'); err != nil && err != os.EOF {
+			return
+		}
+
+		e := new(HistoryEntry)
+		if json.Unmarshal([]byte(line), e) == nil && e.Time >= since {
+			f(e)
+		}
+
+		if err == os.EOF {
+			return nil
+		}
+	}
+	return
+}
+
+// Returns the entries which started at or after @since, oldest first.
+func (this *History) Entries(since int64) (list []*HistoryEntry, err os.Error) {
+	list = make([]*HistoryEntry, 0)
+	err = this.Each(since, func(e *HistoryEntry) {
+		list = append(list, e)
+	})
+	return
+}
+
+// Returns the last @n songs which were played, most recent first.
+func (this *History) LastPlayed(n int) (list []*HistoryEntry, err os.Error) {
+	if list, err = this.Entries(0); err != nil {
+		return
+	}
+
+	if len(list) > n {
+		list = list[len(list)-n:]
+	}
+
+	for i, j := 0, len(list)-1; i < j; i, j = i+1, j-1 {
+		list[i], list[j] = list[j], list[i]
+	}
+	return
+}
+
+// The number of times songs with a given tag value were played, and how
+// many of those were skipped.
+type HistoryCount struct {
+	Name  string
+	Plays int
+	Skips int
+}
+
+// Returns the fraction of plays which were skipped.
+func (this *HistoryCount) SkipRatio() float64 {
+	if this.Plays == 0 {
+		return 0
+	}
+	return float64(this.Skips) / float64(this.Plays)
+}
+
+// Returns the @n values of @tag (or "file") which were played to the end
+// most often since @since, most played first.
+func (this *History) Top(tag string, since int64, n int) (list []*HistoryCount, err os.Error) {
+	if list, err = this.count(tag, since); err != nil {
+		return
+	}
+
+	sort.Sort(&historyCounts{list, func(a, b *HistoryCount) bool {
+		return a.Plays-a.Skips > b.Plays-b.Skips
+	}})
+
+	if len(list) > n {
+		list = list[:n]
+	}
+	return
+}
+
+// Returns the @n artists which were played to the end most often since
+// @since. Use MonthStart for this month's top artists.
+func (this *History) TopArtists(since int64, n int) ([]*HistoryCount, os.Error) {
+	return this.Top("Artist", since, n)
+}
+
+// Returns the values of @tag (or "file") which were skipped since @since,
+// with the highest skip ratio first. Values played fewer than @minPlays
+// times are left out, since a single skip says little.
+func (this *History) SkipRatios(tag string, since int64, minPlays int) (list []*HistoryCount, err os.Error) {
+	var all []*HistoryCount
+	if all, err = this.count(tag, since); err != nil {
+		return
+	}
+
+	list = make([]*HistoryCount, 0)
+	for _, c := range all {
+		if c.Skips > 0 && c.Plays >= minPlays {
+			list = append(list, c)
+		}
+	}
+
+	sort.Sort(&historyCounts{list, func(a, b *HistoryCount) bool {
+		return a.SkipRatio() > b.SkipRatio()
+	}})
+	return
+}
+
+// Counts the plays and skips per value of @tag. Entries without the tag
+// are not counted.
+func (this *History) count(tag string, since int64) (list []*HistoryCount, err os.Error) {
+	counts := make(map[string]*HistoryCount)
+	list = make([]*HistoryCount, 0)
+
+	err = this.Each(since, func(e *HistoryEntry) {
+		name := e.Tag(tag)
+		if len(name) == 0 {
+			return
+		}
+
+		c, ok := counts[name]
+		if !ok {
+			c = &HistoryCount{Name: name}
+			counts[name] = c
+			list = append(list, c)
+		}
+
+		c.Plays++
+		if e.Skipped {
+			c.Skips++
+		}
+	})
+	return
+}
+
+// Sorts counts with @less, by name when they are equal.
+type historyCounts struct {
+	list []*HistoryCount
+	less func(a, b *HistoryCount) bool
+}
+
+func (this *historyCounts) Len() int      { return len(this.list) }
+func (this *historyCounts) Swap(i, j int) { this.list[i], this.list[j] = this.list[j], this.list[i] }
+
+func (this *historyCounts) Less(i, j int) bool {
+	a, b := this.list[i], this.list[j]
+	switch {
+	case this.less(a, b):
+		return true
+	case this.less(b, a):
+		return false
+	}
+	return a.Name < b.Name
+}
+
+// Returns the unix time at which the current day started, in local time.
+func DayStart() int64 {
+	t := time.LocalTime()
+	t.Hour, t.Minute, t.Second = 0, 0, 0
+	return t.Seconds()
+}
+
+// Returns the unix time at which the current week started, on Monday.
+func WeekStart() int64 {
+	days := (time.LocalTime().Weekday + 6) % 7
+	return DayStart() - int64(days)*24*60*60
+}
+
+// Returns the unix time at which the current month started.
+func MonthStart() int64 {
+	t := time.LocalTime()
+	t.Day, t.Hour, t.Minute, t.Second = 1, 0, 0, 0
+	return t.Seconds()
+}
+
+// A song counts as played to the end if it stopped at most this many
+// seconds before its end. This allows for crossfading and for the time it
+// takes to notice that the song changed.
+const historyEndMargin = 10
+
+// Fields of a song which are not kept as tags in the history.
+var historyIgnored = map[string]bool{
+	"file": true, "Id": true, "Pos": true, "Time": true, "duration": true,
+	"Last-Modified": true, "Prio": true, "Range": true, "Format": true,
+}
+
+// HistoryRecorder follows the player and appends each song which is played
+// to a History. Songs which were never playing, like those skipped while
+// paused, are not recorded.
+type HistoryRecorder struct {
+	History *History
+	Log     io.Writer // Receives errors writing the history. Defaults to standard error.
+
+	entry    *HistoryEntry // The current song, or nil.
+	songId   string
+	position int64 // Position in the current song at the last observation, in nanoseconds.
+	played   int64 // Nanoseconds the current song has played.
+	since    int64 // Time of the last observation.
+	playing  bool
+	now      func() int64
+}
+
+func NewHistoryRecorder(h *History) *HistoryRecorder {
+	r := new(HistoryRecorder)
+	r.History = h
+	r.now = time.Nanoseconds
+	return r
+}
+
+// Follows the player over @c until @stop is closed.
+func (this *HistoryRecorder) Run(c *Client, stop <-chan bool) os.Error {
+	if this.Log == nil {
+		this.Log = os.Stderr
+	}
+	return c.followPlayer(stop, func(song []Pair, status *Status) {
+		this.observe(song, status)
+	})
+}
+
+// Updates the play time and position of the current song, given the
+// player's state, and records the previous song when it changes.
+func (this *HistoryRecorder) observe(song []Pair, status *Status) {
+	now := this.now()
+	if this.playing {
+		this.played += now - this.since
+		this.position += now - this.since
+	}
+	this.since = now
+	this.playing = status.State == "play"
+
+	a := pairArgs(song)
+	id := a["Id"] + ":" + a["file"]
+
+	if status.State == "stop" || id != this.songId || restarted(this.position, status) {
+		this.finish()
+		this.songId = ""
+	}
+
+	if status.State == "stop" || len(song) == 0 {
+		return
+	}
+
+	// Seeking changes the position, but not the time played.
+	this.position = status.Elapsed
+	if id == this.songId {
+		return
+	}
+
+	this.songId = id
+	this.played = 0
+
+	e := new(HistoryEntry)
+	e.Time = now / 1e9
+	e.File = a["file"]
+	e.Duration, _ = strconv.Atoi(a["Time"])
+	e.Tags = make(map[string]string)
+
+	for _, p := range song {
+		if _, ok := e.Tags[p.Key]; !ok && !historyIgnored[p.Key] {
+			e.Tags[p.Key] = p.Value
+		}
+	}
+	this.entry = e
+}
+
+// Records the current song, if it played at all.
+func (this *HistoryRecorder) finish() {
+	e := this.entry
+	if e == nil {
+		return
+	}
+
+	this.entry = nil
+	if this.played <= 0 {
+		return
+	}
+
+	e.Played = int(this.played / 1e9)
+	e.Skipped = e.Duration > 0 && this.position < int64(e.Duration-historyEndMargin)*1e9
+
+	if err := this.History.Append(e); err != nil && this.Log != nil {
+		fmt.Fprintf(this.Log, "Can not write history: %v\n", err)
+	}
+}
+
+// Returns the history file given to @cmd, or the default one.
+func historyFile(cmd *Command) string {
+	file := cmd.S("file", "")
+	if home := os.Getenv("HOME"); len(file) == 0 && len(home) > 0 {
+		file = home + "/.mpd_play_history"
+	}
+	return file
+}
+
+func logplays(cmd *Command, c *Client) os.Error {
+	r := NewHistoryRecorder(NewHistory(historyFile(cmd)))
+
+	stop, release := untilInterrupted()
+	defer release()
+	return r.Run(c, stop)
+}
+
+func plays(cmd *Command, c *Client) (err os.Error) {
+	h := NewHistory(historyFile(cmd))
+	n := cmd.I("count", 10)
+	tag := cmd.S("tag", "Artist")
+
+	var since int64
+	switch cmd.S("period", "month") {
+	case "today":
+		since = DayStart()
+	case "week":
+		since = WeekStart()
+	case "month":
+		since = MonthStart()
+	}
+
+	var counts []*HistoryCount
+
+	switch cmd.S("view", "last") {
+	case "last":
+		var list []*HistoryEntry
+		if list, err = h.LastPlayed(n); err != nil {
+			return
+		}
+
+		for _, e := range list {
+			if err = playsRecord(cmd, e); err != nil {
+				return
+			}
+		}
+		return
+
+	case "top":
+		if counts, err = h.Top(tag, since, n); err != nil {
+			return
+		}
+
+	case "skips":
+		if counts, err = h.SkipRatios(tag, since, 1); err != nil {
+			return
+		}
+
+		if len(counts) > n {
+			counts = counts[:n]
+		}
+	}
+
+	for _, c := range counts {
+		fields := []Pair{
+			Pair{strings.ToLower(tag), c.Name},
+			Pair{"plays", strconv.Itoa(c.Plays)},
+			Pair{"skips", strconv.Itoa(c.Skips)},
+		}
+
+		text := fmt.Sprintf("%4d plays %4d skips  %s", c.Plays, c.Skips, c.Name)
+		if err = cmd.Record(fields, text); err != nil {
+			return
+		}
+	}
+	return
+}
+
+func playsRecord(cmd *Command, e *HistoryEntry) os.Error {
+	t := time.SecondsToLocalTime(e.Time)
+
+	fields := []Pair{
+		Pair{"time", t.Format(time.RFC3339)},
+		Pair{"file", e.File},
+		Pair{"Artist", e.Tags["Artist"]},
+		Pair{"Title", e.Tags["Title"]},
+		Pair{"played", strconv.Itoa(e.Played)},
+		Pair{"skipped", strconv.Itoa(btoi(e.Skipped))},
+	}
+
+	name := e.File
+	if len(e.Tags["Title"]) > 0 {
+		name = e.Tags["Title"]
+		if len(e.Tags["Artist"]) > 0 {
+			name = e.Tags["Artist"] + " - " + name
+		}
+	}
+
+	if e.Skipped {
+		name += " (skipped)"
+	}
+	return cmd.Record(fields, fmt.Sprintf("%s  %s", t.Format("2006-01-02 15:04"), name))
+}
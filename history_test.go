@@ -0,0 +1,180 @@
+// Copyright (c) 2010, Jim Teeuwen. All rights reserved.
+// This code is subject to a 1-clause BSD license.
+// See the LICENSE file for its contents.
+
+package mpd
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+// Returns a history in a new, empty file.
+func tempHistory(t *testing.T) *History {
+	f, err := ioutil.TempFile("", "history")
+	if err != nil {
+		t.Fatalf("TempFile: %v", err)
+	}
+	f.Close()
+	os.Remove(f.Name())
+	return NewHistory(f.Name())
+}
+
+func historyEntry(time int64, artist, title string, skipped bool) *HistoryEntry {
+	tags := map[string]string{"Artist": artist, "Title": title}
+	return &HistoryEntry{time, title + ".mp3", tags, 300, 100, skipped}
+}
+
+func TestHistory(t *testing.T) {
+	h := tempHistory(t)
+	defer os.Remove(h.File)
+
+	if list, err := h.LastPlayed(5); err != nil || len(list) != 0 {
+		t.Fatalf("Expected a missing file to be empty, got %v %v.", list, err)
+	}
+
+	entries := []*HistoryEntry{
+		historyEntry(100, "AC/DC", "Hells Bells", false),
+		historyEntry(200, "Miles Davis", "So What", true),
+		historyEntry(300, "AC/DC", "Back in Black", false),
+		historyEntry(400, "Miles Davis", "So What", false),
+		historyEntry(500, "John Coltrane", "Giant Steps", true),
+		historyEntry(600, "John Coltrane", "Giant Steps", true),
+	}
+
+	for i, e := range entries {
+		if err := h.Append(e); err != nil {
+			t.Fatalf("Append: %v", err)
+		}
+
+		// A line which was cut off is skipped.
+		if i == 2 {
+			fd, _ := os.Open(h.File, os.O_WRONLY|os.O_APPEND, 0)
+			fd.Write([]byte(`{"Time":35`))
+			fd.Close()
+		}
+	}
+
+	last, err := h.LastPlayed(2)
+	if err != nil {
+		t.Fatalf("LastPlayed: %v", err)
+	}
+
+	if len(last) != 2 || last[0].Time != 600 || last[1].Time != 500 || last[1].Tags["Title"] != "Giant Steps" {
+		t.Errorf("Unexpected last played %v.", last)
+	}
+
+	top, err := h.TopArtists(0, 2)
+	if err != nil {
+		t.Fatalf("TopArtists: %v", err)
+	}
+
+	if got := fmt.Sprint(top[0], top[1]); got != "&{AC/DC 2 0} &{Miles Davis 2 1}" {
+		t.Errorf("Unexpected top artists %s.", got)
+	}
+
+	if top, _ = h.Top("file", 350, 5); len(top) != 2 || top[0].Name != "So What.mp3" {
+		t.Errorf("Unexpected top songs since 350: %v", top)
+	}
+
+	skips, err := h.SkipRatios("Artist", 0, 1)
+	if err != nil {
+		t.Fatalf("SkipRatios: %v", err)
+	}
+
+	if len(skips) != 2 || skips[0].Name != "John Coltrane" || skips[0].SkipRatio() != 1 || skips[1].SkipRatio() != 0.5 {
+		t.Errorf("Unexpected skip ratios %v.", skips)
+	}
+
+	cmd := CreateCommand("plays")
+	cmd.Output = new(bytes.Buffer)
+	if err = cmd.Run(nil, []string{"plays", "top", "--period=all", "--file=" + h.File}); err != nil {
+		t.Fatalf("plays: %v", err)
+	}
+
+	if got := cmd.Output.(*bytes.Buffer).String(); got != "   2 plays    0 skips  AC/DC\n   2 plays    1 skips  Miles Davis\n   2 plays    2 skips  John Coltrane\n" {
+		t.Errorf("Unexpected output:\n%s", got)
+	}
+}
+
+func TestHistoryRecorder(t *testing.T) {
+	h := tempHistory(t)
+	defer os.Remove(h.File)
+
+	r := NewHistoryRecorder(h)
+	r.Log = new(bytes.Buffer)
+
+	var clock int64
+	r.now = func() int64 { return clock }
+
+	song := func(id, title string) []Pair {
+		return []Pair{Pair{"file", title + ".mp3"}, Pair{"Artist", "X"}, Pair{"Title", title}, Pair{"Time", "100"}, Pair{"Pos", "0"}, Pair{"Id", id}}
+	}
+
+	// Played to the end, with a pause in between.
+	r.observe(song("1", "A"), &Status{State: "play"})
+	clock += 40e9
+	r.observe(song("1", "A"), &Status{State: "pause", Elapsed: 40e9})
+	clock += 500e9
+	r.observe(song("1", "A"), &Status{State: "play", Elapsed: 40e9})
+	clock += 60e9
+
+	// Skipped after seeking to 50s.
+	r.observe(song("2", "B"), &Status{State: "play"})
+	clock += 10e9
+	r.observe(song("2", "B"), &Status{State: "play", Elapsed: 50e9})
+	clock += 20e9
+
+	// Never played.
+	r.observe(song("3", "C"), &Status{State: "pause"})
+	clock += 20e9
+
+	r.observe(song("4", "D"), &Status{State: "play"})
+	clock += 95e9
+	r.observe(nil, &Status{State: "stop"})
+
+	list, err := h.Entries(0)
+	if err != nil {
+		t.Fatalf("Entries: %v", err)
+	}
+
+	if len(list) != 3 {
+		t.Fatalf("Expected 3 entries, got %d.", len(list))
+	}
+
+	want := []string{"A.mp3 100 false 0", "B.mp3 30 true 600", "D.mp3 95 false 650"}
+	for i, e := range list {
+		if got := fmt.Sprintf("%s %d %v %d", e.File, e.Played, e.Skipped, e.Time); got != want[i] {
+			t.Errorf("Entry %d: Expected %s, got %s.", i, want[i], got)
+		}
+	}
+
+	if tags := list[0].Tags; len(tags) != 2 || tags["Artist"] != "X" || tags["Title"] != "A" {
+		t.Errorf("Unexpected tags %v.", tags)
+	}
+
+	// The same queue entry plays again with repeat on, after seeking back
+	// within it. Only the restart makes a new entry.
+	clock += 100e9
+	r.observe(song("4", "D"), &Status{State: "play"})
+	clock += 60e9
+	r.observe(song("4", "D"), &Status{State: "play", Elapsed: 20e9})
+	clock += 80e9
+	r.observe(song("4", "D"), &Status{State: "play", Elapsed: 0})
+	clock += 40e9
+	r.observe(nil, &Status{State: "stop"})
+
+	if list, _ = h.Entries(0); len(list) != 5 {
+		t.Fatalf("Expected 5 entries, got %d.", len(list))
+	}
+
+	want = []string{"D.mp3 140 false 845", "D.mp3 40 true 985"}
+	for i, e := range list[3:] {
+		if got := fmt.Sprintf("%s %d %v %d", e.File, e.Played, e.Skipped, e.Time); got != want[i] {
+			t.Errorf("Entry %d: Expected %s, got %s.", i+3, want[i], got)
+		}
+	}
+}
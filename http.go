@@ -12,27 +12,43 @@ import (
 	"io/ioutil"
 )
 
+// A response with a status of 400 or above.
+type httpError struct {
+	status int
+	body   string
+}
+
+func (this *httpError) String() string {
+	return fmt.Sprintf("HTTP status %d: %s", this.status, strings.TrimSpace(this.body))
+}
+
 func httpGet(uri string, params map[string]interface{}) (response string, err os.Error) {
-	var r *http.Response
+	return httpRequest("GET", fmt.Sprintf("%s?%s", uri, makeQueryString(params)), "", "", nil)
+}
 
-	if r, _, err = http.Get(fmt.Sprintf("%s?%s", uri, makeQueryString(params))); err != nil {
+func httpPost(uri string, params map[string]interface{}) (response string, err os.Error) {
+	return httpRequest("POST", uri, "application/x-www-form-urlencoded", makeQueryString(params), nil)
+}
+
+// Sends a request with the given body and additional headers, and returns
+// the body of the response. Responses with an error status are returned
+// as an *httpError.
+func httpRequest(method, uri, contentType, body string, header map[string]string) (response string, err os.Error) {
+	var req *http.Request
+	if req, err = http.NewRequest(method, uri, strings.NewReader(body)); err != nil {
 		return
 	}
-	defer r.Body.Close()
 
-	var b []byte
-	if b, err = ioutil.ReadAll(r.Body); err != nil {
-		return
+	if len(contentType) > 0 {
+		req.Header.Set("Content-Type", contentType)
 	}
 
-	return string(b), nil
-}
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
 
-func httpPost(uri string, params map[string]interface{}) (response string, err os.Error) {
 	var r *http.Response
-
-	body := strings.NewReader(makeQueryString(params))
-	if r, err = http.Post(uri, "text/html; charset=utf-8", body); err != nil {
+	if r, err = http.DefaultClient.Do(req); err != nil {
 		return
 	}
 	defer r.Body.Close()
@@ -42,12 +58,15 @@ func httpPost(uri string, params map[string]interface{}) (response string, err o
 		return
 	}
 
+	if r.StatusCode >= 400 {
+		return "", &httpError{r.StatusCode, string(b)}
+	}
 	return string(b), nil
 }
 
 func makeQueryString(params map[string]interface{}) (qs string) {
 	for k, v := range params {
-		qs += fmt.Sprintf("%s=%v&", k, http.URLEscape(fmt.Sprintf("%v", v)))
+		qs += fmt.Sprintf("%s=%v&", http.URLEscape(k), http.URLEscape(fmt.Sprintf("%v", v)))
 	}
 	if len(qs) > 1 {
 		qs = qs[0 : len(qs)-1] // strip trailing &
@@ -0,0 +1,80 @@
+// Copyright (c) 2010, Jim Teeuwen. All rights reserved.
+// This code is subject to a 1-clause BSD license.
+// See the LICENSE file for its contents.
+
+package mpd
+
+import (
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+)
+
+// Stop channels of the commands which run until the program is stopped.
+var (
+	interruptLock  sync.Mutex
+	interruptStops = make(map[chan bool]bool)
+)
+
+func init() {
+	go handleSignals()
+}
+
+// Returns a channel which is closed when the program receives SIGINT or
+// SIGTERM, so that a command which runs until then can finish its work
+// first. Call @release once the command is done.
+func untilInterrupted() (stop chan bool, release func()) {
+	stop = make(chan bool)
+
+	interruptLock.Lock()
+	interruptStops[stop] = true
+	interruptLock.Unlock()
+
+	release = func() {
+		interruptLock.Lock()
+		interruptStops[stop] = false, false
+		interruptLock.Unlock()
+	}
+	return
+}
+
+// Once the signal package is linked in, signals which would end the program
+// are delivered to it instead. Those end the program as before, unless a
+// command waits for them in untilInterrupted. A second Ctrl-C ends the
+// program even if that command is still finishing.
+func handleSignals() {
+	for sig := range signal.Incoming {
+		s, ok := sig.(signal.UnixSignal)
+		if !ok {
+			continue
+		}
+
+		switch int(s) {
+		case syscall.SIGINT, syscall.SIGTERM:
+			if stopCommands() {
+				continue
+			}
+			os.Exit(128 + int(s))
+		case syscall.SIGHUP, syscall.SIGUSR1, syscall.SIGUSR2, syscall.SIGALRM:
+			os.Exit(128 + int(s))
+		}
+	}
+}
+
+// Closes the channels handed out by untilInterrupted. Returns false if
+// there were none.
+func stopCommands() bool {
+	interruptLock.Lock()
+	defer interruptLock.Unlock()
+
+	if len(interruptStops) == 0 {
+		return false
+	}
+
+	for stop := range interruptStops {
+		close(stop)
+		interruptStops[stop] = false, false
+	}
+	return true
+}
@@ -0,0 +1,25 @@
+// Copyright (c) 2010, Jim Teeuwen. All rights reserved.
+// This code is subject to a 1-clause BSD license.
+// See the LICENSE file for its contents.
+
+package mpd
+
+import (
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestUntilInterrupted(t *testing.T) {
+	stop, release := untilInterrupted()
+	defer release()
+
+	syscall.Kill(os.Getpid(), syscall.SIGINT)
+
+	select {
+	case <-stop:
+	case <-time.After(5e9):
+		t.Fatalf("Expected SIGINT to close the stop channel.")
+	}
+}
@@ -0,0 +1,314 @@
+// Copyright (c) 2010, Jim Teeuwen. All rights reserved.
+// This code is subject to a 1-clause BSD license.
+// See the LICENSE file for its contents.
+
+package mpd
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// A Kind describes the values a parameter accepts. Check validates a value
+// and returns it in the form it is sent to the server in. Its errors state
+// what was expected, for instance "an integer from 0 to 100". String returns
+// a short description for help output.
+type Kind interface {
+	Check(value string) (string, os.Error)
+	String() string
+}
+
+// Kinds whose values can only be fully checked against the server implement
+// ServerKind. CheckServer is called before the command runs.
+type ServerKind interface {
+	Kind
+	CheckServer(c *Client, value string) os.Error
+}
+
+// Kinds with a fixed set of values implement Chooser. The values are used
+// for help output and completion.
+type Chooser interface {
+	Choices() []string
+}
+
+// Commonly used kinds.
+var (
+	TextKind     Kind = new(textKind)
+	IndexKind         = IntKind(0, math.MaxInt32) // Song positions, ids and the like.
+	BoolKind     Kind = new(boolKind)
+	DurationKind Kind = &durationKind{false}
+	OffsetKind   Kind = &durationKind{true} // Durations with an optional + or - sign.
+	RangeKind    Kind = new(rangeKind)
+	TagKind      Kind = new(tagKind)
+)
+
+type textKind struct{}
+
+func (this *textKind) String() string { return "text" }
+
+func (this *textKind) Check(v string) (string, os.Error) {
+	if len(v) == 0 {
+		return v, os.NewError("expected a non-empty value")
+	}
+
+	if breaksLine(v) {
+		return v, os.NewError("expected a single line of text")
+	}
+	return v, nil
+}
+
+type intKind struct {
+	min, max int
+	relative bool
+}
+
+// Accepts integers from @min to @max, inclusive.
+func IntKind(min, max int) Kind {
+	return &intKind{min, max, false}
+}
+
+// Accepts integers from @min to @max, optionally prefixed with + or - to
+// denote a value relative to the current one. The sign is kept.
+func RelativeKind(min, max int) Kind {
+	return &intKind{min, max, true}
+}
+
+func (this *intKind) String() string {
+	s := fmt.Sprintf("integer from %d to %d", this.min, this.max)
+	if this.max == math.MaxInt32 {
+		s = fmt.Sprintf("integer of at least %d", this.min)
+	}
+
+	if this.relative {
+		s += ", optionally prefixed with + or -"
+	}
+	return s
+}
+
+func (this *intKind) Check(v string) (string, os.Error) {
+	num := v
+	if this.relative && len(v) > 0 && (v[0] == '+' || v[0] == '-') {
+		num = v[1:]
+	}
+
+	n, err := strconv.Atoi(num)
+	if err != nil || num != v && (num[0] == '+' || num[0] == '-') ||
+		n < this.min || n > this.max {
+		return v, os.NewError("expected an " + this.String())
+	}
+	return v, nil
+}
+
+type floatKind struct {
+	min, max float64
+}
+
+// Accepts decimal numbers from @min to @max, inclusive. Use math.Inf for an
+// open end.
+func FloatKind(min, max float64) Kind {
+	return &floatKind{min, max}
+}
+
+func (this *floatKind) String() string {
+	switch {
+	case math.IsInf(this.min, -1) && math.IsInf(this.max, 1):
+		return "number"
+	case math.IsInf(this.max, 1):
+		return fmt.Sprintf("number of at least %g", this.min)
+	case math.IsInf(this.min, -1):
+		return fmt.Sprintf("number of at most %g", this.max)
+	}
+	return fmt.Sprintf("number from %g to %g", this.min, this.max)
+}
+
+func (this *floatKind) Check(v string) (string, os.Error) {
+	f, err := strconv.Atof64(v)
+	if err != nil || f != f || f < this.min || f > this.max {
+		return v, os.NewError("expected a " + this.String())
+	}
+	return v, nil
+}
+
+type enumKind []string
+
+// Accepts one of the given values. Matching is case insensitive.
+func EnumKind(values ...string) Kind {
+	return enumKind(values)
+}
+
+func (this enumKind) Choices() []string { return this }
+
+func (this enumKind) String() string {
+	return "one of " + strings.Join(this, ", ")
+}
+
+func (this enumKind) Check(v string) (string, os.Error) {
+	for _, c := range this {
+		if strings.ToLower(v) == c {
+			return c, nil
+		}
+	}
+	return v, os.NewError("expected " + this.String())
+}
+
+// Accepts on and off, as well as 1/0, yes/no and true/false. Values are
+// reported as on or off.
+type boolKind struct{}
+
+func (this *boolKind) Choices() []string { return []string{"on", "off"} }
+func (this *boolKind) String() string    { return "on or off" }
+
+func (this *boolKind) Check(v string) (string, os.Error) {
+	switch strings.ToLower(v) {
+	case "on", "1", "yes", "true":
+		return "on", nil
+	case "off", "0", "no", "false":
+		return "off", nil
+	}
+	return v, os.NewError("expected on or off")
+}
+
+// Accepts anything ParseDuration does.
+type durationKind struct {
+	signed bool
+}
+
+func (this *durationKind) String() string {
+	s := "duration, like 90.5, 1:30 or 1m30s"
+	if this.signed {
+		s += ", optionally prefixed with + or -"
+	}
+	return s
+}
+
+func (this *durationKind) Check(v string) (string, os.Error) {
+	d := v
+	if this.signed && len(v) > 0 && (v[0] == '+' || v[0] == '-') {
+		d = v[1:]
+	}
+
+	if _, err := ParseDuration(d); err != nil {
+		return v, os.NewError("expected a " + this.String())
+	}
+	return v, nil
+}
+
+// Accepts a song position or a range of them, as START:END. END is
+// exclusive and may be left out to denote the end of the playlist.
+type rangeKind struct{}
+
+func (this *rangeKind) String() string { return "position or range START:END" }
+
+func (this *rangeKind) Check(v string) (string, os.Error) {
+	err := os.NewError("expected a " + this.String())
+	parts := strings.Split(v, ":", -1)
+	if len(parts) > 2 {
+		return v, err
+	}
+
+	bounds := make([]int, len(parts))
+	for i, p := range parts {
+		if i > 0 && len(p) == 0 {
+			return v, nil
+		}
+
+		if _, e := IndexKind.Check(p); e != nil {
+			return v, err
+		}
+		bounds[i], _ = strconv.Atoi(p)
+	}
+
+	if len(bounds) == 2 && bounds[1] <= bounds[0] {
+		return v, os.NewError("expected the end of the range to lie after its start")
+	}
+	return v, nil
+}
+
+// Accepts the name of a tag. The name is checked against the tag types the
+// server supports before the command runs. The pseudo tags 'any', 'file',
+// 'filename' and 'base' are always accepted.
+type tagKind struct{}
+
+var (
+	pseudoTags = []string{"any", "file", "filename", "base"}
+	reTagName  = regexp.MustCompile(`^[A-Za-z][A-Za-z_\-]*$`)
+)
+
+func (this *tagKind) String() string { return "tag name" }
+
+func (this *tagKind) Check(v string) (string, os.Error) {
+	if !reTagName.MatchString(v) {
+		return v, os.NewError("expected a tag name, like artist or album")
+	}
+	return strings.ToLower(v), nil
+}
+
+func (this *tagKind) CheckServer(c *Client, v string) (err os.Error) {
+	for _, t := range pseudoTags {
+		if v == t {
+			return
+		}
+	}
+
+	var tags []string
+	if tags, err = c.TagTypes(); err != nil {
+		return
+	}
+
+	for _, t := range tags {
+		if strings.ToLower(t) == v {
+			return
+		}
+	}
+
+	return os.NewError(fmt.Sprintf("expected one of %s or %s",
+		strings.Join(pseudoTags, ", "), strings.Join(tags, ", ")))
+}
+
+type anyKind []Kind
+
+// Accepts values which match at least one of the given kinds.
+func AnyKind(kinds ...Kind) Kind {
+	return anyKind(kinds)
+}
+
+func (this anyKind) String() string {
+	list := make([]string, len(this))
+	for i, k := range this {
+		list[i] = k.String()
+	}
+	return strings.Join(list, " or ")
+}
+
+func (this anyKind) Check(v string) (string, os.Error) {
+	for _, k := range this {
+		if s, err := k.Check(v); err == nil {
+			return s, nil
+		}
+	}
+	return v, os.NewError("expected a " + this.String())
+}
+
+type patternKind struct {
+	re   *regexp.Regexp
+	desc string
+}
+
+// Accepts values which match @re. @desc describes them, as in "a hexadecimal
+// number".
+func PatternKind(re *regexp.Regexp, desc string) Kind {
+	return &patternKind{re, desc}
+}
+
+func (this *patternKind) String() string { return this.desc }
+
+func (this *patternKind) Check(v string) (string, os.Error) {
+	if !this.re.MatchString(v) {
+		return v, os.NewError("expected " + this.desc)
+	}
+	return v, nil
+}
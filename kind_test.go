@@ -0,0 +1,138 @@
+// Copyright (c) 2010, Jim Teeuwen. All rights reserved.
+// This code is subject to a 1-clause BSD license.
+// See the LICENSE file for its contents.
+
+package mpd
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+type kindTest struct {
+	kind  Kind
+	value string
+	want  string // Empty if the value is invalid.
+}
+
+func TestKinds(t *testing.T) {
+	tests := []kindTest{
+		kindTest{IndexKind, "3", "3"},
+		kindTest{IndexKind, "-1", ""},
+		kindTest{IndexKind, "3x", ""},
+		kindTest{IntKind(0, 100), "100", "100"},
+		kindTest{IntKind(0, 100), "101", ""},
+		kindTest{RelativeKind(0, 100), "+5", "+5"},
+		kindTest{RelativeKind(0, 100), "-5", "-5"},
+		kindTest{RelativeKind(0, 100), "+-5", ""},
+		kindTest{RelativeKind(0, 100), "-", ""},
+		kindTest{FloatKind(math.Inf(-1), 0), "-17.5", "-17.5"},
+		kindTest{FloatKind(math.Inf(-1), 0), "3", ""},
+		kindTest{EnumKind("on", "off", "oneshot"), "OneShot", "oneshot"},
+		kindTest{EnumKind("on", "off", "oneshot"), "twice", ""},
+		kindTest{BoolKind, "yes", "on"},
+		kindTest{BoolKind, "0", "off"},
+		kindTest{BoolKind, "maybe", ""},
+		kindTest{DurationKind, "1:30", "1:30"},
+		kindTest{DurationKind, "-5", ""},
+		kindTest{OffsetKind, "-5", "-5"},
+		kindTest{RangeKind, "4", "4"},
+		kindTest{RangeKind, "2:5", "2:5"},
+		kindTest{RangeKind, "2:", "2:"},
+		kindTest{RangeKind, "5:2", ""},
+		kindTest{RangeKind, ":2", ""},
+		kindTest{RangeKind, "1:2:3", ""},
+		kindTest{TagKind, "Artist", "artist"},
+		kindTest{TagKind, "any", "any"},
+		kindTest{TagKind, "artist ", ""},
+		kindTest{TextKind, "", ""},
+		kindTest{TextKind, "a song.mp3", "a song.mp3"},
+		kindTest{TextKind, "x\nkill", ""},
+		kindTest{TextKind, "x\r", ""},
+		kindTest{TextKind, "x\x00", ""},
+		kindTest{AnyKind(FloatKind(0, math.Inf(1)), EnumKind("nan")), "nan", "nan"},
+		kindTest{AnyKind(FloatKind(0, math.Inf(1)), EnumKind("nan")), "-1", ""},
+	}
+
+	for _, tt := range tests {
+		got, err := tt.kind.Check(tt.value)
+
+		switch {
+		case tt.want == "" && err == nil:
+			t.Errorf("%s: Expected error for '%s'.", tt.kind, tt.value)
+		case tt.want != "" && err != nil:
+			t.Errorf("%s: Unexpected error for '%s': %v", tt.kind, tt.value, err)
+		case tt.want != "" && got != tt.want:
+			t.Errorf("%s: Expected '%s' for '%s', got '%s'.", tt.kind, tt.want, tt.value, got)
+		}
+	}
+
+	if PatType.MatchString("anything") || PatOnOff.MatchString("online") || !PatSign.MatchString("+") {
+		t.Errorf("Patterns are not anchored.")
+	}
+}
+
+func TestParseParams(t *testing.T) {
+	cmd := CreateCommand("seek")
+	if err := cmd.parse([]string{"--time=1:30", "--pos=3"}); err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+
+	if cmd.I("pos", -1) != 3 || cmd.S("time", "") != "1:30" {
+		t.Errorf("Named parameters not assigned: %v", cmd.Params)
+	}
+
+	cmd = CreateCommand("seek")
+	if err := cmd.parse([]string{"--pos=3", "10"}); err != nil || cmd.S("time", "") != "10" {
+		t.Errorf("Expected positional value to fill the remaining parameter (%v).", err)
+	}
+
+	cmd = CreateCommand("tagtypes")
+	if err := cmd.parse(nil); err != nil || cmd.S("action", "") != "list" {
+		t.Errorf("Expected default action 'list', got '%s' (%v).", cmd.S("action", ""), err)
+	}
+
+	if cmd = CreateCommand("addid"); cmd.parse([]string{"a.mp3"}) != nil || cmd.I("pos", -1) != -1 {
+		t.Errorf("Expected missing optional parameter to yield the default.")
+	}
+
+	errors := map[string][]string{
+		"on or off":     []string{"random", "maybe"},
+		"from 0 to 100": []string{"volume", "101"},
+		"Missing":       []string{"seek", "3"},
+		"Too many":      []string{"play", "1", "2"},
+		"Unknown":       []string{"play", "--bogus=1"},
+		"--pos=<value>": []string{"play", "--pos"},
+	}
+
+	for want, args := range errors {
+		err := CreateCommand(args[0]).parse(args[1:])
+		if err == nil || strings.Index(err.String(), want) == -1 {
+			t.Errorf("%v: Expected error containing '%s', got %v.", args, want, err)
+		}
+	}
+}
+
+func TestServerKind(t *testing.T) {
+	srv, c := startServer(t)
+	defer srv.Close()
+	defer c.Close()
+
+	if err := runCommand(c, "find", "Genre", "Jazz"); err != nil {
+		t.Errorf("find: %v", err)
+	}
+
+	if last := srv.Last(); last != `find "genre" "Jazz"` {
+		t.Errorf("Unexpected command '%s'.", last)
+	}
+
+	err := runCommand(c, "find", "mood", "happy")
+	if err == nil || strings.Index(err.String(), "Artist") == -1 {
+		t.Errorf("Expected error listing the supported tags, got %v.", err)
+	}
+
+	if last := srv.Last(); last != "tagtypes" {
+		t.Errorf("Expected the command not to be sent, got '%s'.", last)
+	}
+}
@@ -4,7 +4,12 @@
 
 package mpd
 
-import "fmt"
+import (
+	"os"
+	"fmt"
+	"strconv"
+	"strings"
+)
 
 // Miscellaneous helper functions
 
@@ -29,10 +34,136 @@ func parseTime(seconds int) string {
 	return "00:00"
 }
 
-// simply converts 1 to 'on' and 0 to 'off'
+// simply converts 1 to 'on' and 0 to 'off'. The 'oneshot' state of the
+// single and consume options is passed through as-is.
 func onoff(v string) string {
-	if v == "1" {
+	switch v {
+	case "1":
 		return "on"
+	case "oneshot":
+		return v
 	}
 	return "off"
 }
+
+// converts a boolean into the 0/1 form expected by the MPD protocol.
+func btoi(v bool) int {
+	if v {
+		return 1
+	}
+	return 0
+}
+
+// Parses a duration into nanoseconds. Accepted formats are plain (fractional)
+// seconds ("90", "90.5"), clock notation ("1:30", "1:02:03.5") and unit
+// notation ("1h2m", "2m30s", "1.5s").
+func ParseDuration(v string) (ns int64, err os.Error) {
+	var f float64
+
+	if len(v) == 0 {
+		return 0, os.NewError("Empty duration.")
+	}
+
+	if strings.Index(v, ":") != -1 {
+		parts := strings.Split(v, ":", -1)
+		if len(parts) > 3 {
+			return 0, os.NewError(fmt.Sprintf("Invalid duration '%s'. Expected [hh:]mm:ss.", v))
+		}
+
+		for i, p := range parts {
+			if i < len(parts)-1 {
+				var n int
+				if n, err = strconv.Atoi(p); err != nil || n < 0 {
+					return 0, os.NewError(fmt.Sprintf("Invalid duration '%s'.", v))
+				}
+				f = f*60 + float64(n)
+				continue
+			}
+
+			var sec float64
+			if sec, err = strconv.Atof64(p); err != nil || sec < 0 || sec >= 60 {
+				return 0, os.NewError(fmt.Sprintf("Invalid duration '%s'.", v))
+			}
+			f = f*60 + sec
+		}
+		return int64(f * 1e9), nil
+	}
+
+	if last := v[len(v)-1]; last != 'h' && last != 'm' && last != 's' {
+		if f, err = strconv.Atof64(v); err != nil || f < 0 {
+			return 0, os.NewError(fmt.Sprintf("Invalid duration '%s'.", v))
+		}
+		return int64(f * 1e9), nil
+	}
+
+	start := 0
+	for i := 0; i < len(v); i++ {
+		var unit float64
+		switch v[i] {
+		case 'h':
+			unit = 3600
+		case 'm':
+			unit = 60
+		case 's':
+			unit = 1
+		default:
+			continue
+		}
+
+		var n float64
+		if n, err = strconv.Atof64(v[start:i]); err != nil || n < 0 {
+			return 0, os.NewError(fmt.Sprintf("Invalid duration '%s'. Expected a number before '%c'.", v, v[i]))
+		}
+
+		f += n * unit
+		start = i + 1
+	}
+
+	return int64(f * 1e9), nil
+}
+
+// Formats nanoseconds as fractional seconds for use in protocol commands.
+func formatSeconds(ns int64) string {
+	return strconv.Ftoa64(float64(ns)/1e9, 'f', 3)
+}
+
+// Parses a fractional seconds value as reported by the server into
+// nanoseconds.
+func parseSeconds(v string) (int64, bool) {
+	f, err := strconv.Atof64(v)
+	if err != nil {
+		return 0, false
+	}
+	return int64(f * 1e9), true
+}
+
+// Wraps a string argument in double quotes, escaping any quotes and
+// backslashes it contains.
+func quote(v string) string {
+	v = strings.Replace(v, "\\", "\\\\", -1)
+	v = strings.Replace(v, "\"", "\\\"", -1)
+	return "\"" + v + "\""
+}
+
+// Reports whether @v contains characters which end a line of the protocol:
+// line breaks and NUL. Values with these can not be sent, even quoted, since
+// the server would read the remainder as a command of its own.
+func breaksLine(v string) bool {
+	for i := 0; i < len(v); i++ {
+		switch v[i] {
+		case '\r', '\n', 0:
+			return true
+		}
+	}
+	return false
+}
+
+// Reports whether @list contains @v.
+func contains(list []string, v string) bool {
+	for _, s := range list {
+		if s == v {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,504 @@
+// Copyright (c) 2010, Jim Teeuwen. All rights reserved.
+// This code is subject to a 1-clause BSD license.
+// See the LICENSE file for its contents.
+
+package mpdhttp
+
+import (
+	"bytes"
+	"fmt"
+	"http"
+	"io"
+	"json"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"websocket"
+	"github.com/jteeuwen/go-pkg-mpd"
+)
+
+// An event sent to the clients of Events. Data holds a JSON value.
+type Event struct {
+	Name string
+	Data string
+}
+
+// Sent when there were no other events for a while, so that clients know
+// the stream is alive and broken connections are noticed.
+var heartbeat = &Event{"heartbeat", "{}"}
+
+// Events follows the server over a connection of its own and pushes its
+// changes to HTTP clients, as server-sent events (see ServeHTTP) or over a
+// WebSocket (see WebSocket). A client first receives a snapshot of the
+// whole state:
+//
+//	snapshot  {"status": {...}, "current": {...} or null, "queue": [...],
+//	          "outputs": [...], "options": {...}}
+//
+// and after that, an event for every change:
+//
+//	player    {"status": {...}, "current": {...} or null}
+//	mixer     {"volume": 40}
+//	playlist  {"version": 12, "length": 30, "changes": [...]}
+//	options   {...}
+//	outputs   [...]
+//	error     {"error": "..."}, when the connection to the server is lost
+//
+// A playlist event lists the songs which changed since the previous one,
+// with their positions. The queue is truncated to 'length'. Clients which
+// fall behind by more than QueueSize events lose the events they missed,
+// and receive a new snapshot instead.
+type Events struct {
+	Heartbeat  int64 // Interval of heartbeats, in nanoseconds.
+	QueueSize  int   // Number of events buffered for each client.
+	RetryDelay int64 // Nanoseconds to wait before reconnecting to the server.
+
+	cfg    *mpd.Config
+	stop   chan bool
+	lock   sync.Mutex
+	subs   map[*subscriber]bool
+	state  *state // Nil until the first connection succeeds.
+	closed bool
+}
+
+// The state of the server, as last reported to the clients.
+type state struct {
+	status  []mpd.Pair
+	current []mpd.Pair
+	queue   [][]mpd.Pair
+	outputs [][]mpd.Pair
+	options []mpd.Pair
+}
+
+type subscriber struct {
+	events chan *Event
+	resync bool // Events were dropped; send a snapshot next.
+}
+
+// Subsystems whose changes are pushed to the clients.
+var eventSubsystems = []string{"player", "mixer", "playlist", "options", "output"}
+
+func NewEvents(cfg *mpd.Config) *Events {
+	e := new(Events)
+	e.Heartbeat = 15e9
+	e.QueueSize = 64
+	e.RetryDelay = 5e9
+	e.cfg = cfg
+	e.stop = make(chan bool, 1)
+	e.subs = make(map[*subscriber]bool)
+	return e
+}
+
+// Follows the server until Close is called. Lost connections are reopened
+// after RetryDelay. Run this in a goroutine of its own.
+func (this *Events) Run() {
+	for !this.isClosed() {
+		err := this.follow()
+		if err == nil || this.isClosed() {
+			break
+		}
+
+		msg, _ := json.Marshal(err.String())
+		this.broadcast(&Event{"error", `{"error":` + string(msg) + `}`})
+		time.Sleep(this.RetryDelay)
+	}
+}
+
+// Stops following the server, and ends the streams of all clients.
+func (this *Events) Close() {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	if this.closed {
+		return
+	}
+
+	this.closed = true
+	for sub := range this.subs {
+		close(sub.events)
+	}
+	this.subs = make(map[*subscriber]bool)
+
+	select {
+	case this.stop <- true:
+	default:
+	}
+}
+
+func (this *Events) isClosed() bool {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	return this.closed
+}
+
+// Streams the events to the client as server-sent events.
+func (this *Events) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	flusher, _ := w.(http.Flusher)
+
+	this.stream(func(ev *Event) (err os.Error) {
+		if ev == heartbeat {
+			_, err = io.WriteString(w, ": heartbeat\n\n")
+		} else {
+			_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", ev.Name, ev.Data)
+		}
+
+		if flusher != nil {
+			flusher.Flush()
+		}
+		return
+	})
+}
+
+// Returns a handler which streams the events over a WebSocket. Every
+// message is a JSON object of the form {"event": name, "data": value}.
+func (this *Events) WebSocket() http.Handler {
+	return websocket.Handler(func(ws *websocket.Conn) {
+		defer ws.Close()
+
+		this.stream(func(ev *Event) (err os.Error) {
+			_, err = io.WriteString(ws, `{"event":"`+ev.Name+`","data":`+ev.Data+`}`)
+			return
+		})
+	})
+}
+
+// Sends events through @send until it fails or the Events are closed.
+func (this *Events) stream(send func(ev *Event) os.Error) {
+	sub := this.subscribe()
+	if sub == nil {
+		return
+	}
+	defer this.unsubscribe(sub)
+
+	ticker := time.NewTicker(this.Heartbeat)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case ev, ok := <-sub.events:
+			if !ok {
+				return
+			}
+
+			if snapshot := this.resync(sub); snapshot != nil {
+				ev = snapshot
+			}
+
+			if send(ev) != nil {
+				return
+			}
+
+		case <-ticker.C:
+			if send(heartbeat) != nil {
+				return
+			}
+		}
+	}
+}
+
+// Registers a client. Its first event is a snapshot, if the state is
+// known already. Returns nil if the Events are closed.
+func (this *Events) subscribe() *subscriber {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	if this.closed {
+		return nil
+	}
+
+	size := this.QueueSize
+	if size < 1 {
+		size = 1
+	}
+
+	sub := &subscriber{events: make(chan *Event, size)}
+	if this.state != nil {
+		sub.events <- this.state.snapshot()
+	}
+
+	this.subs[sub] = true
+	return sub
+}
+
+func (this *Events) unsubscribe(sub *subscriber) {
+	this.lock.Lock()
+	this.subs[sub] = false, false
+	this.lock.Unlock()
+}
+
+// Queues @ev for every client. Clients whose queue is full miss it, and
+// are sent a snapshot instead once they catch up.
+func (this *Events) broadcast(ev *Event) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	this.send(ev)
+}
+
+// Like broadcast, for callers which hold the lock.
+func (this *Events) send(ev *Event) {
+	for sub := range this.subs {
+		select {
+		case sub.events <- ev:
+		default:
+			sub.resync = true
+		}
+	}
+}
+
+// Returns a snapshot if @sub missed events, and drops the events which
+// are still queued for it, as the snapshot includes them.
+func (this *Events) resync(sub *subscriber) *Event {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	if !sub.resync || this.state == nil || this.closed {
+		return nil
+	}
+
+	sub.resync = false
+	for {
+		select {
+		case <-sub.events:
+			continue
+		default:
+		}
+		break
+	}
+	return this.state.snapshot()
+}
+
+// Opens a connection, sends a snapshot and pushes changes until the
+// connection fails or the Events are closed.
+func (this *Events) follow() (err os.Error) {
+	var c *mpd.Client
+	if c, err = mpd.Dial(this.cfg); err != nil {
+		return
+	}
+	defer c.Close()
+
+	s := new(state)
+	if err = s.load(c); err != nil {
+		return
+	}
+
+	this.lock.Lock()
+	this.state = s
+	this.send(s.snapshot())
+	this.lock.Unlock()
+
+	var changed []string
+	for {
+		if changed, err = c.IdleUntil(this.stop, eventSubsystems...); err != nil || this.isClosed() {
+			return
+		}
+
+		if err = this.update(c, changed); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// Fetches what changed and pushes an event for each subsystem.
+func (this *Events) update(c *mpd.Client, changed []string) (err os.Error) {
+	s := this.current()
+	events := make([]*Event, 0, len(changed))
+
+	var status []mpd.Pair
+	if status, err = runRecord(c, "status"); err != nil {
+		return
+	}
+
+	for _, name := range changed {
+		switch name {
+		case "player":
+			if s.current, err = runRecord(c, "current"); err != nil {
+				return
+			}
+			events = append(events, &Event{"player", s.playerData(status)})
+
+		case "mixer":
+			volume := value(status, "volume")
+			if _, e := strconv.Atoi(volume); e != nil {
+				volume = "-1"
+			}
+			events = append(events, &Event{"mixer", `{"volume":` + volume + `}`})
+
+		case "playlist":
+			var changes [][]mpd.Pair
+			if changes, err = runCommand(c, "plchanges", value(s.status, "playlist")); err != nil {
+				return
+			}
+
+			length, _ := strconv.Atoi(value(status, "playlistlength"))
+			s.applyChanges(changes, length)
+			events = append(events, &Event{"playlist", fmt.Sprintf(`{"version":%s,"length":%d,"changes":%s}`,
+				value(status, "playlist"), length, jsonList(changes))})
+
+		case "options":
+			if s.options, err = runRecord(c, "options"); err != nil {
+				return
+			}
+			events = append(events, &Event{"options", jsonRecord(s.options)})
+
+		case "output":
+			if s.outputs, err = runCommand(c, "outputs"); err != nil {
+				return
+			}
+			events = append(events, &Event{"outputs", jsonList(s.outputs)})
+		}
+	}
+
+	s.status = status
+
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	this.state = s
+	for _, ev := range events {
+		this.send(ev)
+	}
+	return
+}
+
+// Returns a copy of the state, which update can change while clients read
+// the original.
+func (this *Events) current() *state {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	s := *this.state
+	s.queue = make([][]mpd.Pair, len(this.state.queue))
+	copy(s.queue, this.state.queue)
+	return &s
+}
+
+func (this *state) load(c *mpd.Client) (err os.Error) {
+	if this.status, err = runRecord(c, "status"); err != nil {
+		return
+	}
+
+	if this.current, err = runRecord(c, "current"); err != nil {
+		return
+	}
+
+	if this.queue, err = runCommand(c, "plinfo"); err != nil {
+		return
+	}
+
+	if this.outputs, err = runCommand(c, "outputs"); err != nil {
+		return
+	}
+
+	this.options, err = runRecord(c, "options")
+	return
+}
+
+// Updates the queue with the songs reported by plchanges.
+func (this *state) applyChanges(changes [][]mpd.Pair, length int) {
+	if length < len(this.queue) {
+		this.queue = this.queue[:length]
+	}
+
+	for len(this.queue) < length {
+		this.queue = append(this.queue, nil)
+	}
+
+	for _, song := range changes {
+		if pos, err := strconv.Atoi(value(song, "Pos")); err == nil && pos < length {
+			this.queue[pos] = song
+		}
+	}
+}
+
+func (this *state) snapshot() *Event {
+	return &Event{"snapshot", fmt.Sprintf(`{"status":%s,"current":%s,"queue":%s,"outputs":%s,"options":%s}`,
+		jsonRecord(this.status), jsonRecord(this.current), jsonList(this.queue),
+		jsonList(this.outputs), jsonRecord(this.options))}
+}
+
+func (this *state) playerData(status []mpd.Pair) string {
+	return fmt.Sprintf(`{"status":%s,"current":%s}`, jsonRecord(status), jsonRecord(this.current))
+}
+
+/* Helpers */
+
+// Collects the records of a command.
+type collector struct {
+	records [][]mpd.Pair
+}
+
+func (this *collector) Begin(w io.Writer) os.Error { return nil }
+func (this *collector) End(w io.Writer) os.Error   { return nil }
+
+func (this *collector) Record(w io.Writer, fields []mpd.Pair, text string) os.Error {
+	this.records = append(this.records, fields)
+	return nil
+}
+
+// Runs a command and returns its records.
+func runCommand(c *mpd.Client, name string, args ...string) (records [][]mpd.Pair, err os.Error) {
+	cmd := mpd.CreateCommand(name)
+	if cmd == nil {
+		return nil, os.NewError(fmt.Sprintf("Unknown command '%s'.", name))
+	}
+
+	if err = cmd.Parse(args); err != nil {
+		return
+	}
+
+	f := new(collector)
+	cmd.Format = f
+	cmd.Output = new(bytes.Buffer)
+
+	if err = cmd.Execute(c); err != nil {
+		return
+	}
+	return f.records, nil
+}
+
+// Runs a command and returns its first record, or nil if it has none.
+func runRecord(c *mpd.Client, name string, args ...string) (record []mpd.Pair, err os.Error) {
+	var list [][]mpd.Pair
+	if list, err = runCommand(c, name, args...); err != nil || len(list) == 0 {
+		return
+	}
+	return list[0], nil
+}
+
+// Returns the value of the field @key, or an empty string.
+func value(fields []mpd.Pair, key string) string {
+	for _, f := range fields {
+		if f.Key == key {
+			return f.Value
+		}
+	}
+	return ""
+}
+
+// Encodes a record as a JSON object, like the --json output format. A nil
+// record becomes null.
+func jsonRecord(fields []mpd.Pair) string {
+	if fields == nil {
+		return "null"
+	}
+
+	var buf bytes.Buffer
+	f := &mpd.JSONFormatter{Lines: true}
+	f.Record(&buf, fields, "")
+	return strings.TrimSpace(buf.String())
+}
+
+func jsonList(records [][]mpd.Pair) string {
+	list := make([]string, len(records))
+	for i, r := range records {
+		list[i] = jsonRecord(r)
+	}
+	return "[" + strings.Join(list, ",") + "]"
+}
@@ -0,0 +1,202 @@
+// Copyright (c) 2010, Jim Teeuwen. All rights reserved.
+// This code is subject to a 1-clause BSD license.
+// See the LICENSE file for its contents.
+
+package mpdhttp
+
+import (
+	"bufio"
+	"http"
+	"http/httptest"
+	"io"
+	"os"
+	"strings"
+	"testing"
+	"time"
+	"websocket"
+	"github.com/jteeuwen/go-pkg-mpd"
+)
+
+// Reads server-sent events from @r into @events, skipping comments.
+func readEvents(r io.Reader, events chan<- *Event) {
+	br := bufio.NewReader(r)
+	ev := new(Event)
+
+	for {
+		line, err := br.ReadString('\n')
+		if err != nil {
+			close(events)
+			return
+		}
+
+		switch line = strings.TrimRight(line, "\n"); {
+		case strings.HasPrefix(line, "event: "):
+			ev.Name = line[7:]
+		case strings.HasPrefix(line, "data: "):
+			ev.Data = line[6:]
+		case len(line) == 0 && len(ev.Name) > 0:
+			events <- ev
+			ev = new(Event)
+		}
+	}
+}
+
+// Waits for the event @name, skipping others, and checks that its data
+// contains each of @want.
+func expectEvent(t *testing.T, events <-chan *Event, name string, want ...string) {
+	timeout := make(chan bool, 1)
+	go func() {
+		time.Sleep(5e9)
+		timeout <- true
+	}()
+
+	for {
+		select {
+		case ev, ok := <-events:
+			if !ok {
+				t.Fatalf("Stream ended while waiting for %s.", name)
+			}
+
+			if ev.Name != name {
+				continue
+			}
+
+			for _, w := range want {
+				if strings.Index(ev.Data, w) == -1 {
+					t.Errorf("%s: Expected data to contain %q, got %s.", name, w, ev.Data)
+				}
+			}
+			return
+
+		case <-timeout:
+			t.Fatalf("Timed out waiting for %s.", name)
+		}
+	}
+}
+
+func TestEvents(t *testing.T) {
+	srv, cfg := startServer(t)
+	defer srv.Close()
+
+	ev := NewEvents(cfg)
+	go ev.Run()
+	defer ev.Close()
+
+	hs := httptest.NewServer(ev)
+	defer hs.Close()
+
+	r, _, err := http.Get(hs.URL)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	defer r.Body.Close()
+
+	if ct := r.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Errorf("Unexpected content type %q.", ct)
+	}
+
+	events := make(chan *Event, 10)
+	go readEvents(r.Body, events)
+
+	expectEvent(t, events, "snapshot", `"queue":[]`, `"state":"stop"`, `"current":null`)
+
+	c, err := mpd.Dial(cfg)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer c.Close()
+
+	if _, err = runCommand(c, "volume", "30"); err != nil {
+		t.Fatalf("volume: %v", err)
+	}
+	expectEvent(t, events, "mixer", `{"volume":30}`)
+
+	if _, err = runCommand(c, "add", "rock"); err != nil {
+		t.Fatalf("add: %v", err)
+	}
+	expectEvent(t, events, "playlist", `"length":1`, `"file":"rock/acdc/hells_bells.mp3"`)
+
+	if _, err = runCommand(c, "play", "0"); err != nil {
+		t.Fatalf("play: %v", err)
+	}
+	expectEvent(t, events, "player", `"state":"play"`, `"Title":"Hells Bells"`)
+
+	if _, err = runCommand(c, "random", "on"); err != nil {
+		t.Fatalf("random: %v", err)
+	}
+	expectEvent(t, events, "options", `"random":"1"`)
+
+	// Clients which connect later start with the current state.
+	wsServer := httptest.NewServer(ev.WebSocket())
+	defer wsServer.Close()
+
+	ws, err := websocket.Dial("ws"+wsServer.URL[4:], "", "http://localhost/")
+	if err != nil {
+		t.Fatalf("websocket.Dial: %v", err)
+	}
+	defer ws.Close()
+
+	msg := make([]byte, 8192)
+	n, err := ws.Read(msg)
+	if err != nil {
+		t.Fatalf("Read: %v", err)
+	}
+
+	if s := string(msg[:n]); !strings.HasPrefix(s, `{"event":"snapshot","data":{`) || strings.Index(s, "Hells Bells") == -1 {
+		t.Errorf("Unexpected WebSocket message %s.", s)
+	}
+}
+
+func TestEventsBackpressure(t *testing.T) {
+	ev := NewEvents(nil)
+	ev.QueueSize = 2
+	ev.state = new(state)
+
+	sub := ev.subscribe()
+	if len(sub.events) != 1 {
+		t.Fatalf("Expected a snapshot on subscribe.")
+	}
+
+	for i := 0; i < 3; i++ {
+		ev.broadcast(&Event{"mixer", `{"volume":10}`})
+	}
+
+	if !sub.resync {
+		t.Fatalf("Expected a full queue to require a resync.")
+	}
+
+	<-sub.events
+	if s := ev.resync(sub); s == nil || s.Name != "snapshot" {
+		t.Errorf("Expected a snapshot, got %v.", s)
+	}
+
+	if len(sub.events) != 0 || sub.resync {
+		t.Errorf("Expected missed events to be dropped.")
+	}
+
+	ev.Close()
+	if _, ok := <-sub.events; ok {
+		t.Errorf("Expected Close to end the stream.")
+	}
+}
+
+func TestEventsHeartbeat(t *testing.T) {
+	ev := NewEvents(nil)
+	ev.Heartbeat = 1e7
+
+	sent := make(chan *Event, 100)
+	done := make(chan bool, 1)
+
+	go func() {
+		ev.stream(func(e *Event) os.Error {
+			sent <- e
+			return nil
+		})
+		done <- true
+	}()
+
+	expectEvent(t, sent, "heartbeat")
+
+	ev.Close()
+	<-done
+}
@@ -0,0 +1,300 @@
+// Copyright (c) 2010, Jim Teeuwen. All rights reserved.
+// This code is subject to a 1-clause BSD license.
+// See the LICENSE file for its contents.
+
+// Package mpdhttp offers an MPD server as JSON REST endpoints, so that web
+// and mobile applications do not have to speak the MPD protocol.
+//
+// Every endpoint runs one of the package's commands. Segments of the path
+// written as {name} below, query parameters and form values fill the
+// command's parameters of the same name. Values are checked like on the
+// command line:
+//
+//	GET    /status                      status
+//	GET    /stats                       stats
+//	GET    /current                     current
+//	GET    /options                     options
+//	GET    /outputs                     outputs
+//	POST   /outputs/{id}/enable         enableoutput
+//	POST   /outputs/{id}/disable        disableoutput
+//
+//	GET    /queue?pos=                  plinfo
+//	POST   /queue          path=        add
+//	DELETE /queue                       clear
+//	DELETE /queue/{pos}                 delete
+//	POST   /queue/shuffle               shuffle
+//	GET    /queue/search?tag=&term=     plsearch
+//
+//	GET    /library?path=               lsinfo
+//	GET    /library/find?tag=&term=     find
+//	GET    /library/search?tag=&term=   search
+//	POST   /library/update path=        update
+//
+//	POST   /player/play    pos=         play
+//	POST   /player/pause   toggle=      pause
+//	POST   /player/toggle               toggle
+//	POST   /player/stop                 stop
+//	POST   /player/next                 next
+//	POST   /player/previous             previous
+//	POST   /player/seek    time=        seekcur
+//	PUT    /player/volume  value=       volume
+//	PUT    /player/random  toggle=      random (also repeat, single, consume)
+//	PUT    /player/crossfade time=      crossfade
+//
+//	GET    /playlists                   playlists
+//	GET    /playlists/{name}            listplinfo
+//	PUT    /playlists/{name}            save
+//	DELETE /playlists/{name}            rm
+//	POST   /playlists/{name}/load       load
+//	POST   /playlists/{name}/songs path= pladd
+//	DELETE /playlists/{name}/songs/{id} pldelete
+//
+// Responses are JSON arrays with an object per record, like the --json
+// output format. Endpoints which report a single record, like /status,
+// respond with that object, or null if there is none. Errors are reported
+// as {"error": message, "code": ack code} with a status from StatusCode.
+package mpdhttp
+
+import (
+	"bytes"
+	"fmt"
+	"http"
+	"json"
+	"os"
+	"strings"
+	"github.com/jteeuwen/go-pkg-mpd"
+)
+
+// A Route maps requests to a command.
+type Route struct {
+	Method  string
+	Path    string // Segments written as {name} fill the parameter 'name'.
+	Command string
+	Single  bool // Respond with a single object instead of an array.
+}
+
+// The endpoints described in the package documentation.
+var Routes = []*Route{
+	&Route{"GET", "/status", "status", true},
+	&Route{"GET", "/stats", "stats", true},
+	&Route{"GET", "/current", "current", true},
+	&Route{"GET", "/options", "options", true},
+	&Route{"GET", "/outputs", "outputs", false},
+	&Route{"POST", "/outputs/{id}/enable", "enableoutput", false},
+	&Route{"POST", "/outputs/{id}/disable", "disableoutput", false},
+
+	&Route{"GET", "/queue", "plinfo", false},
+	&Route{"POST", "/queue", "add", false},
+	&Route{"DELETE", "/queue", "clear", false},
+	&Route{"DELETE", "/queue/{pos}", "delete", false},
+	&Route{"POST", "/queue/shuffle", "shuffle", false},
+	&Route{"GET", "/queue/search", "plsearch", false},
+
+	&Route{"GET", "/library", "lsinfo", false},
+	&Route{"GET", "/library/find", "find", false},
+	&Route{"GET", "/library/search", "search", false},
+	&Route{"POST", "/library/update", "update", true},
+
+	&Route{"POST", "/player/play", "play", false},
+	&Route{"POST", "/player/pause", "pause", false},
+	&Route{"POST", "/player/toggle", "toggle", false},
+	&Route{"POST", "/player/stop", "stop", false},
+	&Route{"POST", "/player/next", "next", false},
+	&Route{"POST", "/player/previous", "previous", false},
+	&Route{"POST", "/player/seek", "seekcur", false},
+	&Route{"PUT", "/player/volume", "volume", false},
+	&Route{"PUT", "/player/random", "random", false},
+	&Route{"PUT", "/player/repeat", "repeat", false},
+	&Route{"PUT", "/player/single", "single", false},
+	&Route{"PUT", "/player/consume", "consume", false},
+	&Route{"PUT", "/player/crossfade", "crossfade", false},
+
+	&Route{"GET", "/playlists", "playlists", false},
+	&Route{"GET", "/playlists/{name}", "listplinfo", false},
+	&Route{"PUT", "/playlists/{name}", "save", false},
+	&Route{"DELETE", "/playlists/{name}", "rm", false},
+	&Route{"POST", "/playlists/{name}/load", "load", false},
+	&Route{"POST", "/playlists/{name}/songs", "pladd", false},
+	&Route{"DELETE", "/playlists/{name}/songs/{id}", "pldelete", false},
+}
+
+// Gateway is an http.Handler which runs the commands of its routes over
+// the connections of a pool.
+type Gateway struct {
+	Pool   *mpd.Pool
+	Routes []*Route
+}
+
+func NewGateway(pool *mpd.Pool) *Gateway {
+	return &Gateway{pool, Routes}
+}
+
+func (this *Gateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	route, vars, allowed := this.match(r.Method, r.URL.Path)
+	if route == nil {
+		if len(allowed) > 0 {
+			w.Header().Set("Allow", strings.Join(allowed, ", "))
+			writeError(w, http.StatusMethodNotAllowed, os.NewError(fmt.Sprintf("Method %s is not allowed for %s.", r.Method, r.URL.Path)))
+			return
+		}
+
+		writeError(w, http.StatusNotFound, os.NewError(fmt.Sprintf("Unknown endpoint %s.", r.URL.Path)))
+		return
+	}
+
+	cmd := mpd.CreateCommand(route.Command)
+	if cmd == nil {
+		writeError(w, http.StatusInternalServerError, os.NewError(fmt.Sprintf("Unknown command '%s'.", route.Command)))
+		return
+	}
+
+	// Pass the values by name, so that missing ones are reported by name.
+	args := make([]string, 0, len(cmd.Params))
+	for _, p := range cmd.Params {
+		v, ok := vars[p.Name]
+		if !ok {
+			v = r.FormValue(p.Name)
+		}
+
+		if len(v) > 0 {
+			args = append(args, fmt.Sprintf("--%s=%s", p.Name, v))
+		}
+	}
+
+	if err := cmd.Parse(args); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	var buf bytes.Buffer
+	cmd.Format = &mpd.JSONFormatter{Lines: true}
+	cmd.Output = &buf
+
+	if err := this.execute(cmd); err != nil {
+		writeError(w, StatusCode(err), err)
+		return
+	}
+
+	records := strings.Split(strings.TrimSpace(buf.String()), "\n", -1)
+	if len(buf.String()) == 0 {
+		records = records[:0]
+	}
+
+	var body string
+	switch {
+	case !route.Single:
+		body = "[" + strings.Join(records, ",") + "]"
+	case len(records) > 0:
+		body = records[0]
+	default:
+		body = "null"
+	}
+
+	writeJSON(w, http.StatusOK, body)
+}
+
+// Runs @cmd over a connection from the pool. The pool replaces connections
+// on which reading or writing failed; other errors leave them usable.
+func (this *Gateway) execute(cmd *mpd.Command) (err os.Error) {
+	var c *mpd.Client
+	if c, err = this.Pool.Get(); err != nil {
+		return
+	}
+
+	err = cmd.Execute(c)
+	this.Pool.Put(c)
+	return
+}
+
+// Returns the route for @method and @path, along with the values of its
+// {name} segments. If only the method does not match, the methods which
+// the path does allow are returned instead.
+func (this *Gateway) match(method, path string) (route *Route, vars map[string]string, allowed []string) {
+	segments := splitPath(path)
+
+	for _, rt := range this.Routes {
+		v := matchPath(splitPath(rt.Path), segments)
+		if v == nil {
+			continue
+		}
+
+		if rt.Method == method {
+			return rt, v, nil
+		}
+		allowed = append(allowed, rt.Method)
+	}
+	return nil, nil, allowed
+}
+
+func splitPath(path string) []string {
+	if path = strings.Trim(path, "/"); len(path) == 0 {
+		return []string{}
+	}
+	return strings.Split(path, "/", -1)
+}
+
+// Returns the values of the {name} segments of @pattern, or nil if @path
+// does not match it.
+func matchPath(pattern, path []string) map[string]string {
+	if len(pattern) != len(path) {
+		return nil
+	}
+
+	vars := make(map[string]string)
+	for i, p := range pattern {
+		switch {
+		case strings.HasPrefix(p, "{") && strings.HasSuffix(p, "}"):
+			vars[p[1:len(p)-1]] = path[i]
+		case p != path[i]:
+			return nil
+		}
+	}
+	return vars
+}
+
+// Returns the HTTP status which describes an error of a command. Errors
+// reported by the server map to the status closest to their ACK code.
+// Invalid parameters and commands are bad requests, and any other error
+// means that the server could not be reached or was not understood.
+func StatusCode(err os.Error) int {
+	switch e := err.(type) {
+	case nil:
+		return http.StatusOK
+
+	case *mpd.ParamError, *mpd.UsageError:
+		return http.StatusBadRequest
+
+	case *mpd.AckError:
+		switch e.Code {
+		case mpd.ErrArg, mpd.ErrUnknown:
+			return http.StatusBadRequest
+		case mpd.ErrPassword:
+			return http.StatusUnauthorized
+		case mpd.ErrPermission:
+			return http.StatusForbidden
+		case mpd.ErrNoExist:
+			return http.StatusNotFound
+		case mpd.ErrExist, mpd.ErrPlaylistMax, mpd.ErrUpdateAlready, mpd.ErrPlayerSync:
+			return http.StatusConflict
+		}
+		return http.StatusInternalServerError
+	}
+	return http.StatusBadGateway
+}
+
+func writeJSON(w http.ResponseWriter, status int, body string) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	fmt.Fprintln(w, body)
+}
+
+func writeError(w http.ResponseWriter, status int, err os.Error) {
+	msg, _ := json.Marshal(err.String())
+
+	code := 0
+	if ack, ok := err.(*mpd.AckError); ok {
+		code = ack.Code
+	}
+
+	writeJSON(w, status, fmt.Sprintf(`{"error":%s,"code":%d}`, msg, code))
+}
@@ -0,0 +1,229 @@
+// Copyright (c) 2010, Jim Teeuwen. All rights reserved.
+// This code is subject to a 1-clause BSD license.
+// See the LICENSE file for its contents.
+
+package mpdhttp
+
+import (
+	"http"
+	"http/httptest"
+	"json"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"github.com/jteeuwen/go-pkg-mpd"
+	"github.com/jteeuwen/go-pkg-mpd/mpdtest"
+)
+
+// Starts a fake server with a few songs and returns a configuration which
+// points to it.
+func startServer(t *testing.T) (*mpdtest.Server, *mpd.Config) {
+	srv := mpdtest.NewServer()
+	srv.AddSongs(
+		mpdtest.Song{"file": "rock/acdc/hells_bells.mp3", "Artist": "AC/DC", "Title": "Hells Bells", "Time": "312"},
+		mpdtest.Song{"file": "jazz/davis/so_what.flac", "Artist": "Miles Davis", "Title": "So What", "Time": "562"},
+	)
+
+	if err := srv.Listen("tcp", "127.0.0.1:0"); err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+
+	addr := srv.Addr()
+	pos := strings.LastIndex(addr, ":")
+
+	cfg := mpd.NewConfig()
+	cfg.Address = addr[:pos]
+	cfg.Port, _ = strconv.Atoi(addr[pos+1:])
+	return srv, cfg
+}
+
+func startGateway(t *testing.T) (*mpdtest.Server, *Gateway) {
+	srv, cfg := startServer(t)
+	return srv, NewGateway(mpd.NewPool(cfg, 2))
+}
+
+// Sends a request to the gateway and decodes its JSON response.
+func request(t *testing.T, g *Gateway, method, url, form string) (int, interface{}) {
+	req, err := http.NewRequest(method, url, strings.NewReader(form))
+	if err != nil {
+		t.Fatalf("%s %s: %v", method, url, err)
+	}
+
+	if len(form) > 0 {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+
+	w := httptest.NewRecorder()
+	g.ServeHTTP(w, req)
+
+	var v interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &v); err != nil {
+		t.Errorf("%s %s: Invalid JSON %q: %v", method, url, w.Body.String(), err)
+	}
+	return w.Code, v
+}
+
+func TestGateway(t *testing.T) {
+	srv, g := startGateway(t)
+	defer srv.Close()
+	defer g.Pool.Close()
+
+	if code, v := request(t, g, "GET", "/status", ""); code != 200 || v.(map[string]interface{})["state"] != "stop" {
+		t.Errorf("GET /status: %d %v", code, v)
+	}
+
+	if code, v := request(t, g, "POST", "/queue", "path=rock"); code != 200 {
+		t.Errorf("POST /queue: %d %v", code, v)
+	}
+
+	if code, _ := request(t, g, "POST", "/queue", "path=jazz/davis/so_what.flac"); code != 200 {
+		t.Errorf("POST /queue: %d", code)
+	}
+
+	code, v := request(t, g, "GET", "/queue", "")
+	if list, ok := v.([]interface{}); code != 200 || !ok || len(list) != 2 {
+		t.Errorf("GET /queue: %d %v", code, v)
+	}
+
+	if code, _ = request(t, g, "POST", "/player/play", "pos=1"); code != 200 || srv.Status()["song"] != "1" {
+		t.Errorf("POST /player/play: %d %v", code, srv.Status())
+	}
+
+	if code, _ = request(t, g, "PUT", "/player/volume", "value=40"); code != 200 || srv.Status()["volume"] != "40" {
+		t.Errorf("PUT /player/volume: %d %v", code, srv.Status())
+	}
+
+	code, v = request(t, g, "GET", "/current", "")
+	if code != 200 || v.(map[string]interface{})["Title"] != "So What" {
+		t.Errorf("GET /current: %d %v", code, v)
+	}
+
+	if code, _ = request(t, g, "PUT", "/playlists/mix", ""); code != 200 {
+		t.Errorf("PUT /playlists/mix: %d", code)
+	}
+
+	code, v = request(t, g, "GET", "/playlists/mix", "")
+	if list, ok := v.([]interface{}); code != 200 || !ok || len(list) != 2 {
+		t.Errorf("GET /playlists/mix: %d %v", code, v)
+	}
+
+	code, v = request(t, g, "GET", "/library/search?tag=artist&term=davis", "")
+	if list, ok := v.([]interface{}); code != 200 || !ok || len(list) != 1 {
+		t.Errorf("GET /library/search: %d %v", code, v)
+	}
+
+	if code, _ = request(t, g, "DELETE", "/queue", ""); code != 200 || len(srv.Queue()) != 0 {
+		t.Errorf("DELETE /queue: %d %v", code, srv.Queue())
+	}
+}
+
+// A request and the status it is expected to fail with.
+type errorTest struct {
+	method, url, form string
+	code              int
+}
+
+func TestGatewayErrors(t *testing.T) {
+	srv, g := startGateway(t)
+	defer srv.Close()
+	defer g.Pool.Close()
+
+	tests := []errorTest{
+		errorTest{"GET", "/nowhere", "", 404},
+		errorTest{"DELETE", "/status", "", 405},
+		errorTest{"PUT", "/player/volume", "value=200", 400},
+		errorTest{"PUT", "/player/volume", "", 400},
+		errorTest{"GET", "/library/find?tag=nosuchtag&term=x", "", 400},
+		errorTest{"DELETE", "/queue/5", "", 400},
+		errorTest{"GET", "/playlists/nosuch", "", 404},
+		errorTest{"PUT", "/player/volume", "value=%2B5&sign=-", 400},
+	}
+
+	for _, test := range tests {
+		code, v := request(t, g, test.method, test.url, test.form)
+		if code != test.code {
+			t.Errorf("%s %s: Expected %d, got %d %v.", test.method, test.url, test.code, code, v)
+			continue
+		}
+
+		if m, ok := v.(map[string]interface{}); !ok || m["error"] == nil {
+			t.Errorf("%s %s: Expected an error object, got %v.", test.method, test.url, v)
+		}
+	}
+
+	// The connections stay usable after errors reported by the server.
+	if code, _ := request(t, g, "GET", "/status", ""); code != 200 {
+		t.Errorf("GET /status after errors: %d", code)
+	}
+}
+
+func TestGatewayLineBreaks(t *testing.T) {
+	srv, g := startGateway(t)
+	defer srv.Close()
+	defer g.Pool.Close()
+
+	// Values with line breaks would smuggle in commands of their own.
+	tests := []errorTest{
+		errorTest{"POST", "/queue", "path=x%0Akill", 400},
+		errorTest{"POST", "/queue", "path=x%22%0Akill", 400},
+		errorTest{"POST", "/queue", "path=x%0D%0Akill", 400},
+		errorTest{"PUT", "/playlists/x%0Akill", "", 400},
+		errorTest{"GET", "/library/find?tag=artist&term=x%00", "", 400},
+	}
+
+	for _, test := range tests {
+		if code, v := request(t, g, test.method, test.url, test.form); code != test.code {
+			t.Errorf("%s %s %s: Expected %d, got %d %v.", test.method, test.url, test.form, test.code, code, v)
+		}
+	}
+
+	for _, cmd := range srv.Commands() {
+		if strings.HasPrefix(cmd, "kill") {
+			t.Errorf("Unexpected command '%s'.", cmd)
+		}
+	}
+}
+
+func TestGatewayDroppedConnection(t *testing.T) {
+	srv, g := startGateway(t)
+	defer srv.Close()
+	defer g.Pool.Close()
+
+	g.Pool.PingAfter = 0
+
+	if code, v := request(t, g, "GET", "/status", ""); code != 200 {
+		t.Fatalf("GET /status: %d %v", code, v)
+	}
+
+	// The server closes the pooled connection while it is idle. The next
+	// request must not see it.
+	srv.DropConnections()
+
+	if code, v := request(t, g, "GET", "/status", ""); code != 200 {
+		t.Errorf("GET /status after the connection was dropped: %d %v", code, v)
+	}
+}
+
+func TestStatusCode(t *testing.T) {
+	tests := map[int]os.Error{
+		200: nil,
+		400: &mpd.AckError{Code: mpd.ErrArg},
+		401: &mpd.AckError{Code: mpd.ErrPassword},
+		403: &mpd.AckError{Code: mpd.ErrPermission},
+		404: &mpd.AckError{Code: mpd.ErrNoExist},
+		409: &mpd.AckError{Code: mpd.ErrExist},
+		500: &mpd.AckError{Code: mpd.ErrSystem},
+		502: os.EOF,
+	}
+
+	for want, err := range tests {
+		if got := StatusCode(err); got != want {
+			t.Errorf("%v: Expected %d, got %d.", err, want, got)
+		}
+	}
+
+	if got := StatusCode(&mpd.UsageError{"Missing parameter."}); got != 400 {
+		t.Errorf("UsageError: Expected 400, got %d.", got)
+	}
+}
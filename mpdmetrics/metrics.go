@@ -0,0 +1,320 @@
+// Copyright (c) 2010, Jim Teeuwen. All rights reserved.
+// This code is subject to a 1-clause BSD license.
+// See the LICENSE file for its contents.
+
+// Package mpdmetrics exports the state of an MPD server, and the requests
+// clients make to it, as Prometheus metrics:
+//
+//	requests := mpdmetrics.NewRequests()
+//	cfg := mpd.NewConfig()
+//	cfg.Observer = requests
+//	http.Handle("/metrics", mpdmetrics.NewExporter(mpd.NewPool(cfg, 1), requests))
+//
+// Every scrape reads the stats, status and outputs of the server:
+//
+//	mpd_up                               1 if the server could be read.
+//	mpd_artists, mpd_albums, mpd_songs   Size of the database.
+//	mpd_uptime_seconds_total             Time the server has been running.
+//	mpd_playtime_seconds_total           Time the server has been playing.
+//	mpd_db_playtime_seconds              Total length of all songs.
+//	mpd_db_update_timestamp_seconds      Time of the last database update.
+//	mpd_volume_percent                   Left out without a mixer.
+//	mpd_state{state}                     1 for the current state.
+//	mpd_bitrate_kbps
+//	mpd_queue_length
+//	mpd_elapsed_seconds                  Position in the current song.
+//	mpd_duration_seconds                 Length of the current song.
+//	mpd_crossfade_seconds
+//	mpd_output_enabled{id,name}          1 for enabled outputs.
+//	mpd_outputs_enabled                  The number of enabled outputs.
+//
+// Requests made over connections which report to a Requests add:
+//
+//	mpd_client_requests_total{command}
+//	mpd_client_request_errors_total{command}
+//	mpd_client_request_duration_seconds{command}   A histogram.
+//
+// Scrapers which ask for OpenMetrics get that format. Others get version
+// 0.0.4 of the Prometheus text format.
+package mpdmetrics
+
+import (
+	"bytes"
+	"fmt"
+	"http"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"github.com/jteeuwen/go-pkg-mpd"
+)
+
+// Upper bounds of the buckets of the request latency histogram, in seconds.
+var LatencyBuckets = []float64{0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5}
+
+// Counts of the requests for one command.
+type requestStats struct {
+	count   int64
+	errors  int64
+	seconds float64 // Sum of the latencies.
+	buckets []int64 // Requests per bucket of LatencyBuckets, not cumulative.
+}
+
+// Requests keeps the number, the errors and the latency of requests, per
+// command. Set it as the Observer of a Config, or of a Client, to count
+// the requests made over its connections.
+type Requests struct {
+	lock     sync.Mutex
+	commands map[string]*requestStats
+}
+
+func NewRequests() *Requests {
+	r := new(Requests)
+	r.commands = make(map[string]*requestStats)
+	return r
+}
+
+func (this *Requests) ObserveRequest(command string, duration int64, err os.Error) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	s, ok := this.commands[command]
+	if !ok {
+		s = &requestStats{buckets: make([]int64, len(LatencyBuckets))}
+		this.commands[command] = s
+	}
+
+	seconds := float64(duration) / 1e9
+	s.count++
+	s.seconds += seconds
+
+	if err != nil {
+		s.errors++
+	}
+
+	for i, le := range LatencyBuckets {
+		if seconds <= le {
+			s.buckets[i]++
+			break
+		}
+	}
+}
+
+// Writes the request metrics.
+func (this *Requests) write(w *metricWriter) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	names := make([]string, 0, len(this.commands))
+	for name := range this.commands {
+		names = append(names, name)
+	}
+	sort.SortStrings(names)
+
+	w.header("mpd_client_requests_total", "counter", "Requests sent to the server.")
+	for _, name := range names {
+		w.sample("mpd_client_requests_total", float64(this.commands[name].count), "command", name)
+	}
+
+	w.header("mpd_client_request_errors_total", "counter", "Requests which failed.")
+	for _, name := range names {
+		w.sample("mpd_client_request_errors_total", float64(this.commands[name].errors), "command", name)
+	}
+
+	w.header("mpd_client_request_duration_seconds", "histogram", "Time from sending a request until its response was read.")
+	for _, name := range names {
+		s := this.commands[name]
+
+		var total int64
+		for i, le := range LatencyBuckets {
+			total += s.buckets[i]
+			w.sample("mpd_client_request_duration_seconds_bucket", float64(total), "command", name, "le", formatFloat(le))
+		}
+
+		w.sample("mpd_client_request_duration_seconds_bucket", float64(s.count), "command", name, "le", "+Inf")
+		w.sample("mpd_client_request_duration_seconds_sum", s.seconds, "command", name)
+		w.sample("mpd_client_request_duration_seconds_count", float64(s.count), "command", name)
+	}
+}
+
+// Exporter is an http.Handler which serves the metrics described in the
+// package documentation.
+type Exporter struct {
+	Pool     *mpd.Pool
+	Requests *Requests // Request metrics to include, or nil.
+}
+
+func NewExporter(pool *mpd.Pool, requests *Requests) *Exporter {
+	return &Exporter{pool, requests}
+}
+
+// Content types of the formats.
+const (
+	textType        = "text/plain; version=0.0.4; charset=utf-8"
+	openMetricsType = "application/openmetrics-text; version=1.0.0; charset=utf-8"
+)
+
+func (this *Exporter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var buf bytes.Buffer
+	mw := &metricWriter{w: &buf}
+	mw.open = strings.Index(r.Header.Get("Accept"), "application/openmetrics-text") != -1
+
+	// A server which can not be read is reported by mpd_up, so that the
+	// request metrics are still served.
+	up := 1
+	if err := this.scrape(mw); err != nil {
+		up = 0
+	}
+
+	mw.header("mpd_up", "gauge", "Whether the server could be read.")
+	mw.sample("mpd_up", float64(up))
+
+	if this.Requests != nil {
+		this.Requests.write(mw)
+	}
+
+	if mw.open {
+		io.WriteString(&buf, "# EOF\n")
+		w.Header().Set("Content-Type", openMetricsType)
+	} else {
+		w.Header().Set("Content-Type", textType)
+	}
+	w.Write(buf.Bytes())
+}
+
+// Reads the server's statistics and writes them to @w. Nothing is written
+// if any of them can not be read.
+func (this *Exporter) scrape(w *metricWriter) (err os.Error) {
+	var c *mpd.Client
+	if c, err = this.Pool.Get(); err != nil {
+		return
+	}
+
+	var stats *mpd.Stats
+	var status *mpd.Status
+	var outputs []*mpd.Output
+
+	if stats, err = c.Stats(); err == nil {
+		if status, err = c.Status(); err == nil {
+			outputs, err = c.Outputs()
+		}
+	}
+
+	this.Pool.Put(c)
+	if err != nil {
+		return
+	}
+
+	writeStats(w, stats)
+	writeStatus(w, status)
+	writeOutputs(w, outputs)
+	return
+}
+
+func writeStats(w *metricWriter, s *mpd.Stats) {
+	w.gauge("mpd_artists", "Artists in the database.", float64(s.Artists))
+	w.gauge("mpd_albums", "Albums in the database.", float64(s.Albums))
+	w.gauge("mpd_songs", "Songs in the database.", float64(s.Songs))
+
+	w.header("mpd_uptime_seconds_total", "counter", "Time the server has been running.")
+	w.sample("mpd_uptime_seconds_total", float64(s.Uptime))
+
+	w.header("mpd_playtime_seconds_total", "counter", "Time the server has been playing.")
+	w.sample("mpd_playtime_seconds_total", float64(s.Playtime))
+
+	w.gauge("mpd_db_playtime_seconds", "Total length of all songs in the database.", float64(s.DbPlaytime))
+	w.gauge("mpd_db_update_timestamp_seconds", "Unix time of the last database update.", float64(s.DbUpdate))
+}
+
+func writeStatus(w *metricWriter, s *mpd.Status) {
+	if s.Volume >= 0 {
+		w.gauge("mpd_volume_percent", "Volume of the mixer.", float64(s.Volume))
+	}
+
+	w.header("mpd_state", "gauge", "1 for the current state of the player.")
+	for _, state := range []string{"play", "pause", "stop"} {
+		w.sample("mpd_state", float64(btoi(s.State == state)), "state", state)
+	}
+
+	w.gauge("mpd_bitrate_kbps", "Bitrate of the current song.", float64(s.Bitrate))
+	w.gauge("mpd_queue_length", "Songs in the queue.", float64(s.PlaylistLength))
+	w.gauge("mpd_elapsed_seconds", "Position in the current song.", float64(s.Elapsed)/1e9)
+	w.gauge("mpd_duration_seconds", "Length of the current song.", float64(s.Duration)/1e9)
+	w.gauge("mpd_crossfade_seconds", "Crossfade between songs.", float64(s.Crossfade))
+}
+
+func writeOutputs(w *metricWriter, list []*mpd.Output) {
+	enabled := 0
+
+	w.header("mpd_output_enabled", "gauge", "1 for enabled audio outputs.")
+	for _, o := range list {
+		w.sample("mpd_output_enabled", float64(btoi(o.Enabled)), "id", strconv.Itoa(o.Id), "name", o.Name)
+		enabled += btoi(o.Enabled)
+	}
+
+	w.gauge("mpd_outputs_enabled", "Audio outputs which are enabled.", float64(enabled))
+}
+
+// Writes metrics in the Prometheus text format, or in OpenMetrics if open
+// is set.
+type metricWriter struct {
+	w    io.Writer
+	open bool
+}
+
+func (this *metricWriter) header(name, kind, help string) {
+	// OpenMetrics names a counter without its _total suffix.
+	if this.open && kind == "counter" && strings.HasSuffix(name, "_total") {
+		name = name[:len(name)-len("_total")]
+	}
+
+	fmt.Fprintf(this.w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+// Writes a sample. @labels holds pairs of names and values.
+func (this *metricWriter) sample(name string, value float64, labels ...string) {
+	io.WriteString(this.w, name)
+
+	if len(labels) > 0 {
+		list := make([]string, 0, len(labels)/2)
+		for i := 0; i+1 < len(labels); i += 2 {
+			list = append(list, fmt.Sprintf("%s=\"%s\"", labels[i], escapeLabel(labels[i+1])))
+		}
+		fmt.Fprintf(this.w, "{%s}", strings.Join(list, ","))
+	}
+
+	fmt.Fprintf(this.w, " %s\n", formatFloat(value))
+}
+
+func (this *metricWriter) gauge(name, help string, value float64) {
+	this.header(name, "gauge", help)
+	this.sample(name, value)
+}
+
+func escapeLabel(v string) string {
+	v = strings.Replace(v, `\`, `\\`, -1)
+	v = strings.Replace(v, `"`, `\"`, -1)
+	return strings.Replace(v, "\n", `\n`, -1)
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.Ftoa64(v, 'g', -1)
+}
+
+func btoi(v bool) int {
+	if v {
+		return 1
+	}
+	return 0
+}
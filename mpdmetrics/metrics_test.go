@@ -0,0 +1,158 @@
+// Copyright (c) 2010, Jim Teeuwen. All rights reserved.
+// This code is subject to a 1-clause BSD license.
+// See the LICENSE file for its contents.
+
+package mpdmetrics
+
+import (
+	"http"
+	"http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"github.com/jteeuwen/go-pkg-mpd"
+	"github.com/jteeuwen/go-pkg-mpd/mpdtest"
+)
+
+// Starts a fake server with two songs and an output, and returns a
+// configuration which points to it.
+func startServer(t *testing.T) (*mpdtest.Server, *mpd.Config) {
+	srv := mpdtest.NewServer()
+	srv.AddSongs(
+		mpdtest.Song{"file": "rock/acdc/hells_bells.mp3", "Artist": "AC/DC", "Title": "Hells Bells", "Time": "312"},
+		mpdtest.Song{"file": "jazz/davis/so_what.flac", "Artist": "Miles Davis", "Title": "So What", "Time": "562"},
+	)
+	srv.AddOutput("My \"ALSA\" Device", true)
+
+	if err := srv.Listen("tcp", "127.0.0.1:0"); err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+
+	addr := srv.Addr()
+	pos := strings.LastIndex(addr, ":")
+
+	cfg := mpd.NewConfig()
+	cfg.Address = addr[:pos]
+	cfg.Port, _ = strconv.Atoi(addr[pos+1:])
+	return srv, cfg
+}
+
+// Scrapes @e and returns the content type and the metrics.
+func scrape(t *testing.T, e *Exporter, accept string) (string, string) {
+	req, err := http.NewRequest("GET", "/metrics", strings.NewReader(""))
+	if err != nil {
+		t.Fatalf("NewRequest: %v", err)
+	}
+
+	if len(accept) > 0 {
+		req.Header.Set("Accept", accept)
+	}
+
+	w := httptest.NewRecorder()
+	e.ServeHTTP(w, req)
+	return w.Header().Get("Content-Type"), w.Body.String()
+}
+
+func expectLines(t *testing.T, metrics string, want ...string) {
+	lines := strings.Split(metrics, "\n", -1)
+
+next:
+	for _, w := range want {
+		for _, line := range lines {
+			if line == w {
+				continue next
+			}
+		}
+		t.Errorf("Expected line %s in:\n%s", w, metrics)
+	}
+}
+
+func TestExporter(t *testing.T) {
+	srv, cfg := startServer(t)
+	defer srv.Close()
+
+	requests := NewRequests()
+	cfg.Observer = requests
+
+	pool := mpd.NewPool(cfg, 1)
+	defer pool.Close()
+
+	c, err := mpd.Dial(cfg)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer c.Close()
+
+	if err = c.ExecuteList("status", "bogus"); err == nil {
+		t.Fatalf("Expected the command list to fail.")
+	}
+
+	e := NewExporter(pool, requests)
+	ct, metrics := scrape(t, e, "")
+
+	if ct != textType {
+		t.Errorf("Unexpected content type %q.", ct)
+	}
+
+	expectLines(t, metrics,
+		"mpd_up 1",
+		"mpd_songs 2",
+		"mpd_artists 2",
+		"mpd_db_playtime_seconds 874",
+		"# TYPE mpd_uptime_seconds_total counter",
+		"mpd_uptime_seconds_total 100",
+		`mpd_state{state="play"} 0`,
+		`mpd_state{state="stop"} 1`,
+		"mpd_queue_length 0",
+		`mpd_output_enabled{id="0",name="My \"ALSA\" Device"} 1`,
+		"mpd_outputs_enabled 1",
+		`mpd_client_requests_total{command="command_list"} 1`,
+		`mpd_client_request_errors_total{command="command_list"} 1`,
+		`mpd_client_requests_total{command="stats"} 1`,
+		`mpd_client_request_errors_total{command="stats"} 0`,
+		`mpd_client_request_duration_seconds_bucket{command="stats",le="+Inf"} 1`,
+		`mpd_client_request_duration_seconds_count{command="stats"} 1`,
+	)
+
+	if strings.Index(metrics, `command="idle"`) != -1 || strings.Index(metrics, "# EOF") != -1 {
+		t.Errorf("Unexpected metrics:\n%s", metrics)
+	}
+
+	ct, metrics = scrape(t, e, "application/openmetrics-text; version=1.0.0")
+	if ct != openMetricsType {
+		t.Errorf("Unexpected content type %q.", ct)
+	}
+
+	expectLines(t, metrics,
+		"# TYPE mpd_uptime_seconds counter",
+		"mpd_uptime_seconds_total 100",
+		`mpd_client_requests_total{command="stats"} 2`,
+	)
+
+	if !strings.HasSuffix(metrics, "\n# EOF\n") {
+		t.Errorf("Expected OpenMetrics to end with # EOF.")
+	}
+}
+
+func TestExporterDown(t *testing.T) {
+	srv, cfg := startServer(t)
+	srv.Close()
+
+	requests := NewRequests()
+	requests.ObserveRequest("status", 2e6, nil)
+
+	e := NewExporter(mpd.NewPool(cfg, 1), requests)
+	_, metrics := scrape(t, e, "")
+
+	expectLines(t, metrics,
+		"mpd_up 0",
+		`mpd_client_request_duration_seconds_bucket{command="status",le="0.001"} 0`,
+		`mpd_client_request_duration_seconds_bucket{command="status",le="0.0025"} 1`,
+		`mpd_client_request_duration_seconds_bucket{command="status",le="2.5"} 1`,
+		`mpd_client_request_duration_seconds_sum{command="status"} 0.002`,
+	)
+
+	if strings.Index(metrics, "mpd_songs") != -1 {
+		t.Errorf("Expected no server metrics:\n%s", metrics)
+	}
+}
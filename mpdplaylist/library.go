@@ -0,0 +1,254 @@
+// Copyright (c) 2010, Jim Teeuwen. All rights reserved.
+// This code is subject to a 1-clause BSD license.
+// See the LICENSE file for its contents.
+
+package mpdplaylist
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"github.com/jteeuwen/go-pkg-mpd"
+)
+
+func init() {
+	formats := mpd.EnumKind(Formats...)
+
+	for _, cmd := range []*mpd.Command{
+		&mpd.Command{
+			Name: "plexport",
+			Desc: "Writes the queue, or the stored playlist @name, to a playlist @file. The format follows from the file's extension unless @format is given. With @root, the music directory, songs are written as absolute paths.",
+			Params: []*mpd.Param{
+				&mpd.Param{Name: "file", Desc: "The file to write, or - for standard output.", Kind: mpd.TextKind},
+				&mpd.Param{Name: "name", Desc: "A stored playlist. Defaults to the queue.", Kind: mpd.TextKind, Optional: true},
+				&mpd.Param{Name: "format", Desc: "The format to write.", Kind: formats, Optional: true},
+				&mpd.Param{Name: "root", Desc: "The music directory.", Kind: mpd.TextKind, Optional: true},
+			},
+			Exec: plexport,
+		},
+		&mpd.Command{
+			Name: "plimport",
+			Desc: "Adds the songs of a playlist @file to the queue, or to the stored playlist @name. Entries which are not in the library are reported. With @root, the music directory, relative paths are taken relative to the file, and absolute paths below @root are accepted. Without it, paths are taken relative to the music directory.",
+			Params: []*mpd.Param{
+				&mpd.Param{Name: "file", Desc: "The file to read, or - for standard input.", Kind: mpd.TextKind},
+				&mpd.Param{Name: "name", Desc: "A stored playlist. Defaults to the queue.", Kind: mpd.TextKind, Optional: true},
+				&mpd.Param{Name: "format", Desc: "The format to read.", Kind: formats, Optional: true},
+				&mpd.Param{Name: "root", Desc: "The music directory.", Kind: mpd.TextKind, Optional: true},
+			},
+			Exec: plimport,
+		},
+	} {
+		cmd.Group = mpd.GroupPlaylist
+		if err := mpd.Register(cmd); err != nil {
+			panic(err.String())
+		}
+	}
+}
+
+// Returns the songs of the stored playlist @name, or of the queue if @name
+// is empty. With @root set to the music directory, songs in the library
+// get their absolute path as location.
+func Export(c *mpd.Client, name, root string) (list []*Entry, err os.Error) {
+	var songs []mpd.Args
+	if len(name) == 0 {
+		songs, err = c.PlaylistInfo()
+	} else {
+		songs, err = c.ListPlaylistInfo(name)
+	}
+
+	if err != nil {
+		return
+	}
+
+	list = make([]*Entry, len(songs))
+	for i, s := range songs {
+		e := &Entry{s["file"], s["Artist"], s["Title"], s["Album"], s.Int("Time", -1)}
+
+		if len(root) > 0 && strings.Index(e.Location, "://") == -1 {
+			e.Location = path.Join(root, e.Location)
+		}
+		list[i] = e
+	}
+	return
+}
+
+// Resolver turns the locations in a playlist file into library URIs.
+type Resolver struct {
+	// The music directory. Absolute paths, and file:// URIs, are resolved
+	// if they are below it.
+	Root string
+
+	// The directory of the playlist file, which relative paths are taken
+	// relative to. If it is relative itself, or empty, it is taken
+	// relative to the music directory.
+	Base string
+}
+
+// Returns the library URI of @location. URLs are returned as they are.
+func (this *Resolver) Resolve(location string) (uri string, ok bool) {
+	loc := strings.TrimSpace(location)
+
+	switch {
+	case len(loc) == 0:
+		return "", false
+	case strings.HasPrefix(loc, "file://"):
+		// Only local files: file:///path or file://localhost/path.
+		if loc = loc[7:]; strings.HasPrefix(loc, "localhost/") {
+			loc = loc[9:]
+		}
+
+		if !strings.HasPrefix(loc, "/") {
+			return "", false
+		}
+		loc = unescapePath(loc)
+	case strings.Index(loc, "://") != -1:
+		return loc, true
+	}
+
+	if !path.IsAbs(loc) {
+		loc = path.Join(this.Base, loc)
+	}
+
+	if path.IsAbs(loc) {
+		root := path.Clean(this.Root)
+		if len(this.Root) == 0 || !strings.HasPrefix(loc, root+"/") {
+			return "", false
+		}
+		loc = loc[len(root)+1:]
+	}
+
+	if loc = path.Clean(loc); loc == ".." || strings.HasPrefix(loc, "../") {
+		return "", false
+	}
+	return loc, true
+}
+
+// Adds the entries of @list to the stored playlist @name, or to the queue
+// if @name is empty, in order. Entries which can not be resolved, or which
+// are not in the library, are skipped and returned in @unresolved.
+func Import(c *mpd.Client, list []*Entry, r *Resolver, name string) (added int, unresolved []*Entry, err os.Error) {
+	unresolved = make([]*Entry, 0)
+
+	for _, e := range list {
+		uri, ok := r.Resolve(e.Location)
+		if !ok {
+			unresolved = append(unresolved, e)
+			continue
+		}
+
+		if len(name) == 0 {
+			err = c.Add(uri)
+		} else {
+			err = c.PlaylistAdd(name, uri)
+		}
+
+		if ack, ok := err.(*mpd.AckError); ok && ack.Code == mpd.ErrNoExist {
+			unresolved = append(unresolved, e)
+			continue
+		}
+
+		if err != nil {
+			return
+		}
+		added++
+	}
+	return
+}
+
+// Returns the format given to @cmd, or the one of its file.
+func commandFormat(cmd *mpd.Command, def string) (format string, err os.Error) {
+	file := cmd.S("file", "-")
+	if format = cmd.S("format", FormatOf(file)); len(format) > 0 {
+		return
+	}
+
+	if file == "-" {
+		return def, nil
+	}
+	return "", os.NewError(fmt.Sprintf("Can not tell the format of '%s'. Use --format.", file))
+}
+
+func plexport(cmd *mpd.Command, c *mpd.Client) (err os.Error) {
+	var format string
+	if format, err = commandFormat(cmd, ExtM3U); err != nil {
+		return
+	}
+
+	var list []*Entry
+	if list, err = Export(c, cmd.S("name", ""), cmd.S("root", "")); err != nil {
+		return
+	}
+
+	file := cmd.S("file", "-")
+	if file == "-" {
+		return Write(cmd.Output, format, list)
+	}
+
+	var fd *os.File
+	if fd, err = os.Open(file, os.O_WRONLY|os.O_CREAT|os.O_TRUNC, 0644); err != nil {
+		return
+	}
+
+	err = Write(fd, format, list)
+	if e := fd.Close(); err == nil {
+		err = e
+	}
+
+	if err != nil {
+		return
+	}
+
+	cmd.Printf("Wrote %d songs to %s.\n", len(list), file)
+	return
+}
+
+func plimport(cmd *mpd.Command, c *mpd.Client) (err os.Error) {
+	var format string
+	if format, err = commandFormat(cmd, ExtM3U); err != nil {
+		return
+	}
+
+	file := cmd.S("file", "-")
+	r := &Resolver{Root: cmd.S("root", "")}
+
+	var in io.Reader = os.Stdin
+	if file != "-" {
+		var fd *os.File
+		if fd, err = os.Open(file, os.O_RDONLY, 0); err != nil {
+			return
+		}
+
+		defer fd.Close()
+		in = fd
+
+		// Without the music directory, relative paths can only be taken
+		// relative to it.
+		if len(r.Root) > 0 {
+			if r.Base, _ = path.Split(file); !path.IsAbs(r.Base) {
+				var wd string
+				if wd, err = os.Getwd(); err != nil {
+					return
+				}
+				r.Base = path.Join(wd, r.Base)
+			}
+		}
+	}
+
+	var list []*Entry
+	if list, err = Read(in, format); err != nil {
+		return
+	}
+
+	added, unresolved, err := Import(c, list, r, cmd.S("name", ""))
+	for _, e := range unresolved {
+		fields := []mpd.Pair{mpd.Pair{"unresolved", e.Location}}
+		if rerr := cmd.Record(fields, "Not found: "+e.Location); rerr != nil {
+			return rerr
+		}
+	}
+
+	cmd.Printf("Added %d of %d songs.\n", added, len(list))
+	return
+}
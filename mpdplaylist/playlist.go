@@ -0,0 +1,413 @@
+// Copyright (c) 2010, Jim Teeuwen. All rights reserved.
+// This code is subject to a 1-clause BSD license.
+// See the LICENSE file for its contents.
+
+// Package mpdplaylist reads and writes playlist files in the M3U, extended
+// M3U, PLS and XSPF formats. Export turns the queue or a stored playlist
+// into such a file, and Import adds the songs of a file back to the queue
+// or a stored playlist.
+//
+// Importing the package adds the plexport and plimport commands.
+package mpdplaylist
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"xml"
+)
+
+// An entry of a playlist file.
+type Entry struct {
+	Location string // Library URI, file system path or URL.
+	Artist   string
+	Title    string
+	Album    string
+	Duration int // Length in seconds, or -1 if unknown.
+}
+
+// Returns the entry's name as 'Artist - Title', the title alone, or an
+// empty string if it has no title.
+func (this *Entry) Name() string {
+	switch {
+	case len(this.Title) == 0:
+		return ""
+	case len(this.Artist) == 0:
+		return this.Title
+	}
+	return this.Artist + " - " + this.Title
+}
+
+// Sets the artist and title from a name written by Name. Without a ' - ',
+// the whole name is taken as the title.
+func (this *Entry) setName(name string) {
+	if pos := strings.Index(name, " - "); pos != -1 {
+		this.Artist, this.Title = name[:pos], name[pos+3:]
+	} else {
+		this.Title = name
+	}
+}
+
+// Names of the supported formats. M3U holds only the locations. Extended
+// M3U adds the name and length of each entry, and M3U8 is extended M3U
+// which is always encoded in UTF-8.
+const (
+	M3U    = "m3u"
+	ExtM3U = "extm3u"
+	M3U8   = "m3u8"
+	PLS    = "pls"
+	XSPF   = "xspf"
+)
+
+var Formats = []string{M3U, ExtM3U, M3U8, PLS, XSPF}
+
+// Returns the format of a file named @file, judging by its extension, or
+// an empty string if it is not known. Files ending in .m3u are written as
+// extended M3U, which players of plain M3U read as well.
+func FormatOf(file string) string {
+	switch strings.ToLower(path.Ext(file)) {
+	case ".m3u":
+		return ExtM3U
+	case ".m3u8":
+		return M3U8
+	case ".pls":
+		return PLS
+	case ".xspf":
+		return XSPF
+	}
+	return ""
+}
+
+func unknownFormat(format string) os.Error {
+	return os.NewError(fmt.Sprintf("Unknown playlist format '%s'. Use one of %s.",
+		format, strings.Join(Formats, ", ")))
+}
+
+// Writes @list to @w in @format.
+func Write(w io.Writer, format string, list []*Entry) (err os.Error) {
+	bw := bufio.NewWriter(w)
+
+	switch format {
+	case M3U:
+		for _, e := range list {
+			fmt.Fprintf(bw, "%s\n", e.Location)
+		}
+
+	case ExtM3U, M3U8:
+		writeExtM3U(bw, list)
+
+	case PLS:
+		writePLS(bw, list)
+
+	case XSPF:
+		writeXSPF(bw, list)
+
+	default:
+		return unknownFormat(format)
+	}
+	return bw.Flush()
+}
+
+func writeExtM3U(w io.Writer, list []*Entry) {
+	fmt.Fprintf(w, "#EXTM3U\n")
+
+	for _, e := range list {
+		if name := e.Name(); len(name) > 0 || e.Duration >= 0 {
+			fmt.Fprintf(w, "#EXTINF:%d,%s\n", e.Duration, name)
+		}
+		fmt.Fprintf(w, "%s\n", e.Location)
+	}
+}
+
+func writePLS(w io.Writer, list []*Entry) {
+	fmt.Fprintf(w, "[playlist]\n")
+
+	for i, e := range list {
+		fmt.Fprintf(w, "File%d=%s\n", i+1, e.Location)
+		if name := e.Name(); len(name) > 0 {
+			fmt.Fprintf(w, "Title%d=%s\n", i+1, name)
+		}
+		fmt.Fprintf(w, "Length%d=%d\n", i+1, e.Duration)
+	}
+
+	fmt.Fprintf(w, "NumberOfEntries=%d\nVersion=2\n", len(list))
+}
+
+func writeXSPF(w io.Writer, list []*Entry) {
+	fmt.Fprintf(w, "<?xml version=\"1.0\" encoding=\"UTF-8\"?>\n")
+	fmt.Fprintf(w, "<playlist version=\"1\" xmlns=\"http://xspf.org/ns/0/\">\n  <trackList>\n")
+
+	for _, e := range list {
+		fmt.Fprintf(w, "    <track>\n      <location>%s</location>\n", escapeXML(locationURI(e.Location)))
+
+		fields := []string{"creator", e.Artist, "title", e.Title, "album", e.Album}
+		for i := 0; i < len(fields); i += 2 {
+			if len(fields[i+1]) > 0 {
+				fmt.Fprintf(w, "      <%s>%s</%s>\n", fields[i], escapeXML(fields[i+1]), fields[i])
+			}
+		}
+
+		if e.Duration >= 0 {
+			fmt.Fprintf(w, "      <duration>%d</duration>\n", e.Duration*1000)
+		}
+		fmt.Fprintf(w, "    </track>\n")
+	}
+
+	fmt.Fprintf(w, "  </trackList>\n</playlist>\n")
+}
+
+// Reads a playlist in @format from @r.
+func Read(r io.Reader, format string) (list []*Entry, err os.Error) {
+	switch format {
+	case M3U, ExtM3U, M3U8:
+		return readM3U(r)
+	case PLS:
+		return readPLS(r)
+	case XSPF:
+		return readXSPF(r)
+	}
+	return nil, unknownFormat(format)
+}
+
+// Calls @f with each line of @r which is not empty, without surrounding
+// white space.
+func eachLine(r io.Reader, f func(line string) os.Error) (err os.Error) {
+	reader := bufio.NewReader(r)
+
+	for {
+		var line string
+		if line, err = reader.ReadString('\n'); err != nil && err != os.EOF {
+			return
+		}
+
+		eof := err == os.EOF
+		if line = strings.TrimSpace(line); len(line) > 0 {
+			if err = f(line); err != nil {
+				return
+			}
+		}
+
+		if eof {
+			return nil
+		}
+	}
+	return
+}
+
+// Reads plain and extended M3U. Comments other than #EXTINF are ignored.
+func readM3U(r io.Reader) (list []*Entry, err os.Error) {
+	list = make([]*Entry, 0)
+	e := &Entry{Duration: -1}
+
+	err = eachLine(r, func(line string) os.Error {
+		// A byte order mark, which some programs write in M3U8 files.
+		line = strings.TrimLeft(line, "\ufeff")
+
+		if strings.HasPrefix(line, "#EXTINF:") {
+			info := strings.Split(line[8:], ",", 2)
+			if d, err := strconv.Atoi(strings.TrimSpace(info[0])); err == nil {
+				e.Duration = d
+			}
+
+			if len(info) == 2 {
+				e.setName(strings.TrimSpace(info[1]))
+			}
+			return nil
+		}
+
+		if !strings.HasPrefix(line, "#") {
+			e.Location = line
+			list = append(list, e)
+			e = &Entry{Duration: -1}
+		}
+		return nil
+	})
+	return
+}
+
+func readPLS(r io.Reader) (list []*Entry, err os.Error) {
+	entries := make(map[int]*Entry)
+	max := 0
+
+	err = eachLine(r, func(line string) os.Error {
+		pos := strings.Index(line, "=")
+		if pos == -1 {
+			return nil
+		}
+
+		key, value := strings.ToLower(line[:pos]), line[pos+1:]
+
+		var field string
+		for _, f := range []string{"file", "title", "length"} {
+			if strings.HasPrefix(key, f) {
+				field = f
+			}
+		}
+
+		n, err := strconv.Atoi(key[len(field):])
+		if len(field) == 0 || err != nil || n < 1 {
+			return nil
+		}
+
+		e, ok := entries[n]
+		if !ok {
+			e = &Entry{Duration: -1}
+			entries[n] = e
+		}
+
+		if n > max {
+			max = n
+		}
+
+		switch field {
+		case "file":
+			e.Location = value
+		case "title":
+			e.setName(value)
+		case "length":
+			if d, err := strconv.Atoi(value); err == nil {
+				e.Duration = d
+			}
+		}
+		return nil
+	})
+
+	if err != nil {
+		return
+	}
+
+	list = make([]*Entry, 0, len(entries))
+	for n := 1; n <= max; n++ {
+		if e, ok := entries[n]; ok && len(e.Location) > 0 {
+			list = append(list, e)
+		}
+	}
+	return
+}
+
+func readXSPF(r io.Reader) (list []*Entry, err os.Error) {
+	p := xml.NewParser(r)
+	list = make([]*Entry, 0)
+
+	var e *Entry
+	var field string
+
+	for {
+		var tok xml.Token
+		if tok, err = p.Token(); err != nil {
+			if err == os.EOF {
+				err = nil
+			}
+			return
+		}
+
+		switch t := tok.(type) {
+		case xml.StartElement:
+			switch {
+			case t.Name.Local == "track":
+				e = &Entry{Duration: -1}
+			case e != nil:
+				field = t.Name.Local
+			}
+
+		case xml.EndElement:
+			if t.Name.Local == "track" && e != nil {
+				if len(e.Location) > 0 {
+					list = append(list, e)
+				}
+				e = nil
+			}
+			field = ""
+
+		case xml.CharData:
+			if e == nil {
+				continue
+			}
+
+			v := strings.TrimSpace(string(t))
+			switch field {
+			case "location":
+				e.Location = locationPath(v)
+			case "creator":
+				e.Artist = v
+			case "title":
+				e.Title = v
+			case "album":
+				e.Album = v
+			case "duration":
+				if ms, err := strconv.Atoi(v); err == nil {
+					e.Duration = ms / 1000
+				}
+			}
+		}
+	}
+	return
+}
+
+func escapeXML(v string) string {
+	v = strings.Replace(v, "&", "&amp;", -1)
+	v = strings.Replace(v, "<", "&lt;", -1)
+	v = strings.Replace(v, ">", "&gt;", -1)
+	return strings.Replace(v, "\"", "&quot;", -1)
+}
+
+// Returns the URI of a location for XSPF: URLs stay as they are, absolute
+// paths become file:// URIs and relative paths are escaped.
+func locationURI(loc string) string {
+	switch {
+	case strings.Index(loc, "://") != -1:
+		return loc
+	case strings.HasPrefix(loc, "/"):
+		return "file://" + escapePath(loc)
+	}
+	return escapePath(loc)
+}
+
+// Undoes locationURI.
+func locationPath(uri string) string {
+	switch {
+	case strings.HasPrefix(uri, "file:///"):
+		return unescapePath(uri[7:])
+	case strings.Index(uri, "://") != -1:
+		return uri
+	}
+	return unescapePath(uri)
+}
+
+// Escapes the bytes of @p which are not allowed in the path of a URI.
+func escapePath(p string) string {
+	const hex = "0123456789ABCDEF"
+	buf := make([]byte, 0, len(p))
+
+	for i := 0; i < len(p); i++ {
+		switch c := p[i]; {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9',
+			strings.IndexRune("/-._~!$&'()*+,;=:@", int(c)) != -1:
+			buf = append(buf, c)
+		default:
+			buf = append(buf, '%', hex[c>>4], hex[c&15])
+		}
+	}
+	return string(buf)
+}
+
+// Undoes escapePath. Invalid escapes are kept as they are.
+func unescapePath(p string) string {
+	buf := make([]byte, 0, len(p))
+
+	for i := 0; i < len(p); i++ {
+		if p[i] == '%' && i+2 < len(p) {
+			if v, err := strconv.Btoui64(p[i+1:i+3], 16); err == nil {
+				buf = append(buf, byte(v))
+				i += 2
+				continue
+			}
+		}
+		buf = append(buf, p[i])
+	}
+	return string(buf)
+}
@@ -0,0 +1,253 @@
+// Copyright (c) 2010, Jim Teeuwen. All rights reserved.
+// This code is subject to a 1-clause BSD license.
+// See the LICENSE file for its contents.
+
+package mpdplaylist
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+	"testing"
+	"github.com/jteeuwen/go-pkg-mpd"
+	"github.com/jteeuwen/go-pkg-mpd/mpdtest"
+)
+
+var testEntries = []*Entry{
+	&Entry{"rock/acdc/hells_bells.mp3", "AC/DC", "Hells Bells", "Back in Black", 312},
+	&Entry{"jazz/Miles Davis/so what?.flac", "", "So What & More", "", -1},
+	&Entry{"http://radio.example.com/stream", "", "", "", -1},
+}
+
+func TestWrite(t *testing.T) {
+	tests := map[string]string{
+		M3U: "rock/acdc/hells_bells.mp3\njazz/Miles Davis/so what?.flac\nhttp://radio.example.com/stream\n",
+
+		ExtM3U: "#EXTM3U\n#EXTINF:312,AC/DC - Hells Bells\nrock/acdc/hells_bells.mp3\n" +
+			"#EXTINF:-1,So What & More\njazz/Miles Davis/so what?.flac\nhttp://radio.example.com/stream\n",
+
+		PLS: "[playlist]\nFile1=rock/acdc/hells_bells.mp3\nTitle1=AC/DC - Hells Bells\nLength1=312\n" +
+			"File2=jazz/Miles Davis/so what?.flac\nTitle2=So What & More\nLength2=-1\n" +
+			"File3=http://radio.example.com/stream\nLength3=-1\nNumberOfEntries=3\nVersion=2\n",
+	}
+
+	for format, want := range tests {
+		var buf bytes.Buffer
+		if err := Write(&buf, format, testEntries); err != nil {
+			t.Errorf("%s: %v", format, err)
+			continue
+		}
+
+		if buf.String() != want {
+			t.Errorf("%s: Expected:\n%s\ngot:\n%s", format, want, buf.String())
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := Write(&buf, XSPF, testEntries); err != nil {
+		t.Fatalf("xspf: %v", err)
+	}
+
+	for _, want := range []string{
+		"<location>jazz/Miles%20Davis/so%20what%3F.flac</location>",
+		"<title>So What &amp; More</title>",
+		"<creator>AC/DC</creator>",
+		"<duration>312000</duration>",
+	} {
+		if strings.Index(buf.String(), want) == -1 {
+			t.Errorf("xspf: Expected %s in:\n%s", want, buf.String())
+		}
+	}
+
+	if err := Write(&buf, "wpl", testEntries); err == nil {
+		t.Errorf("Expected an error for an unknown format.")
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	for _, format := range []string{ExtM3U, M3U8, PLS, XSPF} {
+		var buf bytes.Buffer
+		if err := Write(&buf, format, testEntries); err != nil {
+			t.Fatalf("%s: %v", format, err)
+		}
+
+		list, err := Read(&buf, format)
+		if err != nil {
+			t.Errorf("%s: %v", format, err)
+			continue
+		}
+
+		if len(list) != len(testEntries) {
+			t.Errorf("%s: Expected %d entries, got %d.", format, len(testEntries), len(list))
+			continue
+		}
+
+		for i, e := range list {
+			want := *testEntries[i]
+			if format != XSPF {
+				want.Album = "" // Only XSPF keeps the album.
+			}
+
+			if *e != want {
+				t.Errorf("%s: Expected %v, got %v.", format, want, *e)
+			}
+		}
+	}
+}
+
+// A playlist file and the entries it should give, as printed by fmt.
+type readTest struct {
+	format string
+	data   string
+	want   string
+}
+
+func TestRead(t *testing.T) {
+	tests := []readTest{
+		readTest{M3U8, "\ufeff#EXTM3U\r\n# A comment\r\n\r\n#EXTINF:255,AC/DC - Back in Black\r\n../rock/back_in_black.mp3\r\n/music/x.mp3",
+			"[{../rock/back_in_black.mp3 AC/DC Back in Black  255} {/music/x.mp3    -1}]"},
+		readTest{PLS, "[playlist]\nnumberofentries=2\nfile2=b.mp3\ntitle2=B\nFile1=a.mp3\nLength1=10\n",
+			"[{a.mp3    10} {b.mp3  B  -1}]"},
+		readTest{XSPF, `<?xml version="1.0"?><playlist xmlns="http://xspf.org/ns/0/"><title>Mix</title><trackList>
+			<track><location>file:///music/a%20b.mp3</location><title>A</title><duration>1500</duration></track>
+			<track><title>No location</title></track></trackList></playlist>`,
+			"[{/music/a b.mp3  A  1}]"},
+	}
+
+	for _, test := range tests {
+		list, err := Read(strings.NewReader(test.data), test.format)
+		if err != nil {
+			t.Errorf("%s: %v", test.format, err)
+			continue
+		}
+
+		entries := make([]Entry, len(list))
+		for i, e := range list {
+			entries[i] = *e
+		}
+
+		if got := fmt.Sprint(entries); got != test.want {
+			t.Errorf("%s: Expected %s, got %s.", test.format, test.want, got)
+		}
+	}
+}
+
+func TestResolve(t *testing.T) {
+	r := &Resolver{Root: "/music/", Base: "/music/lists"}
+
+	tests := map[string]string{
+		"../rock/a.mp3":                "rock/a.mp3",
+		"b.mp3":                        "lists/b.mp3",
+		"/music/jazz/c.flac":           "jazz/c.flac",
+		"file:///music/jazz/a%20b.mp3": "jazz/a b.mp3",
+		"file://localhost/music/d.mp3": "d.mp3",
+		"http://radio.example.com/":    "http://radio.example.com/",
+		"/home/me/e.mp3":               "",
+		"../../f.mp3":                  "",
+		"file://server/music/g.mp3":    "",
+		"/musicx/h.mp3":                "",
+	}
+
+	for loc, want := range tests {
+		if uri, ok := r.Resolve(loc); uri != want || ok != (len(want) > 0) {
+			t.Errorf("%s: Expected %q, got %q.", loc, want, uri)
+		}
+	}
+
+	// Without a music directory, paths are relative to it.
+	r = new(Resolver)
+	if uri, ok := r.Resolve("rock/./a.mp3"); !ok || uri != "rock/a.mp3" {
+		t.Errorf("Expected rock/a.mp3, got %q.", uri)
+	}
+
+	if _, ok := r.Resolve("/music/a.mp3"); ok {
+		t.Errorf("Expected absolute paths to need a music directory.")
+	}
+}
+
+func TestExportImport(t *testing.T) {
+	srv := mpdtest.NewServer()
+	srv.AddSongs(
+		mpdtest.Song{"file": "rock/acdc/hells_bells.mp3", "Artist": "AC/DC", "Title": "Hells Bells", "Time": "312"},
+		mpdtest.Song{"file": "jazz/davis/so_what.flac", "Artist": "Miles Davis", "Title": "So What", "Time": "562"},
+	)
+
+	if err := srv.Listen("tcp", "127.0.0.1:0"); err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	defer srv.Close()
+
+	addr := srv.Addr()
+	pos := strings.LastIndex(addr, ":")
+
+	cfg := mpd.NewConfig()
+	cfg.Address = addr[:pos]
+	cfg.Port, _ = strconv.Atoi(addr[pos+1:])
+
+	c, err := mpd.Dial(cfg)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer c.Close()
+
+	srv.Enqueue("jazz/davis/so_what.flac", "rock/acdc/hells_bells.mp3")
+
+	list, err := Export(c, "", "/music")
+	if err != nil {
+		t.Fatalf("Export: %v", err)
+	}
+
+	if len(list) != 2 || list[0].Location != "/music/jazz/davis/so_what.flac" || list[0].Duration != 562 || list[1].Title != "Hells Bells" {
+		t.Fatalf("Unexpected export %v.", list)
+	}
+
+	list = append(list, &Entry{"/music/missing.mp3", "", "", "", -1}, &Entry{"/elsewhere/x.mp3", "", "", "", -1})
+
+	added, unresolved, err := Import(c, list, &Resolver{Root: "/music"}, "copy")
+	if err != nil {
+		t.Fatalf("Import: %v", err)
+	}
+
+	if added != 2 || len(unresolved) != 2 || unresolved[0].Location != "/music/missing.mp3" {
+		t.Errorf("Unexpected import: %d added, unresolved %v.", added, unresolved)
+	}
+
+	songs, err := c.ListPlaylistInfo("copy")
+	if err != nil {
+		t.Fatalf("ListPlaylistInfo: %v", err)
+	}
+
+	if len(songs) != 2 || songs[0]["file"] != "jazz/davis/so_what.flac" || songs[1]["file"] != "rock/acdc/hells_bells.mp3" {
+		t.Errorf("Unexpected playlist %v.", songs)
+	}
+
+	cmd := mpd.CreateCommand("plexport")
+	cmd.Output = new(bytes.Buffer)
+	if err = cmd.Parse([]string{"-", "copy", "--format=m3u"}); err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+
+	if err = cmd.Execute(c); err != nil {
+		t.Fatalf("plexport: %v", err)
+	}
+
+	if got := cmd.Output.(*bytes.Buffer).String(); got != "jazz/davis/so_what.flac\nrock/acdc/hells_bells.mp3\n" {
+		t.Errorf("Unexpected plexport output %q.", got)
+	}
+
+	// A file which can not be written is reported as such.
+	cmd = mpd.CreateCommand("plexport")
+	cmd.Output = new(bytes.Buffer)
+	if err = cmd.Parse([]string{"/dev/full", "copy", "--format=m3u"}); err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+
+	if err = cmd.Execute(c); err == nil {
+		t.Errorf("Expected an error writing to /dev/full.")
+	}
+
+	if got := cmd.Output.(*bytes.Buffer).String(); len(got) > 0 {
+		t.Errorf("Unexpected plexport output %q.", got)
+	}
+}
@@ -0,0 +1,1943 @@
+// Copyright (c) 2010, Jim Teeuwen. All rights reserved.
+// This code is subject to a 1-clause BSD license.
+// See the LICENSE file for its contents.
+
+package mpdtest
+
+import (
+	"fmt"
+	"rand"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Handlers installed on every new server. They operate on the in-memory
+// state and mimic the responses and errors of a real MPD.
+var defaultHandlers = map[string]HandlerFunc{
+	// Status and informational commands.
+	"ping":          handlePing,
+	"password":      handlePassword,
+	"status":        handleStatus,
+	"stats":         handleStats,
+	"currentsong":   handleCurrentSong,
+	"outputs":       handleOutputs,
+	"enableoutput":  handleEnableOutput,
+	"disableoutput": handleDisableOutput,
+	"toggleoutput":  handleToggleOutput,
+	"commands":      handleCommands,
+	"notcommands":   handlePing,
+	"tagtypes":      handleTagTypes,
+	"urlhandlers":   handleUrlHandlers,
+	"decoders":      handleDecoders,
+	"config":        handleConfig,
+	"kill":          handlePing,
+	"update":        handleUpdate,
+	"rescan":        handleUpdate,
+
+	// Music database.
+	"find":           handleFind,
+	"search":         handleSearch,
+	"count":          handleCount,
+	"list":           handleList,
+	"listall":        handleListAll,
+	"listallinfo":    handleListAllInfo,
+	"lsinfo":         handleLsInfo,
+	"listfiles":      handleListFiles,
+	"readcomments":   handleReadComments,
+	"getfingerprint": handleFingerprint,
+
+	// Queue.
+	"add":            handleAdd,
+	"addid":          handleAddId,
+	"clear":          handleClear,
+	"delete":         handleDelete,
+	"deleteid":       handleDeleteId,
+	"move":           handleMove,
+	"moveid":         handleMoveId,
+	"swap":           handleSwap,
+	"swapid":         handleSwapId,
+	"shuffle":        handleShuffle,
+	"playlistinfo":   handlePlaylistInfo,
+	"playlistid":     handlePlaylistId,
+	"plchanges":      handlePlChanges,
+	"plchangesposid": handlePlChangesPosId,
+	"playlistsearch": handlePlaylistSearch,
+	"playlistfind":   handlePlaylistFind,
+
+	// Stored playlists.
+	"listplaylists":    handleListPlaylists,
+	"listplaylist":     handleListPlaylist,
+	"listplaylistinfo": handleListPlaylistInfo,
+	"load":             handleLoad,
+	"save":             handleSave,
+	"rm":               handleRm,
+	"rename":           handleRename,
+	"playlistadd":      handlePlaylistAdd,
+	"playlistclear":    handlePlaylistClear,
+	"playlistdelete":   handlePlaylistDelete,
+	"playlistmove":     handlePlaylistMove,
+
+	// Stickers.
+	"sticker": handleSticker,
+
+	// Playback.
+	"play":               handlePlay,
+	"playid":             handlePlayId,
+	"pause":              handlePause,
+	"stop":               handleStop,
+	"next":               handleNext,
+	"previous":           handlePrevious,
+	"seek":               handleSeek,
+	"seekid":             handleSeekId,
+	"seekcur":            handleSeekCur,
+	"setvol":             handleSetVol,
+	"random":             handleRandom,
+	"repeat":             handleRepeat,
+	"single":             handleSingle,
+	"consume":            handleConsume,
+	"crossfade":          handleCrossfade,
+	"mixrampdb":          handleMixRampDb,
+	"mixrampdelay":       handleMixRampDelay,
+	"replay_gain_mode":   handleReplayGainMode,
+	"replay_gain_status": handleReplayGainStatus,
+}
+
+/* Argument helpers */
+
+func checkArgs(args []string, min, max int) *Ack {
+	if len(args) < min || (max >= 0 && len(args) > max) {
+		return NewAck(ErrArg, "wrong number of arguments")
+	}
+	return nil
+}
+
+func parseInt(v string) (int, *Ack) {
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return 0, NewAck(ErrArg, "Integer expected: %s", v)
+	}
+	return n, nil
+}
+
+func parseBool(v string) (bool, *Ack) {
+	switch v {
+	case "0":
+		return false, nil
+	case "1":
+		return true, nil
+	}
+	return false, NewAck(ErrArg, "Boolean (0/1) expected: %s", v)
+}
+
+func parseFloat(v string) (float64, *Ack) {
+	f, err := strconv.Atof64(v)
+	if err != nil {
+		return 0, NewAck(ErrArg, "Float expected: %s", v)
+	}
+	return f, nil
+}
+
+// Parses a single position or a START:END range into a half-open range.
+func parseRange(v string, length int) (start, end int, ack *Ack) {
+	pos := strings.Index(v, ":")
+	if pos == -1 {
+		if start, ack = parseInt(v); ack != nil {
+			return
+		}
+		end = start + 1
+	} else {
+		if start, ack = parseInt(v[:pos]); ack != nil {
+			return
+		}
+
+		end = length
+		if pos < len(v)-1 {
+			if end, ack = parseInt(v[pos+1:]); ack != nil {
+				return
+			}
+		}
+	}
+
+	if start < 0 || end > length || start >= end {
+		ack = NewAck(ErrArg, "Bad song index")
+	}
+	return
+}
+
+func btoi(v bool) int {
+	if v {
+		return 1
+	}
+	return 0
+}
+
+func ftoa(f float64) string {
+	return strconv.Ftoa64(f, 'f', -1)
+}
+
+/* State helpers */
+
+// Returns the song with the given file name from the database, or nil.
+func (this *Server) lookup(file string) Song {
+	for _, s := range this.db {
+		if s["file"] == file {
+			return s
+		}
+	}
+	return nil
+}
+
+// Returns the database songs at or below @path, in database order.
+func (this *Server) songsUnder(path string) []Song {
+	path = strings.Trim(path, "/")
+	list := make([]Song, 0)
+
+	for _, s := range this.db {
+		if path == "" || s["file"] == path || strings.HasPrefix(s["file"], path+"/") {
+			list = append(list, s)
+		}
+	}
+	return list
+}
+
+// Inserts a song into the queue at @pos, or at the end if @pos is negative.
+func (this *Server) enqueue(song Song, pos int) *entry {
+	cur := this.currentId()
+
+	if pos < 0 || pos > len(this.queue) {
+		pos = len(this.queue)
+	}
+
+	this.nextId++
+	e := &entry{song.copy(), this.nextId, 0}
+
+	this.queue = append(this.queue, nil)
+	copy(this.queue[pos+1:], this.queue[pos:])
+	this.queue[pos] = e
+
+	this.follow(cur)
+	this.touch(pos)
+	return e
+}
+
+// Bumps the playlist version and marks entries from @from onwards as changed.
+func (this *Server) touch(from int) {
+	this.version++
+	for i := from; i < len(this.queue); i++ {
+		this.queue[i].version = this.version
+	}
+	this.notify("playlist")
+}
+
+func (this *Server) currentId() int {
+	if this.song < 0 || this.song >= len(this.queue) {
+		return -1
+	}
+	return this.queue[this.song].id
+}
+
+func (this *Server) position(id int) int {
+	for i, e := range this.queue {
+		if e.id == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// Updates the current song position after the queue changed. Stops playback
+// if the current song is gone.
+func (this *Server) follow(id int) {
+	if id == -1 {
+		return
+	}
+
+	if this.song = this.position(id); this.song == -1 {
+		this.state = "stop"
+		this.elapsed = 0
+		this.notify("player")
+	}
+}
+
+func (this *Server) removeRange(start, end int) {
+	cur := this.currentId()
+	this.queue = append(this.queue[:start], this.queue[end:]...)
+	this.follow(cur)
+	this.touch(start)
+}
+
+func (this *Server) moveEntry(from, to int) {
+	cur := this.currentId()
+	e := this.queue[from]
+	this.queue = append(this.queue[:from], this.queue[from+1:]...)
+	this.queue = append(this.queue, nil)
+	copy(this.queue[to+1:], this.queue[to:])
+	this.queue[to] = e
+	this.follow(cur)
+
+	if from < to {
+		this.touch(from)
+	} else {
+		this.touch(to)
+	}
+}
+
+func (this *Server) startPlayback(pos int) {
+	this.song = pos
+	this.state = "play"
+	this.elapsed = 0
+	this.notify("player")
+}
+
+func (this *Server) writeStatus(w *Response) {
+	w.Add("volume", this.volume)
+	w.Add("repeat", btoi(this.repeat))
+	w.Add("random", btoi(this.random))
+	w.Add("single", this.single)
+	w.Add("consume", this.consume)
+	w.Add("playlist", this.version)
+	w.Add("playlistlength", len(this.queue))
+	w.Add("mixrampdb", ftoa(this.mixrampdb))
+
+	if this.mixrampdelay != "nan" {
+		w.Add("mixrampdelay", this.mixrampdelay)
+	}
+
+	w.Add("state", this.state)
+
+	if this.crossfade > 0 {
+		w.Add("xfade", this.crossfade)
+	}
+
+	if this.song >= 0 && this.song < len(this.queue) {
+		e := this.queue[this.song]
+		w.Add("song", this.song)
+		w.Add("songid", e.id)
+
+		if this.state != "stop" {
+			total, _ := strconv.Atoi(e.song["Time"])
+			w.Add("time", fmt.Sprintf("%d:%d", int(this.elapsed), total))
+			w.Add("elapsed", fmt.Sprintf("%.3f", this.elapsed))
+			w.Add("duration", fmt.Sprintf("%.3f", float64(total)))
+			w.Add("bitrate", 320)
+			w.Add("audio", "44100:16:2")
+		}
+
+		if next := this.song + 1; next < len(this.queue) {
+			w.Add("nextsong", next)
+			w.Add("nextsongid", this.queue[next].id)
+		}
+	}
+
+	if this.updating > 0 {
+		w.Add("updating_db", this.updating)
+	}
+}
+
+// Writes a song with its file name first and the remaining tags sorted, so
+// responses are stable. Tags disabled for this connection are left out.
+func (this *Conn) writeSong(w *Response, s Song) {
+	w.Add("file", s["file"])
+
+	keys := make([]string, 0, len(s))
+	for k := range s {
+		if k != "file" && !this.disabled[strings.ToLower(k)] {
+			keys = append(keys, k)
+		}
+	}
+
+	sort.SortStrings(keys)
+	for _, k := range keys {
+		for _, v := range strings.Split(s[k], "\n", -1) {
+			w.Add(k, v)
+		}
+	}
+}
+
+func (this *Conn) writeEntry(w *Response, e *entry, pos int) {
+	this.writeSong(w, e.song)
+	w.Add("Pos", pos)
+	w.Add("Id", e.id)
+}
+
+// Returns the value of tag @name, matching the name case-insensitively.
+func tagValue(s Song, name string) string {
+	name = strings.ToLower(name)
+	if name == "filename" {
+		name = "file"
+	}
+
+	for k, v := range s {
+		if strings.ToLower(k) == name {
+			return v
+		}
+	}
+	return ""
+}
+
+// Returns the values of tag @name. Most tags have one at most.
+func tagValues(s Song, name string) []string {
+	v := tagValue(s, name)
+	if len(v) == 0 {
+		return nil
+	}
+	return strings.Split(v, "\n", -1)
+}
+
+func matchValue(v, term string, exact bool) bool {
+	if exact {
+		return v == term
+	}
+	return strings.Index(strings.ToLower(v), strings.ToLower(term)) != -1
+}
+
+func matchSong(s Song, tag, term string, exact bool) bool {
+	if strings.ToLower(tag) != "any" {
+		// Songs without the tag match an empty term.
+		values := tagValues(s, tag)
+		if len(values) == 0 {
+			values = []string{""}
+		}
+
+		for _, v := range values {
+			if matchValue(v, term, exact) {
+				return true
+			}
+		}
+		return false
+	}
+
+	for k, list := range s {
+		for _, v := range strings.Split(list, "\n", -1) {
+			if k != "Time" && matchValue(v, term, exact) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Filters songs by a list of tag/term pairs. All pairs must match.
+func filterSongs(songs []Song, args []string, exact bool) ([]Song, *Ack) {
+	if len(args) == 0 || len(args)%2 != 0 {
+		return nil, NewAck(ErrArg, "incorrect arguments")
+	}
+
+	list := make([]Song, 0)
+next:
+	for _, s := range songs {
+		for i := 0; i < len(args); i += 2 {
+			if !matchSong(s, args[i], args[i+1], exact) {
+				continue next
+			}
+		}
+		list = append(list, s)
+	}
+	return list, nil
+}
+
+// Returns the canonical spelling of a tag name, as listed by tagtypes.
+func (this *Server) canonicalTag(name string) string {
+	for _, t := range this.tagtypes {
+		if strings.ToLower(t) == strings.ToLower(name) {
+			return t
+		}
+	}
+	return name
+}
+
+/* Status and informational commands */
+
+func handlePing(c *Conn, args []string, w *Response) *Ack {
+	return nil
+}
+
+func handlePassword(c *Conn, args []string, w *Response) *Ack {
+	if ack := checkArgs(args, 1, 1); ack != nil {
+		return ack
+	}
+
+	if args[0] != c.Server.Password {
+		return NewAck(ErrPassword, "incorrect password")
+	}
+
+	c.authed = true
+	return nil
+}
+
+func handleStatus(c *Conn, args []string, w *Response) *Ack {
+	c.Server.writeStatus(w)
+	return nil
+}
+
+func handleStats(c *Conn, args []string, w *Response) *Ack {
+	artists := make(map[string]bool)
+	albums := make(map[string]bool)
+	playtime := 0
+
+	for _, s := range c.Server.db {
+		if v := s["Artist"]; v != "" {
+			artists[v] = true
+		}
+		if v := s["Album"]; v != "" {
+			albums[v] = true
+		}
+
+		t, _ := strconv.Atoi(s["Time"])
+		playtime += t
+	}
+
+	w.Add("artists", len(artists))
+	w.Add("albums", len(albums))
+	w.Add("songs", len(c.Server.db))
+	w.Add("uptime", 100)
+	w.Add("playtime", 0)
+	w.Add("db_playtime", playtime)
+	w.Add("db_update", 1298073600)
+	return nil
+}
+
+func handleCurrentSong(c *Conn, args []string, w *Response) *Ack {
+	s := c.Server
+	if s.song >= 0 && s.song < len(s.queue) {
+		c.writeEntry(w, s.queue[s.song], s.song)
+	}
+	return nil
+}
+
+func handleOutputs(c *Conn, args []string, w *Response) *Ack {
+	for _, o := range c.Server.outputs {
+		w.Add("outputid", o.id)
+		w.Add("outputname", o.name)
+		w.Add("outputenabled", btoi(o.enabled))
+	}
+	return nil
+}
+
+func setOutput(c *Conn, args []string, fn func(o *output)) *Ack {
+	if ack := checkArgs(args, 1, 1); ack != nil {
+		return ack
+	}
+
+	id, ack := parseInt(args[0])
+	if ack != nil {
+		return ack
+	}
+
+	if id < 0 || id >= len(c.Server.outputs) {
+		return NewAck(ErrNoExist, "No such audio output")
+	}
+
+	fn(c.Server.outputs[id])
+	c.Server.notify("output")
+	return nil
+}
+
+func handleEnableOutput(c *Conn, args []string, w *Response) *Ack {
+	return setOutput(c, args, func(o *output) { o.enabled = true })
+}
+
+func handleDisableOutput(c *Conn, args []string, w *Response) *Ack {
+	return setOutput(c, args, func(o *output) { o.enabled = false })
+}
+
+func handleToggleOutput(c *Conn, args []string, w *Response) *Ack {
+	return setOutput(c, args, func(o *output) { o.enabled = !o.enabled })
+}
+
+func handleCommands(c *Conn, args []string, w *Response) *Ack {
+	names := make([]string, 0, len(c.Server.handlers))
+	for name := range c.Server.handlers {
+		names = append(names, name)
+	}
+
+	sort.SortStrings(names)
+	for _, name := range names {
+		w.Add("command", name)
+	}
+	return nil
+}
+
+func handleTagTypes(c *Conn, args []string, w *Response) *Ack {
+	if len(args) == 0 {
+		for _, t := range c.Server.tagtypes {
+			if !c.disabled[strings.ToLower(t)] {
+				w.Add("tagtype", t)
+			}
+		}
+		return nil
+	}
+
+	switch args[0] {
+	case "clear":
+		for _, t := range c.Server.tagtypes {
+			c.disabled[strings.ToLower(t)] = true
+		}
+	case "all":
+		c.disabled = make(map[string]bool)
+	case "enable", "disable":
+		if len(args) < 2 {
+			return NewAck(ErrArg, "Not enough arguments")
+		}
+
+		for _, t := range args[1:] {
+			if !tagKnown(c.Server, t) {
+				return NewAck(ErrArg, "Unknown tag type: %s", t)
+			}
+
+			name := strings.ToLower(t)
+
+			if args[0] == "enable" {
+				c.disabled[name] = false, false
+			} else {
+				c.disabled[name] = true
+			}
+		}
+	default:
+		return NewAck(ErrArg, "Unknown sub command")
+	}
+	return nil
+}
+
+func tagKnown(s *Server, name string) bool {
+	for _, t := range s.tagtypes {
+		if strings.ToLower(t) == strings.ToLower(name) {
+			return true
+		}
+	}
+	return false
+}
+
+func handleUrlHandlers(c *Conn, args []string, w *Response) *Ack {
+	w.Add("handler", "http://")
+	w.Add("handler", "https://")
+	return nil
+}
+
+func handleDecoders(c *Conn, args []string, w *Response) *Ack {
+	w.Add("plugin", "mad")
+	w.Add("suffix", "mp3")
+	w.Add("suffix", "mp2")
+	w.Add("mime_type", "audio/mpeg")
+	w.Add("plugin", "flac")
+	w.Add("suffix", "flac")
+	w.Add("mime_type", "audio/flac")
+	w.Add("mime_type", "audio/x-flac")
+	w.Add("plugin", "vorbis")
+	w.Add("suffix", "ogg")
+	w.Add("suffix", "oga")
+	w.Add("mime_type", "audio/ogg")
+	return nil
+}
+
+func handleConfig(c *Conn, args []string, w *Response) *Ack {
+	if !c.IsLocal() {
+		return NewAck(ErrPermission, "Command only permitted to local clients")
+	}
+
+	w.Add("music_directory", c.Server.MusicDirectory)
+	return nil
+}
+
+func handleUpdate(c *Conn, args []string, w *Response) *Ack {
+	if ack := checkArgs(args, 0, 1); ack != nil {
+		return ack
+	}
+
+	s := c.Server
+	if s.updating != 0 && s.ManualUpdates {
+		return NewAck(ErrUpdateAlready, "already updating")
+	}
+
+	s.lastJob++
+	w.Add("updating_db", s.lastJob)
+
+	if s.ManualUpdates {
+		s.updating = s.lastJob
+		s.notify("update")
+	} else {
+		s.notify("update", "database")
+	}
+	return nil
+}
+
+/* Music database */
+
+func handleFind(c *Conn, args []string, w *Response) *Ack {
+	list, ack := filterSongs(c.Server.db, args, true)
+	if ack != nil {
+		return ack
+	}
+
+	for _, s := range list {
+		c.writeSong(w, s)
+	}
+	return nil
+}
+
+func handleSearch(c *Conn, args []string, w *Response) *Ack {
+	list, ack := filterSongs(c.Server.db, args, false)
+	if ack != nil {
+		return ack
+	}
+
+	for _, s := range list {
+		c.writeSong(w, s)
+	}
+	return nil
+}
+
+func handleCount(c *Conn, args []string, w *Response) *Ack {
+	list, ack := filterSongs(c.Server.db, args, true)
+	if ack != nil {
+		return ack
+	}
+
+	playtime := 0
+	for _, s := range list {
+		t, _ := strconv.Atoi(s["Time"])
+		playtime += t
+	}
+
+	w.Add("songs", len(list))
+	w.Add("playtime", playtime)
+	return nil
+}
+
+func handleList(c *Conn, args []string, w *Response) *Ack {
+	if len(args) == 0 {
+		return NewAck(ErrArg, "too few arguments for \"list\"")
+	}
+
+	songs := c.Server.db
+	filter := args[1:]
+
+	// Old style: list album <artist>
+	if len(filter) == 1 {
+		filter = []string{"artist", filter[0]}
+	}
+
+	if len(filter) > 0 {
+		var ack *Ack
+		if songs, ack = filterSongs(songs, filter, true); ack != nil {
+			return ack
+		}
+	}
+
+	key := c.Server.canonicalTag(args[0])
+	if strings.ToLower(key) == "file" {
+		key = "file"
+	}
+
+	seen := make(map[string]bool)
+	for _, s := range songs {
+		for _, v := range tagValues(s, args[0]) {
+			if v == "" || seen[v] {
+				continue
+			}
+
+			seen[v] = true
+			w.Add(key, v)
+		}
+	}
+	return nil
+}
+
+// Calls @fn for every song below @path, after the directories leading up to
+// it have been passed to @dir. Each directory is reported once.
+func (this *Server) walk(path string, dir func(name string), fn func(s Song)) {
+	path = strings.Trim(path, "/")
+	seen := make(map[string]bool)
+
+	for _, s := range this.songsUnder(path) {
+		parts := strings.Split(s["file"], "/", -1)
+
+		for i := 1; i < len(parts); i++ {
+			d := strings.Join(parts[:i], "/")
+			if len(d) <= len(path) || seen[d] {
+				continue
+			}
+
+			seen[d] = true
+			dir(d)
+		}
+		fn(s)
+	}
+}
+
+func handleListAll(c *Conn, args []string, w *Response) *Ack {
+	if ack := checkArgs(args, 0, 1); ack != nil {
+		return ack
+	}
+
+	path := ""
+	if len(args) > 0 {
+		path = args[0]
+	}
+
+	c.Server.walk(path,
+		func(d string) { w.Add("directory", d) },
+		func(s Song) { w.Add("file", s["file"]) })
+	return nil
+}
+
+func handleListAllInfo(c *Conn, args []string, w *Response) *Ack {
+	if ack := checkArgs(args, 0, 1); ack != nil {
+		return ack
+	}
+
+	path := ""
+	if len(args) > 0 {
+		path = args[0]
+	}
+
+	c.Server.walk(path,
+		func(d string) { w.Add("directory", d) },
+		func(s Song) { c.writeSong(w, s) })
+	return nil
+}
+
+// Returns the directories and songs directly inside @path.
+func (this *Server) children(path string) (dirs []string, songs []Song, ok bool) {
+	path = strings.Trim(path, "/")
+	seen := make(map[string]bool)
+
+	dirs = make([]string, 0)
+	songs = make([]Song, 0)
+
+	for _, s := range this.songsUnder(path) {
+		if s["file"] == path {
+			return nil, []Song{s}, true
+		}
+
+		rel := s["file"]
+		if path != "" {
+			rel = rel[len(path)+1:]
+		}
+
+		if pos := strings.Index(rel, "/"); pos != -1 {
+			d := rel[:pos]
+			if path != "" {
+				d = path + "/" + d
+			}
+
+			if !seen[d] {
+				seen[d] = true
+				dirs = append(dirs, d)
+			}
+			continue
+		}
+		songs = append(songs, s)
+	}
+
+	ok = path == "" || len(dirs) > 0 || len(songs) > 0
+	return
+}
+
+func handleLsInfo(c *Conn, args []string, w *Response) *Ack {
+	if ack := checkArgs(args, 0, 1); ack != nil {
+		return ack
+	}
+
+	path := ""
+	if len(args) > 0 {
+		path = args[0]
+	}
+
+	dirs, songs, ok := c.Server.children(path)
+	if !ok {
+		return NewAck(ErrNoExist, "No such directory")
+	}
+
+	for _, d := range dirs {
+		w.Add("directory", d)
+	}
+
+	for _, s := range songs {
+		c.writeSong(w, s)
+	}
+
+	if strings.Trim(path, "/") == "" {
+		names := make([]string, 0, len(c.Server.playlists))
+		for name := range c.Server.playlists {
+			names = append(names, name)
+		}
+
+		sort.SortStrings(names)
+		for _, name := range names {
+			w.Add("playlist", name)
+		}
+	}
+	return nil
+}
+
+func handleListFiles(c *Conn, args []string, w *Response) *Ack {
+	if ack := checkArgs(args, 0, 1); ack != nil {
+		return ack
+	}
+
+	path := ""
+	if len(args) > 0 {
+		path = args[0]
+	}
+
+	dirs, songs, ok := c.Server.children(path)
+	if !ok {
+		return NewAck(ErrNoExist, "No such directory")
+	}
+
+	for _, d := range dirs {
+		w.Add("directory", d[strings.LastIndex(d, "/")+1:])
+		w.Add("Last-Modified", "2011-02-19T00:00:00Z")
+	}
+
+	for _, s := range songs {
+		f := s["file"]
+		w.Add("file", f[strings.LastIndex(f, "/")+1:])
+
+		size := s["size"]
+		if size == "" {
+			size = "0"
+		}
+		w.Add("size", size)
+
+		modified := s["Last-Modified"]
+		if modified == "" {
+			modified = "2011-02-19T00:00:00Z"
+		}
+		w.Add("Last-Modified", modified)
+	}
+	return nil
+}
+
+func handleReadComments(c *Conn, args []string, w *Response) *Ack {
+	if ack := checkArgs(args, 1, 1); ack != nil {
+		return ack
+	}
+
+	s := c.Server.lookup(args[0])
+	if s == nil {
+		return NewAck(ErrNoExist, "No such file")
+	}
+
+	keys := make([]string, 0, len(s))
+	for k := range s {
+		switch k {
+		case "file", "Time", "Last-Modified", "size":
+		default:
+			keys = append(keys, k)
+		}
+	}
+
+	sort.SortStrings(keys)
+	for _, k := range keys {
+		w.Add(strings.ToUpper(k), s[k])
+	}
+	return nil
+}
+
+func handleFingerprint(c *Conn, args []string, w *Response) *Ack {
+	if ack := checkArgs(args, 1, 1); ack != nil {
+		return ack
+	}
+
+	if c.Server.lookup(args[0]) == nil {
+		return NewAck(ErrNoExist, "No such file")
+	}
+
+	// Not a real fingerprint, but stable for a given file name.
+	var hash uint32 = 2166136261
+	for i := 0; i < len(args[0]); i++ {
+		hash = (hash ^ uint32(args[0][i])) * 16777619
+	}
+
+	w.Add("chromaprint", fmt.Sprintf("AQAA%08x", hash))
+	return nil
+}
+
+/* Queue */
+
+func handleAdd(c *Conn, args []string, w *Response) *Ack {
+	if ack := checkArgs(args, 1, 1); ack != nil {
+		return ack
+	}
+
+	if strings.Index(args[0], "://") != -1 {
+		c.Server.enqueue(Song{"file": args[0]}, -1)
+		return nil
+	}
+
+	songs := c.Server.songsUnder(args[0])
+	if len(songs) == 0 {
+		return NewAck(ErrNoExist, "No such directory")
+	}
+
+	for _, s := range songs {
+		c.Server.enqueue(s, -1)
+	}
+	return nil
+}
+
+func handleAddId(c *Conn, args []string, w *Response) *Ack {
+	if ack := checkArgs(args, 1, 2); ack != nil {
+		return ack
+	}
+
+	pos := -1
+	if len(args) > 1 {
+		var ack *Ack
+		if pos, ack = parseInt(args[1]); ack != nil {
+			return ack
+		}
+
+		if pos < 0 || pos > len(c.Server.queue) {
+			return NewAck(ErrArg, "Bad song index")
+		}
+	}
+
+	song := c.Server.lookup(args[0])
+	if song == nil {
+		if strings.Index(args[0], "://") == -1 {
+			return NewAck(ErrNoExist, "No such song")
+		}
+		song = Song{"file": args[0]}
+	}
+
+	e := c.Server.enqueue(song, pos)
+	w.Add("Id", e.id)
+	return nil
+}
+
+func handleClear(c *Conn, args []string, w *Response) *Ack {
+	s := c.Server
+	s.queue = s.queue[:0]
+	s.song = -1
+	s.state = "stop"
+	s.elapsed = 0
+	s.touch(0)
+	s.notify("player")
+	return nil
+}
+
+func handleDelete(c *Conn, args []string, w *Response) *Ack {
+	if ack := checkArgs(args, 1, 1); ack != nil {
+		return ack
+	}
+
+	start, end, ack := parseRange(args[0], len(c.Server.queue))
+	if ack != nil {
+		return ack
+	}
+
+	c.Server.removeRange(start, end)
+	return nil
+}
+
+func handleDeleteId(c *Conn, args []string, w *Response) *Ack {
+	if ack := checkArgs(args, 1, 1); ack != nil {
+		return ack
+	}
+
+	id, ack := parseInt(args[0])
+	if ack != nil {
+		return ack
+	}
+
+	pos := c.Server.position(id)
+	if pos == -1 {
+		return NewAck(ErrNoExist, "No such song")
+	}
+
+	c.Server.removeRange(pos, pos+1)
+	return nil
+}
+
+func handleMove(c *Conn, args []string, w *Response) *Ack {
+	if ack := checkArgs(args, 2, 2); ack != nil {
+		return ack
+	}
+
+	from, _, ack := parseRange(args[0], len(c.Server.queue))
+	if ack != nil {
+		return ack
+	}
+
+	to, ack := parseInt(args[1])
+	if ack != nil {
+		return ack
+	}
+
+	if to < 0 || to >= len(c.Server.queue) {
+		return NewAck(ErrArg, "Bad song index")
+	}
+
+	c.Server.moveEntry(from, to)
+	return nil
+}
+
+func handleMoveId(c *Conn, args []string, w *Response) *Ack {
+	if ack := checkArgs(args, 2, 2); ack != nil {
+		return ack
+	}
+
+	id, ack := parseInt(args[0])
+	if ack != nil {
+		return ack
+	}
+
+	from := c.Server.position(id)
+	if from == -1 {
+		return NewAck(ErrNoExist, "No such song")
+	}
+
+	to, ack := parseInt(args[1])
+	if ack != nil {
+		return ack
+	}
+
+	if to < 0 || to >= len(c.Server.queue) {
+		return NewAck(ErrArg, "Bad song index")
+	}
+
+	c.Server.moveEntry(from, to)
+	return nil
+}
+
+func (this *Server) swapEntries(a, b int) {
+	cur := this.currentId()
+	this.queue[a], this.queue[b] = this.queue[b], this.queue[a]
+	this.follow(cur)
+
+	if a > b {
+		a = b
+	}
+	this.touch(a)
+}
+
+func handleSwap(c *Conn, args []string, w *Response) *Ack {
+	if ack := checkArgs(args, 2, 2); ack != nil {
+		return ack
+	}
+
+	a, _, ack := parseRange(args[0], len(c.Server.queue))
+	if ack != nil {
+		return ack
+	}
+
+	b, _, ack := parseRange(args[1], len(c.Server.queue))
+	if ack != nil {
+		return ack
+	}
+
+	c.Server.swapEntries(a, b)
+	return nil
+}
+
+func handleSwapId(c *Conn, args []string, w *Response) *Ack {
+	if ack := checkArgs(args, 2, 2); ack != nil {
+		return ack
+	}
+
+	ida, ack := parseInt(args[0])
+	if ack != nil {
+		return ack
+	}
+
+	idb, ack := parseInt(args[1])
+	if ack != nil {
+		return ack
+	}
+
+	a, b := c.Server.position(ida), c.Server.position(idb)
+	if a == -1 || b == -1 {
+		return NewAck(ErrNoExist, "No such song")
+	}
+
+	c.Server.swapEntries(a, b)
+	return nil
+}
+
+func handleShuffle(c *Conn, args []string, w *Response) *Ack {
+	s := c.Server
+	cur := s.currentId()
+
+	for i, j := range rand.Perm(len(s.queue)) {
+		s.queue[i], s.queue[j] = s.queue[j], s.queue[i]
+	}
+
+	s.follow(cur)
+	s.touch(0)
+	return nil
+}
+
+func handlePlaylistInfo(c *Conn, args []string, w *Response) *Ack {
+	if ack := checkArgs(args, 0, 1); ack != nil {
+		return ack
+	}
+
+	start, end := 0, len(c.Server.queue)
+	if len(args) > 0 {
+		var ack *Ack
+		if start, end, ack = parseRange(args[0], len(c.Server.queue)); ack != nil {
+			return ack
+		}
+	}
+
+	for i := start; i < end; i++ {
+		c.writeEntry(w, c.Server.queue[i], i)
+	}
+	return nil
+}
+
+func handlePlaylistId(c *Conn, args []string, w *Response) *Ack {
+	if ack := checkArgs(args, 0, 1); ack != nil {
+		return ack
+	}
+
+	if len(args) == 0 {
+		return handlePlaylistInfo(c, args, w)
+	}
+
+	id, ack := parseInt(args[0])
+	if ack != nil {
+		return ack
+	}
+
+	pos := c.Server.position(id)
+	if pos == -1 {
+		return NewAck(ErrNoExist, "No such song")
+	}
+
+	c.writeEntry(w, c.Server.queue[pos], pos)
+	return nil
+}
+
+func handlePlChanges(c *Conn, args []string, w *Response) *Ack {
+	if ack := checkArgs(args, 1, 1); ack != nil {
+		return ack
+	}
+
+	version, ack := parseInt(args[0])
+	if ack != nil {
+		return ack
+	}
+
+	for i, e := range c.Server.queue {
+		if e.version > version {
+			c.writeEntry(w, e, i)
+		}
+	}
+	return nil
+}
+
+func handlePlChangesPosId(c *Conn, args []string, w *Response) *Ack {
+	if ack := checkArgs(args, 1, 1); ack != nil {
+		return ack
+	}
+
+	version, ack := parseInt(args[0])
+	if ack != nil {
+		return ack
+	}
+
+	for i, e := range c.Server.queue {
+		if e.version > version {
+			w.Add("cpos", i)
+			w.Add("Id", e.id)
+		}
+	}
+	return nil
+}
+
+func playlistFilter(c *Conn, args []string, w *Response, exact bool) *Ack {
+	songs := make([]Song, len(c.Server.queue))
+	for i, e := range c.Server.queue {
+		songs[i] = e.song
+	}
+
+	matched, ack := filterSongs(songs, args, exact)
+	if ack != nil {
+		return ack
+	}
+
+	// filterSongs keeps the order, so the positions can be recovered by
+	// walking both lists in parallel.
+	j := 0
+	for i, e := range c.Server.queue {
+		if j < len(matched) && songs[i]["file"] == matched[j]["file"] {
+			c.writeEntry(w, e, i)
+			j++
+		}
+	}
+	return nil
+}
+
+func handlePlaylistSearch(c *Conn, args []string, w *Response) *Ack {
+	return playlistFilter(c, args, w, false)
+}
+
+func handlePlaylistFind(c *Conn, args []string, w *Response) *Ack {
+	return playlistFilter(c, args, w, true)
+}
+
+/* Stored playlists */
+
+func (this *Server) storedPlaylist(name string) ([]string, *Ack) {
+	list, ok := this.playlists[name]
+	if !ok {
+		return nil, NewAck(ErrNoExist, "No such playlist")
+	}
+	return list, nil
+}
+
+func handleListPlaylists(c *Conn, args []string, w *Response) *Ack {
+	names := make([]string, 0, len(c.Server.playlists))
+	for name := range c.Server.playlists {
+		names = append(names, name)
+	}
+
+	sort.SortStrings(names)
+	for _, name := range names {
+		w.Add("playlist", name)
+		w.Add("Last-Modified", "2011-02-19T00:00:00Z")
+	}
+	return nil
+}
+
+func handleListPlaylist(c *Conn, args []string, w *Response) *Ack {
+	if ack := checkArgs(args, 1, 1); ack != nil {
+		return ack
+	}
+
+	list, ack := c.Server.storedPlaylist(args[0])
+	if ack != nil {
+		return ack
+	}
+
+	for _, f := range list {
+		w.Add("file", f)
+	}
+	return nil
+}
+
+func handleListPlaylistInfo(c *Conn, args []string, w *Response) *Ack {
+	if ack := checkArgs(args, 1, 1); ack != nil {
+		return ack
+	}
+
+	list, ack := c.Server.storedPlaylist(args[0])
+	if ack != nil {
+		return ack
+	}
+
+	for _, f := range list {
+		if s := c.Server.lookup(f); s != nil {
+			c.writeSong(w, s)
+		} else {
+			w.Add("file", f)
+		}
+	}
+	return nil
+}
+
+func handleLoad(c *Conn, args []string, w *Response) *Ack {
+	if ack := checkArgs(args, 1, 2); ack != nil {
+		return ack
+	}
+
+	list, ack := c.Server.storedPlaylist(args[0])
+	if ack != nil {
+		return ack
+	}
+
+	start, end := 0, len(list)
+	if len(args) > 1 {
+		if start, end, ack = parseRange(args[1], len(list)); ack != nil {
+			return ack
+		}
+	}
+
+	for _, f := range list[start:end] {
+		song := c.Server.lookup(f)
+		if song == nil {
+			song = Song{"file": f}
+		}
+		c.Server.enqueue(song, -1)
+	}
+	return nil
+}
+
+func handleSave(c *Conn, args []string, w *Response) *Ack {
+	if ack := checkArgs(args, 1, 1); ack != nil {
+		return ack
+	}
+
+	if _, ok := c.Server.playlists[args[0]]; ok {
+		return NewAck(ErrExist, "Playlist already exists")
+	}
+
+	list := make([]string, len(c.Server.queue))
+	for i, e := range c.Server.queue {
+		list[i] = e.song["file"]
+	}
+
+	c.Server.playlists[args[0]] = list
+	c.Server.notify("stored_playlist")
+	return nil
+}
+
+func handleRm(c *Conn, args []string, w *Response) *Ack {
+	if ack := checkArgs(args, 1, 1); ack != nil {
+		return ack
+	}
+
+	if _, ack := c.Server.storedPlaylist(args[0]); ack != nil {
+		return ack
+	}
+
+	c.Server.playlists[args[0]] = nil, false
+	c.Server.notify("stored_playlist")
+	return nil
+}
+
+func handleRename(c *Conn, args []string, w *Response) *Ack {
+	if ack := checkArgs(args, 2, 2); ack != nil {
+		return ack
+	}
+
+	list, ack := c.Server.storedPlaylist(args[0])
+	if ack != nil {
+		return ack
+	}
+
+	if _, ok := c.Server.playlists[args[1]]; ok {
+		return NewAck(ErrExist, "Playlist already exists")
+	}
+
+	c.Server.playlists[args[0]] = nil, false
+	c.Server.playlists[args[1]] = list
+	c.Server.notify("stored_playlist")
+	return nil
+}
+
+func handlePlaylistAdd(c *Conn, args []string, w *Response) *Ack {
+	if ack := checkArgs(args, 2, 2); ack != nil {
+		return ack
+	}
+
+	files := []string{args[1]}
+	if strings.Index(args[1], "://") == -1 {
+		songs := c.Server.songsUnder(args[1])
+		if len(songs) == 0 {
+			return NewAck(ErrNoExist, "No such directory")
+		}
+
+		files = make([]string, len(songs))
+		for i, s := range songs {
+			files[i] = s["file"]
+		}
+	}
+
+	c.Server.playlists[args[0]] = append(c.Server.playlists[args[0]], files...)
+	c.Server.notify("stored_playlist")
+	return nil
+}
+
+func handlePlaylistClear(c *Conn, args []string, w *Response) *Ack {
+	if ack := checkArgs(args, 1, 1); ack != nil {
+		return ack
+	}
+
+	c.Server.playlists[args[0]] = make([]string, 0)
+	c.Server.notify("stored_playlist")
+	return nil
+}
+
+func handlePlaylistDelete(c *Conn, args []string, w *Response) *Ack {
+	if ack := checkArgs(args, 2, 2); ack != nil {
+		return ack
+	}
+
+	list, ack := c.Server.storedPlaylist(args[0])
+	if ack != nil {
+		return ack
+	}
+
+	start, end, ack := parseRange(args[1], len(list))
+	if ack != nil {
+		return ack
+	}
+
+	c.Server.playlists[args[0]] = append(list[:start], list[end:]...)
+	c.Server.notify("stored_playlist")
+	return nil
+}
+
+func handlePlaylistMove(c *Conn, args []string, w *Response) *Ack {
+	if ack := checkArgs(args, 3, 3); ack != nil {
+		return ack
+	}
+
+	list, ack := c.Server.storedPlaylist(args[0])
+	if ack != nil {
+		return ack
+	}
+
+	from, _, ack := parseRange(args[1], len(list))
+	if ack != nil {
+		return ack
+	}
+
+	to, _, ack := parseRange(args[2], len(list))
+	if ack != nil {
+		return ack
+	}
+
+	f := list[from]
+	list = append(list[:from], list[from+1:]...)
+	list = append(list, "")
+	copy(list[to+1:], list[to:])
+	list[to] = f
+
+	c.Server.playlists[args[0]] = list
+	c.Server.notify("stored_playlist")
+	return nil
+}
+
+/* Stickers */
+
+func (this *Server) setSticker(file, name, value string) {
+	m, ok := this.stickers[file]
+	if !ok {
+		m = make(map[string]string)
+		this.stickers[file] = m
+	}
+	m[name] = value
+}
+
+// Handles 'sticker get|set|delete|list|find song URI ...'. Only songs have
+// stickers.
+func handleSticker(c *Conn, args []string, w *Response) *Ack {
+	if ack := checkArgs(args, 3, 5); ack != nil {
+		return ack
+	}
+
+	if args[1] != "song" {
+		return NewAck(ErrArg, "unknown sticker domain")
+	}
+
+	s := c.Server
+	file := args[2]
+
+	if args[0] != "find" && s.lookup(file) == nil {
+		return NewAck(ErrNoExist, "no such song")
+	}
+
+	switch args[0] {
+	case "get":
+		if ack := checkArgs(args, 4, 4); ack != nil {
+			return ack
+		}
+
+		v, ok := s.stickers[file][args[3]]
+		if !ok {
+			return NewAck(ErrNoExist, "no such sticker")
+		}
+		w.Add("sticker", args[3]+"="+v)
+
+	case "set":
+		if ack := checkArgs(args, 5, 5); ack != nil {
+			return ack
+		}
+		s.setSticker(file, args[3], args[4])
+		s.notify("sticker")
+
+	case "delete":
+		if ack := checkArgs(args, 3, 4); ack != nil {
+			return ack
+		}
+
+		if len(args) == 3 {
+			s.stickers[file] = nil, false
+		} else if _, ok := s.stickers[file][args[3]]; ok {
+			s.stickers[file][args[3]] = "", false
+		} else {
+			return NewAck(ErrNoExist, "no such sticker")
+		}
+		s.notify("sticker")
+
+	case "list":
+		names := make([]string, 0)
+		for name := range s.stickers[file] {
+			names = append(names, name)
+		}
+
+		sort.SortStrings(names)
+		for _, name := range names {
+			w.Add("sticker", name+"="+s.stickers[file][name])
+		}
+
+	case "find":
+		if ack := checkArgs(args, 4, 4); ack != nil {
+			return ack
+		}
+
+		songs := s.songsUnder(file)
+		if len(songs) == 0 && len(strings.Trim(file, "/")) > 0 {
+			return NewAck(ErrNoExist, "No such directory")
+		}
+
+		for _, song := range songs {
+			if v, ok := s.stickers[song["file"]][args[3]]; ok {
+				w.Add("file", song["file"])
+				w.Add("sticker", args[3]+"="+v)
+			}
+		}
+
+	default:
+		return NewAck(ErrArg, "bad request")
+	}
+	return nil
+}
+
+/* Playback */
+
+func handlePlay(c *Conn, args []string, w *Response) *Ack {
+	if ack := checkArgs(args, 0, 1); ack != nil {
+		return ack
+	}
+
+	s := c.Server
+	pos := s.song
+	if pos < 0 {
+		pos = 0
+	}
+
+	if len(args) > 0 {
+		var ack *Ack
+		if pos, ack = parseInt(args[0]); ack != nil {
+			return ack
+		}
+	}
+
+	if pos < 0 || pos >= len(s.queue) {
+		return NewAck(ErrArg, "Bad song index")
+	}
+
+	s.startPlayback(pos)
+	return nil
+}
+
+func handlePlayId(c *Conn, args []string, w *Response) *Ack {
+	if ack := checkArgs(args, 0, 1); ack != nil {
+		return ack
+	}
+
+	if len(args) == 0 {
+		return handlePlay(c, args, w)
+	}
+
+	id, ack := parseInt(args[0])
+	if ack != nil {
+		return ack
+	}
+
+	pos := c.Server.position(id)
+	if pos == -1 {
+		return NewAck(ErrNoExist, "No such song")
+	}
+
+	c.Server.startPlayback(pos)
+	return nil
+}
+
+func handlePause(c *Conn, args []string, w *Response) *Ack {
+	if ack := checkArgs(args, 0, 1); ack != nil {
+		return ack
+	}
+
+	s := c.Server
+	if s.state == "stop" {
+		return nil
+	}
+
+	pause := s.state == "play"
+	if len(args) > 0 {
+		var ack *Ack
+		if pause, ack = parseBool(args[0]); ack != nil {
+			return ack
+		}
+	}
+
+	if pause {
+		s.state = "pause"
+	} else {
+		s.state = "play"
+	}
+
+	s.notify("player")
+	return nil
+}
+
+func handleStop(c *Conn, args []string, w *Response) *Ack {
+	c.Server.state = "stop"
+	c.Server.elapsed = 0
+	c.Server.notify("player")
+	return nil
+}
+
+func handleNext(c *Conn, args []string, w *Response) *Ack {
+	s := c.Server
+	if s.state == "stop" || s.song < 0 {
+		return nil
+	}
+
+	next := s.song + 1
+	if next >= len(s.queue) {
+		if !s.repeat {
+			s.song = -1
+			return handleStop(c, args, w)
+		}
+		next = 0
+	}
+
+	s.startPlayback(next)
+	return nil
+}
+
+func handlePrevious(c *Conn, args []string, w *Response) *Ack {
+	s := c.Server
+	if s.state == "stop" || s.song < 0 {
+		return nil
+	}
+
+	prev := s.song - 1
+	if prev < 0 {
+		prev = 0
+	}
+
+	s.startPlayback(prev)
+	return nil
+}
+
+func seekTo(s *Server, pos int, t string) *Ack {
+	f, ack := parseFloat(t)
+	if ack != nil {
+		return ack
+	}
+
+	if pos < 0 || pos >= len(s.queue) {
+		return NewAck(ErrArg, "Bad song index")
+	}
+
+	if pos != s.song || s.state == "stop" {
+		s.song = pos
+		s.state = "play"
+	}
+
+	s.elapsed = f
+	s.notify("player")
+	return nil
+}
+
+func handleSeek(c *Conn, args []string, w *Response) *Ack {
+	if ack := checkArgs(args, 2, 2); ack != nil {
+		return ack
+	}
+
+	pos, ack := parseInt(args[0])
+	if ack != nil {
+		return ack
+	}
+	return seekTo(c.Server, pos, args[1])
+}
+
+func handleSeekId(c *Conn, args []string, w *Response) *Ack {
+	if ack := checkArgs(args, 2, 2); ack != nil {
+		return ack
+	}
+
+	id, ack := parseInt(args[0])
+	if ack != nil {
+		return ack
+	}
+
+	pos := c.Server.position(id)
+	if pos == -1 {
+		return NewAck(ErrNoExist, "No such song")
+	}
+	return seekTo(c.Server, pos, args[1])
+}
+
+func handleSeekCur(c *Conn, args []string, w *Response) *Ack {
+	if ack := checkArgs(args, 1, 1); ack != nil {
+		return ack
+	}
+
+	s := c.Server
+	if s.state == "stop" || s.song < 0 {
+		return NewAck(ErrPlayerSync, "Not playing")
+	}
+
+	v := args[0]
+	relative := v[0] == '+' || v[0] == '-'
+
+	f, ack := parseFloat(strings.TrimLeft(v, "+-"))
+	if ack != nil {
+		return ack
+	}
+
+	if v[0] == '-' {
+		f = -f
+	}
+
+	if relative {
+		f += s.elapsed
+	}
+
+	if f < 0 {
+		f = 0
+	}
+
+	s.elapsed = f
+	s.notify("player")
+	return nil
+}
+
+func handleSetVol(c *Conn, args []string, w *Response) *Ack {
+	if ack := checkArgs(args, 1, 1); ack != nil {
+		return ack
+	}
+
+	v, ack := parseInt(args[0])
+	if ack != nil {
+		return ack
+	}
+
+	if v < 0 || v > 100 {
+		return NewAck(ErrArg, "Invalid volume value")
+	}
+
+	c.Server.volume = v
+	c.Server.notify("mixer")
+	return nil
+}
+
+func setBool(c *Conn, args []string, v *bool) *Ack {
+	if ack := checkArgs(args, 1, 1); ack != nil {
+		return ack
+	}
+
+	b, ack := parseBool(args[0])
+	if ack != nil {
+		return ack
+	}
+
+	*v = b
+	c.Server.notify("options")
+	return nil
+}
+
+func handleRandom(c *Conn, args []string, w *Response) *Ack {
+	return setBool(c, args, &c.Server.random)
+}
+
+func handleRepeat(c *Conn, args []string, w *Response) *Ack {
+	return setBool(c, args, &c.Server.repeat)
+}
+
+func setMode(c *Conn, args []string, v *string) *Ack {
+	if ack := checkArgs(args, 1, 1); ack != nil {
+		return ack
+	}
+
+	switch args[0] {
+	case "0", "1", "oneshot":
+	default:
+		return NewAck(ErrArg, "Boolean (0/1) or 'oneshot' expected: %s", args[0])
+	}
+
+	*v = args[0]
+	c.Server.notify("options")
+	return nil
+}
+
+func handleSingle(c *Conn, args []string, w *Response) *Ack {
+	return setMode(c, args, &c.Server.single)
+}
+
+func handleConsume(c *Conn, args []string, w *Response) *Ack {
+	return setMode(c, args, &c.Server.consume)
+}
+
+func handleCrossfade(c *Conn, args []string, w *Response) *Ack {
+	if ack := checkArgs(args, 1, 1); ack != nil {
+		return ack
+	}
+
+	v, ack := parseInt(args[0])
+	if ack != nil {
+		return ack
+	}
+
+	if v < 0 {
+		return NewAck(ErrArg, "Number too small: %d", v)
+	}
+
+	c.Server.crossfade = v
+	c.Server.notify("options")
+	return nil
+}
+
+func handleMixRampDb(c *Conn, args []string, w *Response) *Ack {
+	if ack := checkArgs(args, 1, 1); ack != nil {
+		return ack
+	}
+
+	f, ack := parseFloat(args[0])
+	if ack != nil {
+		return ack
+	}
+
+	c.Server.mixrampdb = f
+	c.Server.notify("options")
+	return nil
+}
+
+func handleMixRampDelay(c *Conn, args []string, w *Response) *Ack {
+	if ack := checkArgs(args, 1, 1); ack != nil {
+		return ack
+	}
+
+	if args[0] != "nan" {
+		if _, ack := parseFloat(args[0]); ack != nil {
+			return ack
+		}
+	}
+
+	c.Server.mixrampdelay = args[0]
+	c.Server.notify("options")
+	return nil
+}
+
+func handleReplayGainMode(c *Conn, args []string, w *Response) *Ack {
+	if ack := checkArgs(args, 1, 1); ack != nil {
+		return ack
+	}
+
+	switch args[0] {
+	case "off", "track", "album", "auto":
+	default:
+		return NewAck(ErrArg, "Unrecognized replay gain mode")
+	}
+
+	c.Server.replayGain = args[0]
+	c.Server.notify("options")
+	return nil
+}
+
+func handleReplayGainStatus(c *Conn, args []string, w *Response) *Ack {
+	w.Add("replay_gain_mode", c.Server.replayGain)
+	return nil
+}
@@ -0,0 +1,248 @@
+// Copyright (c) 2010, Jim Teeuwen. All rights reserved.
+// This code is subject to a 1-clause BSD license.
+// See the LICENSE file for its contents.
+
+package mpdtest
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A single line from a transcript.
+type TranscriptLine struct {
+	Client bool  // Sent by the client, rather than the server.
+	Time   int64 // Microseconds since the recording started.
+	Text   string
+}
+
+// Reads a transcript as written by mpd.Recorder.
+func ReadTranscript(r io.Reader) (lines []TranscriptLine, err os.Error) {
+	var line string
+	var num int
+
+	reader := bufio.NewReader(r)
+	lines = make([]TranscriptLine, 0)
+
+	for {
+		if line, err = reader.ReadString('\n'); err != nil {
+			if err == os.EOF && len(line) == 0 {
+				return lines, nil
+			}
+
+			if err != os.EOF {
+				return
+			}
+		}
+
+		num++
+		if line = strings.TrimRight(line, "\r\n"); len(line) == 0 || line[0] == '#' {
+			continue
+		}
+
+		fields := strings.Split(line, " ", 3)
+		if len(fields) < 3 || (fields[0] != ">" && fields[0] != "<") {
+			return nil, os.NewError(fmt.Sprintf("Transcript line %d: Malformed line.", num))
+		}
+
+		var t int64
+		if t, err = strconv.Atoi64(fields[1]); err != nil {
+			return nil, os.NewError(fmt.Sprintf("Transcript line %d: Invalid time '%s'.", num, fields[1]))
+		}
+
+		lines = append(lines, TranscriptLine{fields[0] == ">", t, fields[2]})
+	}
+	return
+}
+
+// Replay serves a recorded transcript back to clients. Every connection gets
+// the complete transcript: the server lines are sent as recorded, as long as
+// the client sends exactly the recorded commands. An unexpected command is
+// answered with an ACK, after which the connection is closed.
+//
+// Problems are collected and can be inspected with Errors once the client is
+// done. Passwords are not compared, because the recorder does not store them.
+type Replay struct {
+	// If set, server lines are delayed as they were in the recorded session.
+	Realtime bool
+
+	lines    []TranscriptLine
+	lock     sync.Mutex
+	listener net.Listener
+	conns    map[net.Conn]bool
+	errors   []string
+	sessions int
+}
+
+// Creates a replay server for the transcript in @r.
+func NewReplay(r io.Reader) (*Replay, os.Error) {
+	lines, err := ReadTranscript(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(lines) == 0 || lines[0].Client {
+		return nil, os.NewError("Transcript does not start with a handshake.")
+	}
+
+	p := new(Replay)
+	p.lines = lines
+	p.conns = make(map[net.Conn]bool)
+	p.errors = make([]string, 0)
+	return p, nil
+}
+
+// Creates a replay server for the transcript file @file.
+func LoadReplay(file string) (*Replay, os.Error) {
+	fd, err := os.Open(file, os.O_RDONLY, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	defer fd.Close()
+	return NewReplay(fd)
+}
+
+// Starts accepting connections on the given network and address.
+func (this *Replay) Listen(network, addr string) (err os.Error) {
+	if this.listener, err = net.Listen(network, addr); err != nil {
+		return
+	}
+
+	go this.accept(this.listener)
+	return
+}
+
+// Returns the address the server is listening on.
+func (this *Replay) Addr() string {
+	if this.listener == nil {
+		return ""
+	}
+	return this.listener.Addr().String()
+}
+
+// Stops listening and closes all open connections.
+func (this *Replay) Close() {
+	if this.listener != nil {
+		this.listener.Close()
+	}
+
+	this.lock.Lock()
+	for c := range this.conns {
+		c.Close()
+	}
+	this.lock.Unlock()
+}
+
+// Returns the problems found in all sessions so far: unexpected commands and
+// sessions which ended before the transcript did.
+func (this *Replay) Errors() []string {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	list := make([]string, len(this.errors))
+	copy(list, this.errors)
+	return list
+}
+
+// Returns the number of sessions which have been played back completely.
+func (this *Replay) Sessions() int {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	return this.sessions
+}
+
+func (this *Replay) fail(format string, args ...interface{}) {
+	this.lock.Lock()
+	this.errors = append(this.errors, fmt.Sprintf(format, args...))
+	this.lock.Unlock()
+}
+
+func (this *Replay) accept(l net.Listener) {
+	for {
+		rw, err := l.Accept()
+		if err != nil {
+			return
+		}
+
+		this.lock.Lock()
+		this.conns[rw] = true
+		this.lock.Unlock()
+
+		go this.serve(rw)
+	}
+}
+
+func (this *Replay) serve(rw net.Conn) {
+	defer func() {
+		this.lock.Lock()
+		this.conns[rw] = false, false
+		this.lock.Unlock()
+		rw.Close()
+	}()
+
+	reader := bufio.NewReader(rw)
+	writer := bufio.NewWriter(rw)
+	var last int64
+
+	for i := 0; i < len(this.lines); i++ {
+		want := this.lines[i]
+
+		if !want.Client {
+			if this.Realtime && want.Time > last {
+				time.Sleep((want.Time - last) * 1e3)
+			}
+
+			last = want.Time
+			writer.WriteString(want.Text)
+			writer.WriteByte('\n')
+			continue
+		}
+
+		writer.Flush()
+
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			this.fail("Session ended early. Expected '%s'.", want.Text)
+			return
+		}
+
+		line = strings.TrimRight(line, "\r\n")
+		last = want.Time
+
+		if !matchLine(want.Text, line) {
+			this.fail("Unexpected command '%s'. Expected '%s'.", line, want.Text)
+			writer.WriteString(fmt.Sprintf("ACK [%d@0] {%s} unexpected command\n",
+				ErrUnknown, commandName(line)))
+			writer.Flush()
+			return
+		}
+	}
+
+	writer.Flush()
+
+	this.lock.Lock()
+	this.sessions++
+	this.lock.Unlock()
+}
+
+func matchLine(want, got string) bool {
+	if strings.HasPrefix(want, "password ") {
+		return strings.HasPrefix(got, "password ")
+	}
+	return want == got
+}
+
+func commandName(line string) string {
+	if pos := strings.Index(line, " "); pos != -1 {
+		return line[:pos]
+	}
+	return line
+}
@@ -0,0 +1,705 @@
+// Copyright (c) 2010, Jim Teeuwen. All rights reserved.
+// This code is subject to a 1-clause BSD license.
+// See the LICENSE file for its contents.
+
+// This package provides an in-process fake MPD server. It speaks enough of
+// the MPD protocol to test code which talks to MPD without a live daemon.
+//
+// The server keeps its queue, database, stored playlists and player state in
+// memory. Every command it receives is recorded, so tests can assert on what
+// a client sent. Individual commands can be replaced with Handle to script
+// specific responses or failures.
+package mpdtest
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+)
+
+// Protocol version reported in the handshake.
+const DefaultVersion = "0.21.0"
+
+// ACK error codes, as defined by the MPD protocol.
+const (
+	ErrNotList       = 1
+	ErrArg           = 2
+	ErrPassword      = 3
+	ErrPermission    = 4
+	ErrUnknown       = 5
+	ErrNoExist       = 50
+	ErrPlaylistMax   = 51
+	ErrSystem        = 52
+	ErrPlaylistLoad  = 53
+	ErrUpdateAlready = 54
+	ErrPlayerSync    = 55
+	ErrExist         = 56
+)
+
+// An error reported to the client as an ACK line.
+type Ack struct {
+	Code    int
+	Message string
+}
+
+func NewAck(code int, format string, args ...interface{}) *Ack {
+	return &Ack{code, fmt.Sprintf(format, args...)}
+}
+
+func (this *Ack) String() string {
+	return fmt.Sprintf("[%d] %s", this.Code, this.Message)
+}
+
+// Metadata of a single song. It holds at least the 'file' key. Other keys
+// are tag names as MPD reports them, like Artist, Title or Time. Tags with
+// several values, like a song in two genres, hold them separated by
+// newlines: "Jazz\nFusion".
+type Song map[string]string
+
+func (this Song) copy() Song {
+	s := make(Song)
+	for k, v := range this {
+		s[k] = v
+	}
+	return s
+}
+
+// Collects the lines of a command response.
+type Response struct {
+	lines []string
+}
+
+func (this *Response) Add(key string, value interface{}) {
+	this.lines = append(this.lines, fmt.Sprintf("%s: %v", key, value))
+}
+
+// Handles a single command. @args holds the unquoted arguments, without the
+// command name. Returning an Ack fails the command. Handlers are called with
+// the server lock held, so they can access the server state directly, but
+// must not call its exported methods.
+type HandlerFunc func(c *Conn, args []string, w *Response) *Ack
+
+type entry struct {
+	song    Song
+	id      int
+	version int // Playlist version at which this entry last changed.
+}
+
+type output struct {
+	id      int
+	name    string
+	enabled bool
+}
+
+type Server struct {
+	Version        string
+	Password       string // If set, most commands require it first.
+	MusicDirectory string // Reported by config, over unix sockets only.
+
+	// If set, update jobs keep running until FinishUpdate is called.
+	// Otherwise they finish right away.
+	ManualUpdates bool
+
+	lock     sync.Mutex
+	listener net.Listener
+	handlers map[string]HandlerFunc
+	received []string
+	conns    map[*Conn]bool
+
+	db        []Song
+	queue     []*entry
+	playlists map[string][]string
+	stickers  map[string]map[string]string // Song stickers, by file.
+	outputs   []*output
+	tagtypes  []string
+
+	state        string
+	volume       int
+	random       bool
+	repeat       bool
+	single       string
+	consume      string
+	crossfade    int
+	mixrampdb    float64
+	mixrampdelay string
+	replayGain   string
+	song         int
+	elapsed      float64
+	version      int
+	nextId       int
+	updating     int
+	lastJob      int
+}
+
+// Creates a server with an empty database and queue. Call Listen to start
+// accepting connections.
+func NewServer() *Server {
+	s := new(Server)
+	s.Version = DefaultVersion
+	s.MusicDirectory = "/var/lib/mpd/music"
+	s.handlers = make(map[string]HandlerFunc)
+	s.received = make([]string, 0)
+	s.conns = make(map[*Conn]bool)
+	s.db = make([]Song, 0)
+	s.queue = make([]*entry, 0)
+	s.playlists = make(map[string][]string)
+	s.stickers = make(map[string]map[string]string)
+	s.outputs = make([]*output, 0)
+	s.tagtypes = []string{
+		"Artist", "ArtistSort", "Album", "AlbumArtist", "Title", "Track", "Name",
+		"Genre", "Date", "Composer", "Performer", "Comment", "Disc",
+	}
+
+	s.state = "stop"
+	s.volume = 100
+	s.single = "0"
+	s.consume = "0"
+	s.mixrampdelay = "nan"
+	s.replayGain = "off"
+	s.song = -1
+	s.version = 1
+
+	for name, fn := range defaultHandlers {
+		s.handlers[name] = fn
+	}
+	return s
+}
+
+// Starts accepting connections on the given network ("tcp" or "unix") and
+// address. Use "127.0.0.1:0" to pick a free port, then ask Addr for it.
+func (this *Server) Listen(network, addr string) (err os.Error) {
+	if this.listener, err = net.Listen(network, addr); err != nil {
+		return
+	}
+
+	go this.accept(this.listener)
+	return
+}
+
+// Returns the address the server is listening on.
+func (this *Server) Addr() string {
+	if this.listener == nil {
+		return ""
+	}
+	return this.listener.Addr().String()
+}
+
+// Stops listening and closes all open connections.
+func (this *Server) Close() {
+	if this.listener != nil {
+		this.listener.Close()
+	}
+	this.DropConnections()
+}
+
+// Closes all open connections, like MPD does with connections which were
+// idle for longer than its connection_timeout. The server keeps listening.
+func (this *Server) DropConnections() {
+	this.lock.Lock()
+	conns := make([]*Conn, 0, len(this.conns))
+	for c := range this.conns {
+		conns = append(conns, c)
+	}
+	this.lock.Unlock()
+
+	for _, c := range conns {
+		c.rw.Close()
+	}
+}
+
+// Replaces the handler for the given command.
+func (this *Server) Handle(name string, fn HandlerFunc) {
+	this.lock.Lock()
+	this.handlers[name] = fn
+	this.lock.Unlock()
+}
+
+// Returns all command lines received so far, in order.
+func (this *Server) Commands() []string {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	list := make([]string, len(this.received))
+	copy(list, this.received)
+	return list
+}
+
+// Returns the last command line received, or an empty string.
+func (this *Server) Last() string {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	if len(this.received) == 0 {
+		return ""
+	}
+	return this.received[len(this.received)-1]
+}
+
+// Forgets all commands received so far.
+func (this *Server) ResetCommands() {
+	this.lock.Lock()
+	this.received = this.received[:0]
+	this.lock.Unlock()
+}
+
+// Returns the number of open client connections.
+func (this *Server) Connections() int {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	return len(this.conns)
+}
+
+// Adds songs to the database.
+func (this *Server) AddSongs(songs ...Song) {
+	this.lock.Lock()
+	for _, s := range songs {
+		this.db = append(this.db, s.copy())
+	}
+	this.lock.Unlock()
+}
+
+// Appends the given files to the queue. Files which are not in the database
+// are added without tags.
+func (this *Server) Enqueue(files ...string) {
+	this.lock.Lock()
+	for _, f := range files {
+		song := this.lookup(f)
+		if song == nil {
+			song = Song{"file": f}
+		}
+		this.enqueue(song, -1)
+	}
+	this.lock.Unlock()
+}
+
+// Creates or replaces a stored playlist.
+func (this *Server) AddPlaylist(name string, files ...string) {
+	this.lock.Lock()
+	list := make([]string, len(files))
+	copy(list, files)
+	this.playlists[name] = list
+	this.lock.Unlock()
+}
+
+// Returns the contents of a stored playlist, or nil if it does not exist.
+func (this *Server) Playlist(name string) []string {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	list, ok := this.playlists[name]
+	if !ok {
+		return nil
+	}
+
+	ret := make([]string, len(list))
+	copy(ret, list)
+	return ret
+}
+
+// Sets a sticker of a song.
+func (this *Server) SetSticker(file, name, value string) {
+	this.lock.Lock()
+	this.setSticker(file, name, value)
+	this.lock.Unlock()
+}
+
+// Returns the value of a sticker of a song, or an empty string.
+func (this *Server) Sticker(file, name string) string {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	return this.stickers[file][name]
+}
+
+// Returns a copy of the songs in the queue, in order.
+func (this *Server) Queue() []Song {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	list := make([]Song, len(this.queue))
+	for i, e := range this.queue {
+		list[i] = e.song.copy()
+	}
+	return list
+}
+
+// Adds an audio output.
+func (this *Server) AddOutput(name string, enabled bool) {
+	this.lock.Lock()
+	this.outputs = append(this.outputs, &output{len(this.outputs), name, enabled})
+	this.lock.Unlock()
+}
+
+// Returns the status as it would be reported by the status command.
+func (this *Server) Status() map[string]string {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	w := new(Response)
+	this.writeStatus(w)
+
+	status := make(map[string]string)
+	for _, line := range w.lines {
+		pos := strings.Index(line, ": ")
+		status[line[:pos]] = line[pos+2:]
+	}
+	return status
+}
+
+// Wakes up idling clients which wait for any of the given subsystems.
+func (this *Server) Notify(subsystems ...string) {
+	this.lock.Lock()
+	this.notify(subsystems...)
+	this.lock.Unlock()
+}
+
+// Finishes the running update job. Only useful with ManualUpdates.
+func (this *Server) FinishUpdate() {
+	this.lock.Lock()
+	if this.updating != 0 {
+		this.updating = 0
+		this.notify("update", "database")
+	}
+	this.lock.Unlock()
+}
+
+func (this *Server) notify(subsystems ...string) {
+	for c := range this.conns {
+		for _, s := range subsystems {
+			c.pending[s] = true
+		}
+
+		select {
+		case c.wake <- true:
+		default:
+		}
+	}
+}
+
+func (this *Server) record(line string) {
+	this.lock.Lock()
+	this.received = append(this.received, line)
+	this.lock.Unlock()
+}
+
+func (this *Server) accept(l net.Listener) {
+	for {
+		rw, err := l.Accept()
+		if err != nil {
+			return
+		}
+
+		c := newConn(this, rw)
+
+		this.lock.Lock()
+		this.conns[c] = true
+		this.lock.Unlock()
+
+		go c.serve()
+	}
+}
+
+// A single client connection.
+type Conn struct {
+	Server *Server
+
+	rw       net.Conn
+	reader   *bufio.Reader
+	writer   *bufio.Writer
+	pending  map[string]bool
+	wake     chan bool
+	disabled map[string]bool // Tag types disabled with 'tagtypes disable'.
+	authed   bool
+}
+
+type readResult struct {
+	line string
+	err  os.Error
+}
+
+func newConn(s *Server, rw net.Conn) *Conn {
+	c := new(Conn)
+	c.Server = s
+	c.rw = rw
+	c.reader = bufio.NewReader(rw)
+	c.writer = bufio.NewWriter(rw)
+	c.pending = make(map[string]bool)
+	c.wake = make(chan bool, 1)
+	c.disabled = make(map[string]bool)
+	return c
+}
+
+// Reports whether the client connected over a unix socket.
+func (this *Conn) IsLocal() bool {
+	return this.rw.LocalAddr().Network() == "unix"
+}
+
+// Closes the connection without responding, as if the server went away.
+// Handlers can call it to simulate a broken connection.
+func (this *Conn) Drop() {
+	this.rw.Close()
+}
+
+func (this *Conn) serve() {
+	defer this.close()
+
+	this.writer.WriteString(fmt.Sprintf("OK MPD %s\n", this.Server.Version))
+	this.writer.Flush()
+
+	lines := make(chan readResult)
+	go this.readLines(lines)
+
+	var list []string
+	inList, listOk := false, false
+
+	for {
+		r := <-lines
+		if r.err != nil {
+			return
+		}
+
+		line := strings.TrimRight(r.line, "\r\n")
+		this.Server.record(line)
+
+		switch {
+		case line == "command_list_begin" || line == "command_list_ok_begin":
+			if inList {
+				this.writeAck(NewAck(ErrNotList, "command lists can not be nested"), 0, line)
+				return
+			}
+			inList, listOk = true, line == "command_list_ok_begin"
+			list = make([]string, 0)
+
+		case line == "command_list_end":
+			if !inList {
+				this.writeAck(NewAck(ErrNotList, "not in command list mode"), 0, line)
+				continue
+			}
+			inList = false
+			this.runList(list, listOk)
+
+		case inList:
+			list = append(list, line)
+
+		case line == "close":
+			return
+
+		case line == "noidle":
+			// A noidle which arrives after idle returned is ignored, like
+			// MPD does.
+
+		case strings.HasPrefix(line, "idle"):
+			if !this.idle(line, lines) {
+				return
+			}
+
+		default:
+			w, ack := this.exec(line)
+			if ack != nil {
+				this.writeAck(ack, 0, line)
+				continue
+			}
+			this.writeLines(w)
+			this.writer.WriteString("OK\n")
+			this.writer.Flush()
+		}
+	}
+}
+
+func (this *Conn) runList(list []string, listOk bool) {
+	for i, line := range list {
+		w, ack := this.exec(line)
+		if ack != nil {
+			this.writeAck(ack, i, line)
+			return
+		}
+
+		this.writeLines(w)
+		if listOk {
+			this.writer.WriteString("list_OK\n")
+		}
+	}
+
+	this.writer.WriteString("OK\n")
+	this.writer.Flush()
+}
+
+func (this *Conn) exec(line string) (w *Response, ack *Ack) {
+	var args []string
+	var err os.Error
+
+	if args, err = splitArgs(line); err != nil {
+		return nil, NewAck(ErrArg, "%s", err)
+	}
+
+	if len(args) == 0 {
+		return nil, NewAck(ErrUnknown, "No command given")
+	}
+
+	this.Server.lock.Lock()
+	defer this.Server.lock.Unlock()
+
+	fn, ok := this.Server.handlers[args[0]]
+	if !ok {
+		return nil, NewAck(ErrUnknown, "unknown command \"%s\"", args[0])
+	}
+
+	if !this.authed && this.Server.Password != "" {
+		switch args[0] {
+		case "password", "ping", "commands", "notcommands":
+		default:
+			return nil, NewAck(ErrPermission, "you don't have permission for \"%s\"", args[0])
+		}
+	}
+
+	w = new(Response)
+	if ack = fn(this, args[1:], w); ack != nil {
+		return nil, ack
+	}
+	return
+}
+
+// Handles the idle command. Returns false if the connection should be closed.
+func (this *Conn) idle(line string, lines chan readResult) bool {
+	args, err := splitArgs(line)
+	if err != nil || args[0] != "idle" {
+		this.writeAck(NewAck(ErrUnknown, "unknown command \"%s\"", line), 0, line)
+		return true
+	}
+
+	for {
+		if changed := this.events(args[1:]); len(changed) > 0 {
+			this.writeChanged(changed)
+			return true
+		}
+
+		select {
+		case <-this.wake:
+		case r := <-lines:
+			if r.err != nil {
+				return false
+			}
+
+			line = strings.TrimRight(r.line, "\r\n")
+			this.Server.record(line)
+
+			// Anything but noidle is a protocol violation while idling.
+			if line != "noidle" {
+				return false
+			}
+
+			this.writeChanged(this.events(args[1:]))
+			return true
+		}
+	}
+	return true
+}
+
+// Takes the pending events for the given subsystems, or all of them.
+func (this *Conn) events(subsystems []string) []string {
+	this.Server.lock.Lock()
+	defer this.Server.lock.Unlock()
+
+	changed := make([]string, 0)
+	for s := range this.pending {
+		if len(subsystems) == 0 || contains(subsystems, s) {
+			changed = append(changed, s)
+			this.pending[s] = false, false
+		}
+	}
+	return changed
+}
+
+func (this *Conn) writeChanged(changed []string) {
+	for _, s := range changed {
+		this.writer.WriteString(fmt.Sprintf("changed: %s\n", s))
+	}
+	this.writer.WriteString("OK\n")
+	this.writer.Flush()
+}
+
+func (this *Conn) writeLines(w *Response) {
+	for _, line := range w.lines {
+		this.writer.WriteString(line)
+		this.writer.WriteByte('\n')
+	}
+}
+
+func (this *Conn) writeAck(ack *Ack, index int, line string) {
+	name := line
+	if pos := strings.Index(line, " "); pos != -1 {
+		name = line[:pos]
+	}
+
+	this.writer.WriteString(fmt.Sprintf("ACK [%d@%d] {%s} %s\n", ack.Code, index, name, ack.Message))
+	this.writer.Flush()
+}
+
+func (this *Conn) readLines(lines chan readResult) {
+	for {
+		line, err := this.reader.ReadString('\n')
+		lines <- readResult{line, err}
+		if err != nil {
+			return
+		}
+	}
+}
+
+func (this *Conn) close() {
+	this.Server.lock.Lock()
+	this.Server.conns[this] = false, false
+	this.Server.lock.Unlock()
+	this.rw.Close()
+}
+
+// Splits a command line into its arguments, removing quotes and escapes.
+func splitArgs(line string) (args []string, err os.Error) {
+	var buf []byte
+	quoted, have := false, false
+
+	args = make([]string, 0)
+
+	for i := 0; i < len(line); i++ {
+		ch := line[i]
+
+		switch {
+		case quoted && ch == '\\':
+			if i+1 < len(line) {
+				i++
+				buf = append(buf, line[i])
+			}
+		case ch == '"':
+			quoted = !quoted
+			have = true
+		case !quoted && (ch == ' ' || ch == '\t'):
+			if have {
+				args = append(args, string(buf))
+				buf = buf[:0]
+				have = false
+			}
+		default:
+			buf = append(buf, ch)
+			have = true
+		}
+	}
+
+	if quoted {
+		return nil, os.NewError("Missing closing '\"'")
+	}
+
+	if have {
+		args = append(args, string(buf))
+	}
+	return
+}
+
+func contains(list []string, v string) bool {
+	for _, s := range list {
+		if s == v {
+			return true
+		}
+	}
+	return false
+}
@@ -5,28 +5,86 @@
 package mpd
 
 import (
-	"regexp"
-	"strconv"
 	"fmt"
+	"os"
+	"strconv"
 )
 
 type Param struct {
 	Name     string
 	Desc     string
-	Pattern  *regexp.Regexp
+	Kind     Kind
 	Optional bool
+	Flag     bool   // Set by name as --name instead of by position.
+	Default  string // Value of an optional parameter which is not given.
 	Value    string
 }
 
-func newParam(name, desc string, pattern *regexp.Regexp, optional bool) *Param {
-	return &Param{name, desc, pattern, optional, ""}
+func newParam(name, desc string, kind Kind, optional bool) *Param {
+	return &Param{name, desc, kind, optional, false, "", ""}
+}
+
+// Creates an optional boolean parameter. Its value is "on" when the flag is
+// present on the command line.
+func newFlag(name, desc string) *Param {
+	return &Param{name, desc, BoolKind, true, true, "", ""}
+}
+
+// Sets the default value and returns the parameter, for use in command
+// definitions.
+func (this *Param) withDefault(v string) *Param {
+	this.Default = v
+	return this
 }
 
 func (this *Param) IsValid(val string) bool {
-	return this.Pattern.MatchString(val)
+	_, err := this.Kind.Check(val)
+	return err == nil
+}
+
+// Checks @val against the parameter's kind and assigns it.
+func (this *Param) Set(val string) (err os.Error) {
+	var v string
+	if v, err = this.Kind.Check(val); err != nil {
+		return this.invalid(val, err)
+	}
+
+	this.Value = v
+	return
+}
+
+// An invalid parameter value. Unlike server errors, these are found before
+// anything is sent.
+type ParamError struct {
+	Param  string
+	Value  string
+	Reason os.Error
+}
+
+func (this *ParamError) String() string {
+	return fmt.Sprintf("Invalid value '%s' for parameter %s: %s.", this.Value, this.Param, this.Reason)
+}
+
+// A command which is used wrongly as a whole, like one with a missing or
+// conflicting parameter. Like ParamError, it is found before anything is
+// sent, so the connection remains usable.
+type UsageError struct {
+	Message string
+}
+
+func (this *UsageError) String() string {
+	return this.Message
+}
+
+func (this *Param) invalid(val string, err os.Error) os.Error {
+	return &ParamError{this.Name, val, err}
 }
 
 func (this *Param) String() string {
+	if this.Flag {
+		return fmt.Sprintf("[--%s]", this.Name)
+	}
+
 	ret := fmt.Sprintf("<%s>", this.Name)
 	if this.Optional {
 		ret = fmt.Sprintf("[%s]", ret)
@@ -48,6 +106,13 @@ func (this *Param) Int64() int64 {
 	return 0
 }
 
+func (this *Param) Float64() float64 {
+	if f, err := strconv.Atof64(this.Value); err == nil {
+		return f
+	}
+	return 0
+}
+
 func (this *Param) Byte() byte {
 	return byte(this.Int())
 }
@@ -6,10 +6,23 @@ package mpd
 
 import "regexp"
 
+// Patterns for values of the protocol. Command parameters are checked by
+// their Kind instead; see kind.go.
 var (
 	PatAny     = regexp.MustCompile(`^.+$`)
 	PatInteger = regexp.MustCompile(`^[0-9]+$`)
-	PatType    = regexp.MustCompile(`^any|artist|album|title|track|name|genre|date|composer|performer|comment|disc|filename$`)
-	PatOnOff   = regexp.MustCompile(`^on|off$`)
-	PatSign    = regexp.MustCompile(`^+|-$`) // this doesn't actually work as intended.
+	PatType    = regexp.MustCompile(`^(any|artist|album|title|track|name|genre|date|composer|performer|comment|disc|filename)$`)
+	PatOnOff   = regexp.MustCompile(`^(on|off)$`)
+	PatSign    = regexp.MustCompile(`^[+\-]$`)
+
+	PatOnOffOneshot = regexp.MustCompile(`^(on|off|oneshot)$`)
+	PatReplayGain   = regexp.MustCompile(`^(off|track|album|auto)$`)
+	PatDecibel      = regexp.MustCompile(`^-?[0-9]+(\.[0-9]+)?$`)
+	PatMixRampDelay = regexp.MustCompile(`^([0-9]+(\.[0-9]+)?|nan)$`)
+
+	// Loose checks only. ParseDuration reports the actual format errors.
+	PatDuration   = regexp.MustCompile(`^[0-9.:hms]+$`)
+	PatTimeOffset = regexp.MustCompile(`^[+\-]?[0-9.:hms]+$`)
+
+	PatTagAction = regexp.MustCompile(`^(list|enable|disable|clear|all)$`)
 )
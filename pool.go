@@ -0,0 +1,86 @@
+// Copyright (c) 2010, Jim Teeuwen. All rights reserved.
+// This code is subject to a 1-clause BSD license.
+// See the LICENSE file for its contents.
+
+package mpd
+
+import (
+	"os"
+	"time"
+)
+
+// A fixed-size set of connections which can be shared between goroutines.
+// Connections are opened lazily, the first time they are needed.
+type Pool struct {
+	// Connections which sat in the pool for longer than this many
+	// nanoseconds are pinged before they are handed out again, since the
+	// server closes idle connections after its connection_timeout.
+	// Defaults to one second.
+	PingAfter int64
+
+	cfg   *Config
+	slots chan *Client
+}
+
+func NewPool(cfg *Config, size int) *Pool {
+	if size < 1 {
+		size = 1
+	}
+
+	p := &Pool{1e9, cfg, make(chan *Client, size)}
+	for i := 0; i < size; i++ {
+		p.slots <- nil
+	}
+	return p
+}
+
+// Returns the maximum number of connections in the pool.
+func (this *Pool) Size() int {
+	return cap(this.slots)
+}
+
+// Takes a connection from the pool, opening a new one if necessary. Blocks
+// until a connection is available. Every successful Get must be followed by
+// a Put. Connections which failed are closed and replaced.
+func (this *Pool) Get() (c *Client, err os.Error) {
+	if c = <-this.slots; c != nil {
+		if c.IsConnected() && !c.Failed() && this.alive(c) {
+			return
+		}
+		c.Close()
+	}
+
+	if c, err = Dial(this.cfg); err != nil {
+		this.slots <- nil
+		return nil, err
+	}
+	return
+}
+
+// Reports whether a connection which has been returned to the pool is
+// still open on the server's side.
+func (this *Pool) alive(c *Client) bool {
+	if time.Nanoseconds()-c.returned < this.PingAfter {
+		return true
+	}
+	return c.Ping() == nil
+}
+
+// Returns a connection to the pool. If the connection broke, the next Get
+// will open a new one in its place. Passing nil does the same.
+func (this *Pool) Put(c *Client) {
+	if c != nil {
+		c.returned = time.Nanoseconds()
+	}
+	this.slots <- c
+}
+
+// Closes all connections. Blocks until connections which are still in use
+// have been returned. The pool can not be used afterwards.
+func (this *Pool) Close() {
+	for i := 0; i < cap(this.slots); i++ {
+		if c := <-this.slots; c != nil && c.IsConnected() {
+			c.Close()
+		}
+	}
+}
@@ -0,0 +1,106 @@
+// Copyright (c) 2010, Jim Teeuwen. All rights reserved.
+// This code is subject to a 1-clause BSD license.
+// See the LICENSE file for its contents.
+
+package mpd
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Recorder wraps the connection to an MPD server and writes a transcript of
+// all traffic on it. The transcript can be served back by mpdtest.Replay.
+//
+// A transcript is plain text with one protocol line per line:
+//
+//   > 1520 status
+//   < 1702 volume: 100
+//   < 1702 OK
+//
+// The first field is '>' for lines sent by the client and '<' for lines sent
+// by the server. The second is the time in microseconds since the recording
+// started. The rest is the line itself. Lines starting with '#' are comments.
+//
+// Passwords are never written to the transcript. Binary responses, like those
+// of albumart, are not supported.
+type Recorder struct {
+	net.Conn
+
+	w     io.Writer
+	lock  sync.Mutex
+	start int64
+	in    []byte // Incomplete line read from the server.
+	out   []byte // Incomplete line written by the client.
+	err   os.Error
+}
+
+// Replacement for the argument of password commands.
+const redacted = "********"
+
+// Creates a recorder for @conn which writes its transcript to @w.
+func NewRecorder(conn net.Conn, w io.Writer) *Recorder {
+	r := new(Recorder)
+	r.Conn = conn
+	r.w = w
+	r.start = time.Nanoseconds()
+	fmt.Fprintf(w, "# MPD protocol transcript. Recorded %s from %s.\n",
+		time.LocalTime().Format(time.RFC3339), conn.RemoteAddr())
+	return r
+}
+
+func (this *Recorder) Read(b []byte) (n int, err os.Error) {
+	n, err = this.Conn.Read(b)
+
+	this.lock.Lock()
+	this.in = this.write('<', append(this.in, b[:n]...))
+	this.lock.Unlock()
+	return
+}
+
+func (this *Recorder) Write(b []byte) (n int, err os.Error) {
+	n, err = this.Conn.Write(b)
+
+	this.lock.Lock()
+	this.out = this.write('>', append(this.out, b[:n]...))
+	this.lock.Unlock()
+	return
+}
+
+// Returns the first error which occurred while writing the transcript.
+// Recording errors do not affect the connection itself.
+func (this *Recorder) Error() os.Error {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	return this.err
+}
+
+// Writes all complete lines in @buf to the transcript and returns the
+// incomplete remainder.
+func (this *Recorder) write(dir byte, buf []byte) []byte {
+	now := (time.Nanoseconds() - this.start) / 1e3
+
+	for {
+		pos := strings.Index(string(buf), "\n")
+		if pos == -1 {
+			return buf
+		}
+
+		line := strings.TrimRight(string(buf[:pos]), "\r")
+		buf = buf[pos+1:]
+
+		if dir == '>' && strings.HasPrefix(line, "password ") {
+			line = "password " + redacted
+		}
+
+		if this.err == nil {
+			_, this.err = fmt.Fprintf(this.w, "%c %d %s\n", dir, now, line)
+		}
+	}
+	return buf
+}
@@ -0,0 +1,206 @@
+// Copyright (c) 2010, Jim Teeuwen. All rights reserved.
+// This code is subject to a 1-clause BSD license.
+// See the LICENSE file for its contents.
+
+package mpd
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+	"github.com/jteeuwen/go-pkg-mpd/mpdtest"
+)
+
+// Records a short session against the fake server.
+func recordSession(t *testing.T) []byte {
+	srv := mpdtest.NewServer()
+	srv.AddSongs(testSongs...)
+	srv.Password = "secret"
+
+	if err := srv.Listen("tcp", "127.0.0.1:0"); err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	defer srv.Close()
+
+	var buf bytes.Buffer
+	c := newClient()
+	c.Record(&buf)
+
+	if err := c.Open("tcp", srv.Addr()); err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+
+	if err := c.Password("secret"); err != nil {
+		t.Fatalf("Password: %v", err)
+	}
+
+	if err := runCommand(c, "add", "jazz"); err != nil {
+		t.Fatalf("add: %v", err)
+	}
+
+	if _, err := c.Status(); err != nil {
+		t.Fatalf("Status: %v", err)
+	}
+
+	c.Close()
+	return buf.Bytes()
+}
+
+func TestRecorder(t *testing.T) {
+	data := recordSession(t)
+
+	if strings.Index(string(data), "secret") != -1 {
+		t.Errorf("Password was written to the transcript.")
+	}
+
+	lines, err := mpdtest.ReadTranscript(bytes.NewBuffer(data))
+	if err != nil {
+		t.Fatalf("ReadTranscript: %v", err)
+	}
+
+	want := []string{"password " + redacted, `add "jazz"`, "status", "close"}
+	sent := make([]string, 0)
+
+	for i, l := range lines {
+		if i > 0 && l.Time < lines[i-1].Time {
+			t.Errorf("Line %d: Time runs backwards.", i)
+		}
+
+		if l.Client {
+			sent = append(sent, l.Text)
+		}
+	}
+
+	if lines[0].Client || !strings.HasPrefix(lines[0].Text, "OK MPD ") {
+		t.Errorf("Expected handshake first, got '%s'.", lines[0].Text)
+	}
+
+	if strings.Join(sent, "|") != strings.Join(want, "|") {
+		t.Errorf("Expected commands %v, got %v.", want, sent)
+	}
+}
+
+func TestReplay(t *testing.T) {
+	replay, err := mpdtest.NewReplay(bytes.NewBuffer(recordSession(t)))
+	if err != nil {
+		t.Fatalf("NewReplay: %v", err)
+	}
+
+	if err = replay.Listen("tcp", "127.0.0.1:0"); err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	defer replay.Close()
+
+	// The same session plays back without errors.
+	c := newClient()
+	if err = c.Open("tcp", replay.Addr()); err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+
+	c.Password("whatever")
+	c.execute(`add "jazz"`)
+
+	s, err := c.Status()
+	if err != nil {
+		t.Fatalf("Status: %v", err)
+	}
+
+	if s.PlaylistLength != 2 {
+		t.Errorf("Expected recorded playlist length 2, got %d.", s.PlaylistLength)
+	}
+
+	c.Close()
+
+	// A different command is refused.
+	c = newClient()
+	if err = c.Open("tcp", replay.Addr()); err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+
+	c.Password("whatever")
+	if err = c.execute("clear"); err == nil {
+		t.Errorf("Expected unexpected command to be refused.")
+	}
+
+	c.Close()
+	time.Sleep(1e8)
+
+	if n := replay.Sessions(); n != 1 {
+		t.Errorf("Expected 1 complete session, got %d.", n)
+	}
+
+	if errs := replay.Errors(); len(errs) != 1 || strings.Index(errs[0], "'clear'") == -1 {
+		t.Errorf("Unexpected errors %v.", errs)
+	}
+}
+
+func TestTranscriptPerConnection(t *testing.T) {
+	srv := mpdtest.NewServer()
+	if err := srv.Listen("tcp", "127.0.0.1:0"); err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	defer srv.Close()
+
+	cfg := testConfig(srv)
+	cfg.Transcript = fmt.Sprintf("/tmp/mpdtest-%d.pool", os.Getpid())
+	files := []string{cfg.Transcript, cfg.Transcript + ".1", cfg.Transcript + ".2"}
+
+	for _, file := range files {
+		defer os.Remove(file)
+	}
+
+	a, err := Dial(cfg)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+
+	b, err := Dial(cfg)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+
+	if err = b.Reconnect(); err != nil {
+		t.Fatalf("Reconnect: %v", err)
+	}
+
+	a.Ping()
+	b.Ping()
+	a.Close()
+	b.Close()
+
+	// Each connection has its own transcript, with a single handshake.
+	for _, file := range files {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			t.Errorf("ReadFile: %v", err)
+			continue
+		}
+
+		if n := strings.Count(string(data), "OK MPD "); n != 1 {
+			t.Errorf("%s: Expected 1 handshake, got %d.", file, n)
+		}
+	}
+}
+
+func TestReadTranscript(t *testing.T) {
+	data := "# comment\n< 0 OK MPD 0.21.0\n\n> 10 status\n< 12 volume: 100\n< 12 OK\n"
+
+	lines, err := mpdtest.ReadTranscript(strings.NewReader(data))
+	if err != nil {
+		t.Fatalf("ReadTranscript: %v", err)
+	}
+
+	if len(lines) != 4 || !lines[1].Client || lines[2].Text != "volume: 100" || lines[3].Time != 12 {
+		t.Errorf("Unexpected lines %v.", lines)
+	}
+
+	for _, bad := range []string{"status\n", "> x status\n", "? 1 OK\n"} {
+		if _, err = mpdtest.ReadTranscript(strings.NewReader(bad)); err == nil {
+			t.Errorf("%q: Expected error.", bad)
+		}
+	}
+}
@@ -0,0 +1,147 @@
+// Copyright (c) 2010, Jim Teeuwen. All rights reserved.
+// This code is subject to a 1-clause BSD license.
+// See the LICENSE file for its contents.
+
+package mpd
+
+import (
+	"fmt"
+	"os"
+	"sort"
+)
+
+// Groups of the built-in commands. They are used to organise help output.
+const (
+	GroupAdmin    = "admin"
+	GroupInfo     = "info"
+	GroupDatabase = "database"
+	GroupPlaylist = "playlist"
+	GroupPlayback = "playback"
+	GroupSession  = "session"
+)
+
+var (
+	registry        = make(map[string]*Command)
+	registryAliases = make(map[string]string)
+	registryOrder   = make([]string, 0)
+)
+
+// Adds a command to the set known to CreateCommand. @cmd serves as the
+// prototype: CreateCommand returns copies of it. Commands are usually
+// registered from an init function, so that they are available to every
+// program which imports the package that defines them.
+func Register(cmd *Command) os.Error {
+	if len(cmd.Name) == 0 || cmd.Exec == nil {
+		return os.NewError("Commands need a name and an Exec function.")
+	}
+
+	if isRegistered(cmd.Name) {
+		return os.NewError(fmt.Sprintf("Command '%s' is already registered.", cmd.Name))
+	}
+
+	registry[cmd.Name] = cmd
+	registryOrder = append(registryOrder, cmd.Name)
+	return nil
+}
+
+// Makes @alias refer to the command @name. The command does not have to be
+// registered yet.
+func Alias(alias, name string) os.Error {
+	if isRegistered(alias) {
+		return os.NewError(fmt.Sprintf("Command '%s' is already registered.", alias))
+	}
+
+	registryAliases[alias] = name
+	return nil
+}
+
+func isRegistered(name string) bool {
+	_, ok := registry[name]
+	_, isAlias := registryAliases[name]
+	return ok || isAlias
+}
+
+// Registers the built-in commands of a group. Fails loudly, since conflicts
+// between them are programming errors.
+func mustRegister(group string, list ...*Command) {
+	for _, cmd := range list {
+		cmd.Group = group
+		if err := Register(cmd); err != nil {
+			panic(err.String())
+		}
+	}
+}
+
+func mustAlias(alias, name string) {
+	if err := Alias(alias, name); err != nil {
+		panic(err.String())
+	}
+}
+
+// Returns the names of all registered commands, in the order they were
+// registered. Aliases are not included.
+func CommandList() []string {
+	list := make([]string, len(registryOrder))
+	copy(list, registryOrder)
+	return list
+}
+
+// Returns the names of all command groups, sorted.
+func CommandGroups() []string {
+	seen := make(map[string]bool)
+	list := make([]string, 0)
+
+	for _, name := range registryOrder {
+		if g := registry[name].Group; !seen[g] {
+			seen[g] = true
+			list = append(list, g)
+		}
+	}
+
+	sort.SortStrings(list)
+	return list
+}
+
+// Returns the names of the commands in @group, in the order they were
+// registered.
+func GroupCommands(group string) []string {
+	list := make([]string, 0)
+	for _, name := range registryOrder {
+		if registry[name].Group == group {
+			list = append(list, name)
+		}
+	}
+	return list
+}
+
+// Returns a new instance of the command with the given name or alias, or nil
+// if there is no such command. Every instance has its own parameters, so
+// they can be parsed and run independently.
+func CreateCommand(name string) *Command {
+	if v, ok := registryAliases[name]; ok {
+		name = v
+	}
+
+	proto, ok := registry[name]
+	if !ok {
+		return nil
+	}
+
+	cmd := new(Command)
+	*cmd = *proto
+	cmd.Params = make([]*Param, len(proto.Params))
+	cmd.Aliases = make([]string, 0)
+
+	for k, v := range registryAliases {
+		if v == name {
+			cmd.Aliases = append(cmd.Aliases, k)
+		}
+	}
+	sort.SortStrings(cmd.Aliases)
+
+	for i, p := range proto.Params {
+		v := *p
+		cmd.Params[i] = &v
+	}
+	return cmd
+}
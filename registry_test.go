@@ -0,0 +1,111 @@
+// Copyright (c) 2010, Jim Teeuwen. All rights reserved.
+// This code is subject to a 1-clause BSD license.
+// See the LICENSE file for its contents.
+
+package mpd
+
+import (
+	"os"
+	"testing"
+)
+
+func TestRegistry(t *testing.T) {
+	for _, name := range CommandList() {
+		cmd := CreateCommand(name)
+		if cmd == nil || cmd.Name != name {
+			t.Errorf("%s: Registered command can not be created.", name)
+			continue
+		}
+
+		if len(cmd.Desc) == 0 || len(cmd.Group) == 0 {
+			t.Errorf("%s: Missing description or group.", name)
+		}
+	}
+
+	total := 0
+	for _, g := range CommandGroups() {
+		total += len(GroupCommands(g))
+	}
+
+	if total != len(CommandList()) {
+		t.Errorf("Expected %d grouped commands, got %d.", len(CommandList()), total)
+	}
+
+	if CreateCommand("bogus") != nil {
+		t.Errorf("Expected nil for unknown command.")
+	}
+}
+
+func TestRegisterCommand(t *testing.T) {
+	ran := ""
+	err := Register(&Command{
+		Name:  "testping",
+		Desc:  "Pings the server.",
+		Group: "test",
+		Params: []*Param{
+			newParam("msg", "Message.", TextKind, true),
+		},
+		Exec: func(cmd *Command, c *Client) os.Error {
+			ran = cmd.S("msg", "")
+			return c.Ping()
+		},
+	})
+
+	if err != nil {
+		t.Fatalf("Register: %v", err)
+	}
+
+	if err = Register(&Command{Name: "testping", Exec: status}); err == nil {
+		t.Errorf("Expected error for duplicate name.")
+	}
+
+	if err = Register(&Command{Name: "nothing"}); err == nil {
+		t.Errorf("Expected error for command without Exec.")
+	}
+
+	if err = Alias("tp", "testping"); err != nil {
+		t.Fatalf("Alias: %v", err)
+	}
+
+	if err = Alias("ls", "status"); err == nil {
+		t.Errorf("Expected error for duplicate alias.")
+	}
+
+	srv, c := startServer(t)
+	defer srv.Close()
+	defer c.Close()
+
+	if err = runCommand(c, "tp", "hello"); err != nil {
+		t.Fatalf("tp: %v", err)
+	}
+
+	if ran != "hello" || srv.Last() != "ping" {
+		t.Errorf("Expected registered command to run, got '%s' and '%s'.", ran, srv.Last())
+	}
+
+	cmd := CreateCommand("tp")
+	if cmd.Name != "testping" || len(cmd.Aliases) != 1 || cmd.Aliases[0] != "tp" {
+		t.Errorf("Unexpected command %+v.", cmd)
+	}
+
+	if g := GroupCommands("test"); len(g) != 1 || g[0] != "testping" {
+		t.Errorf("Unexpected group %v.", g)
+	}
+}
+
+func TestCreateCommandCopies(t *testing.T) {
+	a := CreateCommand("play")
+	b := CreateCommand("play")
+
+	if err := a.parse([]string{"3"}); err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+
+	if a.I("pos", -1) != 3 || b.S("pos", "x") != "x" {
+		t.Errorf("Parameters are shared between instances.")
+	}
+
+	if CreateCommand("prev").Name != "previous" || CreateCommand("ls").Name != "lsinfo" {
+		t.Errorf("Built-in aliases do not resolve.")
+	}
+}
@@ -0,0 +1,441 @@
+// Copyright (c) 2010, Jim Teeuwen. All rights reserved.
+// This code is subject to a 1-clause BSD license.
+// See the LICENSE file for its contents.
+
+package mpd
+
+import (
+	"crypto/md5"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"json"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+)
+
+func init() {
+	mustRegister(GroupSession,
+		&Command{
+			Name: "scrobble",
+			Desc: "Follows the player and submits the songs which are played to Last.fm or ListenBrainz, until the program is stopped. Songs are submitted once they have played for half their length or 4 minutes. Songs which can not be submitted are kept in @queue, and sent along with the next submission.",
+			Params: []*Param{
+				newParam("service", "The service to submit to.", EnumKind("lastfm", "listenbrainz"), false),
+				newParam("token", "The session key for Last.fm, or the user token for ListenBrainz.", TextKind, false),
+				newParam("key", "The API key for Last.fm.", TextKind, true),
+				newParam("secret", "The API secret for Last.fm.", TextKind, true),
+				newParam("endpoint", "URL to submit to, for services compatible with Last.fm or ListenBrainz. Defaults to the service itself.", TextKind, true),
+				newParam("queue", "File which keeps the songs which have not been submitted yet. Defaults to ~/.mpd_scrobbles.", TextKind, true),
+			},
+			Exec: scrobble,
+		},
+	)
+}
+
+// Default endpoints of the scrobbling services.
+const (
+	LastfmEndpoint       = "http://ws.audioscrobbler.com/2.0/"
+	ListenBrainzEndpoint = "https://api.listenbrainz.org/1/submit-listens"
+)
+
+// A song which was played, as submitted to a scrobbling service.
+type Scrobble struct {
+	Artist   string
+	Title    string
+	Album    string
+	Track    string
+	Duration int   // Length of the song in seconds.
+	Start    int64 // Unix time at which the song started playing.
+}
+
+// A service which keeps track of the songs that were played.
+type ScrobbleService interface {
+	// Reports the song which has started playing.
+	NowPlaying(s *Scrobble) os.Error
+
+	// Submits songs which have been played.
+	Scrobble(list []*Scrobble) os.Error
+}
+
+// Submits to the Last.fm API, version 2.0, or a service compatible with it.
+// SessionKey is the key of an authenticated session.
+type LastfmService struct {
+	Endpoint   string
+	APIKey     string
+	Secret     string
+	SessionKey string
+}
+
+func (this *LastfmService) NowPlaying(s *Scrobble) os.Error {
+	params := map[string]interface{}{
+		"method":   "track.updateNowPlaying",
+		"artist":   s.Artist,
+		"track":    s.Title,
+		"duration": s.Duration,
+	}
+
+	if len(s.Album) > 0 {
+		params["album"] = s.Album
+	}
+
+	if len(s.Track) > 0 {
+		params["trackNumber"] = s.Track
+	}
+	return this.call(params)
+}
+
+func (this *LastfmService) Scrobble(list []*Scrobble) os.Error {
+	params := map[string]interface{}{"method": "track.scrobble"}
+
+	for i, s := range list {
+		params[fmt.Sprintf("artist[%d]", i)] = s.Artist
+		params[fmt.Sprintf("track[%d]", i)] = s.Title
+		params[fmt.Sprintf("timestamp[%d]", i)] = s.Start
+		params[fmt.Sprintf("duration[%d]", i)] = s.Duration
+
+		if len(s.Album) > 0 {
+			params[fmt.Sprintf("album[%d]", i)] = s.Album
+		}
+
+		if len(s.Track) > 0 {
+			params[fmt.Sprintf("trackNumber[%d]", i)] = s.Track
+		}
+	}
+	return this.call(params)
+}
+
+// Signs and sends an API call.
+func (this *LastfmService) call(params map[string]interface{}) (err os.Error) {
+	params["api_key"] = this.APIKey
+	params["sk"] = this.SessionKey
+	params["api_sig"] = lastfmSignature(params, this.Secret)
+	params["format"] = "json"
+
+	_, err = httpPost(this.Endpoint, params)
+	return
+}
+
+// Returns the signature of an API call: the MD5 sum of its parameters,
+// sorted by name and concatenated, followed by the secret.
+func lastfmSignature(params map[string]interface{}, secret string) string {
+	keys := make([]string, 0, len(params))
+	for k := range params {
+		if k != "format" && k != "callback" && k != "api_sig" {
+			keys = append(keys, k)
+		}
+	}
+	sort.SortStrings(keys)
+
+	h := md5.New()
+	for _, k := range keys {
+		fmt.Fprintf(h, "%s%v", k, params[k])
+	}
+	io.WriteString(h, secret)
+	return fmt.Sprintf("%x", h.Sum())
+}
+
+// Submits to the ListenBrainz API, or a service compatible with it.
+type ListenBrainzService struct {
+	Endpoint string
+	Token    string
+}
+
+func (this *ListenBrainzService) NowPlaying(s *Scrobble) os.Error {
+	return this.submit("playing_now", []*Scrobble{s})
+}
+
+func (this *ListenBrainzService) Scrobble(list []*Scrobble) os.Error {
+	if len(list) == 1 {
+		return this.submit("single", list)
+	}
+	return this.submit("import", list)
+}
+
+func (this *ListenBrainzService) submit(kind string, list []*Scrobble) (err os.Error) {
+	payload := make([]interface{}, len(list))
+
+	for i, s := range list {
+		info := map[string]interface{}{"duration_ms": s.Duration * 1000}
+		if len(s.Track) > 0 {
+			info["tracknumber"] = s.Track
+		}
+
+		meta := map[string]interface{}{
+			"artist_name":     s.Artist,
+			"track_name":      s.Title,
+			"additional_info": info,
+		}
+
+		if len(s.Album) > 0 {
+			meta["release_name"] = s.Album
+		}
+
+		listen := map[string]interface{}{"track_metadata": meta}
+		if kind != "playing_now" {
+			listen["listened_at"] = s.Start
+		}
+		payload[i] = listen
+	}
+
+	var body []byte
+	if body, err = json.Marshal(map[string]interface{}{"listen_type": kind, "payload": payload}); err != nil {
+		return
+	}
+
+	_, err = httpRequest("POST", this.Endpoint, "application/json", string(body),
+		map[string]string{"Authorization": "Token " + this.Token})
+	return
+}
+
+// Scrobbler follows the player and submits the songs which are played,
+// following the usual rules: a song is submitted if it is longer than 30
+// seconds, and has played for half its length or for 4 minutes. Time spent
+// paused does not count.
+//
+// Submissions which fail are kept in QueueFile, if it is set, and sent
+// along with the next one. Submissions which the service rejects as
+// invalid are dropped.
+type Scrobbler struct {
+	Service   ScrobbleService
+	QueueFile string
+	Log       io.Writer // Receives submission errors. Defaults to standard error.
+
+	queue    []*Scrobble
+	song     *Scrobble // The current song, or nil.
+	songId   string
+	position int64 // Position in the current song at the last observation, in nanoseconds.
+	played   int64 // Nanoseconds the current song has played.
+	since    int64 // Time of the last observation.
+	playing  bool
+	sent     bool // Now playing was sent for the current song.
+	now      func() int64
+}
+
+// Songs are submitted in batches of at most this size.
+const scrobbleBatch = 50
+
+func NewScrobbler(service ScrobbleService, queueFile string) *Scrobbler {
+	s := new(Scrobbler)
+	s.Service = service
+	s.QueueFile = queueFile
+	s.queue = make([]*Scrobble, 0)
+	s.now = time.Nanoseconds
+	return s
+}
+
+// Follows the player over @c until @stop is closed. Songs left in the
+// queue file are submitted first.
+func (this *Scrobbler) Run(c *Client, stop <-chan bool) (err os.Error) {
+	if this.Log == nil {
+		this.Log = os.Stderr
+	}
+
+	if err = this.loadQueue(); err != nil {
+		return
+	}
+	this.flush()
+
+	return c.followPlayer(stop, func(song []Pair, status *Status) {
+		this.observe(song, status)
+		this.flush()
+	})
+}
+
+// Updates the play time of the current song, given the player's state.
+// Submits now playing for songs which start, and queues songs which ended
+// after playing long enough.
+func (this *Scrobbler) observe(song []Pair, status *Status) {
+	now := this.now()
+	if this.playing {
+		this.played += now - this.since
+		this.position += now - this.since
+	}
+	this.since = now
+	this.playing = status.State == "play"
+
+	a := pairArgs(song)
+	id := a["Id"] + ":" + a["file"]
+
+	if status.State == "stop" || id != this.songId || restarted(this.position, status) {
+		this.finish()
+		this.songId = ""
+	}
+	this.position = status.Elapsed
+
+	if status.State == "stop" || len(song) == 0 || id == this.songId {
+		if this.song != nil && this.playing && !this.sent {
+			this.nowPlaying()
+		}
+		return
+	}
+
+	this.songId = id
+	this.song = nil
+	this.played = 0
+	this.sent = false
+
+	duration, _ := strconv.Atoi(a["Time"])
+	if len(a["Artist"]) == 0 || len(a["Title"]) == 0 || duration <= 30 {
+		return
+	}
+
+	this.song = &Scrobble{a["Artist"], a["Title"], a["Album"], a["Track"], duration, now / 1e9}
+	if this.playing {
+		this.nowPlaying()
+	}
+}
+
+// How close to its start a song must be, after having been further along,
+// to count as playing again.
+const restartMargin = 5e9
+
+// Reports whether the current song has gone back to its start, because it
+// plays again with repeat or single, or was restarted. @position is where
+// the song was expected to be, in nanoseconds.
+func restarted(position int64, status *Status) bool {
+	return status.Elapsed < restartMargin && position-status.Elapsed > restartMargin
+}
+
+func (this *Scrobbler) nowPlaying() {
+	this.sent = true
+	if err := this.Service.NowPlaying(this.song); err != nil {
+		this.logf("Now playing failed: %v", err)
+	}
+}
+
+// Queues the current song, if it has played long enough.
+func (this *Scrobbler) finish() {
+	if this.song == nil {
+		return
+	}
+
+	need := int64(this.song.Duration) * 1e9 / 2
+	if need > 240e9 {
+		need = 240e9
+	}
+
+	if this.played >= need {
+		this.queue = append(this.queue, this.song)
+		if err := this.saveQueue(); err != nil {
+			this.logf("Can not save scrobbles: %v", err)
+		}
+	}
+	this.song = nil
+}
+
+// Submits the queued songs. Stops at the first batch which fails for a
+// reason which may go away, like the service being unreachable.
+func (this *Scrobbler) flush() {
+	if len(this.queue) == 0 {
+		return
+	}
+
+	for len(this.queue) > 0 {
+		n := len(this.queue)
+		if n > scrobbleBatch {
+			n = scrobbleBatch
+		}
+
+		err := this.Service.Scrobble(this.queue[:n])
+		if err != nil {
+			this.logf("Scrobble failed: %v", err)
+			if !isRejected(err) {
+				break
+			}
+		}
+		this.queue = this.queue[n:]
+	}
+
+	if err := this.saveQueue(); err != nil {
+		this.logf("Can not save scrobbles: %v", err)
+	}
+}
+
+// Reports whether the service rejected a submission as invalid, so that
+// sending it again is pointless.
+func isRejected(err os.Error) bool {
+	e, ok := err.(*httpError)
+	return ok && e.status >= 400 && e.status < 500 &&
+		e.status != 401 && e.status != 403 && e.status != 429
+}
+
+// Returns the number of songs waiting to be submitted.
+func (this *Scrobbler) Pending() int {
+	return len(this.queue)
+}
+
+func (this *Scrobbler) loadQueue() (err os.Error) {
+	if len(this.QueueFile) == 0 {
+		return
+	}
+
+	if _, err = os.Stat(this.QueueFile); err != nil {
+		return nil // Nothing was left over.
+	}
+
+	var data []byte
+	if data, err = ioutil.ReadFile(this.QueueFile); err != nil {
+		return
+	}
+
+	var list []*Scrobble
+	if err = json.Unmarshal(data, &list); err != nil {
+		return os.NewError(fmt.Sprintf("Invalid scrobble queue '%s': %s", this.QueueFile, err))
+	}
+
+	this.queue = append(list, this.queue...)
+	return
+}
+
+func (this *Scrobbler) saveQueue() (err os.Error) {
+	if len(this.QueueFile) == 0 {
+		return
+	}
+
+	if len(this.queue) == 0 {
+		if _, err = os.Stat(this.QueueFile); err == nil {
+			err = os.Remove(this.QueueFile)
+		}
+		return nil
+	}
+
+	var data []byte
+	if data, err = json.Marshal(this.queue); err != nil {
+		return
+	}
+	return ioutil.WriteFile(this.QueueFile, data, 0600)
+}
+
+func (this *Scrobbler) logf(format string, args ...interface{}) {
+	if this.Log != nil {
+		fmt.Fprintf(this.Log, format+"\n", args...)
+	}
+}
+
+func scrobble(cmd *Command, c *Client) (err os.Error) {
+	var service ScrobbleService
+
+	switch cmd.S("service", "") {
+	case "lastfm":
+		if len(cmd.S("key", "")) == 0 || len(cmd.S("secret", "")) == 0 {
+			return &UsageError{"Last.fm requires an API --key and --secret."}
+		}
+		service = &LastfmService{cmd.S("endpoint", LastfmEndpoint), cmd.S("key", ""), cmd.S("secret", ""), cmd.S("token", "")}
+
+	case "listenbrainz":
+		service = &ListenBrainzService{cmd.S("endpoint", ListenBrainzEndpoint), cmd.S("token", "")}
+	}
+
+	queue := cmd.S("queue", "")
+	if home := os.Getenv("HOME"); len(queue) == 0 && len(home) > 0 {
+		queue = home + "/.mpd_scrobbles"
+	}
+
+	s := NewScrobbler(service, queue)
+
+	stop, release := untilInterrupted()
+	defer release()
+	return s.Run(c, stop)
+}
@@ -0,0 +1,325 @@
+// Copyright (c) 2010, Jim Teeuwen. All rights reserved.
+// This code is subject to a 1-clause BSD license.
+// See the LICENSE file for its contents.
+
+package mpd
+
+import (
+	"bytes"
+	"fmt"
+	"http"
+	"http/httptest"
+	"io/ioutil"
+	"json"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Records the calls of a Scrobbler.
+type testService struct {
+	playing   chan *Scrobble
+	scrobbled []*Scrobble
+	err       os.Error // Returned by Scrobble.
+}
+
+func newTestService() *testService {
+	return &testService{playing: make(chan *Scrobble, 10)}
+}
+
+func (this *testService) NowPlaying(s *Scrobble) os.Error {
+	this.playing <- s
+	return nil
+}
+
+func (this *testService) Scrobble(list []*Scrobble) os.Error {
+	if this.err != nil {
+		return this.err
+	}
+	this.scrobbled = append(this.scrobbled, list...)
+	return nil
+}
+
+func (this *testService) titles() (list []string) {
+	for _, s := range this.scrobbled {
+		list = append(list, s.Title)
+	}
+	return
+}
+
+// Starts a stand-in for a scrobbling service which stores the last request
+// it received, and responds with @status.
+func startService(status *int, last **http.Request, body *string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := ioutil.ReadAll(r.Body)
+		*body = string(data)
+		r.Body = ioutil.NopCloser(strings.NewReader(*body))
+		r.ParseForm()
+		*last = r
+
+		w.WriteHeader(*status)
+		w.Write([]byte(`{"status":"ok"}`))
+	}))
+}
+
+func TestLastfmService(t *testing.T) {
+	var last *http.Request
+	var body string
+	status := 200
+
+	hs := startService(&status, &last, &body)
+	defer hs.Close()
+
+	lfm := &LastfmService{hs.URL, "key", "secret", "session"}
+	song := &Scrobble{"AC/DC", "Hells Bells", "Back in Black", "1", 312, 1300000000}
+
+	if err := lfm.Scrobble([]*Scrobble{song}); err != nil {
+		t.Fatalf("Scrobble: %v", err)
+	}
+
+	f := last.Form
+	if f.Get("method") != "track.scrobble" || f.Get("artist[0]") != "AC/DC" || f.Get("timestamp[0]") != "1300000000" || f.Get("sk") != "session" {
+		t.Errorf("Unexpected request %v.", f)
+	}
+
+	params := make(map[string]interface{})
+	for k, v := range f {
+		params[k] = v[0]
+	}
+
+	if sig := lastfmSignature(params, "secret"); f.Get("api_sig") != sig {
+		t.Errorf("Expected signature %s, got %s.", sig, f.Get("api_sig"))
+	}
+
+	if err := lfm.NowPlaying(song); err != nil || last.Form.Get("method") != "track.updateNowPlaying" {
+		t.Errorf("NowPlaying: %v %v", err, last.Form)
+	}
+
+	status = 503
+	if err := lfm.Scrobble([]*Scrobble{song}); err == nil || isRejected(err) {
+		t.Errorf("Expected a temporary error, got %v.", err)
+	}
+
+	status = 400
+	if err := lfm.Scrobble([]*Scrobble{song}); err == nil || !isRejected(err) {
+		t.Errorf("Expected a rejection, got %v.", err)
+	}
+}
+
+func TestListenBrainzService(t *testing.T) {
+	var last *http.Request
+	var body string
+	status := 200
+
+	hs := startService(&status, &last, &body)
+	defer hs.Close()
+
+	lb := &ListenBrainzService{hs.URL, "token"}
+	song := &Scrobble{"AC/DC", "Hells Bells", "", "", 312, 1300000000}
+
+	if err := lb.Scrobble([]*Scrobble{song}); err != nil {
+		t.Fatalf("Scrobble: %v", err)
+	}
+
+	if auth := last.Header.Get("Authorization"); auth != "Token token" {
+		t.Errorf("Unexpected authorization %q.", auth)
+	}
+
+	var v struct {
+		Listen_type string
+		Payload     []struct {
+			Listened_at    int64
+			Track_metadata struct {
+				Artist_name  string
+				Track_name   string
+				Release_name string
+			}
+		}
+	}
+
+	if err := json.Unmarshal([]byte(body), &v); err != nil {
+		t.Fatalf("Invalid JSON %s: %v", body, err)
+	}
+
+	if v.Listen_type != "single" || len(v.Payload) != 1 || v.Payload[0].Listened_at != 1300000000 ||
+		v.Payload[0].Track_metadata.Track_name != "Hells Bells" || v.Payload[0].Track_metadata.Release_name != "" {
+		t.Errorf("Unexpected submission %s.", body)
+	}
+
+	if err := lb.NowPlaying(song); err != nil || strings.Index(body, `"playing_now"`) == -1 || strings.Index(body, "listened_at") != -1 {
+		t.Errorf("NowPlaying: %v %s", err, body)
+	}
+}
+
+func TestScrobblerRules(t *testing.T) {
+	svc := newTestService()
+	s := NewScrobbler(svc, "")
+
+	var clock int64
+	s.now = func() int64 { return clock }
+
+	play := &Status{State: "play"}
+
+	song := func(id, title, time string) []Pair {
+		return []Pair{Pair{"file", title + ".mp3"}, Pair{"Artist", "X"}, Pair{"Title", title}, Pair{"Time", time}, Pair{"Id", id}}
+	}
+
+	// Hells Bells needs 156s, and gets 100s.
+	s.observe(song("1", "Hells Bells", "312"), play)
+	if np := <-svc.playing; np.Title != "Hells Bells" || np.Start != 0 {
+		t.Errorf("Unexpected now playing %v.", np)
+	}
+
+	// Back in Black needs 127.5s, and gets 130s + 30s. The pause does not
+	// count.
+	clock = 100e9
+	s.observe(song("2", "Back in Black", "255"), play)
+	<-svc.playing
+
+	clock += 130e9
+	s.observe(song("2", "Back in Black", "255"), &Status{State: "pause", Elapsed: 130e9})
+	clock += 1000e9
+	s.observe(song("2", "Back in Black", "255"), &Status{State: "play", Elapsed: 130e9})
+	clock += 30e9
+
+	// Short songs are never submitted.
+	s.observe(song("3", "Intro", "20"), play)
+	clock += 20e9
+
+	// Long songs need 4 minutes.
+	s.observe(song("4", "So What", "562"), play)
+	<-svc.playing
+	clock += 240e9
+
+	s.observe(nil, &Status{State: "stop"})
+	s.flush()
+
+	if got := fmt.Sprint(svc.titles()); got != "[Back in Black So What]" {
+		t.Errorf("Unexpected scrobbles %s.", got)
+	}
+
+	if len(svc.playing) != 0 {
+		t.Errorf("Unexpected now playing for a short song.")
+	}
+}
+
+func TestScrobblerRepeat(t *testing.T) {
+	svc := newTestService()
+	s := NewScrobbler(svc, "")
+
+	var clock int64
+	s.now = func() int64 { return clock }
+
+	song := []Pair{Pair{"file", "a.mp3"}, Pair{"Artist", "X"}, Pair{"Title", "A"}, Pair{"Time", "100"}, Pair{"Id", "1"}}
+
+	// The same queue entry plays twice with single and repeat on. Seeking
+	// back within the song does not count as playing it again.
+	s.observe(song, &Status{State: "play"})
+	clock += 60e9
+	s.observe(song, &Status{State: "play", Elapsed: 30e9})
+	clock += 70e9
+	s.observe(song, &Status{State: "play", Elapsed: 1e9})
+	clock += 100e9
+	s.observe(nil, &Status{State: "stop"})
+	s.flush()
+
+	if got := fmt.Sprint(svc.titles()); got != "[A A]" {
+		t.Errorf("Unexpected scrobbles %s.", got)
+	}
+
+	if len(svc.playing) != 2 {
+		t.Errorf("Expected now playing twice, got %d.", len(svc.playing))
+	}
+}
+
+func TestScrobblerQueue(t *testing.T) {
+	f, err := ioutil.TempFile("", "scrobbles")
+	if err != nil {
+		t.Fatalf("TempFile: %v", err)
+	}
+	f.Close()
+	defer os.Remove(f.Name())
+
+	svc := newTestService()
+	svc.err = os.NewError("offline")
+
+	s := NewScrobbler(svc, f.Name())
+	s.queue = append(s.queue, &Scrobble{"AC/DC", "Hells Bells", "", "", 312, 1})
+	s.flush()
+
+	if s.Pending() != 1 {
+		t.Fatalf("Expected the scrobble to stay queued.")
+	}
+
+	// A new scrobbler picks up where the old one stopped.
+	svc.err = nil
+	s = NewScrobbler(svc, f.Name())
+	if err = s.loadQueue(); err != nil {
+		t.Fatalf("loadQueue: %v", err)
+	}
+
+	s.flush()
+	if s.Pending() != 0 || len(svc.scrobbled) != 1 || svc.scrobbled[0].Title != "Hells Bells" {
+		t.Errorf("Expected the queue to be submitted, got %v.", svc.scrobbled)
+	}
+
+	if _, err = os.Stat(f.Name()); err == nil {
+		t.Errorf("Expected the empty queue file to be removed.")
+	}
+
+	// Rejected submissions are dropped.
+	svc.err = &httpError{400, "invalid"}
+	s.queue = append(s.queue, &Scrobble{"AC/DC", "Hells Bells", "", "", 312, 1})
+	if s.flush(); s.Pending() != 0 {
+		t.Errorf("Expected a rejected scrobble to be dropped.")
+	}
+}
+
+func TestScrobblerRun(t *testing.T) {
+	srv, c := startServer(t)
+	defer srv.Close()
+	defer c.Close()
+
+	srv.Enqueue("rock/acdc/hells_bells.mp3")
+
+	svc := newTestService()
+	s := NewScrobbler(svc, "")
+	s.Log = new(bytes.Buffer)
+
+	stop := make(chan bool)
+	result := make(chan os.Error, 1)
+	go func() {
+		result <- s.Run(c, stop)
+	}()
+
+	other := newClient()
+	if err := other.Open("tcp", srv.Addr()); err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer other.Close()
+
+	if err := runCommand(other, "play", "0"); err != nil {
+		t.Fatalf("play: %v", err)
+	}
+
+	timeout := make(chan bool, 1)
+	go func() {
+		time.Sleep(5e9)
+		timeout <- true
+	}()
+
+	select {
+	case np := <-svc.playing:
+		if np.Title != "Hells Bells" || np.Duration != 312 {
+			t.Errorf("Unexpected now playing %v.", np)
+		}
+	case <-timeout:
+		t.Fatalf("Timed out waiting for now playing.")
+	}
+
+	close(stop)
+	if err := <-result; err != nil {
+		t.Errorf("Run: %v", err)
+	}
+}
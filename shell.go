@@ -0,0 +1,670 @@
+// Copyright (c) 2010, Jim Teeuwen. All rights reserved.
+// This code is subject to a 1-clause BSD license.
+// See the LICENSE file for its contents.
+
+package mpd
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+func init() {
+	mustRegister(GroupSession,
+		&Command{
+			Name: "shell",
+			Desc: "Starts an interactive shell which runs commands over a single connection. Supports quoting, history and tab completion of commands, tags, playlists and library paths.",
+			Exec: shell,
+		},
+	)
+}
+
+// Shell reads commands from its input and runs them over a single
+// connection. Lines are split like a unix shell does, so arguments with
+// spaces can be quoted: add "rock/AC DC". When the input is a terminal,
+// the shell offers line editing, history and tab completion of command
+// names, flags, tag names, playlists and library paths.
+type Shell struct {
+	Client      *Client
+	Prompt      string
+	HistoryFile string // If set, history is loaded from and saved to this file.
+	MaxHistory  int
+	History     []string
+
+	in     *bufio.Reader
+	out    io.Writer
+	raw    bool     // Input is a terminal in raw mode.
+	tags   []string // Tag names, cached for completion.
+	errors int      // Number of failed commands.
+}
+
+func NewShell(c *Client, in io.Reader, out io.Writer) *Shell {
+	s := new(Shell)
+	s.Client = c
+	s.Prompt = "mpd> "
+	s.MaxHistory = 500
+	s.History = make([]string, 0)
+	s.in = bufio.NewReader(in)
+	s.out = out
+
+	if f, ok := in.(*os.File); ok && f == os.Stdin {
+		s.raw = stdinIsTerminal()
+	}
+	return s
+}
+
+// Reads and runs commands until the input ends or the user types 'exit'.
+// Failing commands are reported, but do not stop the shell.
+func (this *Shell) Run() (err os.Error) {
+	this.loadHistory()
+
+	var line string
+	for {
+		if line, err = this.readLine(); err != nil {
+			if err == os.EOF {
+				err = nil
+			}
+			break
+		}
+
+		if line = strings.TrimSpace(line); len(line) == 0 || line[0] == '#' {
+			continue
+		}
+
+		this.addHistory(line)
+
+		if !this.Exec(line) {
+			break
+		}
+	}
+
+	if this.raw {
+		fmt.Fprintln(this.out)
+	}
+	return
+}
+
+// Returns the number of commands which have failed so far.
+func (this *Shell) Errors() int {
+	return this.errors
+}
+
+// Runs a single line. Returns false if the shell should exit.
+func (this *Shell) Exec(line string) bool {
+	args, err := splitLine(line)
+	if err != nil {
+		this.fail(err)
+		return true
+	}
+
+	if len(args) == 0 {
+		return true
+	}
+
+	switch args[0] {
+	case "exit", "quit":
+		return false
+
+	case "history":
+		for i, v := range this.History {
+			fmt.Fprintf(this.out, "%5d  %s\n", i+1, v)
+		}
+		return true
+
+	case "help":
+		this.help(args[1:])
+		return true
+
+	case "shell":
+		this.fail(os.NewError("Already running a shell."))
+		return true
+	}
+
+	cmd := CreateCommand(args[0])
+	if cmd == nil {
+		this.fail(os.NewError(fmt.Sprintf("Unknown command '%s'. Type 'help' for a list of commands.", args[0])))
+		return true
+	}
+
+	if err = cmd.parse(args[1:]); err != nil {
+		this.fail(err)
+		return true
+	}
+
+	cmd.Output = this.out
+	err = cmd.Execute(this.Client)
+
+	// The server drops connections which have been idle for a while. If
+	// ours broke, open a new one and try once more.
+	if err != nil && this.Client.Failed() {
+		if err = this.Client.Reconnect(); err == nil {
+			err = cmd.Execute(this.Client)
+		}
+	}
+
+	if err != nil {
+		this.fail(err)
+	}
+	return true
+}
+
+func (this *Shell) fail(err os.Error) {
+	this.errors++
+	fmt.Fprintf(this.out, "error: %v\n", err)
+}
+
+// Describes a command, or lists all of them. Unlike the help command, this
+// also mentions the shell's own commands.
+func (this *Shell) help(args []string) {
+	if len(args) == 0 {
+		WriteOverview(this.out)
+		fmt.Fprintf(this.out, "\nShell commands: help [command], history, exit\n")
+		return
+	}
+
+	cmd := CreateCommand(args[0])
+	if cmd == nil {
+		this.fail(os.NewError(fmt.Sprintf("Unknown command '%s'.", args[0])))
+		return
+	}
+
+	WriteUsage(this.out, cmd)
+}
+
+/* History */
+
+func (this *Shell) addHistory(line string) {
+	if n := len(this.History); n > 0 && this.History[n-1] == line {
+		return
+	}
+
+	this.History = append(this.History, line)
+	if this.MaxHistory > 0 && len(this.History) > this.MaxHistory {
+		this.History = this.History[len(this.History)-this.MaxHistory:]
+	}
+
+	if len(this.HistoryFile) == 0 {
+		return
+	}
+
+	fd, err := os.Open(this.HistoryFile, os.O_WRONLY|os.O_CREAT|os.O_APPEND, 0600)
+	if err != nil {
+		return
+	}
+
+	fmt.Fprintln(fd, line)
+	fd.Close()
+}
+
+func (this *Shell) loadHistory() {
+	if len(this.HistoryFile) == 0 {
+		return
+	}
+
+	fd, err := os.Open(this.HistoryFile, os.O_RDONLY, 0)
+	if err != nil {
+		return
+	}
+
+	defer fd.Close()
+	r := bufio.NewReader(fd)
+
+	for {
+		line, err := r.ReadString('\n')
+		if line = strings.TrimSpace(line); len(line) > 0 {
+			this.History = append(this.History, line)
+		}
+
+		if err != nil {
+			break
+		}
+	}
+
+	if this.MaxHistory > 0 && len(this.History) > this.MaxHistory {
+		this.History = this.History[len(this.History)-this.MaxHistory:]
+	}
+}
+
+/* Line editing */
+
+// Reads the next line. In a terminal, this handles editing keys, history
+// and completion. Otherwise lines are read as they are.
+func (this *Shell) readLine() (line string, err os.Error) {
+	if !this.raw {
+		line, err = this.in.ReadString('\n')
+		if err == os.EOF && len(line) > 0 {
+			err = nil
+		}
+		return
+	}
+
+	var state string
+	if state, err = rawTerminal(); err != nil {
+		// Not a terminal after all. Fall back to plain input.
+		this.raw = false
+		fmt.Fprint(this.out, this.Prompt)
+		return this.readLine()
+	}
+
+	defer restoreTerminal(state)
+	return this.editLine()
+}
+
+func (this *Shell) editLine() (line string, err os.Error) {
+	var b byte
+	buf := make([]byte, 0, 64)
+	hpos := len(this.History)
+	saved := ""
+
+	fmt.Fprint(this.out, this.Prompt)
+
+	for {
+		if b, err = this.in.ReadByte(); err != nil {
+			return
+		}
+
+		switch b {
+		case '\r', '\n':
+			fmt.Fprint(this.out, "\r\n")
+			return string(buf), nil
+
+		case 4: // Ctrl-D
+			if len(buf) == 0 {
+				return "", os.EOF
+			}
+
+		case 3: // Ctrl-C
+			fmt.Fprint(this.out, "^C\r\n")
+			buf = buf[:0]
+			hpos = len(this.History)
+			fmt.Fprint(this.out, this.Prompt)
+
+		case 21: // Ctrl-U
+			buf = buf[:0]
+			this.redraw(buf)
+
+		case 127, 8: // Backspace
+			if len(buf) > 0 {
+				buf = buf[:len(buf)-1]
+				fmt.Fprint(this.out, "\b \b")
+			}
+
+		case '\t':
+			buf = this.completeLine(buf)
+
+		case 27: // Escape sequences. Only the up and down arrows are used.
+			var seq [2]byte
+			if seq[0], err = this.in.ReadByte(); err != nil {
+				return
+			}
+
+			if seq[1], err = this.in.ReadByte(); err != nil {
+				return
+			}
+
+			if seq[0] != '[' {
+				continue
+			}
+
+			switch {
+			case seq[1] == 'A' && hpos > 0:
+				if hpos == len(this.History) {
+					saved = string(buf)
+				}
+				hpos--
+				buf = append(buf[:0], this.History[hpos]...)
+				this.redraw(buf)
+
+			case seq[1] == 'B' && hpos < len(this.History):
+				if hpos++; hpos == len(this.History) {
+					buf = append(buf[:0], saved...)
+				} else {
+					buf = append(buf[:0], this.History[hpos]...)
+				}
+				this.redraw(buf)
+			}
+
+		default:
+			if b >= 32 {
+				buf = append(buf, b)
+				this.out.Write([]byte{b})
+			}
+		}
+	}
+	return
+}
+
+func (this *Shell) redraw(buf []byte) {
+	fmt.Fprintf(this.out, "\r\x1b[K%s%s", this.Prompt, buf)
+}
+
+// Completes the word under the cursor. A single match replaces the word.
+// Several matches are extended to their common prefix, or listed if there
+// is nothing to extend.
+func (this *Shell) completeLine(buf []byte) []byte {
+	start, list := this.complete(string(buf))
+	if len(list) == 0 {
+		return buf
+	}
+
+	_, quote, _, _ := tokenize(string(buf))
+	word := string(buf[start:])
+
+	if len(list) == 1 {
+		buf = append(buf[:start], quoteWord(list[0], quote != 0)...)
+		if !strings.HasSuffix(list[0], "/") {
+			if needsQuote(list[0]) || quote != 0 {
+				buf = append(buf, '"')
+			}
+			buf = append(buf, ' ')
+		}
+		this.redraw(buf)
+		return buf
+	}
+
+	if prefix := commonPrefix(list); len(prefix) > len(unquoteWord(word)) {
+		buf = append(buf[:start], quoteWord(prefix, quote != 0)...)
+		this.redraw(buf)
+		return buf
+	}
+
+	fmt.Fprint(this.out, "\r\n")
+	for _, v := range list {
+		fmt.Fprintf(this.out, "%s\r\n", v)
+	}
+	this.redraw(buf)
+	return buf
+}
+
+/* Completion */
+
+// Returns the candidates for the word at the end of @line and the offset at
+// which that word starts.
+func (this *Shell) complete(line string) (start int, list []string) {
+	words, _, start, partial := tokenize(line)
+
+	word := ""
+	if partial {
+		word = words[len(words)-1]
+		words = words[:len(words)-1]
+	}
+
+	if len(words) == 0 {
+		names := append(CommandList(), "exit", "history")
+		return start, filterPrefix(names, word)
+	}
+
+	cmd := CreateCommand(words[0])
+	if words[0] == "help" && len(words) == 1 {
+		return start, filterPrefix(CommandList(), word)
+	}
+
+	if cmd == nil {
+		return start, nil
+	}
+
+	if strings.HasPrefix(word, "-") {
+		flags := make([]string, 0)
+		for _, p := range cmd.Params {
+			if p.Flag {
+				flags = append(flags, "--"+p.Name)
+			}
+		}
+		return start, filterPrefix(flags, word)
+	}
+
+	// Find the positional parameter this word is for.
+	index := 0
+	for _, w := range words[1:] {
+		if !strings.HasPrefix(w, "--") {
+			index++
+		}
+	}
+
+	var param *Param
+	for _, p := range cmd.Params {
+		if p.Flag {
+			continue
+		}
+
+		if index == 0 {
+			param = p
+			break
+		}
+		index--
+	}
+
+	if param == nil {
+		return start, nil
+	}
+
+	switch {
+	case param.Name == "path":
+		list = this.paths(word)
+	case param.Name == "name" || param.Name == "oldname":
+		list = this.playlists()
+	case param.Kind == TagKind:
+		list = this.tagNames()
+	default:
+		if c, ok := param.Kind.(Chooser); ok {
+			list = c.Choices()
+		}
+	}
+
+	return start, filterPrefix(list, word)
+}
+
+// Returns the library entries in the directory named by @prefix. Directories
+// end with a slash.
+func (this *Shell) paths(prefix string) []string {
+	var err os.Error
+	dir := ""
+	if pos := strings.LastIndex(prefix, "/"); pos != -1 {
+		dir = prefix[:pos]
+	}
+
+	if len(dir) == 0 {
+		err = this.Client.send("lsinfo")
+	} else {
+		err = this.Client.send("lsinfo %s", quote(dir))
+	}
+
+	if err != nil {
+		return nil
+	}
+
+	entries, err := this.Client.receiveEntries("directory", "file", "playlist")
+	if err != nil {
+		return nil
+	}
+
+	list := make([]string, 0, len(entries))
+	for _, e := range entries {
+		if v, ok := e["directory"]; ok {
+			list = append(list, v+"/")
+		} else if v, ok := e["file"]; ok {
+			list = append(list, v)
+		}
+	}
+	return list
+}
+
+func (this *Shell) playlists() []string {
+	if this.Client.send("listplaylists") != nil {
+		return nil
+	}
+
+	entries, err := this.Client.receiveEntries("playlist")
+	if err != nil {
+		return nil
+	}
+
+	list := make([]string, 0, len(entries))
+	for _, e := range entries {
+		list = append(list, e["playlist"])
+	}
+	return list
+}
+
+// Returns the tag names the server supports, in the lower case form the
+// commands expect.
+func (this *Shell) tagNames() []string {
+	if this.tags != nil {
+		return this.tags
+	}
+
+	tags, err := this.Client.TagTypes()
+	if err != nil {
+		return nil
+	}
+
+	this.tags = []string{"any", "filename"}
+	for _, t := range tags {
+		this.tags = append(this.tags, strings.ToLower(t))
+	}
+	return this.tags
+}
+
+/* Line splitting */
+
+// Splits a command line into words. Words can be quoted with single or
+// double quotes. Outside single quotes, a backslash escapes the next
+// character.
+func splitLine(line string) (words []string, err os.Error) {
+	var quote byte
+	if words, quote, _, _ = tokenize(line); quote != 0 {
+		return nil, os.NewError(fmt.Sprintf("Missing closing %c.", quote))
+	}
+	return
+}
+
+// Splits @line into words. Also returns the open quote character, if the
+// line ends inside quotes, the offset at which the last word starts and
+// whether that word is still being typed.
+func tokenize(line string) (words []string, quote byte, start int, partial bool) {
+	var buf []byte
+	have := false
+
+	words = make([]string, 0)
+	start = len(line)
+
+	for i := 0; i < len(line); i++ {
+		ch := line[i]
+
+		if !have && !isSpace(ch) {
+			start = i
+		}
+
+		switch {
+		case quote == '\'' && ch != '\'':
+			buf = append(buf, ch)
+
+		case ch == '\\' && i+1 < len(line):
+			i++
+			buf = append(buf, line[i])
+			have = true
+
+		case quote == 0 && (ch == '"' || ch == '\''):
+			quote = ch
+			have = true
+
+		case quote != 0 && ch == quote:
+			quote = 0
+
+		case quote == 0 && isSpace(ch):
+			if have {
+				words = append(words, string(buf))
+				buf = buf[:0]
+				have = false
+			}
+			start = len(line)
+
+		default:
+			buf = append(buf, ch)
+			have = true
+		}
+	}
+
+	if have {
+		words = append(words, string(buf))
+		partial = true
+	}
+	return
+}
+
+func isSpace(ch byte) bool {
+	return ch == ' ' || ch == '\t'
+}
+
+// Quotes @word for the command line if needed. The closing quote is left
+// off, so the word can still be extended.
+func quoteWord(word string, force bool) string {
+	if !force && !needsQuote(word) {
+		return word
+	}
+	return `"` + strings.Replace(strings.Replace(word, `\`, `\\`, -1), `"`, `\"`, -1)
+}
+
+func unquoteWord(word string) string {
+	words, _, _, _ := tokenize(word)
+	if len(words) == 0 {
+		return ""
+	}
+	return words[0]
+}
+
+func needsQuote(word string) bool {
+	for i := 0; i < len(word); i++ {
+		switch word[i] {
+		case ' ', '\t', '"', '\'', '\\':
+			return true
+		}
+	}
+	return false
+}
+
+func filterPrefix(list []string, prefix string) []string {
+	ret := make([]string, 0, len(list))
+	for _, v := range list {
+		if strings.HasPrefix(v, prefix) {
+			ret = append(ret, v)
+		}
+	}
+
+	sort.SortStrings(ret)
+	return ret
+}
+
+func commonPrefix(list []string) string {
+	if len(list) == 0 {
+		return ""
+	}
+
+	prefix := list[0]
+	for _, v := range list[1:] {
+		n := 0
+		for n < len(prefix) && n < len(v) && prefix[n] == v[n] {
+			n++
+		}
+		prefix = prefix[:n]
+	}
+	return prefix
+}
+
+func stdinIsTerminal() bool {
+	_, err := stty("-g")
+	return err == nil
+}
+
+func shell(cmd *Command, c *Client) (err os.Error) {
+	s := NewShell(c, os.Stdin, os.Stdout)
+
+	if home := os.Getenv("HOME"); len(home) > 0 {
+		s.HistoryFile = home + "/.mpd_history"
+	}
+	return s.Run()
+}
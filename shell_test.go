@@ -0,0 +1,165 @@
+// Copyright (c) 2010, Jim Teeuwen. All rights reserved.
+// This code is subject to a 1-clause BSD license.
+// See the LICENSE file for its contents.
+
+package mpd
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestSplitLine(t *testing.T) {
+	tests := map[string]string{
+		`add rock`:                "add|rock",
+		`  add   "rock/AC DC"  `:  "add|rock/AC DC",
+		`find artist 'Guns N\'`:   `find|artist|Guns N\`,
+		`find title "say \"hi\""`: `find|title|say "hi"`,
+		`add rock/AC\ DC`:         "add|rock/AC DC",
+		`save ""`:                 "save|",
+		`find "a"'b'c`:            "find|abc",
+	}
+
+	for line, want := range tests {
+		words, err := splitLine(line)
+		if err != nil {
+			t.Errorf("%s: %v", line, err)
+			continue
+		}
+
+		if got := strings.Join(words, "|"); got != want {
+			t.Errorf("%s: Expected '%s', got '%s'.", line, want, got)
+		}
+	}
+
+	for _, line := range []string{`add "rock`, `add 'rock`} {
+		if _, err := splitLine(line); err == nil {
+			t.Errorf("%s: Expected error.", line)
+		}
+	}
+}
+
+func TestShellComplete(t *testing.T) {
+	srv, c := startServer(t)
+	defer srv.Close()
+	defer c.Close()
+
+	srv.AddPlaylist("party", "rock/acdc/hells_bells.mp3")
+
+	s := NewShell(c, strings.NewReader(""), new(bytes.Buffer))
+
+	tests := map[string]string{
+		"plc":               "plchanges|plchangesid|plclear",
+		"add ":              "jazz/|rock/",
+		"add r":             "rock/",
+		"add rock/acdc/hel": "rock/acdc/hells_bells.mp3",
+		`add "rock/acdc/ba`: "rock/acdc/back_in_black.mp3",
+		"random o":          "off|on",
+		"consume on":        "on|oneshot",
+		"find art":          "artist|artistsort",
+		"load p":            "party",
+		"update --w":        "--wait",
+		"update rock --":    "--wait",
+		"help sim":          "simplestatus",
+		"play ":             "",
+		"bogus ":            "",
+	}
+
+	for line, want := range tests {
+		_, list := s.complete(line)
+		if got := strings.Join(list, "|"); got != want {
+			t.Errorf("%s: Expected '%s', got '%s'.", line, want, got)
+		}
+	}
+
+	if start, _ := s.complete(`add "rock/a`); start != 4 {
+		t.Errorf("Expected word to start at 4, got %d.", start)
+	}
+}
+
+func TestShellRun(t *testing.T) {
+	srv, c := startServer(t)
+	defer srv.Close()
+	defer c.Close()
+
+	script := "random on\n# comment\n\nbogus\nadd \"jazz/davis\"\nvolume 300\nexit\nstop\n"
+
+	var out bytes.Buffer
+	s := NewShell(c, strings.NewReader(script), &out)
+
+	if err := s.Run(); err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+
+	if s.Errors() != 2 {
+		t.Errorf("Expected 2 errors, got %d: %s", s.Errors(), out.String())
+	}
+
+	sent := strings.Join(srv.Commands(), "|")
+	if sent != `random 1|add "jazz/davis"` {
+		t.Errorf("Unexpected commands '%s'.", sent)
+	}
+
+	if len(s.History) != 5 || s.History[4] != "exit" {
+		t.Errorf("Unexpected history %v.", s.History)
+	}
+}
+
+func TestShellReconnect(t *testing.T) {
+	srv, other := startServer(t)
+	defer srv.Close()
+	defer other.Close()
+
+	c, err := Dial(testConfig(srv))
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer c.Close()
+
+	// The server closes the connection while the shell waits for input.
+	srv.DropConnections()
+	srv.ResetCommands()
+
+	var out bytes.Buffer
+	s := NewShell(c, strings.NewReader("random on\nbogus\n"), &out)
+
+	if err = s.Run(); err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+
+	if s.Errors() != 1 {
+		t.Errorf("Expected 1 error, got %d: %s", s.Errors(), out.String())
+	}
+
+	if sent := strings.Join(srv.Commands(), "|"); sent != "random 1" {
+		t.Errorf("Unexpected commands '%s'.", sent)
+	}
+}
+
+func TestShellEditLine(t *testing.T) {
+	srv, c := startServer(t)
+	defer srv.Close()
+	defer c.Close()
+
+	var out bytes.Buffer
+	input := "ran\ton\n" + "\x1b[A\x1b[A\n" + "stop\x7f\x7f\x7f\x7f\x15next\n" + "x\x03\x04"
+
+	s := NewShell(c, strings.NewReader(input), &out)
+	s.History = []string{"status", "stats"}
+
+	for _, want := range []string{"random on", "status", "next"} {
+		line, err := s.editLine()
+		if err != nil {
+			t.Fatalf("editLine: %v", err)
+		}
+
+		if line != want {
+			t.Errorf("Expected '%s', got '%s'.", want, line)
+		}
+	}
+
+	if _, err := s.editLine(); err == nil {
+		t.Errorf("Expected end of input after Ctrl-D.")
+	}
+}
@@ -0,0 +1,544 @@
+// Copyright (c) 2010, Jim Teeuwen. All rights reserved.
+// This code is subject to a 1-clause BSD license.
+// See the LICENSE file for its contents.
+
+package mpd
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"rand"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func init() {
+	mustRegister(GroupPlaylist,
+		&Command{
+			Name: "smartpl",
+			Desc: "Replaces the stored playlist @name with the songs chosen by the rules in @file. Run it again to refresh the playlist. See SmartPlaylist for the rules.",
+			Params: []*Param{
+				newParam("file", "The rule file. Use - to read it from standard input.", TextKind, false),
+				newParam("name", "The stored playlist to replace. Defaults to the name of @file, without its extension.", TextKind, true),
+				newFlag("queue", "Replace the queue instead of a stored playlist."),
+			},
+			Exec: smartpl,
+		},
+	)
+}
+
+// SmartPlaylist chooses songs from the library by a set of rules, read from
+// a file like this:
+//
+//	# Unplayed jazz added in the last 30 days, at most two hours.
+//	where genre == Jazz
+//	where added within 30d
+//	sticker playcount missing
+//	order random
+//	limit time 2h
+//
+// Lines starting with # are comments. Values with spaces are quoted, as on
+// the command line. These statements are known:
+//
+//	where TAG OP VALUE     Keeps the songs whose tag compares to VALUE.
+//	sticker NAME OP VALUE  Keeps the songs whose sticker compares to VALUE.
+//	sticker NAME missing   Keeps the songs without the sticker.
+//	sticker NAME exists    Keeps the songs with the sticker.
+//	order TAG [desc]       Sorts the songs by a tag, or by a sticker which
+//	                       a sticker rule reads. More lines break ties.
+//	order random           Shuffles the songs.
+//	limit songs N          Keeps the first N songs.
+//	limit time DURATION    Keeps the first songs which fit in DURATION.
+//
+// OP is one of ==, !=, <, <=, >, >=, contains or within. Values which are
+// both numbers are compared as numbers, others as text. 'within AGE' keeps
+// dates which are no older than AGE, like 12h, 30d or 2w. Besides the tags
+// of a song, 'added' holds the time it was added to the library, or last
+// modified on servers which do not report that, and 'file' holds its path.
+//
+// Tags may have several values, like a song in two genres. A rule keeps
+// the song if any of them satisfies it, except for != which keeps it only
+// if none of them is equal. Songs are sorted by the first value of a tag.
+//
+// Songs are found with the find command, which matches all 'where TAG ==
+// VALUE' rules exactly, or with listallinfo if there are none of those.
+// Stickers are read with sticker find. The other rules are applied to the
+// result.
+type SmartPlaylist struct {
+	Rules    []*SmartRule
+	Order    []*SmartOrder
+	Random   bool
+	MaxSongs int   // 0 for no limit.
+	MaxTime  int64 // In nanoseconds, or 0 for no limit.
+
+	now  func() int64      // Unix time in seconds.
+	perm func(n int) []int // Random order of n songs.
+}
+
+// A condition on a tag or a sticker of a song.
+type SmartRule struct {
+	Sticker bool // Name is a sticker instead of a tag.
+	Name    string
+	Op      string
+	Value   string
+	age     int64 // Value of 'within', in seconds.
+}
+
+type SmartOrder struct {
+	Tag  string
+	Desc bool
+}
+
+var smartOps = []string{"==", "!=", "<", "<=", ">", ">=", "contains", "within"}
+
+func NewSmartPlaylist() *SmartPlaylist {
+	sp := new(SmartPlaylist)
+	sp.Rules = make([]*SmartRule, 0)
+	sp.Order = make([]*SmartOrder, 0)
+	sp.now = time.Seconds
+
+	r := rand.New(rand.NewSource(time.Nanoseconds()))
+	sp.perm = func(n int) []int { return r.Perm(n) }
+	return sp
+}
+
+// Reads the rules of a smart playlist from @r.
+func ParseSmartPlaylist(r io.Reader) (sp *SmartPlaylist, err os.Error) {
+	var data []byte
+	if data, err = ioutil.ReadAll(r); err != nil {
+		return
+	}
+
+	sp = NewSmartPlaylist()
+	for n, line := range strings.Split(string(data), "\n", -1) {
+		if line = strings.TrimSpace(line); len(line) == 0 || line[0] == '#' {
+			continue
+		}
+
+		var args []string
+		if args, err = splitLine(line); err != nil {
+			return nil, lineError(n+1, err)
+		}
+
+		if err = sp.parse(args); err != nil {
+			return nil, lineError(n+1, err)
+		}
+	}
+	return
+}
+
+// Reads the rules of a smart playlist from the file @name.
+func LoadSmartPlaylist(name string) (sp *SmartPlaylist, err os.Error) {
+	var fd *os.File
+	if fd, err = os.Open(name, os.O_RDONLY, 0); err != nil {
+		return
+	}
+
+	defer fd.Close()
+	return ParseSmartPlaylist(fd)
+}
+
+// Parses a single statement.
+func (this *SmartPlaylist) parse(args []string) (err os.Error) {
+	switch args[0] {
+	case "where", "sticker":
+		rule := &SmartRule{Sticker: args[0] == "sticker"}
+		if len(args) == 3 && rule.Sticker && (args[2] == "missing" || args[2] == "exists") {
+			rule.Name, rule.Op = args[1], args[2]
+			this.Rules = append(this.Rules, rule)
+			return
+		}
+
+		if len(args) != 4 {
+			return os.NewError(fmt.Sprintf("Usage: %s <name> <op> <value>", args[0]))
+		}
+
+		rule.Name, rule.Op, rule.Value = args[1], args[2], args[3]
+		if !rule.Sticker {
+			rule.Name = strings.ToLower(rule.Name)
+		}
+
+		if !contains(smartOps, rule.Op) {
+			return os.NewError(fmt.Sprintf("Unknown operator '%s'. Use one of %s.",
+				rule.Op, strings.Join(smartOps, ", ")))
+		}
+
+		if rule.Op == "within" {
+			if rule.age, err = parseAge(rule.Value); err != nil {
+				return
+			}
+		}
+		this.Rules = append(this.Rules, rule)
+
+	case "order":
+		switch {
+		case len(args) == 2 && args[1] == "random":
+			this.Random = true
+		case len(args) == 2, len(args) == 3 && args[2] == "desc":
+			this.Order = append(this.Order, &SmartOrder{strings.ToLower(args[1]), len(args) == 3})
+		default:
+			return os.NewError("Usage: order <tag> [desc] or order random")
+		}
+
+		if this.Random && len(this.Order) > 0 {
+			return os.NewError("Random order can not be combined with sorting.")
+		}
+
+	case "limit":
+		if len(args) != 3 {
+			return os.NewError("Usage: limit songs <count> or limit time <duration>")
+		}
+
+		switch args[1] {
+		case "songs":
+			if this.MaxSongs, err = strconv.Atoi(args[2]); err != nil || this.MaxSongs < 1 {
+				return os.NewError(fmt.Sprintf("Invalid number of songs '%s'.", args[2]))
+			}
+		case "time":
+			if this.MaxTime, err = ParseDuration(args[2]); err != nil {
+				return
+			}
+		default:
+			return os.NewError(fmt.Sprintf("Unknown limit '%s'. Use songs or time.", args[1]))
+		}
+
+	default:
+		return os.NewError(fmt.Sprintf("Unknown statement '%s'.", args[0]))
+	}
+	return
+}
+
+// Parses an age like 30d or 2w, or any duration ParseDuration accepts, into
+// seconds.
+func parseAge(v string) (int64, os.Error) {
+	if n := len(v); n > 1 && (v[n-1] == 'd' || v[n-1] == 'w') {
+		days, err := strconv.Atoi64(v[:n-1])
+		if err != nil || days < 0 {
+			return 0, os.NewError(fmt.Sprintf("Invalid age '%s'.", v))
+		}
+
+		if v[n-1] == 'w' {
+			days *= 7
+		}
+		return days * 86400, nil
+	}
+
+	ns, err := ParseDuration(v)
+	return ns / 1e9, err
+}
+
+// Returns the songs chosen by the rules, in order.
+func (this *SmartPlaylist) Songs(c *Client) (songs []Args, err os.Error) {
+	pairs := make([]string, 0)
+	for _, r := range this.Rules {
+		if !r.Sticker && r.Op == "==" && r.Name != "added" {
+			pairs = append(pairs, r.Name, r.Value)
+		}
+	}
+
+	// Read as pairs, which keep every value of a tag.
+	var all [][]Pair
+	if len(pairs) > 0 {
+		all, err = c.findPairs(pairs...)
+	} else {
+		all, err = c.listAllInfoPairs("")
+	}
+
+	if err != nil {
+		return
+	}
+
+	stickers := make(map[string]map[string]string)
+	for _, r := range this.Rules {
+		if _, ok := stickers[r.Name]; r.Sticker && !ok {
+			if stickers[r.Name], err = c.FindStickers("", r.Name); err != nil {
+				return
+			}
+		}
+	}
+
+	list := make([][]Pair, 0, len(all))
+	for _, s := range all {
+		if this.match(s, stickers) {
+			list = append(list, s)
+		}
+	}
+
+	if this.Random {
+		shuffled := make([][]Pair, len(list))
+		for i, j := range this.perm(len(list)) {
+			shuffled[i] = list[j]
+		}
+		list = shuffled
+	} else {
+		sort.Sort(&smartSongs{list, this.Order, stickers})
+	}
+	return this.limit(songArgs(list)), nil
+}
+
+func (this *SmartPlaylist) match(song []Pair, stickers map[string]map[string]string) bool {
+	for _, r := range this.Rules {
+		var values []string
+		if r.Sticker {
+			// Songs start with their file.
+			if v, ok := stickers[r.Name][song[0].Value]; ok {
+				values = []string{v}
+			}
+		} else {
+			values = smartValues(song, r.Name)
+		}
+
+		if !r.match(values, this.now()) {
+			return false
+		}
+	}
+	return true
+}
+
+// Reports whether the values of a tag or sticker satisfy the rule. @now is
+// the current time in seconds.
+func (this *SmartRule) match(values []string, now int64) bool {
+	switch this.Op {
+	case "missing":
+		return len(values) == 0
+	case "exists":
+		return len(values) > 0
+	case "!=":
+		for _, v := range values {
+			if compareValues(v, this.Value) == 0 {
+				return false
+			}
+		}
+		return true
+	}
+
+	for _, v := range values {
+		if this.matchValue(v, now) {
+			return true
+		}
+	}
+	return false
+}
+
+func (this *SmartRule) matchValue(v string, now int64) bool {
+	switch this.Op {
+	case "contains":
+		return strings.Index(strings.ToLower(v), strings.ToLower(this.Value)) != -1
+	case "within":
+		t, err := time.Parse(time.RFC3339, v)
+		return err == nil && t.Seconds() >= now-this.age
+	}
+	return compareOp(compareValues(v, this.Value), this.Op)
+}
+
+// Keeps the first songs which fit the limits.
+func (this *SmartPlaylist) limit(songs []Args) []Args {
+	if this.MaxSongs == 0 && this.MaxTime == 0 {
+		return songs
+	}
+
+	list := make([]Args, 0)
+	var total int64
+
+	for _, s := range songs {
+		if this.MaxSongs > 0 && len(list) >= this.MaxSongs {
+			break
+		}
+
+		// Songs which do not fit are skipped, so that shorter ones after
+		// them can fill the remaining time.
+		d := s.Int64("Time", 0) * 1e9
+		if this.MaxTime > 0 && total+d > this.MaxTime {
+			continue
+		}
+
+		list = append(list, s)
+		total += d
+	}
+	return list
+}
+
+// Replaces the stored playlist @name, or the queue if @name is empty, with
+// the songs chosen by the rules. Returns those songs.
+func (this *SmartPlaylist) Save(c *Client, name string) (songs []Args, err os.Error) {
+	if songs, err = this.Songs(c); err != nil {
+		return
+	}
+
+	commands := make([]string, 0, len(songs)+1)
+	if len(name) == 0 {
+		commands = append(commands, "clear")
+	} else {
+		commands = append(commands, "playlistclear "+quote(name))
+	}
+
+	for _, s := range songs {
+		if len(name) == 0 {
+			commands = append(commands, "add "+quote(s["file"]))
+		} else {
+			commands = append(commands, fmt.Sprintf("playlistadd %s %s", quote(name), quote(s["file"])))
+		}
+	}
+
+	err = c.ExecuteList(commands...)
+	return
+}
+
+// Returns the values of the field @name of @song, ignoring case. See
+// smartField.
+func smartValues(song []Pair, name string) []string {
+	if name == "added" {
+		if list := smartValues(song, "Added"); len(list) > 0 {
+			return list
+		}
+		name = "last-modified"
+	}
+
+	list := make([]string, 0, 1)
+	for _, p := range song {
+		if strings.ToLower(p.Key) == strings.ToLower(name) {
+			list = append(list, p.Value)
+		}
+	}
+	return list
+}
+
+// Returns the value of the field @name of @song, ignoring case. 'added' is
+// the time the song was added to the library, or else last modified.
+func smartField(song Args, name string) (string, bool) {
+	if name == "added" {
+		if v, ok := song["Added"]; ok {
+			return v, true
+		}
+		name = "last-modified"
+	}
+
+	for k, v := range song {
+		if strings.ToLower(k) == name {
+			return v, true
+		}
+	}
+	return "", false
+}
+
+// Compares two values as numbers if both are, or as text otherwise.
+func compareValues(a, b string) int {
+	x, errx := strconv.Atof64(a)
+	y, erry := strconv.Atof64(b)
+
+	switch {
+	case errx == nil && erry == nil && x < y:
+		return -1
+	case errx == nil && erry == nil && x > y:
+		return 1
+	case errx == nil && erry == nil:
+		return 0
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// Reports whether the result of compareValues satisfies @op.
+func compareOp(cmp int, op string) bool {
+	switch op {
+	case "==":
+		return cmp == 0
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	case ">":
+		return cmp > 0
+	case ">=":
+		return cmp >= 0
+	}
+	return false
+}
+
+// Sorts songs by a list of tags, using the first value of each, or by the
+// stickers of that name. Ties are broken by the file name, so that the
+// order is always the same.
+type smartSongs struct {
+	list     [][]Pair // Each song starts with its file.
+	order    []*SmartOrder
+	stickers map[string]map[string]string // Values by sticker name and file.
+}
+
+func (this *smartSongs) Len() int      { return len(this.list) }
+func (this *smartSongs) Swap(i, j int) { this.list[i], this.list[j] = this.list[j], this.list[i] }
+
+func (this *smartSongs) Less(i, j int) bool {
+	for _, o := range this.order {
+		if cmp := compareValues(this.value(i, o.Tag), this.value(j, o.Tag)); cmp != 0 {
+			return (cmp < 0) != o.Desc
+		}
+	}
+	return this.list[i][0].Value < this.list[j][0].Value
+}
+
+// Returns the value to sort song @i by for @tag.
+func (this *smartSongs) value(i int, tag string) string {
+	if v := smartValues(this.list[i], tag); len(v) > 0 {
+		return v[0]
+	}
+
+	for name, values := range this.stickers {
+		if strings.ToLower(name) == tag {
+			return values[this.list[i][0].Value]
+		}
+	}
+	return ""
+}
+
+func smartpl(cmd *Command, c *Client) (err os.Error) {
+	file := cmd.S("file", "-")
+
+	var sp *SmartPlaylist
+	if file == "-" {
+		sp, err = ParseSmartPlaylist(os.Stdin)
+	} else {
+		sp, err = LoadSmartPlaylist(file)
+	}
+
+	if err != nil {
+		return
+	}
+
+	name := ""
+	if cmd.S("queue", "off") != "on" {
+		_, base := path.Split(file)
+		if name = cmd.S("name", base[:len(base)-len(path.Ext(base))]); len(name) == 0 || name == "-" {
+			return &UsageError{"A playlist name is needed for rules from standard input."}
+		}
+	}
+
+	var songs []Args
+	if songs, err = sp.Save(c, name); err != nil {
+		return
+	}
+
+	var total int64
+	for _, s := range songs {
+		total += s.Int64("Time", 0)
+	}
+
+	fields := []Pair{
+		Pair{"playlist", name},
+		Pair{"songs", strconv.Itoa(len(songs))},
+		Pair{"time", strconv.Itoa64(total)},
+	}
+
+	target := "the queue"
+	if len(name) > 0 {
+		target = "'" + name + "'"
+	}
+	return cmd.Record(fields, fmt.Sprintf("Saved %d songs (%s) to %s.", len(songs), parseTime(int(total)), target))
+}
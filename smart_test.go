@@ -0,0 +1,222 @@
+// Copyright (c) 2010, Jim Teeuwen. All rights reserved.
+// This code is subject to a 1-clause BSD license.
+// See the LICENSE file for its contents.
+
+package mpd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+	"github.com/jteeuwen/go-pkg-mpd/mpdtest"
+)
+
+func TestParseSmartPlaylist(t *testing.T) {
+	sp, err := ParseSmartPlaylist(strings.NewReader(`
+		# Unplayed jazz added in the last 30 days, at most two hours.
+		where Genre == Jazz
+		where added within 30d
+		where title contains "so what"
+		sticker playcount missing
+		order date desc
+		order title
+		limit time 2h
+		limit songs 20`))
+
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+
+	if len(sp.Rules) != 4 || len(sp.Order) != 2 || sp.Random || sp.MaxSongs != 20 || sp.MaxTime != 7200e9 {
+		t.Fatalf("Unexpected playlist %+v.", sp)
+	}
+
+	if r := sp.Rules[0]; r.Name != "genre" || r.Op != "==" || r.Value != "Jazz" || r.Sticker {
+		t.Errorf("Unexpected rule %+v.", r)
+	}
+
+	if r := sp.Rules[1]; r.age != 30*86400 {
+		t.Errorf("Expected an age of 30 days, got %d seconds.", r.age)
+	}
+
+	if r := sp.Rules[2]; r.Value != "so what" {
+		t.Errorf("Expected a quoted value, got %q.", r.Value)
+	}
+
+	if r := sp.Rules[3]; !r.Sticker || r.Name != "playcount" || r.Op != "missing" {
+		t.Errorf("Unexpected rule %+v.", r)
+	}
+
+	if o := sp.Order[0]; o.Tag != "date" || !o.Desc || sp.Order[1].Desc {
+		t.Errorf("Unexpected order %+v.", o)
+	}
+
+	errors := map[string]string{
+		"where genre = Jazz":        "Line 1: Unknown operator '='",
+		"# Comment\nwhere genre":    "Line 2: Usage: where",
+		"sticker rating":            "Line 1: Usage: sticker",
+		"order random\norder title": "Line 2: Random order",
+		"limit songs 0":             "Line 1: Invalid number of songs",
+		"limit time 1x":             "Line 1: Invalid duration",
+		"where added within 3y":     "Line 1: Invalid duration",
+		"play 1":                    "Line 1: Unknown statement",
+		`where title == "x`:         "Line 1: Missing closing",
+	}
+
+	for rules, want := range errors {
+		if _, err := ParseSmartPlaylist(strings.NewReader(rules)); err == nil || !strings.HasPrefix(err.String(), want) {
+			t.Errorf("%q: Expected %s, got %v.", rules, want, err)
+		}
+	}
+}
+
+func TestSmartPlaylist(t *testing.T) {
+	srv, c := startServer(t)
+	defer srv.Close()
+	defer c.Close()
+
+	srv.AddSongs(
+		mpdtest.Song{"file": "jazz/evans/peace_piece.flac", "Artist": "Bill Evans", "Title": "Peace Piece",
+			"Genre": "Jazz", "Time": "403", "Last-Modified": "2010-11-20T10:00:00Z"},
+		mpdtest.Song{"file": "jazz/monk/round_midnight.flac", "Artist": "Thelonious Monk", "Title": "'Round Midnight",
+			"Genre": "Jazz", "Time": "380", "Last-Modified": "2010-10-01T10:00:00Z"},
+	)
+	srv.SetSticker("jazz/coltrane/giant_steps.flac", "playcount", "3")
+	srv.SetSticker("rock/acdc/hells_bells.mp3", "rating", "4")
+	srv.SetSticker("rock/acdc/back_in_black.mp3", "rating", "10")
+
+	now, _ := time.Parse(time.RFC3339, "2010-11-25T00:00:00Z")
+
+	tests := map[string]string{
+		"where genre == Jazz\nsticker playcount missing\norder time desc\nlimit time 20m": "so_what peace_piece",
+		"where genre == Jazz\nwhere added within 30d":                                     "peace_piece",
+		"sticker rating > 5":                               "back_in_black",
+		"sticker rating exists\norder rating":              "hells_bells back_in_black",
+		"where artist != AC/DC\nwhere title contains STEP": "giant_steps",
+		"where genre == Rock\norder random":                "back_in_black hells_bells",
+		"where track >= 2":                                 "back_in_black",
+		"order artist desc\norder title\nlimit songs 3":    "round_midnight so_what giant_steps",
+	}
+
+	for rules, want := range tests {
+		sp, err := ParseSmartPlaylist(strings.NewReader(rules))
+		if err != nil {
+			t.Errorf("%q: %v", rules, err)
+			continue
+		}
+
+		sp.now = func() int64 { return now.Seconds() }
+		sp.perm = func(n int) []int {
+			list := make([]int, n)
+			for i := range list {
+				list[i] = n - i - 1
+			}
+			return list
+		}
+
+		songs, err := sp.Songs(c)
+		if err != nil {
+			t.Errorf("%q: %v", rules, err)
+			continue
+		}
+
+		names := make([]string, len(songs))
+		for i, s := range songs {
+			file := s["file"]
+			names[i] = file[strings.LastIndex(file, "/")+1 : strings.LastIndex(file, ".")]
+		}
+
+		if got := strings.Join(names, " "); got != want {
+			t.Errorf("%q: Expected %s, got %s.", rules, want, got)
+		}
+	}
+
+	// Exact rules are left to the server.
+	sp, _ := ParseSmartPlaylist(strings.NewReader("where genre == Jazz\nwhere artist == \"Miles Davis\""))
+	if _, err := sp.Songs(c); err != nil {
+		t.Fatalf("Songs: %v", err)
+	}
+
+	if last := srv.Last(); last != `find "genre" "Jazz" "artist" "Miles Davis"` {
+		t.Errorf("Unexpected command '%s'.", last)
+	}
+}
+
+func TestSmartMultipleValues(t *testing.T) {
+	srv, c := startServer(t)
+	defer srv.Close()
+	defer c.Close()
+
+	srv.AddSongs(mpdtest.Song{"file": "jazz/metheny/phase_dance.flac", "Artist": "Pat Metheny", "Title": "Phase Dance",
+		"Genre": "Fusion\nJazz", "Time": "503"})
+
+	// Every value of a tag counts, and the first one is used for sorting.
+	tests := map[string]string{
+		"where genre == Jazz":              "giant_steps so_what phase_dance",
+		"where genre == Fusion":            "phase_dance",
+		"where genre != Jazz":              "back_in_black hells_bells",
+		"where genre contains fus":         "phase_dance",
+		"where genre >= Jazz\norder genre": "phase_dance giant_steps so_what back_in_black hells_bells",
+		"where artist == \"Pat Metheny\"":  "phase_dance",
+	}
+
+	for rules, want := range tests {
+		sp, err := ParseSmartPlaylist(strings.NewReader(rules))
+		if err != nil {
+			t.Errorf("%q: %v", rules, err)
+			continue
+		}
+
+		songs, err := sp.Songs(c)
+		if err != nil {
+			t.Errorf("%q: %v", rules, err)
+			continue
+		}
+
+		names := make([]string, len(songs))
+		for i, s := range songs {
+			file := s["file"]
+			names[i] = file[strings.LastIndex(file, "/")+1 : strings.LastIndex(file, ".")]
+		}
+
+		if got := strings.Join(names, " "); got != want {
+			t.Errorf("%q: Expected %s, got %s.", rules, want, got)
+		}
+	}
+}
+
+func TestSmartplCommand(t *testing.T) {
+	srv, c := startServer(t)
+	defer srv.Close()
+	defer c.Close()
+
+	fd, err := ioutil.TempFile("", "smartpl")
+	if err != nil {
+		t.Fatalf("TempFile: %v", err)
+	}
+
+	defer os.Remove(fd.Name())
+	fmt.Fprintf(fd, "where genre == Rock\norder track\n")
+	fd.Close()
+
+	srv.AddPlaylist("rock", "jazz/davis/so_what.flac")
+	if err = runCommand(c, "smartpl", fd.Name(), "rock"); err != nil {
+		t.Fatalf("smartpl: %v", err)
+	}
+
+	if list := srv.Playlist("rock"); len(list) != 2 || list[0] != "rock/acdc/hells_bells.mp3" || list[1] != "rock/acdc/back_in_black.mp3" {
+		t.Errorf("Unexpected playlist %v.", list)
+	}
+
+	srv.Enqueue("jazz/davis/so_what.flac")
+	if err = runCommand(c, "smartpl", fd.Name(), "--queue"); err != nil {
+		t.Fatalf("smartpl --queue: %v", err)
+	}
+
+	if queue := srv.Queue(); len(queue) != 2 || queue[0]["file"] != "rock/acdc/hells_bells.mp3" {
+		t.Errorf("Unexpected queue %v.", queue)
+	}
+}
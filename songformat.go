@@ -0,0 +1,442 @@
+// Copyright (c) 2010, Jim Teeuwen. All rights reserved.
+// This code is subject to a 1-clause BSD license.
+// See the LICENSE file for its contents.
+
+package mpd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// A SongFormat renders songs through a format string, in the style of mpc:
+//
+//	%name%  The value of the tag or field 'name', like %artist% or %file%.
+//	        Names are case insensitive.
+//	[...]   A group. It is only rendered if at least one field in it has a
+//	        value.
+//	a|b     Renders a if any of its fields have a value, b otherwise.
+//	a&b     Renders a and b only if both have a value.
+//	#c      The character c, literally. Use it for [, ], |, &, % and #.
+//
+// Besides the song's tags, these fields are available:
+//
+//	%position%   Position in the playlist, counting from 1. %pos% holds the
+//	             position as the server reports it, counting from 0.
+//	%shortfile%  The file name, without its directory.
+//	%time%       Duration of the song, as mm:ss.
+//	%elapsed%    Elapsed time of the current song, as mm:ss.
+//	%remaining%  Remaining time of the current song, as mm:ss.
+//	%percent%    Elapsed time as a percentage of the duration.
+//	%bar%        A progress bar, like [=====>    ].
+//	%length%     Number of songs in the playlist.
+//	%cuetrack%   Index of a CUE track in its image. See ParseCuePath.
+//	%cueimage%   File name of the image of a CUE track.
+//
+// The player fields are taken from the status fields 'elapsed', 'duration'
+// and 'playlistlength' of the song's record. See FormatStatus.
+type SongFormat struct {
+	BarWidth int // Width of %bar%, including its brackets.
+	root     fmtNode
+}
+
+// Formats of the songs listed by the current and plsearch commands, in the
+// text format. CUE tracks show their index, and their image and index if
+// they have no title.
+var (
+	CurrentFormat  = mustSongFormat("#[%position%/%length%#] [[%artist% - ][%album% - ][%cuetrack%. ]%title%|%cueimage% track %cuetrack%|%file%] (%elapsed%/%time%) %bar%")
+	PlsearchFormat = mustSongFormat("#[%pos%:%id%#] [[%artist% - ][%album% - ][%cuetrack%. ]%title%|%cueimage% track %cuetrack%|%file%] (%time%)")
+)
+
+func NewSongFormat(format string) (*SongFormat, os.Error) {
+	p := &fmtParser{s: format}
+
+	root, err := p.parseAlt()
+	if err != nil {
+		return nil, err
+	}
+	return &SongFormat{20, root}, nil
+}
+
+func mustSongFormat(format string) *SongFormat {
+	f, err := NewSongFormat(format)
+	if err != nil {
+		panic(err.String())
+	}
+	return f
+}
+
+// Renders a song, given the fields of its record.
+func (this *SongFormat) Format(fields []Pair) string {
+	text, _ := this.root.render(&songFields{fields, this.BarWidth})
+	return text
+}
+
+// Renders a song along with the player fields from @status.
+func (this *SongFormat) FormatStatus(song []Pair, status *Status) string {
+	fields := make([]Pair, 0, len(song)+4)
+	fields = append(fields, song...)
+	return this.Format(append(fields, statusPairs(status)...))
+}
+
+// Returns the fields of @status which a SongFormat uses.
+func statusPairs(s *Status) []Pair {
+	return []Pair{
+		Pair{"state", s.State},
+		Pair{"elapsed", strconv.Ftoa64(float64(s.Elapsed)/1e9, 'f', 3)},
+		Pair{"duration", strconv.Ftoa64(float64(s.Duration)/1e9, 'f', 3)},
+		Pair{"playlistlength", strconv.Itoa(s.PlaylistLength)},
+	}
+}
+
+// Writes each record through a SongFormat, followed by a line break.
+type SongFormatter struct {
+	*SongFormat
+}
+
+func NewSongFormatter(format string) (*SongFormatter, os.Error) {
+	f, err := NewSongFormat(format)
+	if err != nil {
+		return nil, err
+	}
+	return &SongFormatter{f}, nil
+}
+
+func (this *SongFormatter) Begin(w io.Writer) os.Error { return nil }
+func (this *SongFormatter) End(w io.Writer) os.Error   { return nil }
+
+func (this *SongFormatter) Record(w io.Writer, fields []Pair, text string) (err os.Error) {
+	_, err = fmt.Fprintln(w, this.Format(fields))
+	return
+}
+
+/* Fields */
+
+type songFields struct {
+	fields   []Pair
+	barWidth int
+}
+
+// Returns the value of the named field, or an empty string.
+func (this *songFields) get(name string) string {
+	switch name {
+	case "position":
+		if n, err := strconv.Atoi(this.raw("pos")); err == nil {
+			return strconv.Itoa(n + 1)
+		}
+		return ""
+
+	case "shortfile":
+		file := this.raw("file")
+		return file[strings.LastIndex(file, "/")+1:]
+
+	case "time":
+		if d := this.duration(); d >= 0 {
+			return parseTime(int(d))
+		}
+		return ""
+
+	case "elapsed":
+		if e := this.seconds("elapsed"); e >= 0 {
+			return parseTime(int(e))
+		}
+		return ""
+
+	case "remaining":
+		if e, d := this.seconds("elapsed"), this.duration(); e >= 0 && d >= e {
+			return parseTime(int(d - e))
+		}
+		return ""
+
+	case "percent":
+		if e, d := this.seconds("elapsed"), this.duration(); e >= 0 && d > 0 {
+			return fmt.Sprintf("%d%%", int(e*100/d))
+		}
+		return ""
+
+	case "bar":
+		if e, d := this.seconds("elapsed"), this.duration(); e >= 0 && d > 0 {
+			return progressBar(e/d, this.barWidth)
+		}
+		return ""
+
+	case "length":
+		return this.raw("playlistlength")
+
+	case "cuetrack":
+		if _, index, ok := ParseCuePath(this.raw("file")); ok {
+			return strconv.Itoa(index)
+		}
+		return ""
+
+	case "cueimage":
+		if image, _, ok := ParseCuePath(this.raw("file")); ok {
+			return image[strings.LastIndex(image, "/")+1:]
+		}
+		return ""
+	}
+	return this.raw(name)
+}
+
+// Returns the value of the record field @name, ignoring case.
+func (this *songFields) raw(name string) string {
+	for _, f := range this.fields {
+		if strings.ToLower(f.Key) == name {
+			return f.Value
+		}
+	}
+	return ""
+}
+
+// Returns the named field in seconds, or -1 if it is missing.
+func (this *songFields) seconds(name string) float64 {
+	f, err := strconv.Atof64(this.raw(name))
+	if err != nil {
+		return -1
+	}
+	return f
+}
+
+// Returns the duration of the song in seconds, or -1 if it is unknown.
+func (this *songFields) duration() float64 {
+	if d := this.seconds("duration"); d > 0 {
+		return d
+	}
+	return this.seconds("time")
+}
+
+// Renders a bar of @width characters which is filled for @frac.
+func progressBar(frac float64, width int) string {
+	inner := width - 2
+	if inner < 1 {
+		return ""
+	}
+
+	if frac > 1 {
+		frac = 1
+	}
+
+	filled := int(frac * float64(inner))
+	if filled == inner {
+		return "[" + strings.Repeat("=", inner) + "]"
+	}
+	return "[" + strings.Repeat("=", filled) + ">" + strings.Repeat(" ", inner-filled-1) + "]"
+}
+
+/* Format tree */
+
+type fmtNode interface {
+	// Returns the rendered text and whether any field in it had a value.
+	render(f *songFields) (string, bool)
+}
+
+type fmtText string
+
+func (this fmtText) render(f *songFields) (string, bool) {
+	return string(this), false
+}
+
+type fmtField string
+
+func (this fmtField) render(f *songFields) (string, bool) {
+	v := f.get(string(this))
+	return v, len(v) > 0
+}
+
+type fmtSeq []fmtNode
+
+func (this fmtSeq) render(f *songFields) (string, bool) {
+	text, found := "", false
+	for _, n := range this {
+		t, ok := n.render(f)
+		text += t
+		found = found || ok
+	}
+	return text, found
+}
+
+type fmtGroup struct {
+	node fmtNode
+}
+
+func (this *fmtGroup) render(f *songFields) (string, bool) {
+	if text, ok := this.node.render(f); ok {
+		return text, true
+	}
+	return "", false
+}
+
+type fmtAnd []fmtNode
+
+func (this fmtAnd) render(f *songFields) (string, bool) {
+	text := ""
+	for _, n := range this {
+		t, ok := n.render(f)
+		if !ok {
+			return "", false
+		}
+		text += t
+	}
+	return text, true
+}
+
+type fmtAlt []fmtNode
+
+func (this fmtAlt) render(f *songFields) (string, bool) {
+	for _, n := range this {
+		if t, ok := n.render(f); ok {
+			return t, true
+		}
+	}
+	return "", false
+}
+
+type fmtParser struct {
+	s     string
+	pos   int
+	depth int // Number of open groups.
+}
+
+func (this *fmtParser) error(msg string) os.Error {
+	return os.NewError(fmt.Sprintf("%s at offset %d of format '%s'.", msg, this.pos, this.s))
+}
+
+func (this *fmtParser) peek(c byte) bool {
+	return this.pos < len(this.s) && this.s[this.pos] == c
+}
+
+// Alternatives bind weakest, followed by '&' and concatenation.
+func (this *fmtParser) parseAlt() (fmtNode, os.Error) {
+	list := make(fmtAlt, 0, 1)
+
+	for {
+		n, err := this.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+
+		list = append(list, n)
+		if !this.peek('|') {
+			break
+		}
+		this.pos++
+	}
+
+	if len(list) == 1 {
+		return list[0], nil
+	}
+	return list, nil
+}
+
+func (this *fmtParser) parseAnd() (fmtNode, os.Error) {
+	list := make(fmtAnd, 0, 1)
+
+	for {
+		n, err := this.parseSeq()
+		if err != nil {
+			return nil, err
+		}
+
+		list = append(list, n)
+		if !this.peek('&') {
+			break
+		}
+		this.pos++
+	}
+
+	if len(list) == 1 {
+		return list[0], nil
+	}
+	return list, nil
+}
+
+func (this *fmtParser) parseSeq() (fmtNode, os.Error) {
+	list := make(fmtSeq, 0)
+	text := ""
+
+	for this.pos < len(this.s) {
+		c := this.s[this.pos]
+
+		switch c {
+		case '|', '&':
+			return this.endSeq(list, text), nil
+
+		case ']':
+			if this.depth == 0 {
+				return nil, this.error("Unexpected ']'")
+			}
+			return this.endSeq(list, text), nil
+
+		case '#':
+			if this.pos+1 >= len(this.s) {
+				return nil, this.error("Missing character after '#'")
+			}
+			text += this.s[this.pos+1 : this.pos+2]
+			this.pos += 2
+			continue
+
+		case '%':
+			end := strings.Index(this.s[this.pos+1:], "%")
+			if end == -1 {
+				return nil, this.error("Missing closing '%'")
+			}
+
+			if end == 0 {
+				return nil, this.error("Missing field name")
+			}
+
+			if len(text) > 0 {
+				list = append(list, fmtText(text))
+				text = ""
+			}
+
+			list = append(list, fmtField(strings.ToLower(this.s[this.pos+1:this.pos+1+end])))
+			this.pos += end + 2
+			continue
+
+		case '[':
+			if len(text) > 0 {
+				list = append(list, fmtText(text))
+				text = ""
+			}
+
+			start := this.pos
+			this.pos++
+			this.depth++
+
+			n, err := this.parseAlt()
+			if err != nil {
+				return nil, err
+			}
+
+			if !this.peek(']') {
+				this.pos = start
+				return nil, this.error("Missing ']' for '['")
+			}
+
+			this.pos++
+			this.depth--
+			list = append(list, &fmtGroup{n})
+			continue
+		}
+
+		text += this.s[this.pos : this.pos+1]
+		this.pos++
+	}
+
+	return this.endSeq(list, text), nil
+}
+
+func (this *fmtParser) endSeq(list fmtSeq, text string) fmtNode {
+	if len(text) > 0 {
+		list = append(list, fmtText(text))
+	}
+
+	if len(list) == 1 {
+		return list[0]
+	}
+	return list
+}
@@ -0,0 +1,107 @@
+// Copyright (c) 2010, Jim Teeuwen. All rights reserved.
+// This code is subject to a 1-clause BSD license.
+// See the LICENSE file for its contents.
+
+package mpd
+
+import (
+	"bytes"
+	"testing"
+)
+
+var testSong = []Pair{
+	Pair{"file", "rock/acdc/hells_bells.mp3"}, Pair{"Artist", "AC/DC"},
+	Pair{"Title", "Hells Bells"}, Pair{"Time", "312"}, Pair{"Pos", "0"}, Pair{"Id", "7"},
+}
+
+func TestSongFormat(t *testing.T) {
+	tests := map[string]string{
+		"%artist% - %title%":                        "AC/DC - Hells Bells",
+		"%ARTIST%":                                  "AC/DC",
+		"[%album% - ]%title%":                       "Hells Bells",
+		"[%artist% - ]%title%":                      "AC/DC - Hells Bells",
+		"[%album%|%artist%]":                        "AC/DC",
+		"%composer%|%shortfile%":                    "hells_bells.mp3",
+		"[%artist%&%album%]|%title%":                "Hells Bells",
+		"[%artist% #[%time%#]]":                     "AC/DC [05:12]",
+		"%position%/%pos%:%id%":                     "1/0:7",
+		"#%#&#|##":                                  "%&|#",
+		"[[%genre% ]x]":                             "",
+		"%elapsed%[ %bar%]":                         "",
+		"%title% 100#%":                             "Hells Bells 100%",
+		"[%album% - %title%]":                       " - Hells Bells",
+		"[[%artist% - ][%album% - ]%title%|%file%]": "AC/DC - Hells Bells",
+	}
+
+	for format, want := range tests {
+		f, err := NewSongFormat(format)
+		if err != nil {
+			t.Errorf("%s: %v", format, err)
+			continue
+		}
+
+		if got := f.Format(testSong); got != want {
+			t.Errorf("%s: Expected '%s', got '%s'.", format, want, got)
+		}
+	}
+
+	for _, format := range []string{"[%title%", "%title%]", "%title", "%%", "abc#"} {
+		if _, err := NewSongFormat(format); err == nil {
+			t.Errorf("%s: Expected error.", format)
+		}
+	}
+}
+
+func TestFormatStatus(t *testing.T) {
+	f, _ := NewSongFormat("%elapsed%/%time% -%remaining% (%percent%) %bar% of %length%")
+	f.BarWidth = 12
+
+	s := &Status{State: "play", Elapsed: 78e9, Duration: 312e9, PlaylistLength: 4}
+	want := "01:18/05:12 -03:54 (25%) [==>       ] of 4"
+
+	if got := f.FormatStatus(testSong, s); got != want {
+		t.Errorf("Expected '%s', got '%s'.", want, got)
+	}
+
+	if got := progressBar(1, 6); got != "[====]" {
+		t.Errorf("Expected a full bar, got '%s'.", got)
+	}
+}
+
+func TestSongFormatCommands(t *testing.T) {
+	srv, c := startServer(t)
+	defer srv.Close()
+	defer c.Close()
+
+	srv.Enqueue("rock/acdc/hells_bells.mp3", "jazz/davis/so_what.flac")
+
+	if err := c.SeekId(2, 281e9); err != nil {
+		t.Fatalf("SeekId: %v", err)
+	}
+
+	tests := map[string][]string{
+		"[2/2] Miles Davis - Kind of Blue - So What (04:41/09:22) [=========>        ]\n": []string{"current"},
+		"[0:1] AC/DC - Back in Black - Hells Bells (05:12)\n":                             []string{"plsearch", "title", "bells"},
+		"1. Hells Bells\n2. So What\n":                                                    []string{"plinfo", "--format=%position%. %title%"},
+	}
+
+	for want, args := range tests {
+		var buf bytes.Buffer
+		cmd := CreateCommand(args[0])
+		cmd.Output = &buf
+
+		if err := cmd.parse(args[1:]); err != nil {
+			t.Errorf("%v: parse: %v", args, err)
+			continue
+		}
+
+		if err := cmd.Execute(c); err != nil {
+			t.Errorf("%v: %v", args, err)
+			continue
+		}
+
+		if got := buf.String(); got != want {
+			t.Errorf("%v: Expected %q, got %q.", args, want, got)
+		}
+	}
+}
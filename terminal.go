@@ -0,0 +1,84 @@
+// Copyright (c) 2010, Jim Teeuwen. All rights reserved.
+// This code is subject to a 1-clause BSD license.
+// See the LICENSE file for its contents.
+
+package mpd
+
+import (
+	"exec"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"syscall"
+)
+
+// Puts the terminal on standard input in raw mode: input is passed on byte
+// by byte, without echo and without turning Ctrl-C into a signal. Returns
+// the previous settings, which should be passed to restoreTerminal.
+func rawTerminal() (state string, err os.Error) {
+	if state, err = stty("-g"); err != nil {
+		return
+	}
+
+	_, err = stty("-icanon", "-echo", "-isig", "min", "1")
+	return
+}
+
+// Makes reads from the raw terminal return after a tenth of a second when
+// no key is pressed, so that the reader can check whether it is still
+// needed.
+func terminalTimeout() (err os.Error) {
+	_, err = stty("min", "0", "time", "1")
+	return
+}
+
+// Reads keys from the terminal on standard input. Unlike os.Stdin, it
+// reports a read which timed out as 0 bytes without an error, rather than
+// as os.EOF.
+type terminalReader struct{}
+
+func (this terminalReader) Read(b []byte) (n int, err os.Error) {
+	n, e := syscall.Read(os.Stdin.Fd(), b)
+	if e != 0 {
+		return 0, os.Errno(e)
+	}
+	return n, nil
+}
+
+func restoreTerminal(state string) (err os.Error) {
+	_, err = stty(state)
+	return
+}
+
+// Runs stty on standard input. This fails when standard input is not a
+// terminal.
+func stty(args ...string) (out string, err os.Error) {
+	var bin string
+	if bin, err = exec.LookPath("stty"); err != nil {
+		return
+	}
+
+	argv := append([]string{"stty"}, args...)
+
+	var cmd *exec.Cmd
+	if cmd, err = exec.Run(bin, argv, os.Environ(), "", exec.PassThrough, exec.Pipe, exec.DevNull); err != nil {
+		return
+	}
+
+	var data []byte
+	if data, err = ioutil.ReadAll(cmd.Stdout); err != nil {
+		cmd.Close()
+		return
+	}
+
+	var msg *os.Waitmsg
+	if msg, err = cmd.Wait(0); err != nil {
+		return
+	}
+
+	if !msg.Exited() || msg.ExitStatus() != 0 {
+		return "", os.NewError(fmt.Sprintf("stty %s: %s", strings.Join(args, " "), msg))
+	}
+	return strings.TrimSpace(string(data)), nil
+}
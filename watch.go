@@ -0,0 +1,267 @@
+// Copyright (c) 2010, Jim Teeuwen. All rights reserved.
+// This code is subject to a 1-clause BSD license.
+// See the LICENSE file for its contents.
+
+package mpd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func init() {
+	mustRegister(GroupInfo,
+		&Command{
+			Name: "watch",
+			Desc: "Shows a status line with the current song, its progress, the volume, the playback options and the next song, and keeps it up to date until Ctrl-C or q is pressed. In formats other than text, every update is reported as a record instead.",
+			Params: []*Param{
+				newParam("interval", "How often the progress is updated while playing.", DurationKind, true).withDefault("1"),
+			},
+			Exec: watch,
+		},
+	)
+}
+
+// Format of the status line drawn by the watch command. Besides the fields
+// of the current song and the player (see SongFormat), it can use %state%,
+// %volume%, %options% and %next%, the title of the next song.
+var WatchFormat = mustSongFormat("#[%state%#] [[%artist% - ]%title%|%shortfile%] [%bar% %elapsed%/%time%][ vol %volume%#%][ (%options%)][ next: %next%]")
+
+// Format of the next song in %next%.
+var NextFormat = mustSongFormat("[[%artist% - ]%title%|%shortfile%]")
+
+// Subsystems whose changes are reported by Watch.
+var watchSubsystems = []string{"player", "mixer", "options", "playlist"}
+
+// Reports the state of the player to @update: once at the start, whenever
+// it changes and, while a song is playing, every @interval nanoseconds. The
+// fields are those of the current song, followed by state, elapsed,
+// duration, playlistlength, volume, options and next. Returns when @update
+// fails, or when something is received from @stop.
+func (this *Client) Watch(interval int64, stop <-chan bool, update func(fields []Pair) os.Error) (err os.Error) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var fields []Pair
+	for {
+		if fields, err = this.watchFields(); err != nil {
+			return
+		}
+
+		if err = update(fields); err != nil {
+			return
+		}
+
+		// Only wake up for progress updates while there is progress.
+		var ticks <-chan int64
+		if pairArgs(fields)["state"] == "play" {
+			ticks = ticker.C
+		}
+
+		// Send idle before waiting for its response in the background, so
+		// that a noidle can not overtake it.
+		if err = this.sendIdle(watchSubsystems); err != nil {
+			return
+		}
+
+		done := make(chan os.Error, 1)
+		go func() {
+			_, e := this.receiveIdle()
+			done <- e
+		}()
+
+		select {
+		case err = <-done:
+			if err != nil {
+				return
+			}
+
+		case <-ticks:
+			if err = this.NoIdle(); err != nil {
+				return
+			}
+
+			if err = <-done; err != nil {
+				return
+			}
+
+		case <-stop:
+			if err = this.NoIdle(); err == nil {
+				err = <-done
+			}
+			return
+		}
+	}
+	return
+}
+
+func (this *Client) watchFields() (fields []Pair, err os.Error) {
+	var status *Status
+	if status, err = this.Status(); err != nil {
+		return
+	}
+
+	if err = this.send("currentsong"); err != nil {
+		return
+	}
+
+	if fields, err = this.receivePairs(); err != nil {
+		return
+	}
+
+	fields = append(fields, statusPairs(status)...)
+	if status.Volume >= 0 {
+		fields = append(fields, Pair{"volume", strconv.Itoa(status.Volume)})
+	}
+	fields = append(fields, Pair{"options", watchOptions(status)})
+
+	next := ""
+	if status.NextSong >= 0 {
+		if err = this.send("playlistinfo %d", status.NextSong); err != nil {
+			return
+		}
+
+		var song []Pair
+		if song, err = this.receivePairs(); err != nil {
+			return
+		}
+		next = NextFormat.Format(song)
+	}
+
+	return append(fields, Pair{"next", next}), nil
+}
+
+// Lists the playback options which are turned on.
+func watchOptions(s *Status) string {
+	list := make([]string, 0, 5)
+
+	if s.Repeat {
+		list = append(list, "repeat")
+	}
+
+	if s.Random {
+		list = append(list, "random")
+	}
+
+	switch s.Single {
+	case ModeOn:
+		list = append(list, "single")
+	case ModeOneshot:
+		list = append(list, "single once")
+	}
+
+	switch s.Consume {
+	case ModeOn:
+		list = append(list, "consume")
+	case ModeOneshot:
+		list = append(list, "consume once")
+	}
+
+	if s.Crossfade > 0 {
+		list = append(list, fmt.Sprintf("crossfade %ds", s.Crossfade))
+	}
+	return strings.Join(list, ", ")
+}
+
+// Returns the number of columns of the terminal, or 80 if it is unknown.
+func terminalWidth() int {
+	size, err := stty("size")
+	if err != nil {
+		return 80
+	}
+
+	f := strings.Fields(size)
+	if len(f) != 2 {
+		return 80
+	}
+
+	n, err := strconv.Atoi(f[1])
+	if err != nil || n <= 0 {
+		return 80
+	}
+	return n
+}
+
+// Sends on @stop when Ctrl-C, Ctrl-D or q is pressed, or @r is exhausted.
+// Returns as soon as @done is closed as well, which is noticed when a read
+// returns, even without input.
+func watchKeys(r io.Reader, stop chan<- bool, done <-chan bool) {
+	b := make([]byte, 1)
+	for {
+		n, err := r.Read(b)
+
+		select {
+		case <-done:
+			return
+		default:
+		}
+
+		if err != nil || n == 1 && (b[0] == 3 || b[0] == 4 || b[0] == 'q') {
+			stop <- true
+			return
+		}
+	}
+}
+
+func watch(cmd *Command, c *Client) (err os.Error) {
+	var interval int64
+	if interval, err = ParseDuration(cmd.S("interval", "1")); err != nil {
+		return
+	}
+
+	if interval <= 0 {
+		return &UsageError{"The interval must be longer than 0."}
+	}
+
+	stop := make(chan bool, 1)
+	_, text := cmd.Format.(*TextFormatter)
+	width := 80
+
+	if text {
+		// Without a terminal, the watch runs until the program is stopped.
+		if state, e := rawTerminal(); e == nil {
+			terminalTimeout()
+			done := make(chan bool)
+			finished := make(chan bool)
+
+			go func() {
+				watchKeys(terminalReader{}, stop, done)
+				finished <- true
+			}()
+
+			// Wait for the key reader, so that it does not take input meant
+			// for whatever reads the terminal next, like the shell.
+			defer func() {
+				close(done)
+				<-finished
+				restoreTerminal(state)
+			}()
+			width = terminalWidth()
+		}
+	}
+
+	err = c.Watch(interval, stop, func(fields []Pair) os.Error {
+		if !text {
+			return cmd.Record(fields, "")
+		}
+
+		// Keep the line shorter than the terminal, so that it does not wrap
+		// and can be redrawn in place.
+		line := []int(WatchFormat.Format(fields))
+		if len(line) > width-1 {
+			line = line[:width-1]
+		}
+
+		_, e := fmt.Fprintf(cmd.Output, "\r\033[K%s", string(line))
+		return e
+	})
+
+	if text {
+		fmt.Fprintln(cmd.Output)
+	}
+	return
+}
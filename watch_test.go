@@ -0,0 +1,142 @@
+// Copyright (c) 2010, Jim Teeuwen. All rights reserved.
+// This code is subject to a 1-clause BSD license.
+// See the LICENSE file for its contents.
+
+package mpd
+
+import (
+	"bytes"
+	"os"
+	"testing"
+	"time"
+)
+
+func nextUpdate(t *testing.T, updates <-chan []Pair) Args {
+	timeout := make(chan bool, 1)
+	go func() {
+		time.Sleep(5e9)
+		timeout <- true
+	}()
+
+	select {
+	case f := <-updates:
+		return pairArgs(f)
+	case <-timeout:
+		t.Fatalf("Timed out waiting for an update.")
+	}
+	return nil
+}
+
+func TestWatch(t *testing.T) {
+	srv, c := startServer(t)
+	defer srv.Close()
+	defer c.Close()
+
+	srv.Enqueue("rock/acdc/hells_bells.mp3", "rock/acdc/back_in_black.mp3")
+
+	other := newClient()
+	if err := other.Open("tcp", srv.Addr()); err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer other.Close()
+
+	updates := make(chan []Pair, 10)
+	stop := make(chan bool, 1)
+	result := make(chan os.Error, 1)
+
+	go func() {
+		result <- c.Watch(2e7, stop, func(f []Pair) os.Error {
+			updates <- f
+			return nil
+		})
+	}()
+
+	if a := nextUpdate(t, updates); a["state"] != "stop" || a["next"] != "" {
+		t.Errorf("Unexpected initial state %v.", a)
+	}
+
+	if err := runCommand(other, "volume", "30"); err != nil {
+		t.Fatalf("volume: %v", err)
+	}
+
+	if a := nextUpdate(t, updates); a["volume"] != "30" {
+		t.Errorf("Expected volume change, got %v.", a)
+	}
+
+	if err := runCommand(other, "play", "0"); err != nil {
+		t.Fatalf("play: %v", err)
+	}
+
+	a := nextUpdate(t, updates)
+	if a["state"] != "play" || a["Title"] != "Hells Bells" || a["next"] != "AC/DC - Back in Black" {
+		t.Errorf("Unexpected state after play %v.", a)
+	}
+
+	// While playing, updates arrive without changes.
+	if a = nextUpdate(t, updates); a["state"] != "play" {
+		t.Errorf("Expected progress update, got %v.", a)
+	}
+
+	stop <- true
+	if err := <-result; err != nil {
+		t.Errorf("Watch: %v", err)
+	}
+
+	if err := c.Ping(); err != nil {
+		t.Errorf("Connection unusable after Watch: %v", err)
+	}
+}
+
+func TestWatchLine(t *testing.T) {
+	s := &Status{State: "play", Elapsed: 78e9, Duration: 312e9, Volume: 80}
+	s.Repeat = true
+	s.Consume = ModeOneshot
+
+	fields := append(testSong, statusPairs(s)...)
+	fields = append(fields, Pair{"volume", "80"}, Pair{"options", watchOptions(s)}, Pair{"next", ""})
+
+	want := "[play] AC/DC - Hells Bells [====>             ] 01:18/05:12 vol 80% (repeat, consume once)"
+	if got := WatchFormat.Format(fields); got != want {
+		t.Errorf("Expected %q, got %q.", want, got)
+	}
+}
+
+// A terminal on which nothing is typed. Reads time out after a while.
+type idleTerminal struct{}
+
+func (this idleTerminal) Read(b []byte) (int, os.Error) {
+	time.Sleep(1e7)
+	return 0, nil
+}
+
+func TestWatchKeys(t *testing.T) {
+	stop := make(chan bool, 1)
+	done := make(chan bool)
+	finished := make(chan bool, 1)
+
+	go func() {
+		watchKeys(idleTerminal{}, stop, done)
+		finished <- true
+	}()
+
+	// The reader stops when it is no longer needed, without a key.
+	close(done)
+	time.Sleep(1e8)
+
+	select {
+	case <-finished:
+	default:
+		t.Fatalf("Expected the key reader to return.")
+	}
+
+	if len(stop) != 0 {
+		t.Errorf("Expected no stop without a key.")
+	}
+
+	done = make(chan bool)
+	watchKeys(bytes.NewBufferString("xq"), stop, done)
+
+	if len(stop) != 1 {
+		t.Errorf("Expected q to stop the watch.")
+	}
+}